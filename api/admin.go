@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/itsyaboikris/go_document_store/audit"
+	"github.com/itsyaboikris/go_document_store/auth"
+	"github.com/itsyaboikris/go_document_store/models"
+)
+
+type createKeyRequest struct {
+	Name       string                 `json:"name"`
+	Grants     []auth.Grant           `json:"grants"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// canAdministerGrants reports whether the caller is an admin over every
+// scope of the grants, which is required to hand them out or manage a key
+// holding them.
+func (h *Handler) canAdministerGrants(r *http.Request, grants []auth.Grant) bool {
+	for _, grant := range grants {
+		if !h.allowed(r, grant.Project, grant.Collection, auth.RoleAdmin) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	visible := make([]*auth.APIKey, 0, len(keys))
+	for _, key := range keys {
+		if h.canAdministerGrants(r, key.Grants) {
+			key.Hash = ""
+			visible = append(visible, key)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": visible})
+}
+
+func (h *Handler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.ValidateGrants(req.Grants); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.canAdministerGrants(r, req.Grants) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	token, doc, err := h.keys.Create(req.Name, req.Grants, req.Attributes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.replicateKey(doc)
+	h.record(r, audit.Entry{
+		Action:     "create_key",
+		Project:    auth.SystemProject,
+		Collection: auth.KeysCollection,
+		Document:   doc.ID,
+		Details:    map[string]interface{}{"name": req.Name, "grants": req.Grants},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         doc.ID,
+		"key":        token,
+		"name":       req.Name,
+		"grants":     req.Grants,
+		"attributes": req.Attributes,
+	})
+}
+
+func (h *Handler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	key, err := h.keys.Get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !h.canAdministerGrants(r, key.Grants) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	token, doc, err := h.keys.Rotate(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.replicateKey(doc)
+	h.record(r, audit.Entry{
+		Action:     "rotate_key",
+		Project:    auth.SystemProject,
+		Collection: auth.KeysCollection,
+		Document:   id,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":  id,
+		"key": token,
+	})
+}
+
+func (h *Handler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	key, err := h.keys.Get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !h.canAdministerGrants(r, key.Grants) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	doc, err := h.keys.Revoke(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.replicateKey(doc)
+	h.record(r, audit.Entry{
+		Action:     "revoke_key",
+		Project:    auth.SystemProject,
+		Collection: auth.KeysCollection,
+		Document:   id,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) replicateKey(doc *models.Document) {
+	h.replicator.Replicate(auth.SystemProject, auth.KeysCollection, doc.ID, map[string]interface{}{
+		"id":         doc.ID,
+		"data":       doc.Data,
+		"project":    auth.SystemProject,
+		"collection": auth.KeysCollection,
+		"created_at": doc.CreatedAt,
+		"updated_at": doc.UpdatedAt,
+	})
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/itsyaboikris/go_document_store/auth"
+	"github.com/itsyaboikris/go_document_store/query"
+	"github.com/itsyaboikris/go_document_store/store"
+)
+
+// AggregateDocuments runs the aggregation pipeline in the request body.
+func (h *Handler) AggregateDocuments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline, err := query.ParsePipeline(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// $lookup reads other collections, which the caller must be allowed to
+	// read as well.
+	for _, joined := range pipeline.Collections() {
+		if !h.allowed(r, projectID, joined, auth.RoleRead) {
+			http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	scopes := func(collectionID string) *store.Scope {
+		return h.scope(r, projectID, collectionID)
+	}
+	results, err := h.store.AggregateAs(scopes, projectID, collectionID, pipeline)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+		"count":   len(results),
+	})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/itsyaboikris/go_document_store/audit"
+	"github.com/itsyaboikris/go_document_store/auth"
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// record appends an entry for the request to the audit log, if one is
+// configured. The principal, method and route are filled in from the
+// request unless already set. A failed write is logged but does not fail
+// the request, since the mutation has already been applied.
+func (h *Handler) record(r *http.Request, entry audit.Entry) {
+	if h.audit == nil {
+		return
+	}
+
+	if entry.Principal == "" {
+		entry.Principal = "anonymous"
+		if principal := auth.PrincipalFrom(r.Context()); principal != nil {
+			entry.Principal = principal.ID
+		}
+	}
+	entry.Method = r.Method
+	if route := mux.CurrentRoute(r); route != nil {
+		entry.Route, _ = route.GetPathTemplate()
+	}
+
+	if err := h.audit.Record(entry); err != nil {
+		log.Printf("Failed to write audit log entry: %v", err)
+	}
+}
+
+// documentData returns the data of a document, or nil if it does not exist.
+func documentData(doc *models.Document, err error) map[string]interface{} {
+	if err != nil || doc == nil {
+		return nil
+	}
+	return doc.Data
+}
+
+// peerName identifies the node behind a replication request by its client
+// certificate, or by its address when peers do not use TLS.
+func peerName(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return "peer:" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return "peer:" + r.RemoteAddr
+}
+
+// ListAuditLog returns the audit log entries matching the filter, since,
+// until and limit query parameters. Only cluster administrators may read
+// the log since it spans every project.
+func (h *Handler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	criteria, err := auditCriteria(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.audit.Query(criteria)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
+
+// ExportAuditLog streams the matching entries as JSON Lines.
+func (h *Handler) ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	criteria, err := auditCriteria(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	if err := h.audit.Export(w, criteria); err != nil {
+		log.Printf("Failed to export audit log: %v", err)
+	}
+}
+
+func auditCriteria(r *http.Request) (audit.Criteria, error) {
+	var criteria audit.Criteria
+	params := r.URL.Query()
+
+	if filter := params.Get("filter"); filter != "" {
+		if err := json.Unmarshal([]byte(filter), &criteria.Filter); err != nil {
+			return criteria, err
+		}
+		if err := query.NewQuery().Validate(criteria.Filter); err != nil {
+			return criteria, err
+		}
+	}
+
+	var err error
+	if since := params.Get("since"); since != "" {
+		if criteria.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return criteria, err
+		}
+	}
+	if until := params.Get("until"); until != "" {
+		if criteria.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return criteria, err
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if criteria.Limit, err = strconv.Atoi(limit); err != nil {
+			return criteria, err
+		}
+	}
+
+	return criteria, nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+type distinctRequest struct {
+	Field  string                 `json:"field"`
+	Filter map[string]interface{} `json:"filter"`
+}
+
+// CountDocuments counts the documents matching the filter in the request
+// body without returning them. An empty body counts every document.
+func (h *Handler) CountDocuments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var filter map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&filter); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := query.NewQuery().Validate(filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.store.CountAs(h.scope(r, projectID, collectionID), projectID, collectionID, filter)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"count": count})
+}
+
+// DistinctValues returns the distinct values of a field, optionally among
+// the documents matching a filter.
+func (h *Handler) DistinctValues(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var req distinctRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Field == "" {
+		http.Error(w, "Missing field", http.StatusBadRequest)
+		return
+	}
+	if err := query.NewQuery().Validate(req.Filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	values, err := h.store.DistinctAs(h.scope(r, projectID, collectionID), projectID, collectionID, req.Field, req.Filter)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"values": values,
+		"count":  len(values),
+	})
+}
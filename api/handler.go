@@ -2,35 +2,113 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/itsyaboikris/go_document_store/config"
+	"github.com/itsyaboikris/go_document_store/audit"
+	"github.com/itsyaboikris/go_document_store/auth"
 	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
 	"github.com/itsyaboikris/go_document_store/replication"
+	"github.com/itsyaboikris/go_document_store/schema"
 	"github.com/itsyaboikris/go_document_store/store"
 )
 
 type Handler struct {
-	store *store.DocumentStore
+	store         *store.DocumentStore
+	replicator    *replication.Replicator
+	authenticator auth.Authenticator
+	keys          *auth.KeyStore
+	audit         *audit.Log
 }
 
-func NewHandler(store *store.DocumentStore) *Handler {
-	return &Handler{store: store}
+// Options configures optional parts of the public API.
+type Options struct {
+	// Authenticator, when set, is required on every route except /health.
+	Authenticator auth.Authenticator
+	// Keys enables the API key admin endpoints.
+	Keys *auth.KeyStore
+	// Audit, when set, records every mutation and admin action and enables
+	// the audit log endpoints.
+	Audit *audit.Log
 }
 
-func RegisterRoutes(r *mux.Router, store *store.DocumentStore) {
-	h := NewHandler(store)
+func NewHandler(store *store.DocumentStore, replicator *replication.Replicator, opts Options) *Handler {
+	return &Handler{
+		store:         store,
+		replicator:    replicator,
+		authenticator: opts.Authenticator,
+		keys:          opts.Keys,
+		audit:         opts.Audit,
+	}
+}
+
+func RegisterRoutes(r *mux.Router, store *store.DocumentStore, replicator *replication.Replicator, opts Options) {
+	h := NewHandler(store, replicator, opts)
 
 	// Register your routes
-	r.HandleFunc("/replicate", h.ReplicationHandler).Methods("POST")
-	r.HandleFunc("/{project}/{collection}/document", h.CreateDocument).Methods("POST")
-	r.HandleFunc("/{project}/{collection}/document", h.GetAllDocuments).Methods("GET")
-	r.HandleFunc("/{project}/{collection}/document/{id}", h.UpdateDocument).Methods("PUT")
-	r.HandleFunc("/{project}/{collection}/document/{id}", h.DeleteDocument).Methods("DELETE")
+	r.HandleFunc("/health", h.Health).Methods("GET")
+
+	if h.keys != nil {
+		r.HandleFunc("/admin/keys", h.authenticate(h.ListKeys)).Methods("GET")
+		r.HandleFunc("/admin/keys", h.authenticate(h.CreateKey)).Methods("POST")
+		r.HandleFunc("/admin/keys/{id}/rotate", h.authenticate(h.RotateKey)).Methods("POST")
+		r.HandleFunc("/admin/keys/{id}", h.authenticate(h.RevokeKey)).Methods("DELETE")
+	}
 
-	r.HandleFunc("/{project}/{collection}/query", h.QueryDocuments).Methods("POST")
+	if h.audit != nil {
+		r.HandleFunc("/admin/audit", h.requireClusterAdmin(h.ListAuditLog)).Methods("GET")
+		r.HandleFunc("/admin/audit/export", h.requireClusterAdmin(h.ExportAuditLog)).Methods("GET")
+	}
+
+	r.HandleFunc("/projects", h.authenticate(h.ListProjects)).Methods("GET")
+	r.HandleFunc("/projects/{project}", h.authorize(auth.RoleAdmin, h.CreateProject)).Methods("PUT")
+	r.HandleFunc("/projects/{project}", h.authorize(auth.RoleAdmin, h.DropProject)).Methods("DELETE")
+	r.HandleFunc("/projects/{project}/rename", h.authorize(auth.RoleAdmin, h.RenameProject)).Methods("POST")
+	r.HandleFunc("/projects/{project}/collections", h.authenticate(h.ListCollections)).Methods("GET")
+	r.HandleFunc("/projects/{project}/collections/{collection}", h.authorize(auth.RoleRead, h.GetCollection)).Methods("GET")
+	r.HandleFunc("/projects/{project}/collections/{collection}", h.authorize(auth.RoleAdmin, h.CreateCollection)).Methods("PUT")
+	r.HandleFunc("/projects/{project}/collections/{collection}", h.authorize(auth.RoleAdmin, h.DropCollection)).Methods("DELETE")
+	r.HandleFunc("/projects/{project}/collections/{collection}/settings", h.authorize(auth.RoleAdmin, h.UpdateSettings)).Methods("PUT")
+	r.HandleFunc("/projects/{project}/collections/{collection}/rename", h.authorize(auth.RoleAdmin, h.RenameCollection)).Methods("POST")
+
+	r.HandleFunc("/{project}/{collection}/document", h.authorize(auth.RoleWrite, h.CreateDocument)).Methods("POST")
+	r.HandleFunc("/{project}/{collection}/document", h.authorize(auth.RoleRead, h.GetAllDocuments)).Methods("GET")
+	r.HandleFunc("/{project}/{collection}/documents", h.authorize(auth.RoleWrite, h.CreateDocuments)).Methods("POST")
+	r.HandleFunc("/{project}/{collection}/document/{id}", h.authorize(auth.RoleRead, h.GetDocument)).Methods("GET")
+	r.HandleFunc("/{project}/{collection}/document/{id}", h.authorize(auth.RoleWrite, h.UpdateDocument)).Methods("PUT")
+	r.HandleFunc("/{project}/{collection}/document/{id}", h.authorize(auth.RoleWrite, h.DeleteDocument)).Methods("DELETE")
+	r.HandleFunc("/{project}/{collection}/document/{id}/history", h.authorize(auth.RoleRead, h.DocumentHistory)).Methods("GET")
+	r.HandleFunc("/{project}/{collection}/document/{id}/history/{version}", h.authorize(auth.RoleRead, h.DocumentRevision)).Methods("GET")
+
+	r.HandleFunc("/{project}/{collection}/query", h.authorize(auth.RoleRead, h.QueryDocuments)).Methods("POST")
+	r.HandleFunc("/{project}/{collection}/aggregate", h.authorize(auth.RoleRead, h.AggregateDocuments)).Methods("POST")
+	r.HandleFunc("/{project}/{collection}/count", h.authorize(auth.RoleRead, h.CountDocuments)).Methods("POST")
+	r.HandleFunc("/{project}/{collection}/distinct", h.authorize(auth.RoleRead, h.DistinctValues)).Methods("POST")
+	r.HandleFunc("/{project}/{collection}/tail", h.authorize(auth.RoleRead, h.TailDocuments)).Methods("GET")
+
+	r.HandleFunc("/{project}/{collection}/samples", h.authorize(auth.RoleWrite, h.InsertSamples)).Methods("POST")
+	r.HandleFunc("/{project}/{collection}/samples/query", h.authorize(auth.RoleRead, h.QuerySamples)).Methods("POST")
+	r.HandleFunc("/{project}/{collection}/samples/downsample", h.authorize(auth.RoleRead, h.DownsampleSamples)).Methods("POST")
+}
+
+// RegisterInternalRoutes registers the peer-to-peer endpoints. They are
+// served on a separate listener that only other nodes should reach. An
+// audit log, when given, records the replicated writes they apply.
+func RegisterInternalRoutes(r *mux.Router, store *store.DocumentStore, replicator *replication.Replicator, auditLog *audit.Log) {
+	h := NewHandler(store, replicator, Options{Audit: auditLog})
+
+	r.HandleFunc("/health", h.Health).Methods("GET")
+	r.HandleFunc("/metrics/hints", h.HintMetrics).Methods("GET")
+	r.HandleFunc("/metrics/replication", h.ReplicationMetrics).Methods("GET")
+	r.HandleFunc("/replicate", h.ReplicationHandler).Methods("POST")
+	r.HandleFunc("/replicate/batch", h.BatchReplicationHandler).Methods("POST")
+	r.HandleFunc("/replication/snapshot", h.SnapshotHandler).Methods("GET")
+	r.HandleFunc("/replication/changes", h.ChangesHandler).Methods("GET")
 }
 
 func (h *Handler) CreateDocument(w http.ResponseWriter, r *http.Request) {
@@ -44,9 +122,9 @@ func (h *Handler) CreateDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	doc, err := h.store.Create(projectID, collectionID, document)
+	doc, err := h.store.CreateAs(h.scope(r, projectID, collectionID), projectID, collectionID, document)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDocumentError(w, err)
 		return
 	}
 
@@ -59,13 +137,79 @@ func (h *Handler) CreateDocument(w http.ResponseWriter, r *http.Request) {
 		"updated_at": doc.UpdatedAt,
 	}
 
-	peers := config.GetPeers()
-	replication.Replicate(peers, projectID, collectionID, doc.ID, replicationDocument)
+	h.replicator.Replicate(projectID, collectionID, doc.ID, replicationDocument)
+	h.record(r, audit.Entry{
+		Action:     "create",
+		Project:    projectID,
+		Collection: collectionID,
+		Document:   doc.ID,
+		Diff:       audit.Diff(nil, doc.Data),
+	})
 
+	setSchemaWarnings(w, h.store.SchemaWarnings(projectID, collectionID, doc.Data))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(doc)
 }
 
+type bulkCreateRequest struct {
+	Documents []map[string]interface{} `json:"documents"`
+}
+
+// CreateDocuments creates several documents at once. Either all of them are
+// created or none is.
+func (h *Handler) CreateDocuments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var req bulkCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Documents) == 0 {
+		http.Error(w, "Missing documents", http.StatusBadRequest)
+		return
+	}
+
+	docs, err := h.store.CreateMany(h.scope(r, projectID, collectionID), projectID, collectionID, req.Documents)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	var warnings []schema.Error
+	for i, doc := range docs {
+		h.replicator.Replicate(projectID, collectionID, doc.ID, map[string]interface{}{
+			"id":         doc.ID,
+			"data":       doc.Data,
+			"project":    projectID,
+			"collection": collectionID,
+			"created_at": doc.CreatedAt,
+			"updated_at": doc.UpdatedAt,
+		})
+		h.record(r, audit.Entry{
+			Action:     "create",
+			Project:    projectID,
+			Collection: collectionID,
+			Document:   doc.ID,
+			Diff:       audit.Diff(nil, doc.Data),
+		})
+
+		for _, warning := range h.store.SchemaWarnings(projectID, collectionID, doc.Data) {
+			warning.Path = "/" + strconv.Itoa(i) + strings.TrimSuffix(warning.Path, "/")
+			warnings = append(warnings, warning)
+		}
+	}
+
+	setSchemaWarnings(w, warnings)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"documents": docs,
+		"count":     len(docs),
+	})
+}
+
 func (h *Handler) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	projectID := vars["project"]
@@ -78,9 +222,10 @@ func (h *Handler) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	doc, err := h.store.Update(projectID, collectionID, documentID, updateData)
+	before := documentData(h.store.Get(projectID, collectionID, documentID))
+	doc, err := h.store.UpdateAs(h.scope(r, projectID, collectionID), projectID, collectionID, documentID, updateData)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDocumentError(w, err)
 		return
 	}
 
@@ -95,8 +240,45 @@ func (h *Handler) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Replicate to peers
-	peers := config.GetPeers()
-	replication.Replicate(peers, projectID, collectionID, doc.ID, replicationDoc)
+	h.replicator.Replicate(projectID, collectionID, doc.ID, replicationDoc)
+	h.record(r, audit.Entry{
+		Action:     "update",
+		Project:    projectID,
+		Collection: collectionID,
+		Document:   doc.ID,
+		Diff:       audit.Diff(before, doc.Data),
+	})
+
+	setSchemaWarnings(w, h.store.SchemaWarnings(projectID, collectionID, doc.Data))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// GetDocument returns one document, or with "asOf" the revision that was
+// current at that time.
+func (h *Handler) GetDocument(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+	documentID := vars["id"]
+
+	at, ok, err := asOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scope := h.scope(r, projectID, collectionID)
+	var doc *models.Document
+	if ok {
+		doc, err = h.store.GetAt(scope, projectID, collectionID, documentID, at)
+	} else {
+		doc, err = h.store.GetAs(scope, projectID, collectionID, documentID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(doc)
@@ -107,9 +289,20 @@ func (h *Handler) GetAllDocuments(w http.ResponseWriter, r *http.Request) {
 	collectionID := vars["collection"]
 	projectID := vars["project"]
 
-	docs, err := h.store.GetAll(projectID, collectionID)
+	at, ok, err := asOf(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var docs []*models.Document
+	if ok {
+		docs, err = h.store.QueryAt(h.scope(r, projectID, collectionID), projectID, collectionID, nil, at)
+	} else {
+		docs, err = h.store.GetAllAs(h.scope(r, projectID, collectionID), projectID, collectionID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
 		return
 	}
 
@@ -117,6 +310,21 @@ func (h *Handler) GetAllDocuments(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{"documents": docs})
 }
 
+func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok"})
+}
+
+func (h *Handler) HintMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"peers": h.replicator.HintMetrics()})
+}
+
+func (h *Handler) ReplicationMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"peers": h.replicator.Metrics()})
+}
+
 func (h *Handler) ReplicationHandler(w http.ResponseWriter, r *http.Request) {
 	var replicationData map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&replicationData); err != nil {
@@ -124,39 +332,93 @@ func (h *Handler) ReplicationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if status, err := h.applyReplication(r, peerName(r), replicationData); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BatchReplicationHandler applies a batch of mutations in order. A mutation
+// that cannot be applied is reported by its position in the batch but does
+// not stop the rest of the batch, since resending it would not change the
+// outcome. The sender dead-letters the mutations reported.
+func (h *Handler) BatchReplicationHandler(w http.ResponseWriter, r *http.Request) {
+	mutations, err := replication.DecodeBatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	peer := peerName(r)
+	failures := make([]replication.BatchFailure, 0)
+	for i, mutation := range mutations {
+		if _, err := h.applyReplication(r, peer, mutation); err != nil {
+			failures = append(failures, replication.BatchFailure{Index: i, Error: err.Error()})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replication.BatchResponse{
+		Applied: len(mutations) - len(failures),
+		Errors:  failures,
+	})
+}
+
+// applyReplication applies one replicated mutation from peer. Writes that
+// change the store are recorded in the audit log; stale ones discarded by
+// last-write-wins are not.
+func (h *Handler) applyReplication(r *http.Request, peer string, replicationData map[string]interface{}) (int, error) {
 	projectID, ok := replicationData["project"].(string)
 	if !ok {
-		http.Error(w, "Missing project ID", http.StatusBadRequest)
-		return
+		return http.StatusBadRequest, errors.New("Missing project ID")
+	}
+
+	operation, _ := replicationData["operation"].(string)
+	if isLifecycleOperation(operation) {
+		return h.applyReplicatedLifecycle(r, peer, operation, projectID, replicationData)
 	}
 
 	collectionID, ok := replicationData["collection"].(string)
 	if !ok {
-		http.Error(w, "Missing collection ID", http.StatusBadRequest)
-		return
+		return http.StatusBadRequest, errors.New("Missing collection ID")
 	}
 
 	docID, ok := replicationData["id"].(string)
 	if !ok {
-		http.Error(w, "Missing document ID", http.StatusBadRequest)
-		return
+		return http.StatusBadRequest, errors.New("Missing document ID")
 	}
 
-	operation, _ := replicationData["operation"].(string)
 	if operation == "delete" {
-		err := h.store.Delete(projectID, collectionID, docID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		deletedAt := time.Now().UTC()
+		if value, ok := replicationData["deleted_at"].(string); ok {
+			parsedTime, err := time.Parse(time.RFC3339, value)
+			if err == nil {
+				deletedAt = parsedTime
+			}
 		}
-		w.WriteHeader(http.StatusNoContent)
-		return
+
+		before := documentData(h.store.Get(projectID, collectionID, docID))
+		if err := h.store.ApplyDelete(projectID, collectionID, docID, deletedAt); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if before != nil && documentData(h.store.Get(projectID, collectionID, docID)) == nil {
+			h.record(r, audit.Entry{
+				Principal:  peer,
+				Action:     "replicate_delete",
+				Project:    projectID,
+				Collection: collectionID,
+				Document:   docID,
+				Diff:       audit.Diff(before, nil),
+			})
+		}
+		return http.StatusNoContent, nil
 	}
 
 	data, ok := replicationData["data"].(map[string]interface{})
 	if !ok {
-		http.Error(w, "Missing document data", http.StatusBadRequest)
-		return
+		return http.StatusBadRequest, errors.New("Missing document data")
 	}
 
 	doc := &models.Document{
@@ -178,13 +440,83 @@ func (h *Handler) ReplicationHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err := h.store.InsertWithID(projectID, collectionID, doc)
+	before := documentData(h.store.Get(projectID, collectionID, docID))
+	if err := h.store.InsertWithID(projectID, collectionID, doc); err != nil {
+		return documentErrorStatus(err), err
+	}
+	after := documentData(h.store.Get(projectID, collectionID, docID))
+	if diff := audit.Diff(before, after); after != nil && (before == nil || len(diff) > 0) {
+		h.record(r, audit.Entry{
+			Principal:  peer,
+			Action:     "replicate_upsert",
+			Project:    projectID,
+			Collection: collectionID,
+			Document:   docID,
+			Diff:       diff,
+		})
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// SnapshotHandler streams a consistent snapshot of the store as JSON Lines:
+// a header with the change log position followed by one line per chunk.
+func (h *Handler) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	chunkSize, _ := strconv.Atoi(r.URL.Query().Get("chunk_size"))
+	snapshot := h.store.Snapshot()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	if err := encoder.Encode(map[string]interface{}{"seq": snapshot.Seq}); err != nil {
+		return
+	}
+	snapshot.Chunks(chunkSize, func(chunk store.SnapshotChunk) error {
+		if err := encoder.Encode(chunk); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// ChangesHandler returns the changes after the "since" position, waiting up
+// to "wait" for new ones when there are none yet.
+func (h *Handler) ChangesHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	since, err := strconv.ParseUint(params.Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid since position", http.StatusBadRequest)
+		return
+	}
+
+	limit, _ := strconv.Atoi(params.Get("limit"))
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	if wait, err := time.ParseDuration(params.Get("wait")); err == nil && wait > 0 {
+		if wait > 30*time.Second {
+			wait = 30 * time.Second
+		}
+		h.store.WaitForChanges(since, wait)
+	}
+
+	changes, seq, err := h.store.ChangesSince(since, limit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), documentErrorStatus(err))
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes": changes,
+		"seq":     seq,
+	})
 }
 
 func (h *Handler) DeleteDocument(w http.ResponseWriter, r *http.Request) {
@@ -193,14 +525,10 @@ func (h *Handler) DeleteDocument(w http.ResponseWriter, r *http.Request) {
 	collectionID := vars["collection"]
 	documentID := vars["id"]
 
-	_, err := h.store.Get(projectID, collectionID, documentID)
+	before := documentData(h.store.Get(projectID, collectionID, documentID))
+	deletedAt, err := h.store.DeleteAs(h.scope(r, projectID, collectionID), projectID, collectionID, documentID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
-	}
-
-	if err := h.store.Delete(projectID, collectionID, documentID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), documentErrorStatus(err))
 		return
 	}
 
@@ -209,10 +537,17 @@ func (h *Handler) DeleteDocument(w http.ResponseWriter, r *http.Request) {
 		"project":    projectID,
 		"collection": collectionID,
 		"operation":  "delete",
+		"deleted_at": deletedAt,
 	}
 
-	peers := config.GetPeers()
-	replication.Replicate(peers, projectID, collectionID, documentID, replicationDoc)
+	h.replicator.Replicate(projectID, collectionID, documentID, replicationDoc)
+	h.record(r, audit.Entry{
+		Action:     "delete",
+		Project:    projectID,
+		Collection: collectionID,
+		Document:   documentID,
+		Diff:       audit.Diff(before, nil),
+	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -228,10 +563,25 @@ func (h *Handler) QueryDocuments(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := query.NewQuery().Validate(filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	at, ok, err := asOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	documents, err := h.store.Query(projectID, collectionID, filter)
+	var documents []*models.Document
+	if ok {
+		documents, err = h.store.QueryAt(h.scope(r, projectID, collectionID), projectID, collectionID, filter, at)
+	} else {
+		documents, err = h.store.QueryAs(h.scope(r, projectID, collectionID), projectID, collectionID, filter)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), documentErrorStatus(err))
 		return
 	}
 
@@ -241,3 +591,111 @@ func (h *Handler) QueryDocuments(w http.ResponseWriter, r *http.Request) {
 		"count":     len(documents),
 	})
 }
+
+// TailDocuments returns documents inserted after the "after" position in
+// insertion order, waiting up to "wait" for new ones when there are none
+// yet. Positions are local to the node serving the request.
+func (h *Handler) TailDocuments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+	params := r.URL.Query()
+
+	var after uint64
+	if value := params.Get("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "Invalid after position", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit, _ := strconv.Atoi(params.Get("limit"))
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	wait, err := time.ParseDuration(params.Get("wait"))
+	if err != nil || wait < 0 {
+		wait = 0
+	}
+	if wait > 30*time.Second {
+		wait = 30 * time.Second
+	}
+
+	entries, err := h.store.TailAs(h.scope(r, projectID, collectionID), projectID, collectionID, after, limit, wait)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	last := after
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].Seq
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"documents": entries,
+		"last":      last,
+	})
+}
+
+// documentErrorStatus maps errors of the store to a status code, for
+// document and lifecycle operations alike. Errors it does not know are
+// internal.
+func documentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrScopeViolation):
+		return http.StatusForbidden
+	case errors.As(err, new(*schema.ValidationError)):
+		return http.StatusUnprocessableEntity
+	case err == store.ErrDocumentTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case err == store.ErrVersioningDisabled, err == store.ErrTextIndexRequired, err == store.ErrTextSearchAsOf,
+		err == store.ErrGeoIndexRequired, err == store.ErrNearAsOf, err == store.ErrTextWithNear, err == store.ErrGeoNearKey,
+		err == store.ErrVectorIndexRequired, err == store.ErrVectorIndexName,
+		err == store.ErrNotTimeSeries, err == store.ErrTimeSeriesWrite, err == store.ErrTimeSeriesSettings,
+		errors.Is(err, store.ErrInvalidSettings), errors.Is(err, store.ErrInvalidGeometry), errors.Is(err, store.ErrInvalidVector),
+		errors.Is(err, store.ErrInvalidSample), errors.Is(err, store.ErrInvalidDownsample):
+		return http.StatusBadRequest
+	case err == store.ErrProjectNotFound, err == store.ErrCollectionNotFound, err == store.ErrDocumentNotFound,
+		err == store.ErrRevisionNotFound:
+		return http.StatusNotFound
+	case err == store.ErrProjectExists, err == store.ErrCollectionExists:
+		return http.StatusConflict
+	case err == store.ErrChangesTruncated:
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeDocumentError reports a failed document operation. Schema violations
+// are returned as JSON so clients can point at the offending fields.
+func writeDocumentError(w http.ResponseWriter, err error) {
+	var validationErr *schema.ValidationError
+	if errors.As(err, &validationErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  validationErr.Error(),
+			"errors": validationErr.Errors,
+		})
+		return
+	}
+	http.Error(w, err.Error(), documentErrorStatus(err))
+}
+
+// setSchemaWarnings reports the violations of a warn-only schema in the
+// X-Schema-Warnings header as a JSON array.
+func setSchemaWarnings(w http.ResponseWriter, warnings []schema.Error) {
+	if len(warnings) == 0 {
+		return
+	}
+	encoded, err := json.Marshal(warnings)
+	if err != nil {
+		return
+	}
+	w.Header().Set("X-Schema-Warnings", string(encoded))
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/itsyaboikris/go_document_store/store"
+)
+
+// DocumentHistory lists the revisions of a document, oldest first.
+func (h *Handler) DocumentHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+	documentID := vars["id"]
+
+	revisions, err := h.store.History(h.scope(r, projectID, collectionID), projectID, collectionID, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"revisions": revisions})
+}
+
+// DocumentRevision returns one revision of a document, by version or by the
+// RFC 3339 time it was written.
+func (h *Handler) DocumentRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+	documentID := vars["id"]
+	scope := h.scope(r, projectID, collectionID)
+
+	var revision store.Revision
+	var err error
+	if version, convErr := strconv.Atoi(vars["version"]); convErr == nil && version > 0 {
+		revision, err = h.store.Revision(scope, projectID, collectionID, documentID, version)
+	} else if updatedAt, timeErr := time.Parse(time.RFC3339Nano, vars["version"]); timeErr == nil {
+		revision, err = h.store.RevisionWrittenAt(scope, projectID, collectionID, documentID, updatedAt)
+	} else {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revision)
+}
+
+// asOf parses the optional "asOf" query parameter as an RFC 3339 time.
+func asOf(r *http.Request) (time.Time, bool, error) {
+	value := r.URL.Query().Get("asOf")
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false, errors.New("invalid asOf time")
+	}
+	return at, true, nil
+}
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/itsyaboikris/go_document_store/audit"
+	"github.com/itsyaboikris/go_document_store/auth"
+	"github.com/itsyaboikris/go_document_store/store"
+)
+
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *Handler) ListProjects(w http.ResponseWriter, r *http.Request) {
+	projects := make([]string, 0)
+	for _, projectID := range h.store.ListProjects() {
+		if h.authenticator == nil || auth.PrincipalFrom(r.Context()).CanAccessProject(projectID) {
+			projects = append(projects, projectID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"projects": projects})
+}
+
+func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["project"]
+
+	project, err := h.store.CreateProject(projectID)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	h.replicator.ReplicateLifecycle(store.ChangeCreateProject, projectID, "", map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+	})
+	h.record(r, audit.Entry{Action: store.ChangeCreateProject, Project: projectID})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"_id": project.ID})
+}
+
+func (h *Handler) DropProject(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["project"]
+
+	droppedAt, err := h.store.DropProject(projectID)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	h.replicator.ReplicateLifecycle(store.ChangeDropProject, projectID, "", map[string]interface{}{
+		"timestamp": droppedAt,
+	})
+	h.record(r, audit.Entry{Action: store.ChangeDropProject, Project: projectID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RenameProject(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["project"]
+
+	var req renameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Missing new name", http.StatusBadRequest)
+		return
+	}
+	if !h.allowed(r, req.Name, "", auth.RoleAdmin) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	renamedAt, err := h.store.RenameProject(projectID, req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	h.replicator.ReplicateLifecycle(store.ChangeRenameProject, projectID, "", map[string]interface{}{
+		"name":      req.Name,
+		"timestamp": renamedAt,
+	})
+	h.record(r, audit.Entry{
+		Action:  store.ChangeRenameProject,
+		Project: projectID,
+		Details: lifecycleDetails(req.Name, nil),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"_id": req.Name})
+}
+
+func (h *Handler) ListCollections(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["project"]
+
+	if h.authenticator != nil && !auth.PrincipalFrom(r.Context()).CanAccessProject(projectID) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	collections, err := h.store.ListCollections(projectID)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	visible := make([]store.CollectionInfo, 0, len(collections))
+	for _, collection := range collections {
+		if h.allowed(r, projectID, collection.ID, auth.RoleRead) {
+			visible = append(visible, collection)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"collections": visible})
+}
+
+func (h *Handler) GetCollection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	settings, err := h.store.GetSettings(projectID, collectionID)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"_id":      collectionID,
+		"settings": settings,
+	})
+}
+
+// CreateCollection creates a collection in an existing project. The body is
+// optional and holds the collection settings.
+func (h *Handler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var settings store.CollectionSettings
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	collection, err := h.store.CreateCollection(projectID, collectionID, settings)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	h.replicator.ReplicateLifecycle(store.ChangeCreateCollection, projectID, collectionID, map[string]interface{}{
+		"settings":  collection.Settings,
+		"timestamp": time.Now().UTC(),
+	})
+	h.record(r, audit.Entry{
+		Action:     store.ChangeCreateCollection,
+		Project:    projectID,
+		Collection: collectionID,
+		Details:    lifecycleDetails("", &collection.Settings),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"_id":      collection.ID,
+		"settings": collection.Settings,
+	})
+}
+
+func (h *Handler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var settings store.CollectionSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	previous, _ := h.store.GetSettings(projectID, collectionID)
+	collection, err := h.store.UpdateSettings(projectID, collectionID, settings)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	h.replicator.ReplicateLifecycle(store.ChangeUpdateSettings, projectID, collectionID, map[string]interface{}{
+		"settings":  collection.Settings,
+		"timestamp": time.Now().UTC(),
+	})
+	h.record(r, audit.Entry{
+		Action:     store.ChangeUpdateSettings,
+		Project:    projectID,
+		Collection: collectionID,
+		Details: map[string]interface{}{
+			"before": previous,
+			"after":  collection.Settings,
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"_id":      collection.ID,
+		"settings": collection.Settings,
+	})
+}
+
+func (h *Handler) DropCollection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	droppedAt, err := h.store.DropCollection(projectID, collectionID)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	h.replicator.ReplicateLifecycle(store.ChangeDropCollection, projectID, collectionID, map[string]interface{}{
+		"timestamp": droppedAt,
+	})
+	h.record(r, audit.Entry{Action: store.ChangeDropCollection, Project: projectID, Collection: collectionID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RenameCollection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var req renameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Missing new name", http.StatusBadRequest)
+		return
+	}
+	if !h.allowed(r, projectID, req.Name, auth.RoleAdmin) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	renamedAt, err := h.store.RenameCollection(projectID, collectionID, req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	h.replicator.ReplicateLifecycle(store.ChangeRenameCollection, projectID, collectionID, map[string]interface{}{
+		"name":      req.Name,
+		"timestamp": renamedAt,
+	})
+	h.record(r, audit.Entry{
+		Action:     store.ChangeRenameCollection,
+		Project:    projectID,
+		Collection: collectionID,
+		Details:    lifecycleDetails(req.Name, nil),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"_id": req.Name})
+}
+
+func isLifecycleOperation(operation string) bool {
+	switch operation {
+	case store.ChangeCreateProject, store.ChangeDropProject, store.ChangeRenameProject,
+		store.ChangeCreateCollection, store.ChangeDropCollection, store.ChangeRenameCollection,
+		store.ChangeUpdateSettings:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *Handler) applyReplicatedLifecycle(r *http.Request, peer, operation, projectID string, replicationData map[string]interface{}) (int, error) {
+	change := store.Change{
+		Operation: operation,
+		Project:   projectID,
+	}
+	change.Collection, _ = replicationData["collection"].(string)
+	change.Name, _ = replicationData["name"].(string)
+
+	if timestamp, ok := replicationData["timestamp"].(string); ok {
+		parsedTime, err := time.Parse(time.RFC3339, timestamp)
+		if err == nil {
+			change.Timestamp = parsedTime
+		}
+	}
+
+	if rawSettings, ok := replicationData["settings"]; ok && rawSettings != nil {
+		var settings store.CollectionSettings
+		if err := remarshal(rawSettings, &settings); err != nil {
+			return http.StatusBadRequest, err
+		}
+		change.Settings = &settings
+	}
+
+	if err := h.store.ApplyChange(change); err != nil {
+		return documentErrorStatus(err), err
+	}
+	h.record(r, audit.Entry{
+		Principal:  peer,
+		Action:     "replicate_" + operation,
+		Project:    projectID,
+		Collection: change.Collection,
+		Details:    lifecycleDetails(change.Name, change.Settings),
+	})
+
+	return http.StatusNoContent, nil
+}
+
+// lifecycleDetails describes the new name or settings of a lifecycle
+// operation for the audit log.
+func lifecycleDetails(name string, settings *store.CollectionSettings) map[string]interface{} {
+	details := make(map[string]interface{})
+	if name != "" {
+		details["name"] = name
+	}
+	if settings != nil {
+		details["settings"] = settings
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	return details
+}
+
+// remarshal converts a decoded JSON value into a typed value.
+func remarshal(value interface{}, target interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
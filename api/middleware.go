@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/itsyaboikris/go_document_store/auth"
+	"github.com/itsyaboikris/go_document_store/store"
+)
+
+// authenticate resolves the principal of the request and stores it in the
+// request context. Handlers wrapped only by authenticate check their own
+// permissions.
+func (h *Handler) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.authenticator == nil {
+			next(w, r)
+			return
+		}
+
+		principal, err := h.authenticator.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go_document_store"`)
+			http.Error(w, auth.ErrUnauthenticated.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}
+}
+
+// authorize authenticates the request and requires the principal to hold at
+// least role on the {project} and {collection} of the route.
+func (h *Handler) authorize(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.authenticator != nil {
+			vars := mux.Vars(r)
+			if !auth.PrincipalFrom(r.Context()).Allows(vars["project"], vars["collection"], role) {
+				http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		next(w, r)
+	})
+}
+
+// requireClusterAdmin only lets principals with an admin grant on every
+// project through.
+func (h *Handler) requireClusterAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if !h.allowed(r, auth.AllProjects, "", auth.RoleAdmin) {
+			http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// allowed reports whether the request's principal holds role on the
+// collection. It is always true when authentication is disabled.
+func (h *Handler) allowed(r *http.Request, projectID, collectionID string, role auth.Role) bool {
+	if h.authenticator == nil {
+		return true
+	}
+	return auth.PrincipalFrom(r.Context()).Allows(projectID, collectionID, role)
+}
+
+// scope describes the request's principal to the store so the collection's
+// security filters apply. It is nil, and so unrestricted, when
+// authentication is disabled.
+func (h *Handler) scope(r *http.Request, projectID, collectionID string) *store.Scope {
+	if h.authenticator == nil {
+		return nil
+	}
+
+	principal := auth.PrincipalFrom(r.Context())
+	if principal == nil {
+		return &store.Scope{}
+	}
+	return &store.Scope{
+		Role: string(principal.RoleOn(projectID, collectionID)),
+		User: principal.Attributes,
+	}
+}
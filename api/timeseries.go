@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/itsyaboikris/go_document_store/audit"
+	"github.com/itsyaboikris/go_document_store/query"
+	"github.com/itsyaboikris/go_document_store/schema"
+	"github.com/itsyaboikris/go_document_store/store"
+)
+
+type insertSamplesRequest struct {
+	Samples []map[string]interface{} `json:"samples"`
+}
+
+// sampleRangeRequest selects samples by time, from inclusive to exclusive,
+// and by a filter on their fields.
+type sampleRangeRequest struct {
+	From   time.Time              `json:"from"`
+	To     time.Time              `json:"to"`
+	Filter map[string]interface{} `json:"filter"`
+}
+
+type downsampleRequest struct {
+	sampleRangeRequest
+	Every  string                 `json:"every"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// InsertSamples adds samples to a time-series collection. Either all of
+// them are stored or none is. The buckets they were written to are
+// replicated like documents.
+func (h *Handler) InsertSamples(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var req insertSamplesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Samples) == 0 {
+		http.Error(w, "Missing samples", http.StatusBadRequest)
+		return
+	}
+
+	buckets, err := h.store.InsertSamples(h.scope(r, projectID, collectionID), projectID, collectionID, req.Samples)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+
+	ids := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		ids[i] = bucket.ID
+		h.replicator.Replicate(projectID, collectionID, bucket.ID, map[string]interface{}{
+			"id":         bucket.ID,
+			"data":       bucket.Data,
+			"project":    projectID,
+			"collection": collectionID,
+			"created_at": bucket.CreatedAt,
+			"updated_at": bucket.UpdatedAt,
+		})
+	}
+	h.record(r, audit.Entry{
+		Action:     "insert_samples",
+		Project:    projectID,
+		Collection: collectionID,
+		Details: map[string]interface{}{
+			"samples": len(req.Samples),
+			"buckets": ids,
+		},
+	})
+
+	var warnings []schema.Error
+	for i, sample := range req.Samples {
+		for _, warning := range h.store.SchemaWarnings(projectID, collectionID, sample) {
+			warning.Path = "/" + strconv.Itoa(i) + strings.TrimSuffix(warning.Path, "/")
+			warnings = append(warnings, warning)
+		}
+	}
+
+	setSchemaWarnings(w, warnings)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"inserted": len(req.Samples),
+		"buckets":  len(buckets),
+	})
+}
+
+// QuerySamples returns the samples of a time-series collection taken in a
+// time range, series by series and oldest first.
+func (h *Handler) QuerySamples(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var req sampleRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := query.NewQuery().Validate(req.Filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	samples, err := h.store.QuerySamplesAs(h.scope(r, projectID, collectionID), projectID, collectionID, req.sampleRange(), req.Filter)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"samples": samples,
+		"count":   len(samples),
+	})
+}
+
+// DownsampleSamples folds the samples of a time-series collection into
+// fixed windows per series.
+func (h *Handler) DownsampleSamples(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID := vars["project"]
+	collectionID := vars["collection"]
+
+	var req downsampleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := query.NewQuery().Validate(req.Filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	every, err := time.ParseDuration(req.Every)
+	if err != nil {
+		http.Error(w, "Invalid every: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Fields) == 0 {
+		http.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.store.DownsampleAs(h.scope(r, projectID, collectionID), projectID, collectionID, req.sampleRange(), req.Filter, every, req.Fields)
+	if err != nil {
+		http.Error(w, err.Error(), documentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+		"count":   len(results),
+	})
+}
+
+func (req sampleRangeRequest) sampleRange() store.SampleRange {
+	return store.SampleRange{From: req.From, To: req.To}
+}
@@ -0,0 +1,203 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// Entry records one mutation or admin action.
+type Entry struct {
+	Time       time.Time              `json:"time"`
+	Principal  string                 `json:"principal"`
+	Action     string                 `json:"action"`
+	Method     string                 `json:"method,omitempty"`
+	Route      string                 `json:"route,omitempty"`
+	Project    string                 `json:"project,omitempty"`
+	Collection string                 `json:"collection,omitempty"`
+	Document   string                 `json:"document,omitempty"`
+	Diff       map[string]FieldChange `json:"diff,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// FieldChange holds the value of a top-level document field before and
+// after a write. A nil side means the field was absent.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Criteria selects entries of the log. Filter uses the query syntax against
+// the JSON form of an entry.
+type Criteria struct {
+	Filter map[string]interface{}
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Log is an append-only audit log stored as JSON Lines. Entries are never
+// rewritten; reading scans the file.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	querier *query.Query
+}
+
+func Open(path string) (*Log, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create audit log directory: %v", err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+
+	return &Log{path: path, file: file, querier: query.NewQuery()}, nil
+}
+
+func (l *Log) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// Query returns the entries matching the criteria, oldest first.
+func (l *Log) Query(criteria Criteria) ([]Entry, error) {
+	entries := []Entry{}
+	err := l.scan(criteria, func(line []byte) error {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Export writes the entries matching the criteria to w as JSON Lines.
+func (l *Log) Export(w io.Writer, criteria Criteria) error {
+	return l.scan(criteria, func(line []byte) error {
+		_, err := w.Write(line)
+		return err
+	})
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// scan calls fn with every line, including its newline, that matches the
+// criteria.
+func (l *Log) scan(criteria Criteria, fn func(line []byte) error) error {
+	if err := l.querier.Validate(criteria.Filter); err != nil {
+		return err
+	}
+	filter, err := l.querier.Compile(criteria.Filter)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	matched := 0
+	for criteria.Limit <= 0 || matched < criteria.Limit {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial last line is an entry still being written.
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log: %v", err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		ok, err := matches(line, criteria, filter)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := fn(line); err != nil {
+			return err
+		}
+		matched++
+	}
+	return nil
+}
+
+func matches(line []byte, criteria Criteria, filter *query.Filter) (bool, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(line, &data); err != nil {
+		return false, fmt.Errorf("corrupt audit log entry: %v", err)
+	}
+
+	if !criteria.Since.IsZero() || !criteria.Until.IsZero() {
+		value, _ := data["time"].(string)
+		at, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return false, nil
+		}
+		if !criteria.Since.IsZero() && at.Before(criteria.Since) {
+			return false, nil
+		}
+		if !criteria.Until.IsZero() && !at.Before(criteria.Until) {
+			return false, nil
+		}
+	}
+
+	return filter.Matches(data), nil
+}
+
+// Diff compares the top-level fields of two versions of a document. Either
+// side may be nil for a create or a delete.
+func Diff(before, after map[string]interface{}) map[string]FieldChange {
+	diff := make(map[string]FieldChange)
+	for key, value := range before {
+		if next, exists := after[key]; !exists || !reflect.DeepEqual(value, next) {
+			diff[key] = FieldChange{Before: value, After: after[key]}
+		}
+	}
+	for key, value := range after {
+		if _, exists := before[key]; !exists {
+			diff[key] = FieldChange{After: value}
+		}
+	}
+	return diff
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// keyRefreshInterval is the least time between two reloads of a key set
+// file, so tokens with made-up key IDs cannot make every request read it.
+const keyRefreshInterval = 30 * time.Second
+
+// JWK is the subset of RFC 7517 fields needed for HS256, RS256 and EdDSA
+// keys.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	K   string `json:"k,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type verificationKey struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// KeySet holds the keys tokens may be signed with. Every key is bound to
+// one algorithm so a token cannot pick a weaker one for the same key.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []verificationKey
+
+	// path is the file the set was loaded from, if any. A token with a key
+	// ID the set does not hold reloads it, at most once per
+	// keyRefreshInterval, so keys the issuer rotates in are picked up
+	// without a restart.
+	path      string
+	refreshed time.Time
+}
+
+func LoadKeySet(path string) (*KeySet, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key set: %v", err)
+	}
+	ks, err := ParseKeySet(raw)
+	if err != nil {
+		return nil, err
+	}
+	ks.path = path
+	ks.refreshed = time.Now()
+	return ks, nil
+}
+
+func ParseKeySet(raw []byte) (*KeySet, error) {
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse key set: %v", err)
+	}
+
+	ks := &KeySet{}
+	for i, jwk := range set.Keys {
+		key, err := jwk.verificationKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		ks.keys = append(ks.keys, key)
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, errors.New("key set is empty")
+	}
+	return ks, nil
+}
+
+// keysFor returns the keys that may verify a token with the given header,
+// reloading the key set file first when it holds no key with the token's
+// key ID.
+func (ks *KeySet) keysFor(alg, kid string, now time.Time) []verificationKey {
+	ks.mu.RLock()
+	keys := ks.candidates(alg, kid)
+	ks.mu.RUnlock()
+	if len(keys) > 0 || kid == "" || ks.path == "" {
+		return keys
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if now.Sub(ks.refreshed) < keyRefreshInterval {
+		return ks.candidates(alg, kid)
+	}
+	ks.refreshed = now
+	reloaded, err := LoadKeySet(ks.path)
+	if err != nil {
+		// The keys already loaded stay in use until the file is fixed.
+		return nil
+	}
+	ks.keys = reloaded.keys
+	return ks.candidates(alg, kid)
+}
+
+// candidates returns the keys that may verify a token with the given header.
+// The caller must hold the lock.
+func (ks *KeySet) candidates(alg, kid string) []verificationKey {
+	var keys []verificationKey
+	for _, key := range ks.keys {
+		if key.alg != alg {
+			continue
+		}
+		if kid != "" && key.kid != "" && key.kid != kid {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (jwk JWK) verificationKey() (verificationKey, error) {
+	switch jwk.Kty {
+	case "oct":
+		if jwk.Alg != "" && jwk.Alg != "HS256" {
+			return verificationKey{}, fmt.Errorf("unsupported algorithm %q for oct key", jwk.Alg)
+		}
+		secret, err := decodeSegment(jwk.K)
+		if err != nil || len(secret) == 0 {
+			return verificationKey{}, errors.New("invalid oct key")
+		}
+		return verificationKey{kid: jwk.Kid, alg: "HS256", key: secret}, nil
+
+	case "RSA":
+		if jwk.Alg != "" && jwk.Alg != "RS256" {
+			return verificationKey{}, fmt.Errorf("unsupported algorithm %q for RSA key", jwk.Alg)
+		}
+		n, err := decodeSegment(jwk.N)
+		if err != nil || len(n) == 0 {
+			return verificationKey{}, errors.New("invalid RSA modulus")
+		}
+		e, err := decodeSegment(jwk.E)
+		if err != nil || len(e) == 0 {
+			return verificationKey{}, errors.New("invalid RSA exponent")
+		}
+		publicKey := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if publicKey.N.BitLen() < 2048 {
+			return verificationKey{}, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return verificationKey{kid: jwk.Kid, alg: "RS256", key: publicKey}, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return verificationKey{}, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeSegment(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return verificationKey{}, errors.New("invalid Ed25519 key")
+		}
+		return verificationKey{kid: jwk.Kid, alg: "EdDSA", key: ed25519.PublicKey(x)}, nil
+	}
+
+	return verificationKey{}, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(segment)
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// clockSkew is tolerated on the exp, nbf and iat claims.
+const clockSkew = time.Minute
+
+// JWTOptions restrict which tokens are accepted beyond a valid signature.
+type JWTOptions struct {
+	Issuer   string
+	Audience string
+}
+
+// JWTAuthenticator validates bearer tokens signed by a key of a local key
+// set. Tokens never trigger a network call.
+//
+// Grants come from the "projects" claim, which maps "project" or
+// "project/collection" to a role or a list of roles, and from a space
+// separated "scope" claim of "project:role" or "project/collection:role"
+// entries. All claims become attributes of the principal, with "id" set to
+// the subject.
+type JWTAuthenticator struct {
+	keys *KeySet
+	opts JWTOptions
+}
+
+func NewJWTAuthenticator(keys *KeySet, opts JWTOptions) *JWTAuthenticator {
+	return &JWTAuthenticator{keys: keys, opts: opts}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string                 `json:"sub"`
+	Name      string                 `json:"name"`
+	Issuer    string                 `json:"iss"`
+	Audience  interface{}            `json:"aud"`
+	ExpiresAt *float64               `json:"exp"`
+	NotBefore *float64               `json:"nbf"`
+	IssuedAt  *float64               `json:"iat"`
+	Projects  map[string]interface{} `json:"projects"`
+	Scope     string                 `json:"scope"`
+}
+
+func (ja *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := BearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		return nil, ErrUnauthenticated
+	}
+
+	claims, attributes, err := ja.verify(token, time.Now())
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	grants, err := claims.grants()
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Subject
+	}
+	attributes["id"] = claims.Subject
+	return &Principal{ID: "jwt:" + claims.Subject, Name: name, Grants: grants, Attributes: attributes}, nil
+}
+
+// verify checks the token and returns its registered claims together with
+// the full claim set, which becomes the principal's attributes.
+func (ja *JWTAuthenticator) verify(token string, now time.Time) (*jwtClaims, map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	var header jwtHeader
+	if err := decodeJSONSegment(parts[0], &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid header: %v", err)
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range ja.keys.keysFor(header.Alg, header.Kid, now) {
+		if key.verify(signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, nil, fmt.Errorf("signature verification failed")
+	}
+
+	var claims jwtClaims
+	if err := decodeJSONSegment(parts[1], &claims); err != nil {
+		return nil, nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	if err := claims.validate(ja.opts, now); err != nil {
+		return nil, nil, err
+	}
+
+	var attributes map[string]interface{}
+	if err := decodeJSONSegment(parts[1], &attributes); err != nil {
+		return nil, nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	return &claims, attributes, nil
+}
+
+func (key verificationKey) verify(signed, signature []byte) bool {
+	switch key.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.key.([]byte))
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key.key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	case "EdDSA":
+		return ed25519.Verify(key.key.(ed25519.PublicKey), signed, signature)
+	}
+	return false
+}
+
+func (c *jwtClaims) validate(opts JWTOptions, now time.Time) error {
+	if c.Subject == "" {
+		return fmt.Errorf("token has no subject")
+	}
+	if c.ExpiresAt == nil {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(unixTime(*c.ExpiresAt).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if c.NotBefore != nil && now.Add(clockSkew).Before(unixTime(*c.NotBefore)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	if c.IssuedAt != nil && now.Add(clockSkew).Before(unixTime(*c.IssuedAt)) {
+		return fmt.Errorf("token issued in the future")
+	}
+	if opts.Issuer != "" && c.Issuer != opts.Issuer {
+		return fmt.Errorf("unexpected issuer %q", c.Issuer)
+	}
+	if opts.Audience != "" && !c.hasAudience(opts.Audience) {
+		return fmt.Errorf("token not issued for this audience")
+	}
+	return nil
+}
+
+func (c *jwtClaims) hasAudience(audience string) bool {
+	switch aud := c.Audience.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// grants maps the projects and scope claims to grants. Unknown roles make
+// the token invalid instead of being silently ignored.
+func (c *jwtClaims) grants() ([]Grant, error) {
+	var grants []Grant
+
+	for target, value := range c.Projects {
+		var roles []string
+		switch v := value.(type) {
+		case string:
+			roles = []string{v}
+		case []interface{}:
+			for _, role := range v {
+				name, ok := role.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid role for %q", target)
+				}
+				roles = append(roles, name)
+			}
+		default:
+			return nil, fmt.Errorf("invalid roles for %q", target)
+		}
+
+		for _, role := range roles {
+			grant, err := parseGrant(target, role)
+			if err != nil {
+				return nil, err
+			}
+			grants = append(grants, grant)
+		}
+	}
+
+	for _, entry := range strings.Fields(c.Scope) {
+		target, role, ok := strings.Cut(entry, ":")
+		if !ok {
+			// Scopes meant for other services are not ours to reject.
+			continue
+		}
+		grant, err := parseGrant(target, role)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, nil
+}
+
+func parseGrant(target, role string) (Grant, error) {
+	project, collection, _ := strings.Cut(target, "/")
+	grant := Grant{Project: project, Collection: collection, Role: Role(role)}
+	if grant.Project == "" || !grant.Role.Valid() {
+		return Grant{}, fmt.Errorf("invalid grant %q:%q", target, role)
+	}
+	return grant, nil
+}
+
+func decodeJSONSegment(segment string, v interface{}) error {
+	raw, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
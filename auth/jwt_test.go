@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signer signs tokens with one key.
+type signer struct {
+	alg string
+	kid string
+	key interface{}
+}
+
+func (s signer) token(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]interface{}{"alg": s.alg, "typ": "JWT"}
+	if s.kid != "" {
+		header["kid"] = s.kid
+	}
+	signed := segment(t, header) + "." + segment(t, claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(s.sign(t, []byte(signed)))
+}
+
+func (s signer) sign(t *testing.T, signed []byte) []byte {
+	t.Helper()
+	switch key := s.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(signed)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, signed)
+	case nil:
+		return nil
+	}
+	t.Fatalf("unsupported key %T", s.key)
+	return nil
+}
+
+func segment(t *testing.T, value interface{}) string {
+	t.Helper()
+	raw, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK{Kty: "RSA", Kid: kid, N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func keySetJSON(t *testing.T, keys ...JWK) []byte {
+	t.Helper()
+	raw, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// testKeys are one key of every supported type and the key set holding
+// their public halves.
+type testKeys struct {
+	hs, rs, ed signer
+	rsaPublic  *rsa.PublicKey
+	set        []JWK
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{
+		hs:        signer{alg: "HS256", kid: "hs", key: secret},
+		rs:        signer{alg: "RS256", kid: "rs", key: rsaKey},
+		ed:        signer{alg: "EdDSA", kid: "ed", key: edPrivate},
+		rsaPublic: &rsaKey.PublicKey,
+		set: []JWK{
+			{Kty: "oct", Kid: "hs", K: b64(secret)},
+			rsaJWK("rs", &rsaKey.PublicKey),
+			{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: b64(edPublic)},
+		},
+	}
+}
+
+func authenticate(ja *JWTAuthenticator, token string) (*Principal, error) {
+	r, _ := http.NewRequest(http.MethodGet, "/p/c/document", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return ja.Authenticate(r)
+}
+
+func TestJWTVerification(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseKeySet(keySetJSON(t, keys.set...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja := NewJWTAuthenticator(set, JWTOptions{Issuer: "issuer", Audience: "docs"})
+
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":      "alice",
+			"iss":      "issuer",
+			"aud":      "docs",
+			"exp":      now.Add(time.Hour).Unix(),
+			"projects": map[string]interface{}{"shop": "read"},
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(keys.rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"HS256", keys.hs.token(t, claims(nil)), true},
+		{"RS256", keys.rs.token(t, claims(nil)), true},
+		{"EdDSA", keys.ed.token(t, claims(nil)), true},
+		{"without kid", signer{alg: "EdDSA", key: keys.ed.key}.token(t, claims(nil)), true},
+
+		{"alg none", signer{alg: "none"}.token(t, claims(nil)), false},
+		{"alg none with kid", signer{alg: "none", kid: "hs"}.token(t, claims(nil)), false},
+		{"HS256 signed with the RSA public key", signer{alg: "HS256", kid: "rs", key: publicDER}.token(t, claims(nil)), false},
+		{"HS256 signed with the RSA modulus", signer{alg: "HS256", kid: "rs", key: keys.rsaPublic.N.Bytes()}.token(t, claims(nil)), false},
+		{"RS256 header on an EdDSA signature", signer{alg: "RS256", kid: "ed", key: keys.ed.key}.token(t, claims(nil)), false},
+		{"kid of another key", signer{alg: "HS256", kid: "rs", key: keys.hs.key}.token(t, claims(nil)), false},
+		{"unknown kid", signer{alg: "HS256", kid: "gone", key: keys.hs.key}.token(t, claims(nil)), false},
+		{"unknown HMAC secret", signer{alg: "HS256", key: []byte("another secret")}.token(t, claims(nil)), false},
+		{"tampered claims", tamper(keys.hs.token(t, claims(nil)), claims(map[string]interface{}{"projects": map[string]interface{}{"shop": "admin"}})), false},
+
+		{"missing exp", keys.hs.token(t, claims(map[string]interface{}{"exp": nil})), false},
+		{"expired", keys.hs.token(t, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), false},
+		{"expired within the skew", keys.hs.token(t, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), true},
+		{"not valid yet", keys.hs.token(t, claims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()})), false},
+		{"nbf within the skew", keys.hs.token(t, claims(map[string]interface{}{"nbf": now.Add(30 * time.Second).Unix()})), true},
+		{"nbf passed", keys.hs.token(t, claims(map[string]interface{}{"nbf": now.Add(-time.Minute).Unix()})), true},
+		{"issued in the future", keys.hs.token(t, claims(map[string]interface{}{"iat": now.Add(2 * time.Minute).Unix()})), false},
+		{"missing sub", keys.hs.token(t, claims(map[string]interface{}{"sub": nil})), false},
+
+		{"other issuer", keys.hs.token(t, claims(map[string]interface{}{"iss": "someone"})), false},
+		{"missing issuer", keys.hs.token(t, claims(map[string]interface{}{"iss": nil})), false},
+		{"other audience", keys.hs.token(t, claims(map[string]interface{}{"aud": "billing"})), false},
+		{"audience list", keys.hs.token(t, claims(map[string]interface{}{"aud": []string{"billing", "docs"}})), true},
+		{"audience list without us", keys.hs.token(t, claims(map[string]interface{}{"aud": []string{"billing"}})), false},
+		{"missing audience", keys.hs.token(t, claims(map[string]interface{}{"aud": nil})), false},
+
+		{"unknown role", keys.hs.token(t, claims(map[string]interface{}{"projects": map[string]interface{}{"shop": "owner"}})), false},
+		{"malformed token", "a.b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := authenticate(ja, tt.token)
+			if tt.ok && err != nil {
+				t.Fatalf("rejected a valid token: %v", err)
+			}
+			if !tt.ok {
+				if err != ErrUnauthenticated {
+					t.Fatalf("err = %v, want ErrUnauthenticated", err)
+				}
+				return
+			}
+			if principal.ID != "jwt:alice" || principal.Attributes["id"] != "alice" {
+				t.Errorf("principal = %+v, want alice", principal)
+			}
+		})
+	}
+}
+
+// tamper replaces the claims of a signed token and keeps its signature.
+func tamper(token string, claims map[string]interface{}) string {
+	parts := strings.Split(token, ".")
+	raw, _ := json.Marshal(claims)
+	return parts[0] + "." + b64(raw) + "." + parts[2]
+}
+
+func TestJWTGrants(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseKeySet(keySetJSON(t, keys.set...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja := NewJWTAuthenticator(set, JWTOptions{})
+
+	principal, err := authenticate(ja, keys.ed.token(t, map[string]interface{}{
+		"sub":      "bob",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"projects": map[string]interface{}{"shop": []string{"read"}, "crm/contacts": "write"},
+		"scope":    "openid billing:admin profile",
+		"tenant":   "acme",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		project, collection string
+		role                Role
+		want                bool
+	}{
+		{"shop", "orders", RoleRead, true},
+		{"shop", "orders", RoleWrite, false},
+		{"crm", "contacts", RoleWrite, true},
+		{"crm", "leads", RoleRead, false},
+		{"billing", "invoices", RoleAdmin, true},
+		{"other", "things", RoleRead, false},
+	}
+	for _, tt := range tests {
+		if got := principal.Allows(tt.project, tt.collection, tt.role); got != tt.want {
+			t.Errorf("Allows(%s, %s, %s) = %v, want %v", tt.project, tt.collection, tt.role, got, tt.want)
+		}
+	}
+	if principal.Attributes["tenant"] != "acme" {
+		t.Errorf("attributes = %v, want the tenant claim", principal.Attributes)
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newTestKeys(t)
+
+	tests := []struct {
+		name string
+		key  JWK
+		ok   bool
+	}{
+		{"RSA 2048", rsaJWK("rs", keys.rsaPublic), true},
+		{"RSA 1024", rsaJWK("small", &small.PublicKey), false},
+		{"RSA bound to HS256", JWK{Kty: "RSA", Alg: "HS256", N: rsaJWK("", keys.rsaPublic).N, E: "AQAB"}, false},
+		{"oct bound to RS256", JWK{Kty: "oct", Alg: "RS256", K: b64([]byte("secret"))}, false},
+		{"empty oct", JWK{Kty: "oct"}, false},
+		{"P-256", JWK{Kty: "EC", Crv: "P-256"}, false},
+		{"X25519", JWK{Kty: "OKP", Crv: "X25519", X: keys.set[2].X}, false},
+		{"short Ed25519", JWK{Kty: "OKP", Crv: "Ed25519", X: b64([]byte("short"))}, false},
+	}
+	for _, tt := range tests {
+		_, err := ParseKeySet(keySetJSON(t, tt.key))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+	if _, err := ParseKeySet([]byte(`{"keys": []}`)); err == nil {
+		t.Error("accepted an empty key set")
+	}
+}
+
+func TestKeySetRefresh(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keySetJSON(t, keys.set[0]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadKeySet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja := NewJWTAuthenticator(set, JWTOptions{})
+	token := keys.rs.token(t, map[string]interface{}{"sub": "carol", "exp": time.Now().Add(time.Hour).Unix()})
+
+	if _, err := authenticate(ja, token); err != ErrUnauthenticated {
+		t.Fatalf("token of an unknown key: err = %v, want ErrUnauthenticated", err)
+	}
+
+	// The issuer rotates a key in. The file is not read again right away,
+	// so unknown key IDs cannot make every request reload it.
+	if err := os.WriteFile(path, keySetJSON(t, keys.set...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	set.refreshed = time.Now()
+	if _, err := authenticate(ja, token); err != ErrUnauthenticated {
+		t.Fatalf("key set reloaded within the refresh interval: err = %v", err)
+	}
+
+	set.refreshed = time.Now().Add(-keyRefreshInterval)
+	if _, err := authenticate(ja, token); err != nil {
+		t.Fatalf("token of a rotated-in key: %v", err)
+	}
+
+	// A broken file keeps the loaded keys.
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	set.refreshed = time.Now().Add(-keyRefreshInterval)
+	unknown := signer{alg: "HS256", kid: "new", key: keys.hs.key}.token(t, map[string]interface{}{"sub": "carol", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := authenticate(ja, unknown); err != ErrUnauthenticated {
+		t.Fatalf("token of an unknown key: err = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := authenticate(ja, token); err != nil {
+		t.Fatalf("known key stopped working after a failed reload: %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/store"
+)
+
+const (
+	// SystemProject holds the store's own data, such as API keys. No grant
+	// covers it; cluster administrators manage keys through the key routes.
+	SystemProject  = "_system"
+	KeysCollection = "api_keys"
+
+	keyPrefix = "dsk_"
+)
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+// APIKey is the stored form of a key. Only a hash of the secret is kept;
+// the full key is shown once when it is created or rotated.
+type APIKey struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Hash   string  `json:"hash,omitempty"`
+	Grants []Grant `json:"grants"`
+	// Attributes are exposed to security filters as "$$user.<name>".
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Revoked    bool                   `json:"revoked"`
+	CreatedAt  time.Time              `json:"created_at"`
+	RotatedAt  time.Time              `json:"rotated_at,omitempty"`
+}
+
+type KeyStore struct {
+	store   *store.DocumentStore
+	rootKey string
+}
+
+// NewKeyStore keeps API keys in the system project of ds. A non-empty
+// rootKey is accepted as a cluster administrator key without being stored.
+func NewKeyStore(ds *store.DocumentStore, rootKey string) *KeyStore {
+	return &KeyStore{store: ds, rootKey: rootKey}
+}
+
+// Authenticate accepts "Authorization: Bearer <key>" or "X-API-Key: <key>".
+func (ks *KeyStore) Authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		token = BearerToken(r)
+	}
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	if ks.rootKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(ks.rootKey)) == 1 {
+		return &Principal{
+			ID:         "root",
+			Name:       "root",
+			Grants:     []Grant{{Project: AllProjects, Role: RoleAdmin}},
+			Attributes: map[string]interface{}{"id": "root", "name": "root"},
+		}, nil
+	}
+
+	id, secret, ok := parseKey(token)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	key, err := ks.Get(id)
+	if err != nil || key.Revoked {
+		return nil, ErrUnauthenticated
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return nil, ErrUnauthenticated
+	}
+
+	attributes := make(map[string]interface{}, len(key.Attributes)+2)
+	for name, value := range key.Attributes {
+		attributes[name] = value
+	}
+	attributes["id"] = key.ID
+	attributes["name"] = key.Name
+
+	return &Principal{ID: "key:" + key.ID, Name: key.Name, Grants: key.Grants, Attributes: attributes}, nil
+}
+
+// Create stores a new key with optional attributes for security filters and
+// returns its plaintext form together with the stored document so the
+// caller can replicate it.
+func (ks *KeyStore) Create(name string, grants []Grant, attributes map[string]interface{}) (string, *models.Document, error) {
+	if err := ValidateGrants(grants); err != nil {
+		return "", nil, err
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := toData(APIKey{
+		Name:       name,
+		Hash:       hashSecret(secret),
+		Grants:     grants,
+		Attributes: attributes,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	doc, err := ks.store.Create(SystemProject, KeysCollection, data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return keyPrefix + doc.ID + "." + secret, doc, nil
+}
+
+// Rotate replaces the secret of a key. The old secret stops working at once.
+func (ks *KeyStore) Rotate(id string) (string, *models.Document, error) {
+	key, err := ks.Get(id)
+	if err != nil {
+		return "", nil, err
+	}
+	if key.Revoked {
+		return "", nil, errors.New("api key is revoked")
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key.Hash = hashSecret(secret)
+	key.RotatedAt = time.Now().UTC()
+
+	doc, err := ks.save(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyPrefix + id + "." + secret, doc, nil
+}
+
+// Revoke disables a key. The record is kept so its history stays visible.
+func (ks *KeyStore) Revoke(id string) (*models.Document, error) {
+	key, err := ks.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Revoked = true
+	return ks.save(key)
+}
+
+func (ks *KeyStore) Get(id string) (*APIKey, error) {
+	doc, err := ks.store.Get(SystemProject, KeysCollection, id)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+	return fromDocument(doc)
+}
+
+func (ks *KeyStore) List() ([]*APIKey, error) {
+	docs, err := ks.store.GetAll(SystemProject, KeysCollection)
+	if err == store.ErrProjectNotFound || err == store.ErrCollectionNotFound {
+		return []*APIKey{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*APIKey, 0, len(docs))
+	for _, doc := range docs {
+		key, err := fromDocument(doc)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (ks *KeyStore) save(key *APIKey) (*models.Document, error) {
+	data, err := toData(*key)
+	if err != nil {
+		return nil, err
+	}
+	return ks.store.Update(SystemProject, KeysCollection, key.ID, data)
+}
+
+func ValidateGrants(grants []Grant) error {
+	if len(grants) == 0 {
+		return errors.New("at least one grant is required")
+	}
+	for _, grant := range grants {
+		if grant.Project == "" {
+			return errors.New("grant must name a project")
+		}
+		if grant.Project == SystemProject {
+			return fmt.Errorf("the %s project cannot be granted", SystemProject)
+		}
+		if !grant.Role.Valid() {
+			return fmt.Errorf("invalid role: %q", grant.Role)
+		}
+	}
+	return nil
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func parseKey(token string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(token, keyPrefix) {
+		return "", "", false
+	}
+	return strings.Cut(strings.TrimPrefix(token, keyPrefix), ".")
+}
+
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate key: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func toData(key APIKey) (map[string]interface{}, error) {
+	key.ID = ""
+	raw, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	delete(data, "id")
+	return data, nil
+}
+
+func fromDocument(doc *models.Document) (*APIKey, error) {
+	raw, err := json.Marshal(doc.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return nil, err
+	}
+	key.ID = doc.ID
+	return &key, nil
+}
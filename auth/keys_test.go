@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/itsyaboikris/go_document_store/store"
+)
+
+func keyRequest(key string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/p/c/document", nil)
+	r.Header.Set("X-API-Key", key)
+	return r
+}
+
+func createKey(t *testing.T, ks *KeyStore, grants ...Grant) (string, string) {
+	t.Helper()
+	key, doc, err := ks.Create("test", grants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, doc.ID
+}
+
+func TestKeyStoreRevokeAndRotate(t *testing.T) {
+	ks := NewKeyStore(store.NewStore(), "root secret")
+	key, id := createKey(t, ks, Grant{Project: "shop", Role: RoleRead})
+
+	principal, err := ks.Authenticate(keyRequest(key))
+	if err != nil {
+		t.Fatalf("new key rejected: %v", err)
+	}
+	if principal.ID != "key:"+id || principal.Attributes["id"] != id {
+		t.Errorf("principal = %+v, want key %s", principal, id)
+	}
+
+	rotated, _, err := ks.Rotate(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Authenticate(keyRequest(key)); err != ErrUnauthenticated {
+		t.Errorf("key before rotation: err = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := ks.Authenticate(keyRequest(rotated)); err != nil {
+		t.Errorf("rotated key rejected: %v", err)
+	}
+
+	if _, err := ks.Revoke(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Authenticate(keyRequest(rotated)); err != ErrUnauthenticated {
+		t.Errorf("revoked key: err = %v, want ErrUnauthenticated", err)
+	}
+	if _, _, err := ks.Rotate(id); err == nil {
+		t.Error("rotated a revoked key")
+	}
+}
+
+func TestKeyStoreAuthenticate(t *testing.T) {
+	ks := NewKeyStore(store.NewStore(), "root secret")
+	key, id := createKey(t, ks, Grant{Project: "shop", Role: RoleRead})
+	_, secret, _ := parseKey(key)
+
+	tests := []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"key", key, true},
+		{"root key", "root secret", true},
+		{"wrong secret", keyPrefix + id + ".0000", false},
+		{"unknown id", keyPrefix + "missing." + secret, false},
+		{"without prefix", id + "." + secret, false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		_, err := ks.Authenticate(keyRequest(tt.key))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/p/c/document", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	if _, err := ks.Authenticate(r); err != nil {
+		t.Errorf("bearer key rejected: %v", err)
+	}
+
+	if _, err := NewKeyStore(store.NewStore(), "").Authenticate(keyRequest("")); err != ErrUnauthenticated {
+		t.Errorf("empty key accepted without a root key: %v", err)
+	}
+}
+
+func TestGrantScopes(t *testing.T) {
+	ks := NewKeyStore(store.NewStore(), "root secret")
+	key, _ := createKey(t, ks,
+		Grant{Project: "shop", Role: RoleRead},
+		Grant{Project: "shop", Collection: "orders", Role: RoleWrite},
+		Grant{Project: "crm", Collection: "contacts", Role: RoleAdmin},
+	)
+	principal, err := ks.Authenticate(keyRequest(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ks.Authenticate(keyRequest("root secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                string
+		principal           *Principal
+		project, collection string
+		role                Role
+		want                bool
+	}{
+		{"project grant", principal, "shop", "products", RoleRead, true},
+		{"project grant role", principal, "shop", "products", RoleWrite, false},
+		{"collection grant", principal, "shop", "orders", RoleWrite, true},
+		{"collection grant role", principal, "shop", "orders", RoleAdmin, false},
+		{"collection grant on the project", principal, "crm", "", RoleRead, false},
+		{"collection grant on another collection", principal, "crm", "leads", RoleRead, false},
+		{"other project", principal, "billing", "invoices", RoleRead, false},
+		{"wildcard", root, "billing", "invoices", RoleAdmin, true},
+		{"wildcard on every project", root, AllProjects, "", RoleAdmin, true},
+		{"project grant on every project", principal, AllProjects, "", RoleRead, false},
+		{"wildcard on the system project", root, SystemProject, KeysCollection, RoleRead, false},
+		{"wildcard on the system project itself", root, SystemProject, "", RoleRead, false},
+	}
+	for _, tt := range tests {
+		if got := tt.principal.Allows(tt.project, tt.collection, tt.role); got != tt.want {
+			t.Errorf("%s: Allows(%q, %q, %s) = %v, want %v", tt.name, tt.project, tt.collection, tt.role, got, tt.want)
+		}
+	}
+
+	if got := principal.RoleOn("shop", "orders"); got != RoleWrite {
+		t.Errorf("RoleOn(shop, orders) = %q, want write", got)
+	}
+	if got := principal.RoleOn("crm", "leads"); got != "" {
+		t.Errorf("RoleOn(crm, leads) = %q, want none", got)
+	}
+	if got := root.RoleOn(SystemProject, KeysCollection); got != "" {
+		t.Errorf("RoleOn(%s, %s) = %q, want none", SystemProject, KeysCollection, got)
+	}
+	if !principal.CanAccessProject("crm") || principal.CanAccessProject("billing") {
+		t.Error("CanAccessProject does not follow the grants")
+	}
+	if root.CanAccessProject(SystemProject) {
+		t.Errorf("wildcard grant can access the %s project", SystemProject)
+	}
+}
+
+func TestValidateGrants(t *testing.T) {
+	tests := []struct {
+		name   string
+		grants []Grant
+		ok     bool
+	}{
+		{"project", []Grant{{Project: "shop", Role: RoleRead}}, true},
+		{"wildcard", []Grant{{Project: AllProjects, Role: RoleAdmin}}, true},
+		{"none", nil, false},
+		{"without project", []Grant{{Role: RoleRead}}, false},
+		{"unknown role", []Grant{{Project: "shop", Role: "owner"}}, false},
+		{"system project", []Grant{{Project: SystemProject, Collection: KeysCollection, Role: RoleRead}}, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateGrants(tt.grants); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type Role string
+
+const (
+	RoleRead  Role = "read"
+	RoleWrite Role = "write"
+	RoleAdmin Role = "admin"
+)
+
+// AllProjects in a grant matches every project except the system project,
+// so it should only be given to cluster administrators.
+const AllProjects = "*"
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	ErrForbidden       = errors.New("not allowed")
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleRead:
+		return 1
+	case RoleWrite:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+func (r Role) Valid() bool {
+	return r.level() > 0
+}
+
+// Grant gives a role on a project, or on one collection of it when
+// Collection is set.
+type Grant struct {
+	Project    string `json:"project"`
+	Collection string `json:"collection,omitempty"`
+	Role       Role   `json:"role"`
+}
+
+// coversProject reports whether the grant applies to the project. No grant
+// reaches the system project, so API key hashes can only be managed through
+// the key routes, never read as documents.
+func (g Grant) coversProject(projectID string) bool {
+	if projectID == SystemProject {
+		return false
+	}
+	return g.Project == AllProjects || g.Project == projectID
+}
+
+func (g Grant) covers(projectID, collectionID string, role Role) bool {
+	if !g.coversProject(projectID) {
+		return false
+	}
+	if g.Collection != "" && g.Collection != collectionID {
+		return false
+	}
+	return g.Role.level() >= role.level()
+}
+
+type Principal struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name,omitempty"`
+	Grants []Grant `json:"grants"`
+	// Attributes are what "$$user." placeholders in security filters
+	// resolve to. "id" is always set.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// RoleOn returns the highest role the principal holds on the collection, or
+// an empty role when it holds none.
+func (p *Principal) RoleOn(projectID, collectionID string) Role {
+	var best Role
+	if p == nil {
+		return best
+	}
+	for _, grant := range p.Grants {
+		if grant.covers(projectID, collectionID, grant.Role) && grant.Role.level() > best.level() {
+			best = grant.Role
+		}
+	}
+	return best
+}
+
+// Allows reports whether the principal holds at least role on the
+// collection. An empty collectionID asks for the role on the whole project.
+func (p *Principal) Allows(projectID, collectionID string, role Role) bool {
+	if p == nil {
+		return false
+	}
+	for _, grant := range p.Grants {
+		if grant.covers(projectID, collectionID, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessProject reports whether the principal has any grant on the
+// project.
+func (p *Principal) CanAccessProject(projectID string) bool {
+	if p == nil {
+		return false
+	}
+	for _, grant := range p.Grants {
+		if grant.coversProject(projectID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves the principal behind a request. It returns
+// ErrUnauthenticated when the request carries no credentials it recognises.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each authenticator in turn until one recognises the
+// request's credentials.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if err == ErrUnauthenticated {
+			continue
+		}
+		return principal, err
+	}
+	return nil, ErrUnauthenticated
+}
+
+type contextKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,50 @@
+// Command certgen creates a local CA and node certificates for running a
+// cluster with mutual TLS in development and tests.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/security"
+)
+
+func main() {
+	out := flag.String("out", "certs", "directory to write the certificates to")
+	nodes := flag.String("nodes", "node1,node2,node3", "comma separated node names")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "extra host names and IPs added to every node certificate")
+	validFor := flag.Duration("valid-for", 365*24*time.Hour, "certificate validity")
+	flag.Parse()
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		log.Fatalf("Failed to create output directory: %v", err)
+	}
+
+	ca, err := security.NewLocalCA("go_document_store local CA", *validFor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeFile(filepath.Join(*out, "ca.pem"), ca.CertPEM(), 0o644)
+
+	extraHosts := strings.Split(*hosts, ",")
+	for _, node := range strings.Split(*nodes, ",") {
+		certPEM, keyPEM, err := ca.Issue(node, append([]string{node}, extraHosts...), *validFor)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeFile(filepath.Join(*out, node+".pem"), certPEM, 0o644)
+		writeFile(filepath.Join(*out, node+"-key.pem"), keyPEM, 0o600)
+	}
+
+	log.Printf("Wrote CA and %d node certificate(s) to %s", len(strings.Split(*nodes, ",")), *out)
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) {
+	if err := os.WriteFile(path, data, perm); err != nil {
+		log.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
@@ -1,19 +1,142 @@
 package main
 
 import (
+	"crypto/tls"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/itsyaboikris/go_document_store/api"
+	"github.com/itsyaboikris/go_document_store/audit"
+	"github.com/itsyaboikris/go_document_store/auth"
+	"github.com/itsyaboikris/go_document_store/config"
+	"github.com/itsyaboikris/go_document_store/replication"
+	"github.com/itsyaboikris/go_document_store/security"
 	"github.com/itsyaboikris/go_document_store/store"
 )
 
 func main() {
-	ds := store.NewStore()
+	ds := store.NewStoreWithChangeLog(config.GetChangeLogSize())
+	ds.StartTombstoneGC(config.GetTombstoneGracePeriod(), config.GetTombstoneGCInterval())
+
+	hints, err := replication.NewHintStore(config.GetHintDir(), config.GetMaxHintsPerPeer())
+	if err != nil {
+		log.Fatalf("Failed to open hint store: %v", err)
+	}
+
+	var serverTLS, clientTLS *tls.Config
+	if certFile, keyFile, caFile := config.GetPeerTLSFiles(); certFile != "" && keyFile != "" && caFile != "" {
+		if serverTLS, err = security.ServerTLSConfig(certFile, keyFile, caFile); err != nil {
+			log.Fatalf("Failed to load peer TLS config: %v", err)
+		}
+		if clientTLS, err = security.ClientTLSConfig(certFile, keyFile, caFile); err != nil {
+			log.Fatalf("Failed to load peer TLS config: %v", err)
+		}
+	}
+
+	secret := config.GetClusterSecret()
+	if serverTLS == nil || len(secret) == 0 {
+		log.Println("Warning: peer traffic is not fully secured, set PEER_TLS_CERT, PEER_TLS_KEY, PEER_TLS_CA and CLUSTER_SECRET")
+	}
+
+	replicator := replication.NewReplicator(config.GetPeers(), hints, replication.Options{
+		BatchSize:      config.GetReplicationBatchSize(),
+		FlushInterval:  config.GetReplicationFlushInterval(),
+		QueueSize:      config.GetReplicationQueueSize(),
+		EnqueueTimeout: config.GetReplicationEnqueueTimeout(),
+		TLS:            clientTLS,
+		Secret:         secret,
+	})
+	replicator.StartHealthChecks(config.GetHealthCheckInterval())
+
+	// Nothing is served before the data is copied. A node that cannot reach
+	// its bootstrap peer exits so the supervisor sees it fail.
+	if peer := config.GetBootstrapPeer(); peer != "" {
+		attempts := config.GetBootstrapAttempts()
+		for attempt := 1; ; attempt++ {
+			seq, err := replicator.Bootstrap(ds, peer)
+			if err == nil {
+				replicator.Tail(ds, peer, seq)
+				break
+			}
+			log.Printf("Failed to bootstrap from %s (attempt %d/%d): %v", peer, attempt, attempts, err)
+			if attempt >= attempts {
+				log.Fatalf("Giving up on bootstrapping from %s", peer)
+			}
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	var auditLog *audit.Log
+	if path := config.GetAuditLogPath(); path != "" {
+		if auditLog, err = audit.Open(path); err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+	}
+
+	ds.StartTTLReaper(config.GetTTLReaperInterval(), func(doc store.ExpiredDocument) {
+		replicator.Replicate(doc.Project, doc.Collection, doc.ID, map[string]interface{}{
+			"id":         doc.ID,
+			"project":    doc.Project,
+			"collection": doc.Collection,
+			"operation":  "delete",
+			"deleted_at": doc.ExpiredAt,
+		})
+		if auditLog != nil {
+			if err := auditLog.Record(audit.Entry{
+				Principal:  "system:ttl",
+				Action:     "expire",
+				Project:    doc.Project,
+				Collection: doc.Collection,
+				Document:   doc.ID,
+			}); err != nil {
+				log.Printf("Failed to write audit log entry: %v", err)
+			}
+		}
+	})
+
+	internalRouter := mux.NewRouter()
+	if len(secret) > 0 {
+		internalRouter.Use(security.RequireSignature(secret, config.GetSignatureMaxSkew()))
+	}
+	api.RegisterInternalRoutes(internalRouter, ds, replicator, auditLog)
+
+	internalServer := &http.Server{
+		Addr:      ":" + config.GetInternalPort(),
+		Handler:   internalRouter,
+		TLSConfig: serverTLS,
+	}
+	go func() {
+		log.Println("Internal server starting on port: ", config.GetInternalPort())
+		if serverTLS != nil {
+			log.Fatal(internalServer.ListenAndServeTLS("", ""))
+		}
+		log.Fatal(internalServer.ListenAndServe())
+	}()
+
+	apiOptions := api.Options{Audit: auditLog}
+	if config.GetAuthEnabled() {
+		keys := auth.NewKeyStore(ds, config.GetAdminAPIKey())
+		apiOptions.Keys = keys
+
+		authenticators := auth.Chain{keys}
+		if path := config.GetJWTKeysFile(); path != "" {
+			keySet, err := auth.LoadKeySet(path)
+			if err != nil {
+				log.Fatalf("Failed to load JWT keys: %v", err)
+			}
+			authenticators = append(authenticators, auth.NewJWTAuthenticator(keySet, auth.JWTOptions{
+				Issuer:   config.GetJWTIssuer(),
+				Audience: config.GetJWTAudience(),
+			}))
+		}
+		apiOptions.Authenticator = authenticators
+	}
+
 	router := mux.NewRouter()
-	api.RegisterRoutes(router, ds)
+	api.RegisterRoutes(router, ds, replicator, apiOptions)
 
 	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		path, _ := route.GetPathTemplate()
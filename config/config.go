@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func GetPeers() []string {
@@ -12,3 +14,127 @@ func GetPeers() []string {
 	}
 	return strings.Split(peers, ",")
 }
+
+func GetHintDir() string {
+	return os.Getenv("HINT_DIR")
+}
+
+func GetMaxHintsPerPeer() int {
+	return getInt("MAX_HINTS_PER_PEER", 10000)
+}
+
+func GetHealthCheckInterval() time.Duration {
+	return getDuration("HEALTH_CHECK_INTERVAL", 5*time.Second)
+}
+
+func GetTombstoneGracePeriod() time.Duration {
+	return getDuration("TOMBSTONE_GRACE_PERIOD", 24*time.Hour)
+}
+
+func GetTombstoneGCInterval() time.Duration {
+	return getDuration("TOMBSTONE_GC_INTERVAL", time.Hour)
+}
+
+func GetBootstrapPeer() string {
+	return os.Getenv("BOOTSTRAP_PEER")
+}
+
+func GetBootstrapAttempts() int {
+	return getInt("BOOTSTRAP_ATTEMPTS", 30)
+}
+
+func GetChangeLogSize() int {
+	return getInt("CHANGELOG_SIZE", 100000)
+}
+
+func GetReplicationBatchSize() int {
+	return getInt("REPLICATION_BATCH_SIZE", 500)
+}
+
+func GetReplicationFlushInterval() time.Duration {
+	return getDuration("REPLICATION_FLUSH_INTERVAL", 50*time.Millisecond)
+}
+
+func GetReplicationQueueSize() int {
+	return getInt("REPLICATION_QUEUE_SIZE", 10000)
+}
+
+func GetReplicationEnqueueTimeout() time.Duration {
+	return getDuration("REPLICATION_ENQUEUE_TIMEOUT", time.Second)
+}
+
+func GetInternalPort() string {
+	port := os.Getenv("INTERNAL_PORT")
+	if port == "" {
+		return "8081"
+	}
+	return port
+}
+
+func GetClusterSecret() []byte {
+	return []byte(os.Getenv("CLUSTER_SECRET"))
+}
+
+func GetSignatureMaxSkew() time.Duration {
+	return getDuration("SIGNATURE_MAX_SKEW", 5*time.Minute)
+}
+
+// GetPeerTLSFiles returns the node certificate, its key and the cluster CA
+// used for mutual TLS between peers. TLS is disabled unless all are set.
+func GetPeerTLSFiles() (certFile, keyFile, caFile string) {
+	return os.Getenv("PEER_TLS_CERT"), os.Getenv("PEER_TLS_KEY"), os.Getenv("PEER_TLS_CA")
+}
+
+func GetAuthEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("AUTH_ENABLED"))
+	return enabled
+}
+
+// GetAdminAPIKey returns the root key that is always accepted as a cluster
+// administrator, used to create the first stored API keys.
+func GetAdminAPIKey() string {
+	return os.Getenv("ADMIN_API_KEY")
+}
+
+// GetJWTKeysFile returns the path of a JWKS file whose keys may sign bearer
+// tokens. JWT authentication is disabled when it is empty.
+func GetJWTKeysFile() string {
+	return os.Getenv("JWT_KEYS_FILE")
+}
+
+// GetJWTIssuer and GetJWTAudience, when set, must match the iss and aud
+// claims of a token.
+func GetJWTIssuer() string {
+	return os.Getenv("JWT_ISSUER")
+}
+
+func GetJWTAudience() string {
+	return os.Getenv("JWT_AUDIENCE")
+}
+
+// GetAuditLogPath returns the JSON Lines file the audit log is appended to.
+// Auditing is disabled when it is empty.
+func GetAuditLogPath() string {
+	return os.Getenv("AUDIT_LOG_PATH")
+}
+
+// GetTTLReaperInterval returns how often expired documents are deleted.
+func GetTTLReaperInterval() time.Duration {
+	return getDuration("TTL_REAPER_INTERVAL", 30*time.Second)
+}
+
+func getInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,246 @@
+// Package geo implements the spherical geometry behind 2dsphere indexes:
+// parsing GeoJSON, measuring distances along the surface of the earth and
+// deciding whether shapes intersect or lie within one another. Edges are
+// great circle arcs, as GeoJSON on a sphere requires, rather than straight
+// lines on a flat map.
+package geo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EarthRadius is the radius of the earth in meters that distances are
+// measured with.
+const EarthRadius = 6378100.0
+
+// Point is a position in degrees of longitude and latitude, the order
+// GeoJSON uses.
+type Point struct {
+	Lng float64
+	Lat float64
+}
+
+// Geometry is a parsed GeoJSON geometry. Every type is reduced to its
+// points, lines and polygons, so a MultiPolygon or a GeometryCollection is
+// handled like any other shape.
+type Geometry struct {
+	Points []Point
+	Lines  [][]Point
+	// Polygons holds the rings of each polygon, the exterior ring first and
+	// its holes after it. Rings are closed: the last point repeats the first.
+	Polygons [][][]Point
+
+	s *shape
+}
+
+// Parse reads a GeoJSON geometry object, a legacy [longitude, latitude]
+// pair, or an array of either, which is treated as a single geometry made
+// of all of them.
+func Parse(value interface{}) (*Geometry, error) {
+	g := &Geometry{}
+	if err := g.add(value, true); err != nil {
+		return nil, err
+	}
+	if len(g.Points) == 0 && len(g.Lines) == 0 && len(g.Polygons) == 0 {
+		return nil, errors.New("geometry is empty")
+	}
+	g.s = g.shape()
+	return g, nil
+}
+
+// ParsePoint reads a GeoJSON Point or a legacy [longitude, latitude] pair.
+func ParsePoint(value interface{}) (Point, error) {
+	g, err := Parse(value)
+	if err != nil {
+		return Point{}, err
+	}
+	if len(g.Points) != 1 || len(g.Lines) > 0 || len(g.Polygons) > 0 {
+		return Point{}, errors.New("expected a Point")
+	}
+	return g.Points[0], nil
+}
+
+// IsPolygonal reports whether the geometry is made of polygons only, so it
+// encloses an area.
+func (g *Geometry) IsPolygonal() bool {
+	return len(g.Polygons) > 0 && len(g.Points) == 0 && len(g.Lines) == 0
+}
+
+func (g *Geometry) add(value interface{}, allowArray bool) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return g.addObject(v)
+	case []interface{}:
+		if p, err := point(v); err == nil {
+			g.Points = append(g.Points, p)
+			return nil
+		} else if isPair(v) {
+			return err
+		}
+		if !allowArray {
+			return errors.New("expected a GeoJSON object or a coordinate pair")
+		}
+		for _, item := range v {
+			if err := g.add(item, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.New("expected a GeoJSON object or a coordinate pair")
+}
+
+func (g *Geometry) addObject(object map[string]interface{}) error {
+	kind, _ := object["type"].(string)
+	if kind == "GeometryCollection" {
+		geometries, ok := object["geometries"].([]interface{})
+		if !ok {
+			return errors.New("GeometryCollection requires geometries")
+		}
+		for _, item := range geometries {
+			sub, ok := item.(map[string]interface{})
+			if !ok {
+				return errors.New("geometries must be GeoJSON objects")
+			}
+			if err := g.addObject(sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	coordinates, ok := object["coordinates"].([]interface{})
+	if !ok {
+		return fmt.Errorf("%s requires coordinates", describe(kind))
+	}
+	switch kind {
+	case "Point":
+		p, err := point(coordinates)
+		if err != nil {
+			return err
+		}
+		g.Points = append(g.Points, p)
+	case "MultiPoint":
+		points, err := points(coordinates)
+		if err != nil {
+			return err
+		}
+		g.Points = append(g.Points, points...)
+	case "LineString":
+		line, err := lineString(coordinates)
+		if err != nil {
+			return err
+		}
+		g.Lines = append(g.Lines, line)
+	case "MultiLineString":
+		for _, item := range coordinates {
+			line, err := lineString(item)
+			if err != nil {
+				return err
+			}
+			g.Lines = append(g.Lines, line)
+		}
+	case "Polygon":
+		rings, err := polygon(coordinates)
+		if err != nil {
+			return err
+		}
+		g.Polygons = append(g.Polygons, rings)
+	case "MultiPolygon":
+		for _, item := range coordinates {
+			rings, err := polygon(item)
+			if err != nil {
+				return err
+			}
+			g.Polygons = append(g.Polygons, rings)
+		}
+	default:
+		return errors.New("unsupported geometry type: " + describe(kind))
+	}
+	return nil
+}
+
+func describe(kind string) string {
+	if kind == "" {
+		return "geometry without a type"
+	}
+	return kind
+}
+
+func isPair(values []interface{}) bool {
+	if len(values) < 2 {
+		return false
+	}
+	for _, value := range values {
+		if _, ok := value.(float64); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// point reads [longitude, latitude], ignoring an altitude after them.
+func point(value interface{}) (Point, error) {
+	values, ok := value.([]interface{})
+	if !ok || !isPair(values) || len(values) > 3 {
+		return Point{}, errors.New("a position must be [longitude, latitude]")
+	}
+	p := Point{Lng: values[0].(float64), Lat: values[1].(float64)}
+	if p.Lng < -180 || p.Lng > 180 {
+		return Point{}, fmt.Errorf("longitude %v is out of range", p.Lng)
+	}
+	if p.Lat < -90 || p.Lat > 90 {
+		return Point{}, fmt.Errorf("latitude %v is out of range", p.Lat)
+	}
+	return p, nil
+}
+
+func points(value interface{}) ([]Point, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("expected an array of positions")
+	}
+	result := make([]Point, len(values))
+	for i, item := range values {
+		p, err := point(item)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = p
+	}
+	return result, nil
+}
+
+func lineString(value interface{}) ([]Point, error) {
+	line, err := points(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 {
+		return nil, errors.New("a LineString needs at least two positions")
+	}
+	return line, nil
+}
+
+func polygon(value interface{}) ([][]Point, error) {
+	values, ok := value.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, errors.New("a Polygon needs at least one ring")
+	}
+	rings := make([][]Point, len(values))
+	for i, item := range values {
+		ring, err := points(item)
+		if err != nil {
+			return nil, err
+		}
+		if len(ring) < 4 {
+			return nil, errors.New("a ring needs at least four positions")
+		}
+		if ring[0] != ring[len(ring)-1] {
+			return nil, errors.New("a ring must end where it starts")
+		}
+		rings[i] = ring
+	}
+	return rings, nil
+}
@@ -0,0 +1,440 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// epsilon is the tolerance, in radians, for a point to count as lying on
+// an edge. It is a few millimeters on the surface of the earth.
+const epsilon = 1e-9
+
+// vector is a point on the unit sphere.
+type vector struct {
+	x, y, z float64
+}
+
+func (p Point) vector() vector {
+	lat, lng := p.Lat*math.Pi/180, p.Lng*math.Pi/180
+	return vector{math.Cos(lat) * math.Cos(lng), math.Cos(lat) * math.Sin(lng), math.Sin(lat)}
+}
+
+func (v vector) latitude() float64 {
+	return math.Atan2(v.z, math.Hypot(v.x, v.y)) * 180 / math.Pi
+}
+
+func (v vector) add(w vector) vector    { return vector{v.x + w.x, v.y + w.y, v.z + w.z} }
+func (v vector) sub(w vector) vector    { return vector{v.x - w.x, v.y - w.y, v.z - w.z} }
+func (v vector) scale(f float64) vector { return vector{v.x * f, v.y * f, v.z * f} }
+func (v vector) dot(w vector) float64   { return v.x*w.x + v.y*w.y + v.z*w.z }
+func (v vector) norm() float64          { return math.Sqrt(v.dot(v)) }
+func (v vector) normalize() vector      { return v.scale(1 / v.norm()) }
+func (v vector) cross(w vector) vector {
+	return vector{v.y*w.z - v.z*w.y, v.z*w.x - v.x*w.z, v.x*w.y - v.y*w.x}
+}
+
+// angle is the angle between two points seen from the center of the
+// sphere, their distance on the unit sphere.
+func angle(v, w vector) float64 {
+	return math.Atan2(v.cross(w).norm(), v.dot(w))
+}
+
+// Distance returns the great circle distance between two points in meters.
+func Distance(a, b Point) float64 {
+	return angle(a.vector(), b.vector()) * EarthRadius
+}
+
+// onArc reports whether p lies on the shorter great circle arc from a to b.
+func onArc(p, a, b vector) bool {
+	return angle(a, p)+angle(p, b) <= angle(a, b)+epsilon
+}
+
+// arcIntersections returns the points where two arcs meet.
+func arcIntersections(a, b, c, d vector) []vector {
+	n1, n2 := a.cross(b), c.cross(d)
+	line := n1.cross(n2)
+	var found []vector
+	if line.norm() < epsilon {
+		// Both arcs lie on the same great circle and meet where one of them
+		// holds an end of the other.
+		for _, p := range []vector{a, b} {
+			if onArc(p, c, d) {
+				found = append(found, p)
+			}
+		}
+		for _, p := range []vector{c, d} {
+			if onArc(p, a, b) {
+				found = append(found, p)
+			}
+		}
+		return found
+	}
+	line = line.normalize()
+	for _, p := range []vector{line, line.scale(-1)} {
+		if onArc(p, a, b) && onArc(p, c, d) {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// arcDistance returns the angular distance from p to the nearest point of
+// the arc from a to b.
+func arcDistance(p, a, b vector) float64 {
+	n := a.cross(b)
+	if n.norm() > epsilon {
+		n = n.normalize()
+		// The point of the great circle nearest to p is its projection onto
+		// the plane of the circle; p is equally far from every point of the
+		// circle when it is one of its poles.
+		projected := p.sub(n.scale(p.dot(n)))
+		if projected.norm() > epsilon {
+			if c := projected.normalize(); onArc(c, a, b) {
+				return angle(p, c)
+			}
+		}
+	}
+	return math.Min(angle(p, a), angle(p, b))
+}
+
+// inRing reports whether p is inside a closed ring, taking the inside to be
+// the side the ring winds around. Rings must be smaller than a hemisphere.
+func inRing(p vector, ring []vector) bool {
+	// The winding below cannot tell p from its antipode, so p must also be
+	// on the side of the sphere the ring is on.
+	center := vector{}
+	for _, v := range ring[1:] {
+		center = center.add(v)
+	}
+	if p.dot(center) <= 0 {
+		return false
+	}
+
+	total := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		// Project both ends onto the plane tangent at p and add up the
+		// signed angles they turn through.
+		a := ring[i].sub(p.scale(ring[i].dot(p)))
+		b := ring[i+1].sub(p.scale(ring[i+1].dot(p)))
+		total += math.Atan2(p.dot(a.cross(b)), a.dot(b))
+	}
+	return math.Abs(total) > math.Pi
+}
+
+// shape is a geometry converted to unit vectors for the predicates.
+type shape struct {
+	points   []vector
+	vertices []vector
+	edges    [][2]vector
+	polygons [][][]vector
+	holes    []vector
+}
+
+func (g *Geometry) shape() *shape {
+	if g.s != nil {
+		return g.s
+	}
+	s := &shape{}
+	for _, p := range g.Points {
+		s.points = append(s.points, p.vector())
+	}
+	s.vertices = append(s.vertices, s.points...)
+	addPath := func(path []Point) []vector {
+		vectors := make([]vector, len(path))
+		for i, p := range path {
+			vectors[i] = p.vector()
+			s.vertices = append(s.vertices, vectors[i])
+			if i > 0 && angle(vectors[i-1], vectors[i]) > epsilon {
+				s.edges = append(s.edges, [2]vector{vectors[i-1], vectors[i]})
+			}
+		}
+		return vectors
+	}
+	for _, line := range g.Lines {
+		addPath(line)
+	}
+	for _, rings := range g.Polygons {
+		polygon := make([][]vector, len(rings))
+		for i, ring := range rings {
+			polygon[i] = addPath(ring)
+			if i > 0 {
+				s.holes = append(s.holes, polygon[i]...)
+			}
+		}
+		s.polygons = append(s.polygons, polygon)
+	}
+	return s
+}
+
+// inPolygon reports whether p lies in a polygon and whether it lies on its
+// boundary, which counts as in it.
+func inPolygon(p vector, rings [][]vector) (inside, boundary bool) {
+	for _, ring := range rings {
+		for i := 0; i+1 < len(ring); i++ {
+			if onArc(p, ring[i], ring[i+1]) {
+				return true, true
+			}
+		}
+	}
+	if !inRing(p, rings[0]) {
+		return false, false
+	}
+	for _, hole := range rings[1:] {
+		if inRing(p, hole) {
+			return false, false
+		}
+	}
+	return true, false
+}
+
+// encloses reports whether p lies in one of the polygons of the shape,
+// boundary included.
+func (s *shape) encloses(p vector) bool {
+	for _, polygon := range s.polygons {
+		if inside, _ := inPolygon(p, polygon); inside {
+			return true
+		}
+	}
+	return false
+}
+
+// enclosesStrictly reports whether p lies inside a polygon of the shape and
+// not on its boundary.
+func (s *shape) enclosesStrictly(p vector) bool {
+	for _, polygon := range s.polygons {
+		if inside, boundary := inPolygon(p, polygon); inside && !boundary {
+			return true
+		}
+	}
+	return false
+}
+
+// holds reports whether p is one of the points of the shape, lies on one of
+// its edges or in one of its polygons.
+func (s *shape) holds(p vector) bool {
+	for _, q := range s.points {
+		if angle(p, q) <= epsilon {
+			return true
+		}
+	}
+	for _, e := range s.edges {
+		if onArc(p, e[0], e[1]) {
+			return true
+		}
+	}
+	return s.encloses(p)
+}
+
+// Intersects reports whether two geometries share at least one point.
+func (g *Geometry) Intersects(other *Geometry) bool {
+	a, b := g.shape(), other.shape()
+	for _, p := range a.vertices {
+		if b.holds(p) {
+			return true
+		}
+	}
+	for _, p := range b.vertices {
+		if a.holds(p) {
+			return true
+		}
+	}
+	for _, e := range a.edges {
+		for _, f := range b.edges {
+			if len(arcIntersections(e[0], e[1], f[0], f[1])) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DistanceTo returns the distance in meters from p to the nearest point of
+// the geometry, zero if p lies in one of its polygons.
+func DistanceTo(p Point, g *Geometry) float64 {
+	v := p.vector()
+	s := g.shape()
+	if s.encloses(v) {
+		return 0
+	}
+	nearest := math.Inf(1)
+	for _, q := range s.points {
+		nearest = math.Min(nearest, angle(v, q))
+	}
+	for _, e := range s.edges {
+		nearest = math.Min(nearest, arcDistance(v, e[0], e[1]))
+	}
+	return nearest * EarthRadius
+}
+
+// Region is an area that geometries can lie within.
+type Region interface {
+	// Covers reports whether the geometry lies entirely within the region,
+	// its boundary included.
+	Covers(g *Geometry) bool
+	// Bound returns a rectangle enclosing the region.
+	Bound() Rect
+}
+
+// Covers reports whether other lies within the polygons of g, which must be
+// polygonal.
+func (g *Geometry) Covers(other *Geometry) bool {
+	s, o := g.shape(), other.shape()
+	for _, p := range o.vertices {
+		if !s.encloses(p) {
+			return false
+		}
+	}
+
+	// An edge with both ends inside can still leave the region through a
+	// concave part of its boundary. Split it where it meets the boundary;
+	// every piece must then be inside.
+	for _, e := range o.edges {
+		cuts := []vector{e[0], e[1]}
+		for _, f := range s.edges {
+			cuts = append(cuts, arcIntersections(e[0], e[1], f[0], f[1])...)
+		}
+		sort.Slice(cuts, func(i, j int) bool {
+			return angle(e[0], cuts[i]) < angle(e[0], cuts[j])
+		})
+		for i := 0; i+1 < len(cuts); i++ {
+			if angle(cuts[i], cuts[i+1]) <= epsilon {
+				continue
+			}
+			if !s.encloses(cuts[i].add(cuts[i+1]).normalize()) {
+				return false
+			}
+		}
+	}
+
+	// A polygon around a hole of the region does not lie within it.
+	for _, p := range s.holes {
+		if o.enclosesStrictly(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Cap is the area within an angle of a center point, as given by
+// $centerSphere.
+type Cap struct {
+	Center Point
+	// Radius is the angle in radians.
+	Radius float64
+}
+
+// Covers reports whether every point of the geometry is within the cap.
+func (c Cap) Covers(g *Geometry) bool {
+	center := c.Center.vector()
+	s := g.shape()
+	for _, p := range s.vertices {
+		if angle(center, p) > c.Radius+epsilon {
+			return false
+		}
+	}
+	// An edge leaves the cap where it comes closer to the antipode of the
+	// center than the cap reaches, which can happen between two points
+	// inside a cap wider than a hemisphere.
+	antipode := center.scale(-1)
+	for _, e := range s.edges {
+		if arcDistance(antipode, e[0], e[1]) < math.Pi-c.Radius-epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// Rect is a range of latitudes and longitudes in degrees. When MinLng is
+// greater than MaxLng the range crosses the antimeridian.
+type Rect struct {
+	MinLat, MaxLat float64
+	MinLng, MaxLng float64
+}
+
+// Bound returns a rectangle enclosing the cap.
+func (c Cap) Bound() Rect {
+	radius := c.Radius * 180 / math.Pi
+	r := Rect{MinLat: c.Center.Lat - radius, MaxLat: c.Center.Lat + radius, MinLng: -180, MaxLng: 180}
+	if r.MinLat <= -90 || r.MaxLat >= 90 {
+		// The cap holds a pole and so every longitude.
+		r.MinLat, r.MaxLat = math.Max(r.MinLat, -90), math.Min(r.MaxLat, 90)
+		return r
+	}
+	spread := math.Asin(math.Sin(c.Radius)/math.Cos(c.Center.Lat*math.Pi/180)) * 180 / math.Pi
+	r.MinLng, r.MaxLng = wrapLongitude(c.Center.Lng-spread), wrapLongitude(c.Center.Lng+spread)
+	return r
+}
+
+// Bound returns a rectangle enclosing the geometry.
+func (g *Geometry) Bound() Rect {
+	s := g.shape()
+	r := Rect{MinLat: 90, MaxLat: -90, MinLng: 180, MaxLng: -180}
+	crossesAntimeridian := false
+	addPoint := func(p Point) {
+		r.MinLat, r.MaxLat = math.Min(r.MinLat, p.Lat), math.Max(r.MaxLat, p.Lat)
+		r.MinLng, r.MaxLng = math.Min(r.MinLng, p.Lng), math.Max(r.MaxLng, p.Lng)
+	}
+	addPath := func(path []Point) {
+		for i, p := range path {
+			addPoint(p)
+			if i > 0 && math.Abs(p.Lng-path[i-1].Lng) > 180 {
+				crossesAntimeridian = true
+			}
+		}
+	}
+	for _, p := range g.Points {
+		addPoint(p)
+	}
+	for _, line := range g.Lines {
+		addPath(line)
+	}
+	for _, rings := range g.Polygons {
+		addPath(rings[0])
+	}
+
+	// An arc bulges toward the pole between its ends.
+	for _, e := range s.edges {
+		n := e[0].cross(e[1]).normalize()
+		for _, pole := range []vector{{0, 0, 1}, {0, 0, -1}} {
+			if top := pole.sub(n.scale(pole.dot(n))); top.norm() > epsilon {
+				if top = top.normalize(); onArc(top, e[0], e[1]) {
+					lat := top.latitude()
+					r.MinLat, r.MaxLat = math.Min(r.MinLat, lat), math.Max(r.MaxLat, lat)
+				}
+			}
+		}
+	}
+
+	for _, pole := range []vector{{0, 0, 1}, {0, 0, -1}} {
+		if s.encloses(pole) {
+			r.MinLat, r.MaxLat = math.Min(r.MinLat, pole.latitude()), math.Max(r.MaxLat, pole.latitude())
+			r.MinLng, r.MaxLng = -180, 180
+			return r
+		}
+	}
+
+	if crossesAntimeridian {
+		// Measure the longitudes from 0 to 360 instead, so the range runs
+		// across 180 rather than across 0.
+		low, high := 360.0, 0.0
+		for _, p := range s.vertices {
+			lng := math.Atan2(p.y, p.x) * 180 / math.Pi
+			if lng < 0 {
+				lng += 360
+			}
+			low, high = math.Min(low, lng), math.Max(high, lng)
+		}
+		r.MinLng, r.MaxLng = wrapLongitude(low), wrapLongitude(high)
+	}
+	return r
+}
+
+func wrapLongitude(lng float64) float64 {
+	for lng > 180 {
+		lng -= 360
+	}
+	for lng < -180 {
+		lng += 360
+	}
+	return lng
+}
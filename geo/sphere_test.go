@@ -0,0 +1,185 @@
+package geo
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func parse(t *testing.T, raw string) *Geometry {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	g, err := Parse(value)
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", raw, err)
+	}
+	return g
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Point
+		want float64
+	}{
+		{"london to paris", Point{-0.1278, 51.5074}, Point{2.3522, 48.8566}, 343939},
+		{"new york to los angeles", Point{-74.006, 40.7128}, Point{-118.2437, 34.0522}, 3940132},
+		{"tokyo to sydney", Point{139.6917, 35.6895}, Point{151.2093, -33.8688}, 7835337},
+		{"across the antimeridian", Point{179, 0}, Point{-179, 0}, 222638},
+		{"equator to pole", Point{0, 0}, Point{0, 90}, math.Pi / 2 * EarthRadius},
+		{"antipodes", Point{0, 0}, Point{180, 0}, math.Pi * EarthRadius},
+		{"same point", Point{12.5, 41.9}, Point{12.5, 41.9}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Distance(tt.a, tt.b); math.Abs(got-tt.want) > 1 {
+				t.Errorf("Distance = %.1f m, want %.1f m", got, tt.want)
+			}
+			if got := Distance(tt.b, tt.a); math.Abs(got-tt.want) > 1 {
+				t.Errorf("reverse Distance = %.1f m, want %.1f m", got, tt.want)
+			}
+		})
+	}
+}
+
+// squareWithHole is the square from 0 to 10 degrees with a hole from 4 to
+// 6 degrees.
+const squareWithHole = `{"type": "Polygon", "coordinates": [
+	[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]],
+	[[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]
+]}`
+
+func TestPolygonWithHole(t *testing.T) {
+	region := parse(t, squareWithHole)
+	tests := []struct {
+		name   string
+		shape  string
+		covers bool
+		meets  bool
+	}{
+		{"point in the polygon", `[2, 2]`, true, true},
+		{"point in the hole", `[5, 5]`, false, false},
+		{"point on the hole's edge", `[4, 5]`, true, true},
+		{"point outside", `[12, 5]`, false, false},
+		{"line in the polygon", `{"type": "LineString", "coordinates": [[1, 1], [3, 1]]}`, true, true},
+		{"line across the hole", `{"type": "LineString", "coordinates": [[2, 5], [8, 5]]}`, false, true},
+		{"polygon in the hole", `{"type": "Polygon", "coordinates": [[[4.5, 4.5], [5.5, 4.5], [5.5, 5.5], [4.5, 5.5], [4.5, 4.5]]]}`, false, false},
+		{"polygon around the hole", `{"type": "Polygon", "coordinates": [[[1, 1], [9, 1], [9, 9], [1, 9], [1, 1]]]}`, false, true},
+		{"polygon beside the hole", `{"type": "Polygon", "coordinates": [[[1, 1], [3, 1], [3, 3], [1, 3], [1, 1]]]}`, true, true},
+		{"polygon overlapping the edge", `{"type": "Polygon", "coordinates": [[[8, 8], [12, 8], [12, 12], [8, 12], [8, 8]]]}`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := parse(t, tt.shape)
+			if got := region.Covers(g); got != tt.covers {
+				t.Errorf("Covers = %v, want %v", got, tt.covers)
+			}
+			if got := region.Intersects(g); got != tt.meets {
+				t.Errorf("Intersects = %v, want %v", got, tt.meets)
+			}
+		})
+	}
+
+	if d := DistanceTo(Point{5, 5}, region); math.Abs(d-Distance(Point{5, 5}, Point{4, 5})) > 1000 {
+		t.Errorf("DistanceTo from the hole = %.1f m, want about the distance to its edge", d)
+	}
+	if d := DistanceTo(Point{2, 2}, region); d != 0 {
+		t.Errorf("DistanceTo inside = %.1f m, want 0", d)
+	}
+}
+
+func TestCapRadius(t *testing.T) {
+	center := Point{0, 0}
+	radius := 1000000 / EarthRadius
+	edge := radius * 180 / math.Pi
+	c := Cap{Center: center, Radius: radius}
+
+	tests := []struct {
+		name   string
+		shape  string
+		covers bool
+	}{
+		{"center", `[0, 0]`, true},
+		{"just inside", pointJSON(edge*0.999, 0), true},
+		{"on the radius", pointJSON(edge, 0), true},
+		{"just outside", pointJSON(edge*1.001, 0), false},
+		{"inside to the north", pointJSON(0, edge*0.999), true},
+		{"outside to the south", pointJSON(0, -edge*1.001), false},
+		{"line within", `{"type": "LineString", "coordinates": [[-1, 0], [1, 0]]}`, true},
+		{"line leaving", `{"type": "LineString", "coordinates": [[0, 0], [20, 0]]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Covers(parse(t, tt.shape)); got != tt.covers {
+				t.Errorf("Covers = %v, want %v", got, tt.covers)
+			}
+		})
+	}
+
+	// A cap wider than a hemisphere must not take in an edge passing near
+	// its antipode.
+	wide := Cap{Center: center, Radius: math.Pi * 0.9}
+	if wide.Covers(parse(t, `{"type": "LineString", "coordinates": [[170, 10], [-170, -10]]}`)) {
+		t.Error("wide cap covers an edge through its antipode")
+	}
+	if !wide.Covers(parse(t, `[90, 0]`)) {
+		t.Error("wide cap misses a point within it")
+	}
+
+	r := c.Bound()
+	if math.Abs(r.MaxLat-edge) > 1e-9 || math.Abs(r.MinLat+edge) > 1e-9 {
+		t.Errorf("Bound latitudes = [%v, %v], want ±%v", r.MinLat, r.MaxLat, edge)
+	}
+}
+
+func pointJSON(lng, lat float64) string {
+	encoded, _ := json.Marshal([]float64{lng, lat})
+	return string(encoded)
+}
+
+// acrossAntimeridian spans longitudes 170 to -170 through 180.
+const acrossAntimeridian = `{"type": "Polygon", "coordinates": [
+	[[170, -10], [-170, -10], [-170, 10], [170, 10], [170, -10]]
+]}`
+
+func TestAntimeridian(t *testing.T) {
+	region := parse(t, acrossAntimeridian)
+	tests := []struct {
+		name   string
+		shape  string
+		covers bool
+	}{
+		{"on the antimeridian", `[180, 0]`, true},
+		{"on the antimeridian from the west", `[-180, 5]`, true},
+		{"east of it", `[175, 5]`, true},
+		{"west of it", `[-175, -5]`, true},
+		{"outside to the west", `[165, 0]`, false},
+		{"opposite side of the earth", `[0, 0]`, false},
+		{"line across the antimeridian", `{"type": "LineString", "coordinates": [[175, 0], [-175, 0]]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := region.Covers(parse(t, tt.shape)); got != tt.covers {
+				t.Errorf("Covers = %v, want %v", got, tt.covers)
+			}
+		})
+	}
+
+	if !region.Intersects(parse(t, `{"type": "LineString", "coordinates": [[179, -20], [179, 20]]}`)) {
+		t.Error("Intersects misses a line crossing the polygon")
+	}
+	if region.Intersects(parse(t, `{"type": "LineString", "coordinates": [[160, -20], [160, 20]]}`)) {
+		t.Error("Intersects finds a line west of the polygon")
+	}
+
+	r := region.Bound()
+	if r.MinLng != 170 || r.MaxLng != -170 {
+		t.Errorf("Bound longitudes = [%v, %v], want [170, -170]", r.MinLng, r.MaxLng)
+	}
+	if r.MinLat > -10 || r.MaxLat < 10 {
+		t.Errorf("Bound latitudes = [%v, %v], want to hold [-10, 10]", r.MinLat, r.MaxLat)
+	}
+}
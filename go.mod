@@ -3,6 +3,6 @@ module github.com/itsyaboikris/go_document_store
 go 1.21.0
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
 )
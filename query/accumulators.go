@@ -0,0 +1,170 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+)
+
+// accumulator folds the documents of one $group bucket into a value.
+type accumulator interface {
+	add(doc map[string]interface{}, vars map[string]interface{})
+	result() interface{}
+}
+
+// compileAccumulator compiles a $group field such as {"$sum": "$price"}
+// and returns a constructor for a fresh accumulator per bucket.
+func compileAccumulator(spec interface{}) (func() accumulator, error) {
+	object, ok := spec.(map[string]interface{})
+	if !ok || len(object) != 1 {
+		return nil, errors.New("accumulator must be an object with a single operator")
+	}
+
+	for op, arg := range object {
+		if op == "$count" {
+			if args, ok := arg.(map[string]interface{}); !ok || len(args) != 0 {
+				return nil, errors.New("$count accumulator takes an empty object")
+			}
+			return func() accumulator { return &countAccumulator{} }, nil
+		}
+
+		expr, err := compileExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "$sum":
+			return func() accumulator { return &sumAccumulator{expr: expr} }, nil
+		case "$avg":
+			return func() accumulator { return &avgAccumulator{expr: expr} }, nil
+		case "$min":
+			return func() accumulator { return &extremeAccumulator{expr: expr, want: -1} }, nil
+		case "$max":
+			return func() accumulator { return &extremeAccumulator{expr: expr, want: 1} }, nil
+		case "$push":
+			return func() accumulator { return &pushAccumulator{expr: expr, values: []interface{}{}} }, nil
+		case "$addToSet":
+			return func() accumulator { return &pushAccumulator{expr: expr, values: []interface{}{}, unique: true} }, nil
+		case "$first":
+			return func() accumulator { return &firstAccumulator{expr: expr} }, nil
+		case "$last":
+			return func() accumulator { return &lastAccumulator{expr: expr} }, nil
+		}
+		return nil, fmt.Errorf("unknown accumulator: %s", op)
+	}
+	return nil, nil
+}
+
+type countAccumulator struct{ n int }
+
+func (a *countAccumulator) add(map[string]interface{}, map[string]interface{}) { a.n++ }
+func (a *countAccumulator) result() interface{}                                { return a.n }
+
+// sumAccumulator adds up numbers and ignores every other value, so
+// {"$sum": 1} counts documents.
+type sumAccumulator struct {
+	expr  expression
+	total float64
+}
+
+func (a *sumAccumulator) add(doc map[string]interface{}, vars map[string]interface{}) {
+	if n, ok := numeric(a.expr(doc, vars)); ok {
+		a.total += n
+	}
+}
+
+func (a *sumAccumulator) result() interface{} { return a.total }
+
+// avgAccumulator averages the numbers and is null when there are none.
+type avgAccumulator struct {
+	expr  expression
+	total float64
+	count int
+}
+
+func (a *avgAccumulator) add(doc map[string]interface{}, vars map[string]interface{}) {
+	if n, ok := numeric(a.expr(doc, vars)); ok {
+		a.total += n
+		a.count++
+	}
+}
+
+func (a *avgAccumulator) result() interface{} {
+	if a.count == 0 {
+		return nil
+	}
+	return a.total / float64(a.count)
+}
+
+// extremeAccumulator keeps the smallest or largest value, ignoring nulls
+// and missing fields.
+type extremeAccumulator struct {
+	expr  expression
+	want  int
+	value interface{}
+}
+
+func (a *extremeAccumulator) add(doc map[string]interface{}, vars map[string]interface{}) {
+	value := a.expr(doc, vars)
+	if value == nil || value == missing {
+		return
+	}
+	if a.value == nil || compareOrder(value, a.value) == a.want {
+		a.value = value
+	}
+}
+
+func (a *extremeAccumulator) result() interface{} { return a.value }
+
+type pushAccumulator struct {
+	expr   expression
+	values []interface{}
+	unique bool
+}
+
+func (a *pushAccumulator) add(doc map[string]interface{}, vars map[string]interface{}) {
+	value := a.expr(doc, vars)
+	if value == missing {
+		return
+	}
+	if a.unique {
+		for _, existing := range a.values {
+			if compareOrder(existing, value) == 0 {
+				return
+			}
+		}
+	}
+	a.values = append(a.values, value)
+}
+
+func (a *pushAccumulator) result() interface{} { return a.values }
+
+type firstAccumulator struct {
+	expr  expression
+	seen  bool
+	value interface{}
+}
+
+func (a *firstAccumulator) add(doc map[string]interface{}, vars map[string]interface{}) {
+	if !a.seen {
+		a.seen = true
+		if a.value = a.expr(doc, vars); a.value == missing {
+			a.value = nil
+		}
+	}
+}
+
+func (a *firstAccumulator) result() interface{} { return a.value }
+
+type lastAccumulator struct {
+	expr  expression
+	value interface{}
+}
+
+func (a *lastAccumulator) add(doc map[string]interface{}, vars map[string]interface{}) {
+	if a.value = a.expr(doc, vars); a.value == missing {
+		a.value = nil
+	}
+}
+
+func (a *lastAccumulator) result() interface{} { return a.value }
@@ -0,0 +1,186 @@
+package query
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// typeRank orders values of different types the way BSON does: null,
+// numbers, strings, objects, arrays, booleans and dates. Dates have no
+// JSON type of their own, so a string holding an RFC 3339 timestamp is
+// ranked as a date.
+func typeRank(v interface{}) int {
+	switch x := v.(type) {
+	case nil, missingValue:
+		return 0
+	case string:
+		if _, ok := parseDate(x); ok {
+			return 6
+		}
+		return 2
+	case map[string]interface{}:
+		return 3
+	case []interface{}:
+		return 4
+	case bool:
+		return 5
+	}
+	if _, ok := numeric(v); ok {
+		return 1
+	}
+	return 7
+}
+
+// parseDate reads an RFC 3339 timestamp. Strings that cannot be one are
+// turned away before they reach the parser.
+func parseDate(s string) (time.Time, bool) {
+	if len(s) < len("2006-01-02T15:04:05Z") || s[4] != '-' || (s[10] != 'T' && s[10] != 't') {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+// compareOrder returns -1, 0 or 1 as a sorts before, with or after b.
+// Values of different types are ordered by type, so any two values compare.
+// Dates compare by the instant they name, objects field by field in key
+// order, and arrays element by element.
+func compareOrder(a, b interface{}) int {
+	rankA, rankB := typeRank(a), typeRank(b)
+	if rankA != rankB {
+		return sign(rankA - rankB)
+	}
+
+	switch x := a.(type) {
+	case string:
+		if rankA == 6 {
+			t, _ := parseDate(x)
+			u, _ := parseDate(b.(string))
+			return t.Compare(u)
+		}
+		return strings.Compare(x, b.(string))
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareOrder(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return sign(len(x) - len(y))
+	case map[string]interface{}:
+		y := b.(map[string]interface{})
+		keysA, keysB := sortedKeys(x), sortedKeys(y)
+		for i := 0; i < len(keysA) && i < len(keysB); i++ {
+			if c := strings.Compare(keysA[i], keysB[i]); c != 0 {
+				return c
+			}
+			if c := compareOrder(x[keysA[i]], y[keysB[i]]); c != 0 {
+				return c
+			}
+		}
+		return sign(len(keysA) - len(keysB))
+	}
+
+	if rankA == 1 {
+		x, _ := numeric(a)
+		y, _ := numeric(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// equalValues reports whether two values are equal in the comparison
+// order, which tells types apart except that a missing field equals null.
+func equalValues(a, b interface{}) bool {
+	return compareOrder(a, b) == 0
+}
+
+// numeric converts numbers of any Go type to float64. Unlike toNumber it
+// does not parse strings.
+func numeric(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// Compare orders two values the way $sort does. It returns -1, 0 or 1 as a
+// sorts before, with or after b.
+func Compare(a, b interface{}) int {
+	return compareOrder(a, b)
+}
+
+// Canonical returns a value that encodes to the same JSON as every value
+// it is equal to in the comparison order, with dates rewritten in UTC.
+// Hash index keys are built from it.
+func Canonical(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if t, ok := parseDate(v); ok {
+			return formatTime(t)
+		}
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = Canonical(item)
+		}
+		return items
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[key] = Canonical(item)
+		}
+		return object
+	}
+	if n, ok := numeric(value); ok {
+		if n == 0 {
+			// -0 equals 0 but would encode as "-0".
+			return 0.0
+		}
+		return n
+	}
+	return value
+}
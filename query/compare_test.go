@@ -0,0 +1,186 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// ordered lists values in the comparison order, one or more of each type.
+var ordered = []string{
+	`null`,
+	`-1`,
+	`2.5`,
+	`10`,
+	`""`,
+	`"10"`,
+	`"2"`,
+	`"2024-05-01"`,
+	`"2024-05-01T10:00:00"`,
+	`"abc"`,
+	`{}`,
+	`{"a": 1}`,
+	`{"a": 1, "b": 0}`,
+	`{"b": 0}`,
+	`[]`,
+	`[1]`,
+	`[1, 2]`,
+	`[2]`,
+	`false`,
+	`true`,
+	`"1999-12-31T23:59:59Z"`,
+	`"2024-05-01T10:00:00Z"`,
+	`"2024-05-01T12:00:01+02:00"`,
+}
+
+func decodeValue(t *testing.T, raw string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	return value
+}
+
+func TestCompareOrdersAcrossTypes(t *testing.T) {
+	for i, rawA := range ordered {
+		for j, rawB := range ordered {
+			want := sign(i - j)
+			if got := Compare(decodeValue(t, rawA), decodeValue(t, rawB)); got != want {
+				t.Errorf("Compare(%s, %s) = %d, want %d", rawA, rawB, got, want)
+			}
+		}
+	}
+}
+
+func TestCompareDateStrings(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		// An RFC 3339 timestamp compares by the instant it names.
+		{`"2024-05-01T12:00:00+02:00"`, `"2024-05-01T10:00:00Z"`, 0},
+		{`"2024-05-01T10:00:00.000Z"`, `"2024-05-01T10:00:00Z"`, 0},
+		{`"2024-05-01T09:00:00-02:00"`, `"2024-05-01T10:00:00Z"`, 1},
+		{`"2024-05-01T10:00:00.5Z"`, `"2024-05-01T10:00:00.25Z"`, 1},
+		// Without a time or a zone it is a plain string and compares by
+		// its bytes, below every date.
+		{`"2024-05-01T12:00:00"`, `"2024-05-01T10:00:00"`, 1},
+		{`"2024-05-01"`, `"2024-05-01T10:00:00Z"`, -1},
+		{`"9999"`, `"1970-01-01T00:00:00Z"`, -1},
+		{`"2024-13-01T10:00:00Z"`, `"2024-05-01T10:00:00Z"`, -1},
+		// Missing compares as null.
+		{`null`, `"2024-05-01T10:00:00Z"`, -1},
+	}
+	for _, tt := range tests {
+		a, b := decodeValue(t, tt.a), decodeValue(t, tt.b)
+		if got := Compare(a, b); got != tt.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := Compare(b, a); got != -tt.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+	if Compare(missingValue{}, nil) != 0 {
+		t.Error("a missing value does not equal null")
+	}
+}
+
+func TestComparisonOperatorsBracketTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		filter string
+		want   bool
+	}{
+		{"number above a number", `{"v": 30}`, `{"v": {"$gt": 25}}`, true},
+		{"string above a number", `{"v": "30"}`, `{"v": {"$gt": 25}}`, false},
+		{"boolean above a number", `{"v": true}`, `{"v": {"$gt": 25}}`, false},
+		{"null below a number", `{"v": null}`, `{"v": {"$lt": 25}}`, false},
+		{"missing below a number", `{}`, `{"v": {"$lt": 25}}`, false},
+		{"number below a string", `{"v": 1}`, `{"v": {"$lt": "a"}}`, false},
+		{"array above an array", `{"v": [1, 3]}`, `{"v": {"$gt": [1, 2]}}`, true},
+		{"number element above a number", `{"v": ["x", 30]}`, `{"v": {"$gt": 25}}`, true},
+		{"object below an object", `{"v": {"a": 1}}`, `{"v": {"$lt": {"b": 0}}}`, true},
+		{"false below true", `{"v": false}`, `{"v": {"$lt": true}}`, true},
+
+		{"string above a string", `{"v": "3"}`, `{"v": {"$gt": "2"}}`, true},
+		{"date-only string above a string", `{"v": "2024-05-01"}`, `{"v": {"$gt": "2"}}`, true},
+		{"date above a string", `{"v": "2024-05-01T10:00:00Z"}`, `{"v": {"$gt": "2"}}`, false},
+		{"date below a string", `{"v": "2024-05-01T10:00:00Z"}`, `{"v": {"$lt": "3"}}`, false},
+		{"string below a date", `{"v": "1"}`, `{"v": {"$lt": "2024-05-01T10:00:00Z"}}`, false},
+		{"date above a date", `{"v": "2024-05-01T10:00:01Z"}`, `{"v": {"$gt": "2024-05-01T10:00:00Z"}}`, true},
+		{"date above a date in another zone", `{"v": "2024-05-01T11:30:00+01:00"}`, `{"v": {"$gt": "2024-05-01T10:00:00Z"}}`, true},
+		{"date below by instant, above by bytes", `{"v": "2024-05-01T11:30:00+02:00"}`, `{"v": {"$lt": "2024-05-01T10:00:00Z"}}`, true},
+		{"date equal in another zone", `{"v": "2024-05-01T12:00:00+02:00"}`, `{"v": {"$gte": "2024-05-01T10:00:00Z", "$lte": "2024-05-01T10:00:00Z"}}`, true},
+
+		{"null gte null", `{"v": null}`, `{"v": {"$gte": null}}`, true},
+		{"missing lte null", `{}`, `{"v": {"$lte": null}}`, true},
+		{"missing gt null", `{}`, `{"v": {"$gt": null}}`, false},
+		{"number gte null", `{"v": 0}`, `{"v": {"$gte": null}}`, false},
+	}
+	q := NewQuery()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := q.Matches(decode(t, tt.doc), decode(t, tt.filter))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s on %s = %v, want %v", tt.filter, tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCanonicalAgreesWithCompare checks that hash index keys, built from
+// Canonical, group exactly the values that Compare finds equal.
+func TestCanonicalAgreesWithCompare(t *testing.T) {
+	values := append([]string{
+		`1`, `1.0`, `-0`, `0`,
+		`"2024-05-01T12:00:00+02:00"`, `"2024-05-01T10:00:00.000Z"`, `"2024-05-01T10:00:00Z"`,
+		`"2024-05-01T10:00:00"`,
+		`{"at": "2024-05-01T12:00:00+02:00", "n": 1}`, `{"n": 1.0, "at": "2024-05-01T10:00:00Z"}`,
+		`["2024-05-01T10:00:00Z", 2]`, `["2024-05-01T11:00:00+01:00", 2.0]`,
+	}, ordered...)
+
+	key := func(value interface{}) string {
+		encoded, err := json.Marshal(Canonical(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(encoded)
+	}
+	for _, rawA := range values {
+		for _, rawB := range values {
+			a, b := decodeValue(t, rawA), decodeValue(t, rawB)
+			equal := Compare(a, b) == 0
+			if sameKey := key(a) == key(b); sameKey != equal {
+				t.Errorf("%s and %s: same index key = %v, Compare equal = %v", rawA, rawB, sameKey, equal)
+			}
+		}
+	}
+}
+
+func TestSortStageUsesComparisonOrder(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`[{"$sort": {"v": 1}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Feed the values in reverse so the stage has to reorder all of them.
+	documents := make([]map[string]interface{}, 0, len(ordered))
+	for i := len(ordered) - 1; i >= 0; i-- {
+		documents = append(documents, decode(t, fmt.Sprintf(`{"i": %d, "v": %s}`, i, ordered[i])))
+	}
+
+	results := pipeline.Run(documents, nil, nil)
+	if len(results) != len(ordered) {
+		t.Fatalf("got %d documents, want %d", len(results), len(ordered))
+	}
+	for i, doc := range results {
+		if doc["i"] != float64(i) {
+			t.Fatalf("position %d holds %s, want %s", i, doc["v"], ordered[i])
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package query
+
+import (
+	"errors"
+	"strings"
+)
+
+// expression is a compiled aggregation expression evaluated against one
+// document and the variables in scope.
+type expression func(doc map[string]interface{}, vars map[string]interface{}) interface{}
+
+// missingValue is what a reference to an absent field evaluates to. Stages
+// leave such fields out of their output instead of writing null.
+type missingValue struct{}
+
+var missing = missingValue{}
+
+// compileExpression compiles an aggregation expression. A string starting
+// with "$" reads a field by its dotted path and one starting with "$$"
+// reads a variable, where $$ROOT is the current document. An object with a
+// single "$" key applies an operator, {"$literal": value} returns value as
+// is, other objects are evaluated field by field, and anything else is a
+// literal.
+func compileExpression(expr interface{}) (expression, error) {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$$") {
+			return compileVariable(e[2:])
+		}
+		if strings.HasPrefix(e, "$") {
+			path := e[1:]
+			if path == "" {
+				return nil, errors.New("invalid field path: " + e)
+			}
+			return func(doc map[string]interface{}, _ map[string]interface{}) interface{} {
+				return fieldValue(doc, path)
+			}, nil
+		}
+	case map[string]interface{}:
+		return compileObjectExpression(e)
+	case []interface{}:
+		items := make([]expression, len(e))
+		for i, item := range e {
+			compiled, err := compileExpression(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = compiled
+		}
+		return func(doc map[string]interface{}, vars map[string]interface{}) interface{} {
+			values := make([]interface{}, len(items))
+			for i, item := range items {
+				if values[i] = item(doc, vars); values[i] == missing {
+					values[i] = nil
+				}
+			}
+			return values
+		}, nil
+	}
+	return func(map[string]interface{}, map[string]interface{}) interface{} { return expr }, nil
+}
+
+// compileVariable compiles a reference such as "$$order.total".
+func compileVariable(reference string) (expression, error) {
+	name, path, _ := strings.Cut(reference, ".")
+	if name == "ROOT" || name == "CURRENT" {
+		return func(doc map[string]interface{}, _ map[string]interface{}) interface{} {
+			if path == "" {
+				return doc
+			}
+			return fieldValue(doc, path)
+		}, nil
+	}
+	if !validVariable(name) {
+		return nil, errors.New("invalid variable name: " + name)
+	}
+
+	return func(_ map[string]interface{}, vars map[string]interface{}) interface{} {
+		value, exists := vars[name]
+		if !exists {
+			return missing
+		}
+		if path == "" {
+			return value
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return missing
+		}
+		return fieldValue(object, path)
+	}, nil
+}
+
+// validVariable reports whether name can be bound by "let". User variables
+// start with a lowercase letter or an underscore so they never clash with
+// system variables such as ROOT.
+func validVariable(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_' || ('a' <= r && r <= 'z'):
+		case i > 0 && (('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+func compileObjectExpression(e map[string]interface{}) (expression, error) {
+	if value, exists := e["$literal"]; exists && len(e) == 1 {
+		return func(map[string]interface{}, map[string]interface{}) interface{} { return value }, nil
+	}
+
+	fields := make(map[string]expression, len(e))
+	for key, value := range e {
+		if strings.HasPrefix(key, "$") {
+			if len(e) != 1 {
+				return nil, errors.New("an operator must be the only field of its object: " + key)
+			}
+			return compileOperator(key, value)
+		}
+		compiled, err := compileExpression(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = compiled
+	}
+	return func(doc map[string]interface{}, vars map[string]interface{}) interface{} {
+		result := make(map[string]interface{}, len(fields))
+		for key, field := range fields {
+			if value := field(doc, vars); value != missing {
+				result[key] = value
+			}
+		}
+		return result
+	}, nil
+}
+
+func fieldValue(doc map[string]interface{}, path string) interface{} {
+	if value, ok := lookupField(doc, path); ok {
+		return value
+	}
+	return missing
+}
+
+// lookupField reads a dotted path and reports whether it exists.
+func lookupField(data map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// withField returns a copy of data with the dotted path set to value. Only
+// the maps along the path are copied; data itself is left untouched.
+func withField(data map[string]interface{}, path []string, value interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(data)+1)
+	for key, v := range data {
+		result[key] = v
+	}
+	if len(path) == 1 {
+		result[path[0]] = value
+		return result
+	}
+	child, _ := data[path[0]].(map[string]interface{})
+	result[path[0]] = withField(child, path[1:], value)
+	return result
+}
+
+// withoutField returns a copy of data with the dotted path removed.
+func withoutField(data map[string]interface{}, path []string) map[string]interface{} {
+	if _, exists := data[path[0]]; !exists {
+		return data
+	}
+	result := make(map[string]interface{}, len(data))
+	for key, v := range data {
+		result[key] = v
+	}
+	if len(path) == 1 {
+		delete(result, path[0])
+		return result
+	}
+	if child, ok := data[path[0]].(map[string]interface{}); ok {
+		result[path[0]] = withoutField(child, path[1:])
+	}
+	return result
+}
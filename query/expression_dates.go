@@ -0,0 +1,445 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dates have no JSON type of their own, so date operators accept RFC 3339
+// strings or unix timestamps in seconds, the same forms TTL fields take,
+// and return dates as RFC 3339 strings in UTC.
+
+func toTime(value interface{}) (time.Time, bool) {
+	if s, ok := value.(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t.UTC(), err == nil
+	}
+	if n, ok := numeric(value); ok && !math.IsNaN(n) && !math.IsInf(n, 0) {
+		return time.Unix(0, int64(n*float64(time.Second))).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+var dateUnits = map[string]bool{
+	"year": true, "quarter": true, "month": true, "week": true, "day": true,
+	"hour": true, "minute": true, "second": true, "millisecond": true,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+func dateOperators() map[string]operatorCompiler {
+	return map[string]operatorCompiler{
+		"$year":         datePartOperator(func(t time.Time) int { return t.Year() }),
+		"$month":        datePartOperator(func(t time.Time) int { return int(t.Month()) }),
+		"$dayOfMonth":   datePartOperator(func(t time.Time) int { return t.Day() }),
+		"$dayOfWeek":    datePartOperator(func(t time.Time) int { return int(t.Weekday()) + 1 }),
+		"$dayOfYear":    datePartOperator(func(t time.Time) int { return t.YearDay() }),
+		"$hour":         datePartOperator(func(t time.Time) int { return t.Hour() }),
+		"$minute":       datePartOperator(func(t time.Time) int { return t.Minute() }),
+		"$second":       datePartOperator(func(t time.Time) int { return t.Second() }),
+		"$millisecond":  datePartOperator(func(t time.Time) int { return t.Nanosecond() / int(time.Millisecond) }),
+		"$dateTrunc":    compileDateTrunc,
+		"$dateAdd":      dateAddOperator(1),
+		"$dateSubtract": dateAddOperator(-1),
+		"$dateDiff":     compileDateDiff,
+		"$dateToString": compileDateToString,
+	}
+}
+
+// literalString reads an optional argument that must be a literal string.
+func literalString(object map[string]interface{}, name string) (string, error) {
+	value, exists := object[name]
+	if !exists {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", name)
+	}
+	return s, nil
+}
+
+// dateArguments splits the arguments of a date operator into the literal
+// unit, timezone and startOfWeek, which are checked once, and the rest,
+// which are compiled as expressions.
+func dateArguments(arg interface{}, required []string, optional ...string) (map[string]expression, map[string]string, error) {
+	object, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, nil, errors.New("takes an object")
+	}
+
+	literals := make(map[string]string)
+	rest := make(map[string]interface{}, len(object))
+	for name, value := range object {
+		switch name {
+		case "unit", "timezone", "startOfWeek", "format":
+			s, err := literalString(object, name)
+			if err != nil {
+				return nil, nil, err
+			}
+			literals[name] = s
+		default:
+			rest[name] = value
+		}
+	}
+
+	if unit, exists := literals["unit"]; exists && !dateUnits[unit] {
+		return nil, nil, errors.New("unknown unit " + unit)
+	}
+	if day, exists := literals["startOfWeek"]; exists {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return nil, nil, errors.New("unknown startOfWeek " + day)
+		}
+	}
+	if zone, exists := literals["timezone"]; exists {
+		if _, err := time.LoadLocation(zone); err != nil {
+			return nil, nil, errors.New("unknown timezone " + zone)
+		}
+	}
+
+	named, err := compileNamed(rest, required, optional...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return named, literals, nil
+}
+
+func location(literals map[string]string) *time.Location {
+	if zone := literals["timezone"]; zone != "" {
+		loc, _ := time.LoadLocation(zone)
+		return loc
+	}
+	return time.UTC
+}
+
+// datePartOperator extracts a part of a date. It takes a date, or
+// {"date": ..., "timezone": "..."} to read the part in another zone.
+func datePartOperator(part func(time.Time) int) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		loc := time.UTC
+		var date expression
+		if object, ok := arg.(map[string]interface{}); ok {
+			if _, isOperator := singleOperator(object); !isOperator {
+				named, literals, err := dateArguments(object, []string{"date"}, "timezone")
+				if err != nil {
+					return nil, err
+				}
+				date, loc = named["date"], location(literals)
+			}
+		}
+		if date == nil {
+			operands, err := compileOperands(arg, 1, 1)
+			if err != nil {
+				return nil, err
+			}
+			date = operands[0]
+		}
+
+		return func(doc, vars map[string]interface{}) interface{} {
+			t, ok := toTime(date(doc, vars))
+			if !ok {
+				return nil
+			}
+			return float64(part(t.In(loc)))
+		}, nil
+	}
+}
+
+// singleOperator reports whether an object is an operator expression.
+func singleOperator(object map[string]interface{}) (string, bool) {
+	if len(object) != 1 {
+		return "", false
+	}
+	for key := range object {
+		return key, strings.HasPrefix(key, "$")
+	}
+	return "", false
+}
+
+// compileDateTrunc takes {"date", "unit", "binSize", "timezone",
+// "startOfWeek"} and rounds a date down to the start of its bin. Bins of
+// days or longer follow the calendar in the timezone; shorter ones count
+// from the unix epoch.
+func compileDateTrunc(arg interface{}) (expression, error) {
+	named, literals, err := dateArguments(arg, []string{"date"}, "binSize")
+	if err != nil {
+		return nil, err
+	}
+	unit := literals["unit"]
+	if unit == "" {
+		return nil, errors.New("missing argument unit")
+	}
+	loc := location(literals)
+	startOfWeek := time.Sunday
+	if day := literals["startOfWeek"]; day != "" {
+		startOfWeek = weekdays[strings.ToLower(day)]
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		t, ok := toTime(named["date"](doc, vars))
+		if !ok {
+			return nil
+		}
+		binSize := 1
+		if expr, exists := named["binSize"]; exists {
+			n, ok := numeric(expr(doc, vars))
+			if !ok || n < 1 {
+				return nil
+			}
+			binSize = int(n)
+		}
+		return formatTime(truncate(t.In(loc), unit, binSize, startOfWeek))
+	}, nil
+}
+
+func truncate(t time.Time, unit string, binSize int, startOfWeek time.Weekday) time.Time {
+	loc := t.Location()
+	switch unit {
+	case "year":
+		return time.Date(t.Year()-floorMod(t.Year()-1970, binSize), 1, 1, 0, 0, 0, 0, loc)
+	case "quarter", "month":
+		months := binSize
+		if unit == "quarter" {
+			months *= 3
+		}
+		index := (t.Year()-1970)*12 + int(t.Month()) - 1
+		index -= floorMod(index, months)
+		return time.Date(1970, time.Month(index+1), 1, 0, 0, 0, 0, loc)
+	case "week", "day":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		if unit == "week" {
+			day = day.AddDate(0, 0, -floorMod(int(day.Weekday())-int(startOfWeek), 7))
+			binSize *= 7
+		}
+		// Count days from a reference day so bins line up across months.
+		reference := time.Date(2000, 1, 2, 0, 0, 0, 0, loc)
+		if unit == "week" {
+			reference = reference.AddDate(0, 0, floorMod(int(startOfWeek)-int(reference.Weekday()), 7))
+		}
+		days := int(math.Round(float64(day.Sub(reference)) / float64(24*time.Hour)))
+		return day.AddDate(0, 0, -floorMod(days, binSize))
+	}
+
+	size := time.Duration(binSize) * unitDuration(unit)
+	return time.Unix(0, t.UnixNano()-floorMod64(t.UnixNano(), int64(size))).In(loc)
+}
+
+func unitDuration(unit string) time.Duration {
+	switch unit {
+	case "hour":
+		return time.Hour
+	case "minute":
+		return time.Minute
+	case "second":
+		return time.Second
+	case "millisecond":
+		return time.Millisecond
+	case "week":
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func floorMod(a, b int) int {
+	return int(floorMod64(int64(a), int64(b)))
+}
+
+func floorMod64(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+// dateAddOperator takes {"startDate", "unit", "amount", "timezone"} and
+// moves a date by a number of units, backwards when sign is -1.
+func dateAddOperator(sign int) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		named, literals, err := dateArguments(arg, []string{"startDate", "amount"})
+		if err != nil {
+			return nil, err
+		}
+		unit := literals["unit"]
+		if unit == "" {
+			return nil, errors.New("missing argument unit")
+		}
+		loc := location(literals)
+
+		return func(doc, vars map[string]interface{}) interface{} {
+			t, ok := toTime(named["startDate"](doc, vars))
+			amount, okAmount := numeric(named["amount"](doc, vars))
+			if !ok || !okAmount {
+				return nil
+			}
+			n := sign * int(math.Trunc(amount))
+			t = t.In(loc)
+			switch unit {
+			case "year":
+				t = addMonths(t, 12*n)
+			case "quarter":
+				t = addMonths(t, 3*n)
+			case "month":
+				t = addMonths(t, n)
+			case "week":
+				t = t.AddDate(0, 0, 7*n)
+			case "day":
+				t = t.AddDate(0, 0, n)
+			default:
+				t = t.Add(time.Duration(n) * unitDuration(unit))
+			}
+			return formatTime(t)
+		}, nil
+	}
+}
+
+// addMonths moves t by n calendar months, keeping the wall clock time. A
+// day past the end of the target month becomes its last day, so January
+// 31 plus one month is the end of February rather than early March.
+func addMonths(t time.Time, n int) time.Time {
+	year, month, day := t.Date()
+	last := time.Date(year, month+time.Month(n)+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month+time.Month(n), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// compileDateDiff takes {"startDate", "endDate", "unit", "timezone",
+// "startOfWeek"} and counts the unit boundaries crossed between two dates.
+func compileDateDiff(arg interface{}) (expression, error) {
+	named, literals, err := dateArguments(arg, []string{"startDate", "endDate"})
+	if err != nil {
+		return nil, err
+	}
+	unit := literals["unit"]
+	if unit == "" {
+		return nil, errors.New("missing argument unit")
+	}
+	loc := location(literals)
+	startOfWeek := time.Sunday
+	if day := literals["startOfWeek"]; day != "" {
+		startOfWeek = weekdays[strings.ToLower(day)]
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		start, ok := toTime(named["startDate"](doc, vars))
+		end, okEnd := toTime(named["endDate"](doc, vars))
+		if !ok || !okEnd {
+			return nil
+		}
+		start, end = start.In(loc), end.In(loc)
+
+		switch unit {
+		case "year":
+			return float64(end.Year() - start.Year())
+		case "quarter":
+			return float64((end.Year()-start.Year())*4 + (int(end.Month())-1)/3 - (int(start.Month())-1)/3)
+		case "month":
+			return float64((end.Year()-start.Year())*12 + int(end.Month()) - int(start.Month()))
+		case "week", "day":
+			from := truncate(start, unit, 1, startOfWeek)
+			to := truncate(end, unit, 1, startOfWeek)
+			return math.Round(float64(to.Sub(from)) / float64(unitDuration(unit)))
+		}
+		size := int64(unitDuration(unit))
+		from := start.UnixNano() - floorMod64(start.UnixNano(), size)
+		to := end.UnixNano() - floorMod64(end.UnixNano(), size)
+		return float64((to - from) / size)
+	}, nil
+}
+
+// compileDateToString takes {"date", "format", "timezone", "onNull"}. The
+// format supports %Y, %m, %d, %H, %M, %S, %L (milliseconds), %j (day of
+// year), %u (ISO day of week) and %%; without one the date is returned in
+// RFC 3339.
+func compileDateToString(arg interface{}) (expression, error) {
+	named, literals, err := dateArguments(arg, []string{"date"}, "onNull")
+	if err != nil {
+		return nil, err
+	}
+	format, hasFormat := literals["format"]
+	if hasFormat {
+		if err := checkDateFormat(format); err != nil {
+			return nil, err
+		}
+	}
+	loc := location(literals)
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		value := named["date"](doc, vars)
+		if nullish(value) {
+			if onNull, exists := named["onNull"]; exists {
+				return onNull(doc, vars)
+			}
+			return nil
+		}
+		t, ok := toTime(value)
+		if !ok {
+			return nil
+		}
+		t = t.In(loc)
+		if !hasFormat {
+			return t.Format(time.RFC3339Nano)
+		}
+		return formatDate(t, format)
+	}, nil
+}
+
+func checkDateFormat(format string) error {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		if i+1 == len(format) || !strings.ContainsRune("YmdHMSLju%", rune(format[i+1])) {
+			return errors.New("invalid format specifier at position " + strconv.Itoa(i))
+		}
+		i++
+	}
+	return nil
+}
+
+func formatDate(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'L':
+			fmt.Fprintf(&b, "%03d", t.Nanosecond()/int(time.Millisecond))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'u':
+			fmt.Fprintf(&b, "%d", (int(t.Weekday())+6)%7+1)
+		case '%':
+			b.WriteByte('%')
+		}
+	}
+	return b.String()
+}
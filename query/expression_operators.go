@@ -0,0 +1,1205 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// operatorCompiler compiles the argument of an expression operator such as
+// {"$add": ["$price", "$tax"]}.
+type operatorCompiler func(arg interface{}) (expression, error)
+
+var expressionOperators map[string]operatorCompiler
+
+func init() {
+	expressionOperators = map[string]operatorCompiler{
+		// Arithmetic
+		"$add":      numericOperator(1, -1, sum),
+		"$subtract": numericOperator(2, 2, func(n []float64) (float64, bool) { return n[0] - n[1], true }),
+		"$multiply": numericOperator(1, -1, product),
+		"$divide":   numericOperator(2, 2, func(n []float64) (float64, bool) { return n[0] / n[1], n[1] != 0 }),
+		"$mod":      numericOperator(2, 2, func(n []float64) (float64, bool) { return math.Mod(n[0], n[1]), n[1] != 0 }),
+		"$pow":      numericOperator(2, 2, func(n []float64) (float64, bool) { return math.Pow(n[0], n[1]), true }),
+		"$abs":      numericOperator(1, 1, func(n []float64) (float64, bool) { return math.Abs(n[0]), true }),
+		"$ceil":     numericOperator(1, 1, func(n []float64) (float64, bool) { return math.Ceil(n[0]), true }),
+		"$floor":    numericOperator(1, 1, func(n []float64) (float64, bool) { return math.Floor(n[0]), true }),
+		"$sqrt":     numericOperator(1, 1, func(n []float64) (float64, bool) { return math.Sqrt(n[0]), n[0] >= 0 }),
+		"$exp":      numericOperator(1, 1, func(n []float64) (float64, bool) { return math.Exp(n[0]), true }),
+		"$ln":       numericOperator(1, 1, func(n []float64) (float64, bool) { return math.Log(n[0]), n[0] > 0 }),
+		"$log10":    numericOperator(1, 1, func(n []float64) (float64, bool) { return math.Log10(n[0]), n[0] > 0 }),
+		"$round":    numericOperator(1, 2, func(n []float64) (float64, bool) { return atPlace(n, math.RoundToEven), true }),
+		"$trunc":    numericOperator(1, 2, func(n []float64) (float64, bool) { return atPlace(n, math.Trunc), true }),
+
+		// Comparison and boolean
+		"$eq":  comparisonOperator(func(c int) bool { return c == 0 }),
+		"$ne":  comparisonOperator(func(c int) bool { return c != 0 }),
+		"$gt":  comparisonOperator(func(c int) bool { return c > 0 }),
+		"$gte": comparisonOperator(func(c int) bool { return c >= 0 }),
+		"$lt":  comparisonOperator(func(c int) bool { return c < 0 }),
+		"$lte": comparisonOperator(func(c int) bool { return c <= 0 }),
+		"$cmp": compileCmp,
+		"$and": compileAnd,
+		"$or":  compileOr,
+		"$not": compileNot,
+
+		// Conditional
+		"$cond":   compileCond,
+		"$ifNull": compileIfNull,
+		"$switch": compileSwitch,
+		"$let":    compileLet,
+
+		// String
+		"$concat":      compileConcat,
+		"$toLower":     stringOperator(strings.ToLower),
+		"$toUpper":     stringOperator(strings.ToUpper),
+		"$substr":      compileSubstr(true),
+		"$substrCP":    compileSubstr(true),
+		"$substrBytes": compileSubstr(false),
+		"$strLenCP":    compileStrLen,
+		"$split":       compileSplit,
+		"$indexOfCP":   compileIndexOf,
+		"$trim":        compileTrim(strings.Trim, strings.TrimSpace),
+		"$ltrim":       compileTrim(strings.TrimLeft, func(s string) string { return strings.TrimLeftFunc(s, isSpace) }),
+		"$rtrim":       compileTrim(strings.TrimRight, func(s string) string { return strings.TrimRightFunc(s, isSpace) }),
+		"$regexMatch":  compileRegexMatch,
+		"$replaceAll":  compileReplace(-1),
+		"$replaceOne":  compileReplace(1),
+
+		// Array
+		"$size":         compileSize,
+		"$arrayElemAt":  compileArrayElemAt,
+		"$first":        arrayEndOperator(true),
+		"$last":         arrayEndOperator(false),
+		"$concatArrays": compileConcatArrays,
+		"$in":           compileIn,
+		"$isArray":      compileIsArray,
+		"$reverseArray": compileReverseArray,
+		"$slice":        compileSlice,
+		"$range":        compileRange,
+		"$indexOfArray": compileIndexOfArray,
+		"$map":          compileMap,
+		"$filter":       compileFilter,
+		"$reduce":       compileReduce,
+
+		// Type
+		"$type":      compileType,
+		"$isNumber":  compileIsNumber,
+		"$convert":   compileConvert,
+		"$toString":  conversionOperator("string"),
+		"$toInt":     conversionOperator("int"),
+		"$toLong":    conversionOperator("long"),
+		"$toDouble":  conversionOperator("double"),
+		"$toDecimal": conversionOperator("decimal"),
+		"$toBool":    conversionOperator("bool"),
+		"$toDate":    conversionOperator("date"),
+
+		"$meta": compileMeta,
+	}
+
+	for name, compiler := range dateOperators() {
+		expressionOperators[name] = compiler
+	}
+}
+
+// compileOperator compiles {"$op": arg}.
+func compileOperator(op string, arg interface{}) (expression, error) {
+	compiler, exists := expressionOperators[op]
+	if !exists {
+		return nil, errors.New("unknown expression operator: " + op)
+	}
+	expr, err := compiler(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	return expr, nil
+}
+
+// compileOperands compiles the operands of an operator. A single operand
+// may be given without the surrounding array. max is -1 for no limit.
+func compileOperands(arg interface{}, min, max int) ([]expression, error) {
+	list, ok := arg.([]interface{})
+	if !ok {
+		list = []interface{}{arg}
+	}
+	if len(list) < min || (max >= 0 && len(list) > max) {
+		if min == max {
+			return nil, fmt.Errorf("takes %d arguments", min)
+		}
+		return nil, fmt.Errorf("takes %d to %d arguments", min, max)
+	}
+
+	operands := make([]expression, len(list))
+	for i, item := range list {
+		compiled, err := compileExpression(item)
+		if err != nil {
+			return nil, err
+		}
+		operands[i] = compiled
+	}
+	return operands, nil
+}
+
+// compileNamed compiles the fields of an operator that takes an object of
+// named arguments. Required names must be present; others may be.
+func compileNamed(arg interface{}, required []string, optional ...string) (map[string]expression, error) {
+	object, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("takes an object")
+	}
+
+	allowed := make(map[string]bool)
+	for _, name := range append(required, optional...) {
+		allowed[name] = true
+	}
+	for name := range object {
+		if !allowed[name] {
+			return nil, errors.New("unknown argument " + name)
+		}
+	}
+
+	named := make(map[string]expression, len(object))
+	for _, name := range required {
+		if _, exists := object[name]; !exists {
+			return nil, errors.New("missing argument " + name)
+		}
+	}
+	for name, value := range object {
+		compiled, err := compileExpression(value)
+		if err != nil {
+			return nil, err
+		}
+		named[name] = compiled
+	}
+	return named, nil
+}
+
+// withoutArgument returns a copy of an operator's arguments without the
+// named one, for arguments that are not expressions.
+func withoutArgument(object map[string]interface{}, name string) map[string]interface{} {
+	rest := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		if key != name {
+			rest[key] = value
+		}
+	}
+	return rest
+}
+
+func nullish(v interface{}) bool {
+	return v == nil || v == missing
+}
+
+// truthy follows the aggregation rules: false, null, missing and zero are
+// false and everything else is true.
+func truthy(v interface{}) bool {
+	switch x := v.(type) {
+	case nil, missingValue:
+		return false
+	case bool:
+		return x
+	}
+	if n, ok := numeric(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+// withVariables returns a copy of vars with extra bindings.
+func withVariables(vars map[string]interface{}, bindings map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(vars)+len(bindings))
+	for name, value := range vars {
+		result[name] = value
+	}
+	for name, value := range bindings {
+		result[name] = value
+	}
+	return result
+}
+
+// Arithmetic
+
+// numericOperator applies f to numeric operands. The result is null when
+// an operand is null, missing or not a number, or when f is undefined for
+// the operands, such as a division by zero.
+func numericOperator(min, max int, f func([]float64) (float64, bool)) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		operands, err := compileOperands(arg, min, max)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc, vars map[string]interface{}) interface{} {
+			values := make([]float64, len(operands))
+			for i, operand := range operands {
+				n, ok := numeric(operand(doc, vars))
+				if !ok {
+					return nil
+				}
+				values[i] = n
+			}
+			result, ok := f(values)
+			if !ok || math.IsNaN(result) || math.IsInf(result, 0) {
+				return nil
+			}
+			return result
+		}, nil
+	}
+}
+
+func sum(n []float64) (float64, bool) {
+	total := 0.0
+	for _, v := range n {
+		total += v
+	}
+	return total, true
+}
+
+func product(n []float64) (float64, bool) {
+	total := 1.0
+	for _, v := range n {
+		total *= v
+	}
+	return total, true
+}
+
+// atPlace rounds n[0] with f to n[1] decimal places, or to an integer.
+func atPlace(n []float64, f func(float64) float64) float64 {
+	if len(n) == 1 || n[1] == 0 {
+		return f(n[0])
+	}
+	scale := math.Pow(10, math.Trunc(n[1]))
+	return f(n[0]*scale) / scale
+}
+
+// Comparison and boolean
+
+func comparisonOperator(test func(int) bool) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		operands, err := compileOperands(arg, 2, 2)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc, vars map[string]interface{}) interface{} {
+			return test(compareOrder(operands[0](doc, vars), operands[1](doc, vars)))
+		}, nil
+	}
+}
+
+func compileCmp(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		return float64(compareOrder(operands[0](doc, vars), operands[1](doc, vars)))
+	}, nil
+}
+
+func compileAnd(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		for _, operand := range operands {
+			if !truthy(operand(doc, vars)) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func compileOr(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		for _, operand := range operands {
+			if truthy(operand(doc, vars)) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func compileNot(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		return !truthy(operands[0](doc, vars))
+	}, nil
+}
+
+// Conditional
+
+func compileCond(arg interface{}) (expression, error) {
+	var cond, then, otherwise expression
+	if object, ok := arg.(map[string]interface{}); ok {
+		named, err := compileNamed(object, []string{"if", "then", "else"})
+		if err != nil {
+			return nil, err
+		}
+		cond, then, otherwise = named["if"], named["then"], named["else"]
+	} else {
+		operands, err := compileOperands(arg, 3, 3)
+		if err != nil {
+			return nil, err
+		}
+		cond, then, otherwise = operands[0], operands[1], operands[2]
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		if truthy(cond(doc, vars)) {
+			return then(doc, vars)
+		}
+		return otherwise(doc, vars)
+	}, nil
+}
+
+// compileIfNull returns the first operand that is neither null nor
+// missing, or the last one.
+func compileIfNull(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		for _, operand := range operands[:len(operands)-1] {
+			if value := operand(doc, vars); !nullish(value) {
+				return value
+			}
+		}
+		return operands[len(operands)-1](doc, vars)
+	}, nil
+}
+
+// compileSwitch evaluates the first branch whose case is true, or default.
+// Without a default it returns null when no case matches.
+func compileSwitch(arg interface{}) (expression, error) {
+	object, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("takes an object with branches and default")
+	}
+	branches, ok := object["branches"].([]interface{})
+	if !ok || len(branches) == 0 {
+		return nil, errors.New("branches must be a non-empty array")
+	}
+
+	type branch struct{ when, then expression }
+	compiled := make([]branch, len(branches))
+	for i, b := range branches {
+		named, err := compileNamed(b, []string{"case", "then"})
+		if err != nil {
+			return nil, fmt.Errorf("branch %d: %v", i, err)
+		}
+		compiled[i] = branch{when: named["case"], then: named["then"]}
+	}
+
+	var otherwise expression
+	if value, exists := object["default"]; exists {
+		var err error
+		if otherwise, err = compileExpression(value); err != nil {
+			return nil, err
+		}
+	}
+	for name := range object {
+		if name != "branches" && name != "default" {
+			return nil, errors.New("unknown argument " + name)
+		}
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		for _, b := range compiled {
+			if truthy(b.when(doc, vars)) {
+				return b.then(doc, vars)
+			}
+		}
+		if otherwise != nil {
+			return otherwise(doc, vars)
+		}
+		return nil
+	}, nil
+}
+
+// compileLet binds variables for the "in" expression.
+func compileLet(arg interface{}) (expression, error) {
+	object, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("takes an object with vars and in")
+	}
+	definitions, ok := object["vars"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("vars must be an object")
+	}
+	if _, exists := object["in"]; !exists {
+		return nil, errors.New("missing argument in")
+	}
+
+	bindings := make(map[string]expression, len(definitions))
+	for name, value := range definitions {
+		if !validVariable(name) {
+			return nil, errors.New("invalid variable name: " + name)
+		}
+		compiled, err := compileExpression(value)
+		if err != nil {
+			return nil, err
+		}
+		bindings[name] = compiled
+	}
+	in, err := compileExpression(object["in"])
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		values := make(map[string]interface{}, len(bindings))
+		for name, binding := range bindings {
+			values[name] = binding(doc, vars)
+		}
+		return in(doc, withVariables(vars, values))
+	}, nil
+}
+
+// String
+
+func compileConcat(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		var b strings.Builder
+		for _, operand := range operands {
+			s, ok := operand(doc, vars).(string)
+			if !ok {
+				return nil
+			}
+			b.WriteString(s)
+		}
+		return b.String()
+	}, nil
+}
+
+// stringOperator applies f to the operand converted to a string. Null and
+// missing become the empty string.
+func stringOperator(f func(string) string) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		operands, err := compileOperands(arg, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc, vars map[string]interface{}) interface{} {
+			value := operands[0](doc, vars)
+			if nullish(value) {
+				return ""
+			}
+			s, ok := convert(value, "string")
+			if !ok {
+				return nil
+			}
+			return f(s.(string))
+		}, nil
+	}
+}
+
+// compileSubstr takes [string, start, length], counting code points or
+// bytes. A negative length runs to the end of the string.
+func compileSubstr(codePoints bool) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		operands, err := compileOperands(arg, 3, 3)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc, vars map[string]interface{}) interface{} {
+			value := operands[0](doc, vars)
+			if nullish(value) {
+				return ""
+			}
+			s, ok := value.(string)
+			start, okStart := numeric(operands[1](doc, vars))
+			length, okLength := numeric(operands[2](doc, vars))
+			if !ok || !okStart || !okLength || start < 0 {
+				return nil
+			}
+
+			if codePoints {
+				runes := []rune(s)
+				from, to := bounds(int(start), int(length), len(runes))
+				return string(runes[from:to])
+			}
+			from, to := bounds(int(start), int(length), len(s))
+			return s[from:to]
+		}, nil
+	}
+}
+
+func bounds(start, length, size int) (int, int) {
+	if start > size {
+		start = size
+	}
+	end := size
+	if length >= 0 && start+length < size {
+		end = start + length
+	}
+	return start, end
+}
+
+func compileStrLen(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		s, ok := operands[0](doc, vars).(string)
+		if !ok {
+			return nil
+		}
+		return float64(utf8.RuneCountInString(s))
+	}, nil
+}
+
+func compileSplit(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		s, ok := operands[0](doc, vars).(string)
+		separator, okSeparator := operands[1](doc, vars).(string)
+		if !ok || !okSeparator || separator == "" {
+			return nil
+		}
+		parts := strings.Split(s, separator)
+		result := make([]interface{}, len(parts))
+		for i, part := range parts {
+			result[i] = part
+		}
+		return result
+	}, nil
+}
+
+// compileIndexOf returns the code point index of a substring, or -1. It
+// takes [string, substring] with an optional start and end index.
+func compileIndexOf(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, 4)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		s, ok := operands[0](doc, vars).(string)
+		sub, okSub := operands[1](doc, vars).(string)
+		if !ok || !okSub {
+			return nil
+		}
+		runes := []rune(s)
+		start, end := 0, len(runes)
+		if len(operands) > 2 {
+			n, ok := numeric(operands[2](doc, vars))
+			if !ok || n < 0 {
+				return nil
+			}
+			start = int(n)
+		}
+		if len(operands) > 3 {
+			n, ok := numeric(operands[3](doc, vars))
+			if !ok || n < 0 {
+				return nil
+			}
+			end = int(n)
+		}
+		if end > len(runes) {
+			end = len(runes)
+		}
+		if start > end {
+			return float64(-1)
+		}
+		i := strings.Index(string(runes[start:end]), sub)
+		if i < 0 {
+			return float64(-1)
+		}
+		return float64(start + utf8.RuneCountInString(string(runes[start:end])[:i]))
+	}, nil
+}
+
+// compileTrim takes {"input": ..., "chars": ...}. Without chars it trims
+// whitespace.
+func compileTrim(trim func(string, string) string, trimSpace func(string) string) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		named, err := compileNamed(arg, []string{"input"}, "chars")
+		if err != nil {
+			return nil, err
+		}
+		return func(doc, vars map[string]interface{}) interface{} {
+			s, ok := named["input"](doc, vars).(string)
+			if !ok {
+				return nil
+			}
+			if chars, exists := named["chars"]; exists {
+				set, ok := chars(doc, vars).(string)
+				if !ok {
+					return nil
+				}
+				return trim(s, set)
+			}
+			return trimSpace(s)
+		}, nil
+	}
+}
+
+func isSpace(r rune) bool {
+	return strings.ContainsRune(" \t\n\v\f\r", r)
+}
+
+// compileRegexMatch takes {"input": ..., "regex": "...", "options": "..."}.
+// The pattern and options must be literal strings so they are compiled
+// once; the options are Go's flags i, m and s.
+func compileRegexMatch(arg interface{}) (expression, error) {
+	object, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("takes an object with input and regex")
+	}
+	pattern, ok := object["regex"].(string)
+	if !ok {
+		return nil, errors.New("regex must be a string")
+	}
+	if options, exists := object["options"]; exists {
+		flags, ok := options.(string)
+		if !ok || strings.Trim(flags, "ims") != "" {
+			return nil, errors.New("options may only contain i, m and s")
+		}
+		if flags != "" {
+			pattern = "(?" + flags + ")" + pattern
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	input, err := compileExpression(object["input"])
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		s, ok := input(doc, vars).(string)
+		return ok && re.MatchString(s)
+	}, nil
+}
+
+// compileReplace takes {"input", "find", "replacement"} and replaces n
+// occurrences, or all of them when n is -1.
+func compileReplace(n int) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		named, err := compileNamed(arg, []string{"input", "find", "replacement"})
+		if err != nil {
+			return nil, err
+		}
+		return func(doc, vars map[string]interface{}) interface{} {
+			s, ok := named["input"](doc, vars).(string)
+			find, okFind := named["find"](doc, vars).(string)
+			replacement, okReplacement := named["replacement"](doc, vars).(string)
+			if !ok || !okFind || !okReplacement {
+				return nil
+			}
+			return strings.Replace(s, find, replacement, n)
+		}, nil
+	}
+}
+
+// Array
+
+func arrayOperand(arg interface{}) (expression, error) {
+	// A literal array operand is the array itself, not an argument list.
+	if list, ok := arg.([]interface{}); ok && len(list) == 1 {
+		arg = list[0]
+	}
+	return compileExpression(arg)
+}
+
+func compileSize(arg interface{}) (expression, error) {
+	operand, err := arrayOperand(arg)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		items, ok := operand(doc, vars).([]interface{})
+		if !ok {
+			return nil
+		}
+		return float64(len(items))
+	}, nil
+}
+
+func compileArrayElemAt(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		items, ok := operands[0](doc, vars).([]interface{})
+		n, okIndex := numeric(operands[1](doc, vars))
+		if !ok || !okIndex {
+			return nil
+		}
+		i := int(n)
+		if i < 0 {
+			i += len(items)
+		}
+		if i < 0 || i >= len(items) {
+			return missing
+		}
+		return items[i]
+	}, nil
+}
+
+// arrayEndOperator returns the first or last element of an array. In a
+// $group field, $first and $last are accumulators instead.
+func arrayEndOperator(first bool) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		operand, err := arrayOperand(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc, vars map[string]interface{}) interface{} {
+			value := operand(doc, vars)
+			items, ok := value.([]interface{})
+			if !ok {
+				if nullish(value) {
+					return nil
+				}
+				return missing
+			}
+			if len(items) == 0 {
+				return missing
+			}
+			if first {
+				return items[0]
+			}
+			return items[len(items)-1]
+		}, nil
+	}
+}
+
+func compileConcatArrays(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		result := []interface{}{}
+		for _, operand := range operands {
+			items, ok := operand(doc, vars).([]interface{})
+			if !ok {
+				return nil
+			}
+			result = append(result, items...)
+		}
+		return result
+	}, nil
+}
+
+func compileIn(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		value := operands[0](doc, vars)
+		items, ok := operands[1](doc, vars).([]interface{})
+		if !ok {
+			return nil
+		}
+		for _, item := range items {
+			if compareOrder(item, value) == 0 {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func compileIsArray(arg interface{}) (expression, error) {
+	operand, err := arrayOperand(arg)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		_, ok := operand(doc, vars).([]interface{})
+		return ok
+	}, nil
+}
+
+func compileReverseArray(arg interface{}) (expression, error) {
+	operand, err := arrayOperand(arg)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		items, ok := operand(doc, vars).([]interface{})
+		if !ok {
+			return nil
+		}
+		reversed := make([]interface{}, len(items))
+		for i, item := range items {
+			reversed[len(items)-1-i] = item
+		}
+		return reversed
+	}, nil
+}
+
+// compileSlice takes [array, n] for the first n elements, or the last ones
+// when n is negative, or [array, position, n].
+func compileSlice(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, 3)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		items, ok := operands[0](doc, vars).([]interface{})
+		if !ok {
+			return nil
+		}
+		values := make([]int, len(operands)-1)
+		for i, operand := range operands[1:] {
+			n, ok := numeric(operand(doc, vars))
+			if !ok {
+				return nil
+			}
+			values[i] = int(n)
+		}
+
+		start, count := 0, values[0]
+		if len(values) == 2 {
+			start, count = values[0], values[1]
+			if count < 0 {
+				return nil
+			}
+			if start < 0 {
+				start += len(items)
+			}
+		} else if count < 0 {
+			start, count = len(items)+count, -count
+		}
+		if start < 0 {
+			start = 0
+		}
+		from, to := bounds(start, count, len(items))
+		return append([]interface{}{}, items[from:to]...)
+	}, nil
+}
+
+// compileRange takes [start, end] with an optional step and returns the
+// numbers from start up to but not including end.
+func compileRange(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, 3)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		values := []float64{0, 0, 1}
+		for i, operand := range operands {
+			n, ok := numeric(operand(doc, vars))
+			if !ok {
+				return nil
+			}
+			values[i] = math.Trunc(n)
+		}
+		start, end, step := values[0], values[1], values[2]
+		if step == 0 {
+			return nil
+		}
+		result := []interface{}{}
+		for n := start; (step > 0 && n < end) || (step < 0 && n > end); n += step {
+			result = append(result, n)
+		}
+		return result
+	}, nil
+}
+
+func compileIndexOfArray(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		items, ok := operands[0](doc, vars).([]interface{})
+		if !ok {
+			return nil
+		}
+		value := operands[1](doc, vars)
+		for i, item := range items {
+			if compareOrder(item, value) == 0 {
+				return float64(i)
+			}
+		}
+		return float64(-1)
+	}, nil
+}
+
+// iterationVariable reads the name an array operator binds each element
+// to, "this" unless "as" says otherwise.
+func iterationVariable(object map[string]interface{}) (string, error) {
+	value, exists := object["as"]
+	if !exists {
+		return "this", nil
+	}
+	name, ok := value.(string)
+	if !ok || !validVariable(name) {
+		return "", fmt.Errorf("invalid variable name: %v", value)
+	}
+	return name, nil
+}
+
+func compileMap(arg interface{}) (expression, error) {
+	object, _ := arg.(map[string]interface{})
+	as, err := iterationVariable(object)
+	if err != nil {
+		return nil, err
+	}
+	named, err := compileNamed(withoutArgument(object, "as"), []string{"input", "in"})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		items, ok := named["input"](doc, vars).([]interface{})
+		if !ok {
+			return nil
+		}
+		scope := withVariables(vars, nil)
+		result := make([]interface{}, len(items))
+		for i, item := range items {
+			scope[as] = item
+			if result[i] = named["in"](doc, scope); result[i] == missing {
+				result[i] = nil
+			}
+		}
+		return result
+	}, nil
+}
+
+func compileFilter(arg interface{}) (expression, error) {
+	object, _ := arg.(map[string]interface{})
+	as, err := iterationVariable(object)
+	if err != nil {
+		return nil, err
+	}
+	named, err := compileNamed(withoutArgument(object, "as"), []string{"input", "cond"}, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		items, ok := named["input"](doc, vars).([]interface{})
+		if !ok {
+			return nil
+		}
+		limit := len(items)
+		if expr, exists := named["limit"]; exists {
+			n, ok := numeric(expr(doc, vars))
+			if !ok || n < 1 {
+				return nil
+			}
+			limit = int(n)
+		}
+
+		scope := withVariables(vars, nil)
+		result := []interface{}{}
+		for _, item := range items {
+			if len(result) == limit {
+				break
+			}
+			scope[as] = item
+			if truthy(named["cond"](doc, scope)) {
+				result = append(result, item)
+			}
+		}
+		return result
+	}, nil
+}
+
+// compileReduce folds an array, binding the running value to $$value and
+// each element to $$this.
+func compileReduce(arg interface{}) (expression, error) {
+	named, err := compileNamed(arg, []string{"input", "initialValue", "in"})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		items, ok := named["input"](doc, vars).([]interface{})
+		if !ok {
+			return nil
+		}
+		scope := withVariables(vars, nil)
+		value := named["initialValue"](doc, vars)
+		for _, item := range items {
+			scope["value"], scope["this"] = value, item
+			value = named["in"](doc, scope)
+		}
+		return value
+	}, nil
+}
+
+// Type
+
+// typeName names the type of a value. Numbers decoded from JSON are all
+// doubles.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case missingValue:
+		return "missing"
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if _, ok := numeric(v); ok {
+		return "double"
+	}
+	return reflect.TypeOf(v).String()
+}
+
+func compileType(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		return typeName(operands[0](doc, vars))
+	}, nil
+}
+
+func compileIsNumber(arg interface{}) (expression, error) {
+	operands, err := compileOperands(arg, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, vars map[string]interface{}) interface{} {
+		_, ok := numeric(operands[0](doc, vars))
+		return ok
+	}, nil
+}
+
+var conversionTypes = map[string]bool{
+	"string": true, "int": true, "long": true, "double": true, "decimal": true, "bool": true, "date": true,
+}
+
+// conversionOperator converts its operand to a type and returns null if
+// it cannot.
+func conversionOperator(to string) operatorCompiler {
+	return func(arg interface{}) (expression, error) {
+		operands, err := compileOperands(arg, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc, vars map[string]interface{}) interface{} {
+			value := operands[0](doc, vars)
+			if nullish(value) {
+				return nil
+			}
+			if converted, ok := convert(value, to); ok {
+				return converted
+			}
+			return nil
+		}, nil
+	}
+}
+
+// compileConvert takes {"input", "to", "onError", "onNull"}. The target
+// type must be a literal string.
+func compileConvert(arg interface{}) (expression, error) {
+	object, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("takes an object with input and to")
+	}
+	to, ok := object["to"].(string)
+	if !ok || !conversionTypes[to] {
+		return nil, fmt.Errorf("unsupported target type %v", object["to"])
+	}
+	named, err := compileNamed(withoutArgument(object, "to"), []string{"input"}, "onError", "onNull")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc, vars map[string]interface{}) interface{} {
+		value := named["input"](doc, vars)
+		if nullish(value) {
+			if onNull, exists := named["onNull"]; exists {
+				return onNull(doc, vars)
+			}
+			return nil
+		}
+		if converted, ok := convert(value, to); ok {
+			return converted
+		}
+		if onError, exists := named["onError"]; exists {
+			return onError(doc, vars)
+		}
+		return nil
+	}, nil
+}
+
+// convert converts a non-null value to one of the conversion types.
+// Integers are truncated doubles, since JSON has a single number type, and
+// dates are RFC 3339 strings in UTC.
+func convert(value interface{}, to string) (interface{}, bool) {
+	switch to {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+		if n, ok := numeric(value); ok {
+			return strconv.FormatFloat(n, 'f', -1, 64), true
+		}
+	case "int", "long", "double", "decimal":
+		var n float64
+		switch v := value.(type) {
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, false
+			}
+			n = parsed
+		case bool:
+			if v {
+				n = 1
+			}
+		default:
+			var ok bool
+			if n, ok = numeric(value); !ok {
+				return nil, false
+			}
+		}
+		if to == "int" || to == "long" {
+			n = math.Trunc(n)
+		}
+		return n, !math.IsNaN(n) && !math.IsInf(n, 0)
+	case "bool":
+		return truthy(value), true
+	case "date":
+		if t, ok := toTime(value); ok {
+			return formatTime(t), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,313 @@
+package query
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type expressionCase struct {
+	name string
+	expr string
+	doc  string
+	// want is the JSON of the result, or empty when the expression
+	// evaluates to a missing value.
+	want string
+}
+
+func runExpressionCases(t *testing.T, tests []expressionCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			if err := json.Unmarshal([]byte(tt.expr), &raw); err != nil {
+				t.Fatalf("decode %s: %v", tt.expr, err)
+			}
+			expr, err := compileExpression(raw)
+			if err != nil {
+				t.Fatalf("compile %s: %v", tt.expr, err)
+			}
+
+			doc := map[string]interface{}{}
+			if tt.doc != "" {
+				doc = decode(t, tt.doc)
+			}
+			got := expr(doc, nil)
+
+			if tt.want == "" {
+				if got != missing {
+					t.Errorf("%s = %#v, want missing", tt.expr, got)
+				}
+				return
+			}
+			var want interface{}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("decode %s: %v", tt.want, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s on %s = %#v, want %s", tt.expr, tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArithmeticOperators(t *testing.T) {
+	runExpressionCases(t, []expressionCase{
+		{"add", `{"$add": [1, 2.5, "$n"]}`, `{"n": 3}`, `6.5`},
+		{"add missing", `{"$add": ["$n", 1]}`, ``, `null`},
+		{"add null", `{"$add": [null, 1]}`, ``, `null`},
+		{"add string", `{"$add": ["$n", 1]}`, `{"n": "1"}`, `null`},
+		{"add boolean", `{"$add": [true, 1]}`, ``, `null`},
+		{"subtract", `{"$subtract": [10, "$n"]}`, `{"n": 4}`, `6`},
+		{"multiply array", `{"$multiply": [[2], 3]}`, ``, `null`},
+		{"divide", `{"$divide": [7, 2]}`, ``, `3.5`},
+		{"divide by zero", `{"$divide": [1, 0]}`, ``, `null`},
+		{"divide zero by zero", `{"$divide": [0, "$n"]}`, `{"n": 0}`, `null`},
+		{"divide by missing", `{"$divide": [1, "$n"]}`, ``, `null`},
+		{"mod", `{"$mod": [-7, 3]}`, ``, `-1`},
+		{"mod by zero", `{"$mod": [5, 0]}`, ``, `null`},
+		{"pow", `{"$pow": [2, 10]}`, ``, `1024`},
+		{"pow to infinity", `{"$pow": [0, -1]}`, ``, `null`},
+		{"sqrt of a negative", `{"$sqrt": -1}`, ``, `null`},
+		{"ln of zero", `{"$ln": 0}`, ``, `null`},
+		{"log10", `{"$log10": 1000}`, ``, `3`},
+		{"abs of missing", `{"$abs": "$n"}`, ``, `null`},
+		{"round half to even", `{"$round": [2.5]}`, ``, `2`},
+		{"round half to even up", `{"$round": 3.5}`, ``, `4`},
+		{"round to places", `{"$round": [1234.5678, 2]}`, ``, `1234.57`},
+		{"trunc", `{"$trunc": -2.7}`, ``, `-2`},
+		{"trunc to places", `{"$trunc": [1234.5678, 2]}`, ``, `1234.56`},
+		{"ceil of a string", `{"$ceil": "1.5"}`, ``, `null`},
+	})
+}
+
+func TestComparisonAndBooleanOperators(t *testing.T) {
+	runExpressionCases(t, []expressionCase{
+		{"eq across number forms", `{"$eq": [1, 1.0]}`, ``, `true`},
+		{"eq missing and null", `{"$eq": ["$n", null]}`, ``, `true`},
+		{"eq number and string", `{"$eq": [1, "1"]}`, ``, `false`},
+		{"gt orders types", `{"$gt": ["a", 1]}`, ``, `true`},
+		{"lt missing and number", `{"$lt": ["$n", 0]}`, ``, `true`},
+		{"cmp", `{"$cmp": ["$n", 1]}`, `{"n": 2}`, `1`},
+		{"cmp missing", `{"$cmp": ["$n", 1]}`, ``, `-1`},
+		{"and empty", `{"$and": []}`, ``, `true`},
+		{"and missing", `{"$and": [1, "$n"]}`, ``, `false`},
+		{"or empty", `{"$or": []}`, ``, `false`},
+		{"or zero and empty string", `{"$or": [0, ""]}`, ``, `true`},
+		{"not zero", `{"$not": [0]}`, ``, `true`},
+		{"not null", `{"$not": [null]}`, ``, `true`},
+		{"not empty array", `{"$not": [{"$literal": []}]}`, ``, `false`},
+	})
+}
+
+func TestConditionalOperators(t *testing.T) {
+	runExpressionCases(t, []expressionCase{
+		{"cond null", `{"$cond": [null, 1, 2]}`, ``, `2`},
+		{"cond object", `{"$cond": {"if": "$n", "then": "yes", "else": "no"}}`, `{"n": 1}`, `"yes"`},
+		{"cond missing", `{"$cond": {"if": "$n", "then": "yes", "else": "no"}}`, ``, `"no"`},
+		{"cond then missing", `{"$cond": [true, "$n", 2]}`, ``, ``},
+		{"ifNull skips null and missing", `{"$ifNull": ["$a", "$b", "d"]}`, `{"b": null}`, `"d"`},
+		{"ifNull keeps false", `{"$ifNull": ["$a", 0]}`, `{"a": false}`, `false`},
+		{"ifNull last is missing", `{"$ifNull": ["$a", "$b"]}`, ``, ``},
+		{"switch", `{"$switch": {"branches": [{"case": {"$gt": ["$n", 5]}, "then": "big"}], "default": "small"}}`, `{"n": 9}`, `"big"`},
+		{"switch without default", `{"$switch": {"branches": [{"case": "$n", "then": 1}]}}`, ``, `null`},
+		{"let", `{"$let": {"vars": {"x": "$n"}, "in": {"$multiply": ["$$x", 2]}}}`, `{"n": 4}`, `8`},
+		{"let missing", `{"$let": {"vars": {"x": "$n"}, "in": "$$x"}}`, ``, ``},
+	})
+}
+
+func TestStringOperators(t *testing.T) {
+	runExpressionCases(t, []expressionCase{
+		{"concat", `{"$concat": ["a", "$b"]}`, `{"b": "c"}`, `"ac"`},
+		{"concat missing", `{"$concat": ["a", "$b"]}`, ``, `null`},
+		{"concat number", `{"$concat": ["a", 1]}`, ``, `null`},
+		{"toUpper missing", `{"$toUpper": "$s"}`, ``, `""`},
+		{"toUpper number", `{"$toUpper": 1.5}`, ``, `"1.5"`},
+		{"toUpper object", `{"$toUpper": {"$literal": {"a": 1}}}`, ``, `null`},
+		{"substrCP counts code points", `{"$substrCP": ["héllo", 1, 3]}`, ``, `"éll"`},
+		{"substrBytes to the end", `{"$substrBytes": ["abc", 1, -1]}`, ``, `"bc"`},
+		{"substr past the end", `{"$substr": ["abc", 5, 2]}`, ``, `""`},
+		{"substr missing", `{"$substrCP": ["$s", 0, 1]}`, ``, `""`},
+		{"substr number", `{"$substrCP": [1, 0, 1]}`, ``, `null`},
+		{"substr negative start", `{"$substrCP": ["abc", -1, 1]}`, ``, `null`},
+		{"strLenCP", `{"$strLenCP": "héllo"}`, ``, `5`},
+		{"strLenCP missing", `{"$strLenCP": "$s"}`, ``, `null`},
+		{"split", `{"$split": ["a,b,,c", ","]}`, ``, `["a", "b", "", "c"]`},
+		{"split on empty", `{"$split": ["a,b", ""]}`, ``, `null`},
+		{"indexOfCP", `{"$indexOfCP": ["héllo", "l"]}`, ``, `2`},
+		{"indexOfCP outside the range", `{"$indexOfCP": ["abc", "c", 0, 2]}`, ``, `-1`},
+		{"indexOfCP missing", `{"$indexOfCP": ["$s", "a"]}`, ``, `null`},
+		{"trim", `{"$trim": {"input": "  a b \n"}}`, ``, `"a b"`},
+		{"ltrim chars", `{"$ltrim": {"input": "xxaxx", "chars": "x"}}`, ``, `"axx"`},
+		{"trim number", `{"$trim": {"input": 1}}`, ``, `null`},
+		{"regexMatch", `{"$regexMatch": {"input": "$s", "regex": "^a", "options": "i"}}`, `{"s": "Abc"}`, `true`},
+		{"regexMatch missing", `{"$regexMatch": {"input": "$s", "regex": "^a"}}`, ``, `false`},
+		{"replaceOne", `{"$replaceOne": {"input": "aaa", "find": "a", "replacement": "b"}}`, ``, `"baa"`},
+		{"replaceAll null", `{"$replaceAll": {"input": "aaa", "find": null, "replacement": "b"}}`, ``, `null`},
+	})
+}
+
+func TestArrayOperators(t *testing.T) {
+	runExpressionCases(t, []expressionCase{
+		{"size", `{"$size": [[1, 2]]}`, ``, `2`},
+		{"size missing", `{"$size": "$a"}`, ``, `null`},
+		{"size of a string", `{"$size": "$a"}`, `{"a": "ab"}`, `null`},
+		{"arrayElemAt from the end", `{"$arrayElemAt": ["$a", -1]}`, `{"a": [1, 2]}`, `2`},
+		{"arrayElemAt out of range", `{"$arrayElemAt": ["$a", 5]}`, `{"a": [1, 2]}`, ``},
+		{"arrayElemAt missing", `{"$arrayElemAt": ["$a", 0]}`, ``, `null`},
+		{"first", `{"$first": "$a"}`, `{"a": [1, 2]}`, `1`},
+		{"first of empty", `{"$first": "$a"}`, `{"a": []}`, ``},
+		{"first of missing", `{"$first": "$a"}`, ``, `null`},
+		{"last of a scalar", `{"$last": "$a"}`, `{"a": 3}`, ``},
+		{"in", `{"$in": [1, [1.0, 2]]}`, ``, `true`},
+		{"in missing array", `{"$in": [1, "$a"]}`, ``, `null`},
+		{"in missing value matches null", `{"$in": ["$n", [null]]}`, ``, `true`},
+		{"concatArrays", `{"$concatArrays": [[1], "$a"]}`, `{"a": [2]}`, `[1, 2]`},
+		{"concatArrays missing", `{"$concatArrays": [[1], "$a"]}`, ``, `null`},
+		{"slice last", `{"$slice": [[1, 2, 3], -2]}`, ``, `[2, 3]`},
+		{"slice position", `{"$slice": [[1, 2, 3], -2, 1]}`, ``, `[2]`},
+		{"slice negative count", `{"$slice": [[1, 2, 3], 1, -1]}`, ``, `null`},
+		{"range", `{"$range": [5, 0, -2]}`, ``, `[5, 3, 1]`},
+		{"range step zero", `{"$range": [0, 10, 0]}`, ``, `null`},
+		{"reverseArray", `{"$reverseArray": "$a"}`, `{"a": [1, 2]}`, `[2, 1]`},
+		{"indexOfArray", `{"$indexOfArray": [["a", "b"], "b"]}`, ``, `1`},
+		{"indexOfArray absent", `{"$indexOfArray": [["a"], "z"]}`, ``, `-1`},
+		{"map", `{"$map": {"input": "$a", "as": "x", "in": {"$multiply": ["$$x", 2]}}}`, `{"a": [1, 2]}`, `[2, 4]`},
+		{"map missing field of element", `{"$map": {"input": "$a", "in": "$$this.v"}}`, `{"a": [{"v": 1}, {}]}`, `[1, null]`},
+		{"map missing input", `{"$map": {"input": "$a", "in": 1}}`, ``, `null`},
+		{"filter", `{"$filter": {"input": "$a", "cond": {"$gt": ["$$this", 1]}, "limit": 1}}`, `{"a": [1, 2, 3]}`, `[2]`},
+		{"filter bad limit", `{"$filter": {"input": "$a", "cond": true, "limit": 0}}`, `{"a": [1]}`, `null`},
+		{"reduce", `{"$reduce": {"input": "$a", "initialValue": 0, "in": {"$add": ["$$value", "$$this"]}}}`, `{"a": [1, 2, 3]}`, `6`},
+		{"reduce over a null element", `{"$reduce": {"input": "$a", "initialValue": 0, "in": {"$add": ["$$value", "$$this"]}}}`, `{"a": [1, null]}`, `null`},
+	})
+}
+
+func TestTypeOperators(t *testing.T) {
+	runExpressionCases(t, []expressionCase{
+		{"type missing", `{"$type": "$n"}`, ``, `"missing"`},
+		{"type null", `{"$type": [null]}`, ``, `"null"`},
+		{"type number", `{"$type": "$n"}`, `{"n": 1}`, `"double"`},
+		{"isNumber string", `{"$isNumber": "1"}`, ``, `false`},
+		{"toInt", `{"$toInt": "12.7"}`, ``, `12`},
+		{"toInt garbage", `{"$toInt": "x"}`, ``, `null`},
+		{"toInt missing", `{"$toInt": "$n"}`, ``, `null`},
+		{"toDouble boolean", `{"$toDouble": true}`, ``, `1`},
+		{"toString", `{"$toString": 1.5}`, ``, `"1.5"`},
+		{"toString array", `{"$toString": [[1]]}`, ``, `null`},
+		{"toBool zero", `{"$toBool": 0}`, ``, `false`},
+		{"toBool string", `{"$toBool": "false"}`, ``, `true`},
+		{"toDate seconds", `{"$toDate": 0}`, ``, `"1970-01-01T00:00:00Z"`},
+		{"toDate offset", `{"$toDate": "2024-05-01T12:00:00+02:00"}`, ``, `"2024-05-01T10:00:00Z"`},
+		{"toDate garbage", `{"$toDate": "yesterday"}`, ``, `null`},
+		{"convert onError", `{"$convert": {"input": "x", "to": "int", "onError": -1}}`, ``, `-1`},
+		{"convert onNull", `{"$convert": {"input": "$n", "to": "int", "onNull": 0}}`, ``, `0`},
+		{"convert without onError", `{"$convert": {"input": "x", "to": "double"}}`, ``, `null`},
+	})
+}
+
+func TestDateOperators(t *testing.T) {
+	runExpressionCases(t, []expressionCase{
+		// Parts
+		{"year", `{"$year": "$d"}`, `{"d": "2024-05-01T10:00:00Z"}`, `2024`},
+		{"year of seconds", `{"$year": 0}`, ``, `1970`},
+		{"year missing", `{"$year": "$d"}`, ``, `null`},
+		{"year of a plain string", `{"$year": "2024-05-01"}`, ``, `null`},
+		{"year of a boolean", `{"$year": true}`, ``, `null`},
+		{"hour in a zone on the DST change", `{"$hour": {"date": "2024-03-10T12:00:00Z", "timezone": "America/New_York"}}`, ``, `8`},
+		{"hour in a zone before the DST change", `{"$hour": {"date": "2024-03-09T12:00:00Z", "timezone": "America/New_York"}}`, ``, `7`},
+		{"dayOfWeek", `{"$dayOfWeek": "2024-05-12T00:00:00Z"}`, ``, `1`},
+		{"dayOfYear in a leap year", `{"$dayOfYear": "2024-12-31T00:00:00Z"}`, ``, `366`},
+		{"millisecond", `{"$millisecond": "2024-05-01T10:00:00.123456Z"}`, ``, `123`},
+
+		// $dateAdd and $dateSubtract
+		{"add a month at the end of January", `{"$dateAdd": {"startDate": "2024-01-31T10:00:00Z", "unit": "month", "amount": 1}}`, ``, `"2024-02-29T10:00:00Z"`},
+		{"add a month at the end of January outside a leap year", `{"$dateAdd": {"startDate": "2023-01-31T10:00:00Z", "unit": "month", "amount": 1}}`, ``, `"2023-02-28T10:00:00Z"`},
+		{"add two months at the end of January", `{"$dateAdd": {"startDate": "2024-01-31T10:00:00Z", "unit": "month", "amount": 2}}`, ``, `"2024-03-31T10:00:00Z"`},
+		{"subtract a month at the end of March", `{"$dateSubtract": {"startDate": "2024-03-31T00:00:00Z", "unit": "month", "amount": 1}}`, ``, `"2024-02-29T00:00:00Z"`},
+		{"add a month across a year", `{"$dateAdd": {"startDate": "2024-12-31T00:00:00Z", "unit": "month", "amount": 2}}`, ``, `"2025-02-28T00:00:00Z"`},
+		{"subtract months across a year", `{"$dateSubtract": {"startDate": "2024-01-15T00:00:00Z", "unit": "month", "amount": 13}}`, ``, `"2022-12-15T00:00:00Z"`},
+		{"add a quarter at the end of November", `{"$dateAdd": {"startDate": "2024-11-30T00:00:00Z", "unit": "quarter", "amount": 1}}`, ``, `"2025-02-28T00:00:00Z"`},
+		{"add a year to a leap day", `{"$dateAdd": {"startDate": "2024-02-29T00:00:00Z", "unit": "year", "amount": 1}}`, ``, `"2025-02-28T00:00:00Z"`},
+		{"add a month in a zone", `{"$dateAdd": {"startDate": "2024-01-31T23:30:00Z", "unit": "month", "amount": 1, "timezone": "Asia/Tokyo"}}`, ``, `"2024-02-29T23:30:00Z"`},
+		{"add a day across spring forward", `{"$dateAdd": {"startDate": "2024-03-09T12:00:00Z", "unit": "day", "amount": 1, "timezone": "America/New_York"}}`, ``, `"2024-03-10T11:00:00Z"`},
+		{"add 24 hours across spring forward", `{"$dateAdd": {"startDate": "2024-03-09T12:00:00Z", "unit": "hour", "amount": 24, "timezone": "America/New_York"}}`, ``, `"2024-03-10T12:00:00Z"`},
+		{"add a day across fall back", `{"$dateAdd": {"startDate": "2024-11-02T12:00:00Z", "unit": "day", "amount": 1, "timezone": "America/New_York"}}`, ``, `"2024-11-03T13:00:00Z"`},
+		{"add a week across fall back", `{"$dateAdd": {"startDate": "2024-10-30T12:00:00Z", "unit": "week", "amount": 1, "timezone": "America/New_York"}}`, ``, `"2024-11-06T13:00:00Z"`},
+		{"add a day in UTC", `{"$dateAdd": {"startDate": "2024-03-09T12:00:00Z", "unit": "day", "amount": 1}}`, ``, `"2024-03-10T12:00:00Z"`},
+		{"add truncates the amount", `{"$dateAdd": {"startDate": "2024-01-01T00:00:00Z", "unit": "minute", "amount": 1.9}}`, ``, `"2024-01-01T00:01:00Z"`},
+		{"add to seconds", `{"$dateAdd": {"startDate": 0, "unit": "millisecond", "amount": 5}}`, ``, `"1970-01-01T00:00:00.005Z"`},
+		{"add a missing amount", `{"$dateAdd": {"startDate": "2024-01-01T00:00:00Z", "unit": "day", "amount": "$n"}}`, ``, `null`},
+		{"add a string amount", `{"$dateAdd": {"startDate": "2024-01-01T00:00:00Z", "unit": "day", "amount": "1"}}`, ``, `null`},
+		{"add to a missing date", `{"$dateAdd": {"startDate": "$d", "unit": "day", "amount": 1}}`, ``, `null`},
+
+		// $dateTrunc
+		{"trunc to the hour", `{"$dateTrunc": {"date": "2024-05-15T13:45:30.5Z", "unit": "hour"}}`, ``, `"2024-05-15T13:00:00Z"`},
+		{"trunc to 15 minutes", `{"$dateTrunc": {"date": "2024-05-15T13:59:59Z", "unit": "minute", "binSize": 15}}`, ``, `"2024-05-15T13:45:00Z"`},
+		{"trunc to the month", `{"$dateTrunc": {"date": "2024-05-15T13:45:30Z", "unit": "month"}}`, ``, `"2024-05-01T00:00:00Z"`},
+		{"trunc to two months", `{"$dateTrunc": {"date": "2024-06-15T00:00:00Z", "unit": "month", "binSize": 2}}`, ``, `"2024-05-01T00:00:00Z"`},
+		{"trunc to the quarter", `{"$dateTrunc": {"date": "2024-05-15T13:45:30Z", "unit": "quarter"}}`, ``, `"2024-04-01T00:00:00Z"`},
+		{"trunc to the year", `{"$dateTrunc": {"date": "2024-05-15T13:45:30Z", "unit": "year"}}`, ``, `"2024-01-01T00:00:00Z"`},
+		{"trunc to the week", `{"$dateTrunc": {"date": "2024-05-15T13:45:30Z", "unit": "week"}}`, ``, `"2024-05-12T00:00:00Z"`},
+		{"trunc to a week starting monday", `{"$dateTrunc": {"date": "2024-05-15T13:45:30Z", "unit": "week", "startOfWeek": "Monday"}}`, ``, `"2024-05-13T00:00:00Z"`},
+		{"trunc a sunday to a week starting monday", `{"$dateTrunc": {"date": "2024-05-12T13:45:30Z", "unit": "week", "startOfWeek": "monday"}}`, ``, `"2024-05-06T00:00:00Z"`},
+		{"trunc to the day in a zone", `{"$dateTrunc": {"date": "2024-05-15T02:00:00Z", "unit": "day", "timezone": "America/New_York"}}`, ``, `"2024-05-14T04:00:00Z"`},
+		{"trunc to the day on spring forward", `{"$dateTrunc": {"date": "2024-03-10T12:00:00Z", "unit": "day", "timezone": "America/New_York"}}`, ``, `"2024-03-10T05:00:00Z"`},
+		{"trunc to two days across months", `{"$dateTrunc": {"date": "2024-03-01T12:00:00Z", "unit": "day", "binSize": 2}}`, `{}`, `"2024-02-29T00:00:00Z"`},
+		{"trunc before the epoch", `{"$dateTrunc": {"date": "1969-12-31T23:59:59Z", "unit": "hour"}}`, ``, `"1969-12-31T23:00:00Z"`},
+		{"trunc a missing date", `{"$dateTrunc": {"date": "$d", "unit": "day"}}`, ``, `null`},
+		{"trunc with bin size zero", `{"$dateTrunc": {"date": "2024-05-15T00:00:00Z", "unit": "day", "binSize": 0}}`, ``, `null`},
+
+		// $dateDiff
+		{"diff months crosses one boundary", `{"$dateDiff": {"startDate": "2024-01-31T23:00:00Z", "endDate": "2024-02-01T01:00:00Z", "unit": "month"}}`, ``, `1`},
+		{"diff days crosses one boundary", `{"$dateDiff": {"startDate": "2024-01-31T23:00:00Z", "endDate": "2024-02-01T01:00:00Z", "unit": "day"}}`, ``, `1`},
+		{"diff hours", `{"$dateDiff": {"startDate": "2024-01-31T23:00:00Z", "endDate": "2024-02-01T01:00:00Z", "unit": "hour"}}`, ``, `2`},
+		{"diff years backwards", `{"$dateDiff": {"startDate": "2025-01-01T00:00:00Z", "endDate": "2024-12-31T00:00:00Z", "unit": "year"}}`, ``, `-1`},
+		{"diff days across spring forward", `{"$dateDiff": {"startDate": "2024-03-09T12:00:00Z", "endDate": "2024-03-11T12:00:00Z", "unit": "day", "timezone": "America/New_York"}}`, ``, `2`},
+		{"diff a missing date", `{"$dateDiff": {"startDate": "$d", "endDate": "2024-01-01T00:00:00Z", "unit": "day"}}`, ``, `null`},
+
+		// $dateToString
+		{"toString in a zone", `{"$dateToString": {"date": "2024-05-01T10:00:00Z", "format": "%Y-%m-%d %H:%M", "timezone": "Europe/Paris"}}`, ``, `"2024-05-01 12:00"`},
+		{"toString every specifier", `{"$dateToString": {"date": "2024-05-12T01:02:03.004Z", "format": "%j %u %S.%L %%"}}`, ``, `"133 7 03.004 %"`},
+		{"toString without format", `{"$dateToString": {"date": 0, "timezone": "Asia/Tokyo"}}`, ``, `"1970-01-01T09:00:00+09:00"`},
+		{"toString onNull", `{"$dateToString": {"date": "$d", "onNull": "none"}}`, ``, `"none"`},
+		{"toString not a date", `{"$dateToString": {"date": "May 1st"}}`, ``, `null`},
+	})
+}
+
+func TestExpressionCompileErrors(t *testing.T) {
+	tests := []string{
+		`{"$bogus": 1}`,
+		`{"$add": 1, "x": 2}`,
+		`{"$add": []}`,
+		`{"$divide": [1]}`,
+		`{"$cond": [true, 1]}`,
+		`{"$cond": {"if": true, "then": 1}}`,
+		`{"$switch": {"branches": []}}`,
+		`{"$let": {"vars": {"Bad": 1}, "in": 1}}`,
+		`{"$regexMatch": {"input": "a", "regex": "("}}`,
+		`{"$regexMatch": {"input": "a", "regex": "a", "options": "x"}}`,
+		`{"$trim": {"input": "a", "extra": 1}}`,
+		`{"$map": {"input": [], "as": "Bad", "in": 1}}`,
+		`{"$convert": {"input": 1, "to": "object"}}`,
+		`{"$dateAdd": {"startDate": 0, "amount": 1}}`,
+		`{"$dateAdd": {"startDate": 0, "unit": "decade", "amount": 1}}`,
+		`{"$dateAdd": {"startDate": 0, "unit": "day", "amount": 1, "timezone": "Mars/Olympus"}}`,
+		`{"$dateAdd": {"startDate": 0, "unit": "$unit", "amount": 1}}`,
+		`{"$dateTrunc": {"date": 0}}`,
+		`{"$dateTrunc": {"date": 0, "unit": "week", "startOfWeek": "someday"}}`,
+		`{"$dateDiff": {"startDate": 0, "unit": "day"}}`,
+		`{"$dateToString": {"date": 0, "format": "%Q"}}`,
+		`{"$dateToString": {"date": 0, "format": "100%"}}`,
+		`"$"`,
+		`"$$Bad"`,
+	}
+	for _, raw := range tests {
+		var expr interface{}
+		if err := json.Unmarshal([]byte(raw), &expr); err != nil {
+			t.Fatalf("decode %s: %v", raw, err)
+		}
+		if _, err := compileExpression(expr); err == nil {
+			t.Errorf("compiled %s, want an error", raw)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/itsyaboikris/go_document_store/geo"
+)
+
+// Near is a parsed $near condition or the search of a $geoNear stage. It is
+// answered by a 2dsphere index on Field rather than by the Matcher, which
+// returns the documents nearest first.
+type Near struct {
+	// Field is the indexed field; a $geoNear stage leaves it empty when the
+	// collection's only 2dsphere index is meant.
+	Field string
+	Point geo.Point
+	// MinDistance and MaxDistance bound the distance in meters.
+	MinDistance float64
+	MaxDistance float64
+}
+
+// GeoNear is a parsed $geoNear stage.
+type GeoNear struct {
+	Near
+	// Query further filters the documents found.
+	Query map[string]interface{}
+	// DistanceField receives the distance of each document, multiplied by
+	// DistanceMultiplier.
+	DistanceField      string
+	DistanceMultiplier float64
+	// IncludeLocs, when set, receives the value of the indexed field.
+	IncludeLocs string
+}
+
+// parseNear parses {"$geometry": Point, "$minDistance": m, "$maxDistance": m}.
+func parseNear(field string, condition interface{}) (*Near, error) {
+	spec, ok := condition.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("$near takes an object with $geometry")
+	}
+	near := &Near{Field: field, MaxDistance: math.Inf(1)}
+	found := false
+	for key, value := range spec {
+		var err error
+		switch key {
+		case "$geometry":
+			if near.Point, err = geo.ParsePoint(value); err != nil {
+				return nil, fmt.Errorf("$near: %v", err)
+			}
+			found = true
+		case "$minDistance":
+			near.MinDistance, err = distance(key, value)
+		case "$maxDistance":
+			near.MaxDistance, err = distance(key, value)
+		default:
+			err = errors.New("unsupported $near option: " + key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, errors.New("$near requires a $geometry Point")
+	}
+	return near, nil
+}
+
+func distance(name string, value interface{}) (float64, error) {
+	d, ok := value.(float64)
+	if !ok || d < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number of meters", name)
+	}
+	return d, nil
+}
+
+// ParseGeoWithin parses the argument of $geoWithin: a Polygon or
+// MultiPolygon under $geometry, or a circle given as
+// {"$centerSphere": [[longitude, latitude], radius in radians]}.
+func ParseGeoWithin(condition interface{}) (geo.Region, error) {
+	spec, ok := condition.(map[string]interface{})
+	if !ok || len(spec) != 1 {
+		return nil, errors.New("$geoWithin takes an object with either $geometry or $centerSphere")
+	}
+	if value, exists := spec["$geometry"]; exists {
+		g, err := geo.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("$geoWithin: %v", err)
+		}
+		if !g.IsPolygonal() {
+			return nil, errors.New("$geoWithin requires a Polygon or MultiPolygon")
+		}
+		return g, nil
+	}
+	if value, exists := spec["$centerSphere"]; exists {
+		args, ok := value.([]interface{})
+		if !ok || len(args) != 2 {
+			return nil, errors.New("$centerSphere takes [[longitude, latitude], radius]")
+		}
+		center, err := geo.ParsePoint(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("$centerSphere: %v", err)
+		}
+		radius, ok := args[1].(float64)
+		if !ok || radius < 0 || radius > math.Pi {
+			return nil, errors.New("$centerSphere radius must be between 0 and pi radians")
+		}
+		return geo.Cap{Center: center, Radius: radius}, nil
+	}
+	return nil, errors.New("$geoWithin takes an object with either $geometry or $centerSphere")
+}
+
+// ParseGeoIntersects parses the argument of $geoIntersects, a GeoJSON
+// geometry under $geometry.
+func ParseGeoIntersects(condition interface{}) (*geo.Geometry, error) {
+	spec, ok := condition.(map[string]interface{})
+	if !ok || len(spec) != 1 || spec["$geometry"] == nil {
+		return nil, errors.New("$geoIntersects takes an object with $geometry")
+	}
+	g, err := geo.Parse(spec["$geometry"])
+	if err != nil {
+		return nil, fmt.Errorf("$geoIntersects: %v", err)
+	}
+	return g, nil
+}
+
+// SplitNear separates the $near condition of a filter from the rest of it.
+// Like $text, $near may appear once, at the top level or inside $and. The
+// search is nil when the filter has no $near condition.
+func SplitNear(filter map[string]interface{}) (*Near, map[string]interface{}, error) {
+	var found *Near
+	rest, err := extract(filter, OpNear, func(key string, value interface{}) (interface{}, bool, error) {
+		operators, ok := value.(map[string]interface{})
+		if strings.HasPrefix(key, "$") || !ok {
+			return value, false, nil
+		}
+		condition, exists := operators[string(OpNear)]
+		if !exists {
+			return value, false, nil
+		}
+		if found != nil {
+			return nil, true, errors.New("$near may appear only once")
+		}
+		near, err := parseNear(key, condition)
+		if err != nil {
+			return nil, true, err
+		}
+		found = near
+
+		if len(operators) == 1 {
+			return nil, true, nil
+		}
+		remaining := make(map[string]interface{}, len(operators)-1)
+		for op, arg := range operators {
+			if op != string(OpNear) {
+				remaining[op] = arg
+			}
+		}
+		return remaining, true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return found, rest, nil
+}
+
+// compileGeoNear compiles a $geoNear stage. It has to be the first stage:
+// the caller runs the search and passes the documents it found, nearest
+// first, to Run, so the stage itself passes them on unchanged.
+func (p *Pipeline) compileGeoNear(arg interface{}) (stage, error) {
+	if len(p.stages) > 0 {
+		return nil, errors.New("$geoNear is only allowed as the first stage")
+	}
+	spec, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("specification must be an object")
+	}
+
+	g := &GeoNear{Near: Near{MaxDistance: math.Inf(1)}, DistanceMultiplier: 1}
+	found := false
+	for key, value := range spec {
+		var err error
+		switch key {
+		case "near":
+			if g.Point, err = geo.ParsePoint(value); err != nil {
+				return nil, fmt.Errorf("near: %v", err)
+			}
+			found = true
+		case "key":
+			if g.Field, ok = value.(string); !ok || g.Field == "" {
+				err = errors.New("key must be a field name")
+			}
+		case "distanceField":
+			g.DistanceField, err = outputField(key, value)
+		case "includeLocs":
+			g.IncludeLocs, err = outputField(key, value)
+		case "minDistance":
+			g.MinDistance, err = distance(key, value)
+		case "maxDistance":
+			g.MaxDistance, err = distance(key, value)
+		case "distanceMultiplier":
+			if g.DistanceMultiplier, ok = value.(float64); !ok || g.DistanceMultiplier <= 0 {
+				err = errors.New("distanceMultiplier must be a positive number")
+			}
+		case "query":
+			g.Query, err = geoNearQuery(value)
+		case "spherical":
+			// Distances are always measured on the sphere.
+			if _, ok := value.(bool); !ok {
+				err = errors.New("spherical must be a boolean")
+			}
+		default:
+			err = errors.New("unsupported option: " + key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, errors.New("near is required")
+	}
+	if g.DistanceField == "" {
+		return nil, errors.New("distanceField is required")
+	}
+	p.near = g
+
+	return func(input iterator, _ environment) iterator {
+		return input
+	}, nil
+}
+
+func outputField(name string, value interface{}) (string, error) {
+	field, ok := value.(string)
+	if !ok || field == "" || strings.HasPrefix(field, "$") {
+		return "", fmt.Errorf("%s must be a field name", name)
+	}
+	return field, nil
+}
+
+func geoNearQuery(value interface{}) (map[string]interface{}, error) {
+	filter, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("query must be an object")
+	}
+	if err := NewQuery().Validate(filter); err != nil {
+		return nil, fmt.Errorf("query: %v", err)
+	}
+	if containsOperator(filter, OpText) || containsOperator(filter, OpNear) {
+		return nil, errors.New("query cannot use $text or $near")
+	}
+	return filter, nil
+}
+
+// Annotate returns a copy of a document the search found with its distance
+// and, if asked for, its location added.
+func (g *GeoNear) Annotate(doc map[string]interface{}, distance float64, location interface{}) map[string]interface{} {
+	doc = withField(doc, strings.Split(g.DistanceField, "."), distance*g.DistanceMultiplier)
+	if g.IncludeLocs != "" {
+		doc = withField(doc, strings.Split(g.IncludeLocs, "."), location)
+	}
+	return doc
+}
@@ -0,0 +1,177 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+type lookupSpec struct {
+	From         string                 `json:"from"`
+	LocalField   string                 `json:"localField"`
+	ForeignField string                 `json:"foreignField"`
+	As           string                 `json:"as"`
+	Let          map[string]interface{} `json:"let"`
+	Pipeline     json.RawMessage        `json:"pipeline"`
+}
+
+// compileLookup compiles a $lookup stage, which adds to every document an
+// array of the documents of another collection it joins with. The join is
+// an equality on localField and foreignField, a pipeline run over the other
+// collection with the let variables bound per document, or both, in which
+// case the pipeline runs over the documents that matched the equality.
+func (p *Pipeline) compileLookup(raw json.RawMessage) (stage, error) {
+	var spec lookupSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, errors.New("specification must be an object")
+	}
+	if spec.From == "" {
+		return nil, errors.New("from is required")
+	}
+	if spec.As == "" || strings.HasPrefix(spec.As, "$") {
+		return nil, errors.New("as must be a field name")
+	}
+	if (spec.LocalField == "") != (spec.ForeignField == "") {
+		return nil, errors.New("localField and foreignField go together")
+	}
+	if spec.LocalField == "" && spec.Pipeline == nil {
+		return nil, errors.New("localField and foreignField or a pipeline are required")
+	}
+	if spec.Let != nil && spec.Pipeline == nil {
+		return nil, errors.New("let requires a pipeline")
+	}
+
+	let := make(map[string]expression, len(spec.Let))
+	for name, value := range spec.Let {
+		if !validVariable(name) {
+			return nil, errors.New("invalid variable name: " + name)
+		}
+		expr, err := compileExpression(value)
+		if err != nil {
+			return nil, err
+		}
+		let[name] = expr
+	}
+
+	var sub *Pipeline
+	if spec.Pipeline != nil {
+		var err error
+		if sub, err = ParsePipeline(spec.Pipeline); err != nil {
+			return nil, err
+		}
+		if sub.text != nil {
+			return nil, errors.New("$text is not allowed in a $lookup pipeline")
+		}
+		if sub.near != nil {
+			return nil, errors.New("$geoNear is not allowed in a $lookup pipeline")
+		}
+		if sub.vector != nil {
+			return nil, errors.New("$vectorSearch is not allowed in a $lookup pipeline")
+		}
+	}
+
+	p.collections = append(p.collections, spec.From)
+	if sub != nil {
+		p.collections = append(p.collections, sub.collections...)
+	}
+
+	as := strings.Split(spec.As, ".")
+	return func(input iterator, env environment) iterator {
+		var foreign []map[string]interface{}
+		var index map[string][]int
+		loaded := false
+
+		return func() (map[string]interface{}, bool) {
+			doc, ok := input()
+			if !ok {
+				return nil, false
+			}
+
+			if !loaded {
+				if env.source != nil {
+					foreign = env.source(spec.From)
+				}
+				if spec.ForeignField != "" {
+					index = indexBy(foreign, spec.ForeignField)
+				}
+				loaded = true
+			}
+
+			joined := foreign
+			if index != nil {
+				joined = joinKeys(doc, spec.LocalField, foreign, index)
+			}
+
+			if sub != nil {
+				vars := env.vars
+				if len(let) > 0 {
+					vars = make(map[string]interface{}, len(env.vars)+len(let))
+					for name, value := range env.vars {
+						vars[name] = value
+					}
+					for name, expr := range let {
+						if vars[name] = expr(doc, env.vars); vars[name] == missing {
+							vars[name] = nil
+						}
+					}
+				}
+				joined = sub.run(joined, environment{source: env.source, vars: vars})
+			}
+
+			matches := make([]interface{}, len(joined))
+			for i, match := range joined {
+				matches[i] = match
+			}
+			return withField(doc, as, matches), true
+		}
+	}, nil
+}
+
+// indexBy maps the value of a field to the positions of the documents
+// holding it. Like a hash index, a document is filed under every value the
+// path reaches through arrays, each element of an array value, and null
+// where the path is missing.
+func indexBy(documents []map[string]interface{}, field string) map[string][]int {
+	index := make(map[string][]int)
+	for i, doc := range documents {
+		keys := make(map[string]bool)
+		for _, value := range PathValues(doc, field) {
+			keys[joinKey(value)] = true
+		}
+		for key := range keys {
+			index[key] = append(index[key], i)
+		}
+	}
+	return index
+}
+
+// joinKeys returns the documents equal to any value the local field reaches
+// through arrays, or to any element of those values, in their original
+// order. A missing local field joins with null.
+func joinKeys(doc map[string]interface{}, field string, documents []map[string]interface{}, index map[string][]int) []map[string]interface{} {
+	var positions []int
+	seen := make(map[int]bool)
+	for _, key := range PathValues(doc, field) {
+		for _, i := range index[joinKey(key)] {
+			if !seen[i] {
+				seen[i] = true
+				positions = append(positions, i)
+			}
+		}
+	}
+	sort.Ints(positions)
+
+	joined := make([]map[string]interface{}, len(positions))
+	for j, i := range positions {
+		joined[j] = documents[i]
+	}
+	return joined
+}
+
+// joinKey encodes a value so that values equal to the Matcher, such as the
+// same instant written in two time zones, share a key.
+func joinKey(value interface{}) string {
+	encoded, _ := json.Marshal(Canonical(value))
+	return string(encoded)
+}
@@ -0,0 +1,74 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLookupJoinsLikeTheMatcher(t *testing.T) {
+	var foreign []map[string]interface{}
+	if err := json.Unmarshal([]byte(`[
+		{"_id": "a", "sku": "A", "at": "2024-03-01T12:00:00Z"},
+		{"_id": "b", "sku": "B", "at": "2024-03-02T12:00:00Z"},
+		{"_id": "c", "sku": ["C", "D"]},
+		{"_id": "n", "sku": null},
+		{"_id": "m"},
+		{"_id": "i", "qty": 2},
+		{"_id": "f", "qty": 2.0}
+	]`), &foreign); err != nil {
+		t.Fatal(err)
+	}
+	source := func(collection string) []map[string]interface{} { return foreign }
+
+	tests := []struct {
+		name         string
+		doc          string
+		localField   string
+		foreignField string
+		want         []string
+	}{
+		{"scalar", `{"sku": "B"}`, "sku", "sku", []string{"b"}},
+		{"local array", `{"sku": ["A", "D"]}`, "sku", "sku", []string{"a", "c"}},
+		{"local fan out", `{"items": [{"sku": "A"}, {"sku": "B"}]}`, "items.sku", "sku", []string{"a", "b"}},
+		{"local fan out into arrays", `{"items": [{"sku": ["D"]}, {"sku": "A"}]}`, "items.sku", "sku", []string{"a", "c"}},
+		{"local missing joins null", `{}`, "sku", "sku", []string{"n", "m", "i", "f"}},
+		{"instant in another zone", `{"at": "2024-03-01T13:00:00+01:00"}`, "at", "at", []string{"a"}},
+		{"numbers", `{"qty": 2}`, "qty", "qty", []string{"i", "f"}},
+		{"no match", `{"sku": "Z"}`, "sku", "sku", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, _ := json.Marshal(map[string]interface{}{"$lookup": map[string]interface{}{
+				"from": "items", "localField": tt.localField, "foreignField": tt.foreignField, "as": "joined",
+			}})
+			p, err := ParsePipeline([]byte("[" + string(spec) + "]"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			results := p.Run([]map[string]interface{}{decode(t, tt.doc)}, nil, source)
+			var got []string
+			for _, match := range results[0]["joined"].([]interface{}) {
+				got = append(got, match.(map[string]interface{})["_id"].(string))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("joined %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("joined %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// Foreign documents fan out too.
+	orders := []map[string]interface{}{decode(t, `{"_id": "o", "lines": [{"sku": "X"}, {"sku": "B"}]}`)}
+	p, err := ParsePipeline([]byte(`[{"$lookup": {"from": "orders", "localField": "sku", "foreignField": "lines.sku", "as": "orders"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := p.Run([]map[string]interface{}{decode(t, `{"sku": "B"}`)}, nil, func(string) []map[string]interface{} { return orders })
+	if joined := results[0]["orders"].([]interface{}); len(joined) != 1 {
+		t.Errorf("foreign fan out joined %v, want the order", joined)
+	}
+}
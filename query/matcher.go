@@ -2,11 +2,13 @@
 package query
 
 import (
-	"fmt"
-	"reflect"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/itsyaboikris/go_document_store/geo"
+	"github.com/itsyaboikris/go_document_store/schema"
 )
 
 type Matcher struct{}
@@ -17,6 +19,27 @@ func NewMatcher() *Matcher {
 
 func (m *Matcher) Matches(data map[string]interface{}, filter map[string]interface{}) bool {
 	for key, condition := range filter {
+		if Operator(key) == OpJSONSchema {
+			if !matchSchema(data, condition) {
+				return false
+			}
+			continue
+		}
+
+		// A text search is answered by the collection's text index before
+		// the rest of the filter reaches the Matcher, which cannot evaluate
+		// it on its own.
+		if Operator(key) == OpText {
+			return false
+		}
+
+		if Operator(key) == OpExpr {
+			if !matchExpr(data, condition) {
+				return false
+			}
+			continue
+		}
+
 		if IsLogicalOperator(Operator(key)) {
 			if !m.evaluateLogicalOperator(Operator(key), data, condition) {
 				return false
@@ -24,8 +47,7 @@ func (m *Matcher) Matches(data map[string]interface{}, filter map[string]interfa
 			continue
 		}
 
-		value := getNestedValue(data, key)
-		if !m.evaluateCondition(value, condition) {
+		if !m.matchField(data, key, condition) {
 			return false
 		}
 	}
@@ -57,130 +79,442 @@ func (m *Matcher) evaluateLogicalOperator(operator Operator, data map[string]int
 			}
 		}
 		return false
+	case OpNor:
+		for _, c := range conditions {
+			if cond, ok := c.(map[string]interface{}); ok {
+				if m.Matches(data, cond) {
+					return false
+				}
+			}
+		}
+		return true
 	}
 	return false
 }
 
-func (m *Matcher) evaluateCondition(value, condition interface{}) bool {
-	switch cond := condition.(type) {
-	case map[string]interface{}:
-		return m.evaluateOperators(value, cond)
-	default:
-		return reflect.DeepEqual(value, condition)
+// matchSchema reports whether the document conforms to a $jsonSchema
+// condition. Query.Execute compiles the schema once up front; a raw schema
+// is compiled here and never matches if it is invalid.
+func matchSchema(data map[string]interface{}, condition interface{}) bool {
+	compiled, ok := condition.(*schema.Schema)
+	if !ok {
+		var err error
+		if compiled, err = schema.Compile(condition); err != nil {
+			return false
+		}
+	}
+	return compiled.Valid(data)
+}
+
+// matchExpr reports whether an $expr condition is true for the document.
+// Like a schema, a raw expression is compiled here and never matches if it
+// is invalid.
+func matchExpr(data map[string]interface{}, condition interface{}) bool {
+	compiled, ok := condition.(expression)
+	if !ok {
+		var err error
+		if compiled, err = compileExpression(condition); err != nil {
+			return false
+		}
+	}
+	return truthy(compiled(data, nil))
+}
+
+// matchField reports whether a condition on a dotted path holds. The path
+// may reach several values through arrays, and the condition holds if it
+// does for any of them.
+func (m *Matcher) matchField(data map[string]interface{}, path string, condition interface{}) bool {
+	values := resolvePath(data, path)
+	if operators, ok := condition.(map[string]interface{}); ok && hasOperatorKeys(operators) {
+		return m.evaluateOperators(values, operators)
 	}
+	return anyElement(values, func(value interface{}) bool { return equalValues(value, condition) })
 }
 
-func (m *Matcher) evaluateOperators(value interface{}, operators map[string]interface{}) bool {
+// evaluateOperators reports whether every operator of a field condition
+// holds for the values its path reaches. Each operator is checked on its
+// own, so {"$gt": 1, "$lt": 5} matches an array with one element above 1
+// and another below 5, as in MongoDB.
+func (m *Matcher) evaluateOperators(values []interface{}, operators map[string]interface{}) bool {
 	for op, condition := range operators {
-		switch Operator(op) {
-		case OpEquals:
-			if !m.matchEQ(value, condition) {
-				return false
-			}
-		case OpNotEquals:
-			if m.matchEQ(value, condition) {
-				return false
-			}
-		case OpGreater:
-			if !compareValues(value, condition, ">") {
-				return false
-			}
-		case OpGreaterEqual:
-			if !compareValues(value, condition, ">=") {
-				return false
-			}
-		case OpLess:
-			if !compareValues(value, condition, "<") {
-				return false
-			}
-		case OpLessEqual:
-			if !compareValues(value, condition, "<=") {
+		if !m.evaluateOperator(values, Operator(op), condition) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Matcher) evaluateOperator(values []interface{}, op Operator, condition interface{}) bool {
+	switch op {
+	case OpEquals:
+		return anyElement(values, func(value interface{}) bool { return equalValues(value, condition) })
+	case OpNotEquals:
+		return !anyElement(values, func(value interface{}) bool { return equalValues(value, condition) })
+	case OpGreater, OpGreaterEqual, OpLess, OpLessEqual:
+		return anyElement(values, func(value interface{}) bool { return compareValues(value, condition, op) })
+	case OpIn:
+		return anyElement(values, func(value interface{}) bool { return containsValue(condition, value) })
+	case OpNotIn:
+		return !anyElement(values, func(value interface{}) bool { return containsValue(condition, value) })
+	case OpExists:
+		exists, _ := condition.(bool)
+		return exists == anyValue(values, func(value interface{}) bool { return value != missing })
+	case OpType:
+		return anyValue(values, func(value interface{}) bool { return matchType(value, condition) })
+	case OpRegex:
+		return anyElement(values, func(value interface{}) bool { return matchRegex(value, condition) })
+	case OpMod:
+		return anyElement(values, func(value interface{}) bool { return matchMod(value, condition) })
+	case OpSize:
+		n, _ := condition.(float64)
+		return anyValue(values, func(value interface{}) bool {
+			items, isArray := value.([]interface{})
+			return isArray && float64(len(items)) == n
+		})
+	case OpAll:
+		return m.matchAll(values, condition)
+	case OpElemMatch:
+		return m.matchElemMatch(values, condition)
+	case OpNot:
+		operators, ok := condition.(map[string]interface{})
+		return ok && !m.evaluateOperators(values, operators)
+	case OpGeoWithin:
+		return anyValue(values, func(value interface{}) bool { return matchGeoWithin(value, condition) })
+	case OpGeoIntersects:
+		return anyValue(values, func(value interface{}) bool { return matchGeoIntersects(value, condition) })
+	}
+	// $near is answered by an index, like $text.
+	return false
+}
+
+// matchAll reports whether every item of an $all list is equal to one of
+// the values or, for an {"$elemMatch": ...} item, matched by one of their
+// elements. An empty list matches nothing.
+func (m *Matcher) matchAll(values []interface{}, condition interface{}) bool {
+	items, ok := condition.([]interface{})
+	if !ok || len(items) == 0 {
+		return false
+	}
+	for _, item := range items {
+		if operators, ok := item.(map[string]interface{}); ok && hasOperatorKeys(operators) {
+			if !m.evaluateOperators(values, operators) {
 				return false
 			}
-		case OpIn:
-			if !containsValue(condition, value) {
-				return false
+			continue
+		}
+		if !anyElement(values, func(value interface{}) bool { return equalValues(value, item) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchElemMatch reports whether one element of an array satisfies every
+// condition of an $elemMatch. Conditions made of operators apply to the
+// element itself; otherwise they are a filter on elements that are
+// documents.
+func (m *Matcher) matchElemMatch(values []interface{}, condition interface{}) bool {
+	spec, ok := condition.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	onValues := isFieldCondition(spec)
+	return anyValue(values, func(value interface{}) bool {
+		items, isArray := value.([]interface{})
+		if !isArray {
+			return false
+		}
+		for _, item := range items {
+			if onValues {
+				if m.evaluateOperators([]interface{}{item}, spec) {
+					return true
+				}
+			} else if document, ok := item.(map[string]interface{}); ok && m.Matches(document, spec) {
+				return true
 			}
-		case OpNotIn:
-			if containsValue(condition, value) {
-				return false
+		}
+		return false
+	})
+}
+
+// resolvePath returns the values a dotted path reaches. A path through an
+// array of documents continues into each of them, and a numeric part
+// selects an array element by position. Branches where the path ends
+// early contribute missing, and a path that reaches nothing yields missing
+// alone.
+func resolvePath(data map[string]interface{}, path string) []interface{} {
+	values := resolveParts(data, strings.Split(path, "."))
+	if len(values) == 0 {
+		return []interface{}{missing}
+	}
+	return values
+}
+
+func resolveParts(value interface{}, parts []string) []interface{} {
+	if len(parts) == 0 {
+		return []interface{}{value}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		next, exists := v[parts[0]]
+		if !exists {
+			return []interface{}{missing}
+		}
+		return resolveParts(next, parts[1:])
+	case []interface{}:
+		var found []interface{}
+		if i, err := strconv.Atoi(parts[0]); err == nil && i >= 0 {
+			if i < len(v) {
+				found = append(found, resolveParts(v[i], parts[1:])...)
 			}
-		case OpExists:
-			exists := condition.(bool)
-			if exists != (value != nil) {
-				return false
+			// Documents in the array may also have a field of that name.
+			for _, item := range v {
+				if document, ok := item.(map[string]interface{}); ok {
+					if _, exists := document[parts[0]]; exists {
+						found = append(found, resolveParts(document, parts)...)
+					}
+				}
 			}
-		case OpRegex:
-			if !matchRegex(value, condition) {
-				return false
+			return found
+		}
+		for _, item := range v {
+			if _, ok := item.(map[string]interface{}); ok {
+				found = append(found, resolveParts(item, parts)...)
 			}
 		}
+		return found
 	}
-	return true
+	return nil
 }
 
-func getNestedValue(data map[string]interface{}, path string) interface{} {
-	parts := strings.Split(path, ".")
-	current := data
+// PathValues returns the values an equality condition on a dotted path can
+// match in data: every value the path reaches, the elements of those that
+// are arrays, and null where the path is missing. Hash indexes file
+// documents under these values so they agree with the Matcher.
+func PathValues(data map[string]interface{}, path string) []interface{} {
+	var values []interface{}
+	for _, value := range resolvePath(data, path) {
+		if value == missing {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, value)
+		if items, isArray := value.([]interface{}); isArray {
+			values = append(values, items...)
+		}
+	}
+	return values
+}
 
-	for i, part := range parts {
-		if i == len(parts)-1 {
-			return current[part]
+// Lookup returns the values a dotted path reaches in data, following arrays
+// of documents like the Matcher, without the missing branches.
+func Lookup(data map[string]interface{}, path string) []interface{} {
+	var values []interface{}
+	for _, value := range resolvePath(data, path) {
+		if value != missing {
+			values = append(values, value)
 		}
+	}
+	return values
+}
 
-		next, ok := current[part].(map[string]interface{})
-		if !ok {
-			return nil
+// anyValue reports whether the predicate holds for one of the values.
+func anyValue(values []interface{}, predicate func(value interface{}) bool) bool {
+	for _, value := range values {
+		if predicate(value) {
+			return true
 		}
-		current = next
 	}
-	return nil
+	return false
 }
 
-func compareValues(a, b interface{}, op string) bool {
-	aVal, errA := toNumber(a)
-	bVal, errB := toNumber(b)
-
-	if errA != nil && errB != nil {
-		switch op {
-		case ">":
-			return aVal > bVal
-		case ">=":
-			return aVal >= bVal
-		case "<":
-			return aVal < bVal
-		case "<=":
-			return aVal <= bVal
-		default:
-			return false
+// anyElement is anyValue that also tries the elements of arrays, the way
+// most conditions treat a field holding an array.
+func anyElement(values []interface{}, predicate func(value interface{}) bool) bool {
+	for _, value := range values {
+		if predicate(value) {
+			return true
+		}
+		if items, isArray := value.([]interface{}); isArray {
+			for _, item := range items {
+				if predicate(item) {
+					return true
+				}
+			}
 		}
 	}
+	return false
+}
 
-	aStr := fmt.Sprintf("%v", a)
-	bStr := fmt.Sprintf("%v", b)
+// isFieldCondition reports whether an object holds only operators that
+// apply to a value, as opposed to a filter on the fields of a document.
+func isFieldCondition(object map[string]interface{}) bool {
+	if len(object) == 0 {
+		return false
+	}
+	for key := range object {
+		if !strings.HasPrefix(key, "$") || isTopLevelOperator(Operator(key)) {
+			return false
+		}
+	}
+	return true
+}
 
+// isTopLevelOperator reports whether an operator applies to a whole
+// document rather than to a field.
+func isTopLevelOperator(op Operator) bool {
 	switch op {
-	case ">":
-		return aStr > bStr
-	case ">=":
-		return aStr >= bStr
-	case "<":
-		return aStr < bStr
-	case "<=":
-		return aStr <= bStr
+	case OpAnd, OpOr, OpNor, OpExpr, OpJSONSchema, OpText:
+		return true
 	}
+	return false
+}
 
+func hasOperatorKeys(object map[string]interface{}) bool {
+	for key := range object {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
 	return false
 }
 
-func containsValue(list interface{}, value interface{}) bool {
-	listValue := reflect.ValueOf(list)
-	if listValue.Kind() != reflect.Slice {
+// typeCodes maps the numeric $type codes to their aliases.
+var typeCodes = map[int]string{
+	1: "double", 2: "string", 3: "object", 4: "array", 8: "bool", 9: "date", 10: "null", 16: "int", 18: "long",
+}
+
+var typeAliases = map[string]bool{
+	"double": true, "string": true, "object": true, "array": true, "bool": true, "null": true,
+	"date": true, "int": true, "long": true, "number": true,
+}
+
+// typeAlias returns the alias a $type argument names.
+func typeAlias(arg interface{}) (string, bool) {
+	switch t := arg.(type) {
+	case string:
+		return t, typeAliases[t]
+	case float64:
+		alias, exists := typeCodes[int(t)]
+		return alias, exists && t == float64(int(t))
+	}
+	return "", false
+}
+
+// matchType reports whether a value, or an element of an array, has one of
+// the types a $type condition names. JSON numbers are doubles, so "double"
+// and "number" match every number, and "int" and "long" those with an
+// integral value in range.
+func matchType(value, condition interface{}) bool {
+	if value == missing {
 		return false
 	}
+	if items, isArray := value.([]interface{}); isArray {
+		if typeMatches(condition, "array") {
+			return true
+		}
+		for _, item := range items {
+			if typeMatches(condition, typeOf(item)...) {
+				return true
+			}
+		}
+		return false
+	}
+	return typeMatches(condition, typeOf(value)...)
+}
 
-	for i := 0; i < listValue.Len(); i++ {
-		if reflect.DeepEqual(listValue.Index(i).Interface(), value) {
+func typeMatches(condition interface{}, types ...string) bool {
+	names, isList := condition.([]interface{})
+	if !isList {
+		names = []interface{}{condition}
+	}
+	for _, name := range names {
+		alias, _ := typeAlias(name)
+		for _, t := range types {
+			if alias == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeOf returns the $type aliases of a value other than an array. An
+// RFC 3339 timestamp is both a string and a date.
+func typeOf(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return []string{"null"}
+	case string:
+		if _, ok := parseDate(v); ok {
+			return []string{"string", "date"}
+		}
+		return []string{"string"}
+	case map[string]interface{}:
+		return []string{"object"}
+	case bool:
+		return []string{"bool"}
+	}
+	n, ok := numeric(value)
+	if !ok {
+		return nil
+	}
+	types := []string{"double", "number"}
+	if n == math.Trunc(n) {
+		if n >= math.MinInt32 && n <= math.MaxInt32 {
+			types = append(types, "int")
+		}
+		if n >= math.MinInt64 && n < math.MaxInt64 {
+			types = append(types, "long")
+		}
+	}
+	return types
+}
+
+// matchMod reports whether a number divided by the divisor leaves the
+// remainder, both truncated to integers.
+func matchMod(value, condition interface{}) bool {
+	n, ok := numeric(value)
+	args, _ := condition.([]interface{})
+	if !ok || len(args) != 2 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return false
+	}
+	divisor, _ := numeric(args[0])
+	remainder, _ := numeric(args[1])
+	if int64(divisor) == 0 {
+		return false
+	}
+	return int64(n)%int64(divisor) == int64(remainder)
+}
+
+// compareValues applies a comparison operator in the comparison order.
+// Only values of the same type compare, so {"$gt": 25} never matches a
+// string, and a missing field compares as null.
+func compareValues(value, condition interface{}, op Operator) bool {
+	if typeRank(value) != typeRank(condition) {
+		return false
+	}
+	c := compareOrder(value, condition)
+	switch op {
+	case OpGreater:
+		return c > 0
+	case OpGreaterEqual:
+		return c >= 0
+	case OpLess:
+		return c < 0
+	case OpLessEqual:
+		return c <= 0
+	}
+	return false
+}
+
+// containsValue reports whether an $in list holds a value equal to the
+// given one.
+func containsValue(list interface{}, value interface{}) bool {
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		if equalValues(item, value) {
 			return true
 		}
 	}
@@ -192,6 +526,9 @@ func matchRegex(value interface{}, pattern interface{}) bool {
 	if !ok {
 		return false
 	}
+	if re, ok := pattern.(*regexp.Regexp); ok {
+		return re.MatchString(str)
+	}
 	patternStr, ok := pattern.(string)
 	if !ok {
 		return false
@@ -200,41 +537,28 @@ func matchRegex(value interface{}, pattern interface{}) bool {
 	return err == nil && matched
 }
 
-func toNumber(v interface{}) (float64, error) {
-	switch v := v.(type) {
-	case float64:
-		return v, nil
-	case float32:
-		return float64(v), nil
-	case int:
-		return float64(v), nil
-	case int32:
-		return float64(v), nil
-	case int64:
-		return float64(v), nil
-	case string:
-		return strconv.ParseFloat(v, 64)
-	}
-	return 0, fmt.Errorf("cannot convert %T to number", v)
-}
-
-func (m *Matcher) matchEQ(value, filterValue interface{}) bool {
-
-	if value == nil || filterValue == nil {
-		return value == filterValue
-	}
-
-	if v1, err1 := toNumber(value); err1 == nil {
-		if v2, err2 := toNumber(filterValue); err2 == nil {
-			return v1 == v2
+// matchGeoWithin reports whether a GeoJSON value lies within a $geoWithin
+// region. Values that are not geometry never match.
+func matchGeoWithin(value, condition interface{}) bool {
+	region, ok := condition.(geo.Region)
+	if !ok {
+		var err error
+		if region, err = ParseGeoWithin(condition); err != nil {
+			return false
 		}
 	}
+	g, err := geo.Parse(value)
+	return err == nil && region.Covers(g)
+}
 
-	if str1, ok1 := value.(string); ok1 {
-		if str2, ok2 := filterValue.(string); ok2 {
-			return str1 == str2
+func matchGeoIntersects(value, condition interface{}) bool {
+	other, ok := condition.(*geo.Geometry)
+	if !ok {
+		var err error
+		if other, err = ParseGeoIntersects(condition); err != nil {
+			return false
 		}
 	}
-
-	return reflect.DeepEqual(value, filterValue)
+	g, err := geo.Parse(value)
+	return err == nil && g.Intersects(other)
 }
@@ -0,0 +1,211 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decode(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var value map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	return value
+}
+
+func TestMatcherConformance(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		filter string
+		want   bool
+	}{
+		// $all
+		{"all contains every item", `{"tags": ["a", "b", "c"]}`, `{"tags": {"$all": ["a", "c"]}}`, true},
+		{"all lacks an item", `{"tags": ["a", "b"]}`, `{"tags": {"$all": ["a", "z"]}}`, false},
+		{"all on a scalar", `{"tags": "a"}`, `{"tags": {"$all": ["a"]}}`, true},
+		{"all empty list", `{"tags": ["a"]}`, `{"tags": {"$all": []}}`, false},
+		{"all with elemMatch", `{"scores": [70, 85]}`, `{"scores": {"$all": [{"$elemMatch": {"$gt": 80, "$lt": 90}}]}}`, true},
+		{"all with elemMatch no element", `{"scores": [70, 95]}`, `{"scores": {"$all": [{"$elemMatch": {"$gt": 80, "$lt": 90}}]}}`, false},
+		{"all on missing", `{}`, `{"tags": {"$all": ["a"]}}`, false},
+
+		// $size
+		{"size matches", `{"tags": ["a", "b"]}`, `{"tags": {"$size": 2}}`, true},
+		{"size differs", `{"tags": ["a", "b"]}`, `{"tags": {"$size": 1}}`, false},
+		{"size empty", `{"tags": []}`, `{"tags": {"$size": 0}}`, true},
+		{"size on a scalar", `{"tags": "a"}`, `{"tags": {"$size": 1}}`, false},
+		{"size on missing", `{}`, `{"tags": {"$size": 0}}`, false},
+
+		// $elemMatch
+		{"elemMatch operators on one element", `{"scores": [70, 85]}`, `{"scores": {"$elemMatch": {"$gte": 80, "$lt": 90}}}`, true},
+		{"elemMatch operators split across elements", `{"scores": [70, 95]}`, `{"scores": {"$elemMatch": {"$gte": 80, "$lt": 90}}}`, false},
+		{"elemMatch document filter", `{"items": [{"sku": "A", "qty": 1}, {"sku": "B", "qty": 5}]}`, `{"items": {"$elemMatch": {"sku": "B", "qty": {"$gt": 2}}}}`, true},
+		{"elemMatch document filter split", `{"items": [{"sku": "A", "qty": 5}, {"sku": "B", "qty": 1}]}`, `{"items": {"$elemMatch": {"sku": "B", "qty": {"$gt": 2}}}}`, false},
+		{"elemMatch on a scalar", `{"scores": 85}`, `{"scores": {"$elemMatch": {"$gte": 80}}}`, false},
+		{"elemMatch with or", `{"items": [{"sku": "A"}, {"sku": "C"}]}`, `{"items": {"$elemMatch": {"$or": [{"sku": "B"}, {"sku": "C"}]}}}`, true},
+
+		// $type
+		{"type string", `{"v": "x"}`, `{"v": {"$type": "string"}}`, true},
+		{"type code", `{"v": "x"}`, `{"v": {"$type": 2}}`, true},
+		{"type list", `{"v": true}`, `{"v": {"$type": ["string", "bool"]}}`, true},
+		{"type null", `{"v": null}`, `{"v": {"$type": "null"}}`, true},
+		{"type null on missing", `{}`, `{"v": {"$type": "null"}}`, false},
+		{"type int", `{"v": 3}`, `{"v": {"$type": "int"}}`, true},
+		{"type int on a fraction", `{"v": 3.5}`, `{"v": {"$type": "int"}}`, false},
+		{"type double", `{"v": 3}`, `{"v": {"$type": "double"}}`, true},
+		{"type number", `{"v": 3.5}`, `{"v": {"$type": "number"}}`, true},
+		{"type array", `{"v": [1]}`, `{"v": {"$type": "array"}}`, true},
+		{"type of an element", `{"v": [1, "x"]}`, `{"v": {"$type": "string"}}`, true},
+		{"type object", `{"v": {"a": 1}}`, `{"v": {"$type": "object"}}`, true},
+
+		// $mod
+		{"mod matches", `{"qty": 10}`, `{"qty": {"$mod": [4, 2]}}`, true},
+		{"mod differs", `{"qty": 10}`, `{"qty": {"$mod": [4, 0]}}`, false},
+		{"mod truncates", `{"qty": 10.7}`, `{"qty": {"$mod": [4, 2]}}`, true},
+		{"mod negative", `{"qty": -6}`, `{"qty": {"$mod": [4, -2]}}`, true},
+		{"mod element", `{"qty": [3, 8]}`, `{"qty": {"$mod": [4, 0]}}`, true},
+		{"mod on a string", `{"qty": "8"}`, `{"qty": {"$mod": [4, 0]}}`, false},
+
+		// $not
+		{"not inverts", `{"price": 50}`, `{"price": {"$not": {"$gt": 100}}}`, true},
+		{"not excludes", `{"price": 150}`, `{"price": {"$not": {"$gt": 100}}}`, false},
+		{"not on missing", `{}`, `{"price": {"$not": {"$gt": 100}}}`, true},
+		{"not on null", `{"price": null}`, `{"price": {"$not": {"$gt": 100}}}`, true},
+		{"not over array", `{"price": [50, 150]}`, `{"price": {"$not": {"$gt": 100}}}`, false},
+		{"not regex", `{"name": "bob"}`, `{"name": {"$not": {"$regex": "^a"}}}`, true},
+
+		// $nor
+		{"nor none match", `{"a": 1}`, `{"$nor": [{"a": 2}, {"b": 1}]}`, true},
+		{"nor one matches", `{"a": 1}`, `{"$nor": [{"a": 1}, {"b": 1}]}`, false},
+		{"nor on missing field", `{}`, `{"$nor": [{"a": 1}]}`, true},
+		{"nor on missing with exists", `{}`, `{"$nor": [{"a": {"$exists": false}}]}`, false},
+		{"nor null matches missing", `{}`, `{"$nor": [{"a": null}]}`, false},
+
+		// missing and null
+		{"null equals null", `{"a": null}`, `{"a": null}`, true},
+		{"null equals missing", `{}`, `{"a": null}`, true},
+		{"exists on null", `{"a": null}`, `{"a": {"$exists": true}}`, true},
+		{"exists on missing", `{}`, `{"a": {"$exists": true}}`, false},
+		{"not exists on missing", `{}`, `{"a": {"$exists": false}}`, true},
+		{"ne null on missing", `{}`, `{"a": {"$ne": null}}`, false},
+		{"ne value on missing", `{}`, `{"a": {"$ne": 1}}`, true},
+		{"nin on missing", `{}`, `{"a": {"$nin": [1, 2]}}`, true},
+		{"in null on missing", `{}`, `{"a": {"$in": [null]}}`, true},
+		{"gte null on missing", `{}`, `{"a": {"$gte": null}}`, true},
+		{"gt null on null", `{"a": null}`, `{"a": {"$gt": null}}`, false},
+
+		// array paths
+		{"fan out through documents", `{"items": [{"sku": "A"}, {"sku": "B"}]}`, `{"items.sku": "B"}`, true},
+		{"fan out finds nothing", `{"items": [{"sku": "A"}, {"sku": "B"}]}`, `{"items.sku": "C"}`, false},
+		{"fan out through nested arrays", `{"orders": [{"items": [{"sku": "A"}]}, {"items": [{"sku": "B"}, {"sku": "C"}]}]}`, `{"orders.items.sku": "C"}`, true},
+		{"fan out into array values", `{"orders": [{"tags": ["x"]}, {"tags": ["y", "z"]}]}`, `{"orders.tags": "z"}`, true},
+		{"fan out comparison", `{"items": [{"qty": 1}, {"qty": 7}]}`, `{"items.qty": {"$gt": 5}}`, true},
+		{"fan out skips scalars", `{"items": [1, {"sku": "A"}]}`, `{"items.sku": "A"}`, true},
+		{"fan out exists", `{"items": [{"sku": "A"}, {"qty": 1}]}`, `{"items.qty": {"$exists": true}}`, true},
+		{"fan out not exists", `{"items": [{"sku": "A"}]}`, `{"items.qty": {"$exists": false}}`, true},
+		{"fan out ne", `{"items": [{"sku": "A"}, {"sku": "B"}]}`, `{"items.sku": {"$ne": "A"}}`, false},
+		{"fan out size", `{"orders": [{"items": [1, 2]}]}`, `{"orders.items": {"$size": 2}}`, true},
+		{"positional path", `{"items": [{"sku": "A"}, {"sku": "B"}]}`, `{"items.0.sku": "A"}`, true},
+		{"positional path other element", `{"items": [{"sku": "A"}, {"sku": "B"}]}`, `{"items.0.sku": "B"}`, false},
+		{"positional path out of range", `{"items": [{"sku": "A"}]}`, `{"items.3.sku": {"$exists": false}}`, true},
+		{"positional scalar", `{"scores": [10, 20]}`, `{"scores.1": 20}`, true},
+		{"positional field name", `{"items": [{"0": "x"}]}`, `{"items.0": "x"}`, true},
+		{"nested document path", `{"a": {"b": {"c": 1}}}`, `{"a.b.c": 1}`, true},
+		{"path through a scalar", `{"a": 1}`, `{"a.b": {"$exists": false}}`, true},
+
+		// array equality
+		{"equals an element", `{"tags": ["a", "b"]}`, `{"tags": "b"}`, true},
+		{"equals the whole array", `{"tags": ["a", "b"]}`, `{"tags": ["a", "b"]}`, true},
+		{"array order matters", `{"tags": ["a", "b"]}`, `{"tags": ["b", "a"]}`, false},
+		{"operators apply independently", `{"scores": [70, 95]}`, `{"scores": {"$gt": 80, "$lt": 90}}`, true},
+	}
+
+	q := NewQuery()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := q.Matches(decode(t, tt.doc), decode(t, tt.filter))
+			if err != nil {
+				t.Fatalf("Matches(%s, %s): %v", tt.doc, tt.filter, err)
+			}
+			if got != tt.want {
+				t.Errorf("Matches(%s, %s) = %v, want %v", tt.doc, tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompiledFilterMatchesManyDocuments(t *testing.T) {
+	q := NewQuery()
+	filter, err := q.Compile(decode(t, `{"name": {"$regex": "^a"}, "$expr": {"$gt": ["$qty", 1]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []struct {
+		doc  string
+		want bool
+	}{
+		{`{"name": "ann", "qty": 2}`, true},
+		{`{"name": "bob", "qty": 2}`, false},
+		{`{"name": "amy", "qty": 1}`, false},
+		{`{"name": "al", "qty": 5}`, true},
+	}
+	for _, tt := range docs {
+		if got := filter.Matches(decode(t, tt.doc)); got != tt.want {
+			t.Errorf("Matches(%s) = %v, want %v", tt.doc, got, tt.want)
+		}
+	}
+
+	all, err := q.Compile(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !all.Matches(decode(t, `{}`)) {
+		t.Error("nil filter does not match everything")
+	}
+	if _, err := q.Compile(decode(t, `{"a": {"$bogus": 1}}`)); err == nil {
+		t.Error("Compile accepted an unknown operator")
+	}
+}
+
+func TestValidateRejectsMalformedOperators(t *testing.T) {
+	filters := []string{
+		`{"$not": {"a": 1}}`,
+		`{"a": {"$not": 1}}`,
+		`{"a": {"$size": -1}}`,
+		`{"a": {"$size": 1.5}}`,
+		`{"a": {"$mod": [0, 1]}}`,
+		`{"a": {"$mod": [2]}}`,
+		`{"a": {"$type": "bogus"}}`,
+		`{"a": {"$type": []}}`,
+		`{"a": {"$all": "x"}}`,
+		`{"a": {"$all": [{"$gt": 1}]}}`,
+		`{"a": {"$elemMatch": 1}}`,
+		`{"a": {"$exists": 1}}`,
+		`{"a": {"$in": 1}}`,
+		`{"a": {"$gt": 1, "b": 2}}`,
+		`{"a": {"$and": [{"b": 1}]}}`,
+		`{"$nor": []}`,
+		`{"$or": [1]}`,
+		`{"$bogus": 1}`,
+	}
+
+	q := NewQuery()
+	for _, filter := range filters {
+		if err := q.Validate(decode(t, filter)); err == nil {
+			t.Errorf("Validate(%s) succeeded, want an error", filter)
+		}
+	}
+}
+
+func TestPathValues(t *testing.T) {
+	doc := decode(t, `{"items": [{"sku": "A"}, {"sku": ["B", "C"]}, {"qty": 1}]}`)
+	got, _ := json.Marshal(PathValues(doc, "items.sku"))
+	if want := `["A",["B","C"],"B","C",null]`; string(got) != want {
+		t.Errorf("PathValues = %s, want %s", got, want)
+	}
+	got, _ = json.Marshal(Lookup(doc, "items.sku"))
+	if want := `["A",["B","C"]]`; string(got) != want {
+		t.Errorf("Lookup = %s, want %s", got, want)
+	}
+}
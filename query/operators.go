@@ -26,11 +26,19 @@ const (
     // Evaluation Operators
     OpRegex        Operator = "$regex"
     OpMod          Operator = "$mod"
+    OpJSONSchema   Operator = "$jsonSchema"
+    OpExpr         Operator = "$expr"
+    OpText         Operator = "$text"
     
     // Array Operators
     OpAll          Operator = "$all"
     OpSize         Operator = "$size"
     OpElemMatch    Operator = "$elemMatch"
+
+    // Geospatial Operators
+    OpGeoWithin     Operator = "$geoWithin"
+    OpGeoIntersects Operator = "$geoIntersects"
+    OpNear          Operator = "$near"
 )
 
 func IsComparisonOperator(op Operator) bool {
@@ -62,7 +70,7 @@ func IsElementOperator(op Operator) bool {
 
 func IsEvaluationOperator(op Operator) bool {
     switch op {
-    case OpRegex, OpMod:
+    case OpRegex, OpMod, OpJSONSchema, OpExpr, OpText:
         return true
     default:
         return false
@@ -78,7 +86,16 @@ func IsArrayOperator(op Operator) bool {
     }
 }
 
+func IsGeospatialOperator(op Operator) bool {
+    switch op {
+    case OpGeoWithin, OpGeoIntersects, OpNear:
+        return true
+    default:
+        return false
+    }
+}
+
 func ValidateOperator(op Operator) bool {
     operator := Operator(op)
-    return IsComparisonOperator(operator) || IsLogicalOperator(operator) || IsElementOperator(operator) || IsEvaluationOperator(operator) || IsArrayOperator(operator)
+    return IsComparisonOperator(operator) || IsLogicalOperator(operator) || IsElementOperator(operator) || IsEvaluationOperator(operator) || IsArrayOperator(operator) || IsGeospatialOperator(operator)
 }
\ No newline at end of file
@@ -0,0 +1,651 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pipeline is a compiled aggregation pipeline. Stages pull documents from
+// the stage before them one at a time; only $group, $sort and $count need
+// to see all of their input before producing output.
+type Pipeline struct {
+	stages      []stage
+	collections []string
+	text        *TextSearch
+	near        *GeoNear
+	vector      *VectorSearch
+}
+
+// stage wraps the iterator of the previous stage.
+type stage func(input iterator, env environment) iterator
+
+// Source returns the documents of another collection of the same project,
+// or nil if it does not exist. $lookup reads through it.
+type Source func(collection string) []map[string]interface{}
+
+// environment is what a running stage can see besides its input.
+type environment struct {
+	source Source
+	vars   map[string]interface{}
+}
+
+// iterator returns the next document, or false once the input is done.
+type iterator func() (map[string]interface{}, bool)
+
+// ParsePipeline compiles a pipeline from its JSON form, an array of
+// single-operator stage objects. The JSON is parsed here rather than
+// decoded by the caller so $sort keys keep their order.
+func ParsePipeline(raw []byte) (*Pipeline, error) {
+	var rawStages []json.RawMessage
+	if err := json.Unmarshal(raw, &rawStages); err != nil {
+		return nil, errors.New("pipeline must be an array of stages")
+	}
+
+	pipeline := &Pipeline{}
+	for i, rawStage := range rawStages {
+		var spec map[string]json.RawMessage
+		if err := json.Unmarshal(rawStage, &spec); err != nil || len(spec) != 1 {
+			return nil, fmt.Errorf("stage %d must be an object with a single operator", i)
+		}
+
+		for name, rawArg := range spec {
+			compiled, err := pipeline.compileStage(name, rawArg)
+			if err != nil {
+				return nil, fmt.Errorf("stage %d (%s): %v", i, name, err)
+			}
+			pipeline.stages = append(pipeline.stages, compiled)
+		}
+	}
+	return pipeline, nil
+}
+
+// Collections returns the collections the pipeline reads through $lookup,
+// including those of nested pipelines.
+func (p *Pipeline) Collections() []string {
+	return p.collections
+}
+
+// TextSearch returns the $text condition of the pipeline's first $match
+// stage, or nil. The caller runs the search and passes the documents it
+// found to Run; the stage itself only applies the rest of its filter.
+func (p *Pipeline) TextSearch() *TextSearch {
+	return p.text
+}
+
+// GeoNear returns the pipeline's $geoNear stage, or nil. Like a text
+// search, the caller runs it and passes the documents it found to Run.
+func (p *Pipeline) GeoNear() *GeoNear {
+	return p.near
+}
+
+// VectorSearch returns the pipeline's $vectorSearch stage, or nil. The
+// caller runs the search and passes the documents it found to Run.
+func (p *Pipeline) VectorSearch() *VectorSearch {
+	return p.vector
+}
+
+// Run feeds the documents through the pipeline, resolving $lookup through
+// source. scores holds the score of each document for {"$meta":
+// "vectorSearchScore"} when the pipeline starts with $vectorSearch, or for
+// {"$meta": "textScore"} when it starts with a $text search, and is nil
+// otherwise. The input documents are never modified.
+func (p *Pipeline) Run(documents []map[string]interface{}, scores []float64, source Source) []map[string]interface{} {
+	scoreField := []string{textScoreField}
+	if p.vector != nil {
+		scoreField = []string{vectorScoreField}
+	}
+	if scores != nil {
+		scored := make([]map[string]interface{}, len(documents))
+		for i, doc := range documents {
+			scored[i] = withField(doc, scoreField, scores[i])
+		}
+		documents = scored
+	}
+
+	results := p.run(documents, environment{source: source})
+	if scores != nil {
+		for i, doc := range results {
+			results[i] = withoutField(doc, scoreField)
+		}
+	}
+	return results
+}
+
+func (p *Pipeline) run(documents []map[string]interface{}, env environment) []map[string]interface{} {
+	next := 0
+	var it iterator = func() (map[string]interface{}, bool) {
+		if next == len(documents) {
+			return nil, false
+		}
+		next++
+		return documents[next-1], true
+	}
+
+	for _, s := range p.stages {
+		it = s(it, env)
+	}
+
+	results := []map[string]interface{}{}
+	for doc, ok := it(); ok; doc, ok = it() {
+		results = append(results, doc)
+	}
+	return results
+}
+
+func (p *Pipeline) compileStage(name string, raw json.RawMessage) (stage, error) {
+	switch name {
+	case "$sort":
+		return compileSort(raw)
+	case "$lookup":
+		return p.compileLookup(raw)
+	}
+
+	var arg interface{}
+	if err := json.Unmarshal(raw, &arg); err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "$match":
+		return p.compileMatch(arg)
+	case "$geoNear":
+		return p.compileGeoNear(arg)
+	case "$vectorSearch":
+		return p.compileVectorSearch(arg)
+	case "$project":
+		return compileProject(arg)
+	case "$addFields":
+		return compileAddFields(arg)
+	case "$group":
+		return compileGroup(arg)
+	case "$limit":
+		n, err := count(arg)
+		if err != nil {
+			return nil, err
+		}
+		return limitStage(n), nil
+	case "$skip":
+		n, err := count(arg)
+		if err != nil {
+			return nil, err
+		}
+		return skipStage(n), nil
+	case "$unwind":
+		return compileUnwind(arg)
+	case "$count":
+		return compileCount(arg)
+	}
+	return nil, errors.New("unknown stage")
+}
+
+// compileMatch compiles a $match stage. A $text condition is only allowed
+// in the first stage, whose input is what the text search found.
+func (p *Pipeline) compileMatch(arg interface{}) (stage, error) {
+	filter, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("filter must be an object")
+	}
+	q := NewQuery()
+	if err := q.Validate(filter); err != nil {
+		return nil, err
+	}
+	if containsOperator(filter, OpNear) {
+		return nil, errors.New("$near is not allowed in $match; use $geoNear")
+	}
+	text, filter, err := SplitText(filter)
+	if err != nil {
+		return nil, err
+	}
+	if text != nil {
+		if len(p.stages) > 0 {
+			return nil, errors.New("$text is only allowed in the first $match stage")
+		}
+		p.text = text
+	}
+	filter, err = compileConditions(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(input iterator, env environment) iterator {
+		filter := filter
+		if len(env.vars) > 0 {
+			filter = bindVariables(filter, env.vars).(map[string]interface{})
+		}
+		return func() (map[string]interface{}, bool) {
+			for doc, ok := input(); ok; doc, ok = input() {
+				if q.matcher.Matches(doc, filter) {
+					return doc, true
+				}
+			}
+			return nil, false
+		}
+	}, nil
+}
+
+// bindVariables returns a copy of a compiled filter whose $expr conditions
+// see the given variables, such as those bound by a $lookup.
+func bindVariables(condition interface{}, vars map[string]interface{}) interface{} {
+	switch c := condition.(type) {
+	case expression:
+		return expression(func(doc, _ map[string]interface{}) interface{} {
+			return c(doc, vars)
+		})
+	case map[string]interface{}:
+		bound := make(map[string]interface{}, len(c))
+		for key, value := range c {
+			bound[key] = bindVariables(value, vars)
+		}
+		return bound
+	case []interface{}:
+		bound := make([]interface{}, len(c))
+		for i, value := range c {
+			bound[i] = bindVariables(value, vars)
+		}
+		return bound
+	}
+	return condition
+}
+
+// compileProject handles both forms of $project: listing the fields to
+// keep, possibly with computed ones, or listing the fields to drop. _id is
+// kept unless it is excluded explicitly.
+func compileProject(arg interface{}) (stage, error) {
+	spec, ok := arg.(map[string]interface{})
+	if !ok || len(spec) == 0 {
+		return nil, errors.New("specification must be a non-empty object")
+	}
+
+	var included, excluded [][]string
+	computed := make(map[string]expression)
+	keepID := true
+	for field, value := range spec {
+		path := strings.Split(field, ".")
+		switch flag, isFlag := projectionFlag(value); {
+		case isFlag && field == "_id":
+			keepID = flag
+		case isFlag && flag:
+			included = append(included, path)
+		case isFlag:
+			excluded = append(excluded, path)
+		default:
+			expr, err := compileExpression(value)
+			if err != nil {
+				return nil, err
+			}
+			computed[field] = expr
+		}
+	}
+
+	if len(excluded) > 0 {
+		if len(included) > 0 || len(computed) > 0 {
+			return nil, errors.New("cannot mix excluded fields with included or computed ones")
+		}
+		if !keepID {
+			excluded = append(excluded, []string{"_id"})
+		}
+		return mapStage(func(doc map[string]interface{}, _ map[string]interface{}) map[string]interface{} {
+			for _, path := range excluded {
+				doc = withoutField(doc, path)
+			}
+			return doc
+		}), nil
+	}
+
+	if !keepID && len(included) == 0 && len(computed) == 0 {
+		// {"_id": 0} on its own drops just _id.
+		return mapStage(func(doc map[string]interface{}, _ map[string]interface{}) map[string]interface{} {
+			return withoutField(doc, []string{"_id"})
+		}), nil
+	}
+	if keepID {
+		included = append(included, []string{"_id"})
+	}
+	// Search scores survive projection so a later stage can still read them.
+	included = append(included, []string{textScoreField}, []string{vectorScoreField})
+
+	return mapStage(func(doc map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		result := map[string]interface{}{}
+		for _, path := range included {
+			if value, ok := lookupField(doc, strings.Join(path, ".")); ok {
+				result = withField(result, path, value)
+			}
+		}
+		for field, expr := range computed {
+			if value := expr(doc, vars); value != missing {
+				result = withField(result, strings.Split(field, "."), value)
+			}
+		}
+		return result
+	}), nil
+}
+
+// projectionFlag reports whether a $project value includes or excludes a
+// field rather than computing it.
+func projectionFlag(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case float64:
+		return v != 0, true
+	}
+	return false, false
+}
+
+func compileAddFields(arg interface{}) (stage, error) {
+	spec, ok := arg.(map[string]interface{})
+	if !ok || len(spec) == 0 {
+		return nil, errors.New("specification must be a non-empty object")
+	}
+
+	fields := make(map[string]expression, len(spec))
+	for field, value := range spec {
+		expr, err := compileExpression(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[field] = expr
+	}
+
+	return mapStage(func(doc map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		result := doc
+		for field, expr := range fields {
+			if value := expr(doc, vars); value != missing {
+				result = withField(result, strings.Split(field, "."), value)
+			}
+		}
+		return result
+	}), nil
+}
+
+func mapStage(transform func(doc map[string]interface{}, vars map[string]interface{}) map[string]interface{}) stage {
+	return func(input iterator, env environment) iterator {
+		return func() (map[string]interface{}, bool) {
+			doc, ok := input()
+			if !ok {
+				return nil, false
+			}
+			return transform(doc, env.vars), true
+		}
+	}
+}
+
+// compileGroup buckets documents by the _id expression. Buckets are
+// returned in the order their first document arrived.
+func compileGroup(arg interface{}) (stage, error) {
+	spec, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("specification must be an object")
+	}
+	idSpec, exists := spec["_id"]
+	if !exists {
+		return nil, errors.New("_id is required")
+	}
+	key, err := compileExpression(idSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	accumulators := make(map[string]func() accumulator, len(spec)-1)
+	for field, value := range spec {
+		if field == "_id" {
+			continue
+		}
+		if strings.Contains(field, ".") {
+			return nil, errors.New("field names cannot contain dots: " + field)
+		}
+		if accumulators[field], err = compileAccumulator(value); err != nil {
+			return nil, fmt.Errorf("%s: %v", field, err)
+		}
+	}
+
+	type bucket struct {
+		id     interface{}
+		fields map[string]accumulator
+	}
+
+	return blockingStage(func(docs []map[string]interface{}, env environment) []map[string]interface{} {
+		var order []*bucket
+		buckets := make(map[string]*bucket)
+		for _, doc := range docs {
+			id := key(doc, env.vars)
+			if id == missing {
+				id = nil
+			}
+			encoded, _ := json.Marshal(id)
+
+			b, exists := buckets[string(encoded)]
+			if !exists {
+				b = &bucket{id: id, fields: make(map[string]accumulator, len(accumulators))}
+				for field, newAccumulator := range accumulators {
+					b.fields[field] = newAccumulator()
+				}
+				buckets[string(encoded)] = b
+				order = append(order, b)
+			}
+			for _, acc := range b.fields {
+				acc.add(doc, env.vars)
+			}
+		}
+
+		results := make([]map[string]interface{}, len(order))
+		for i, b := range order {
+			result := map[string]interface{}{"_id": b.id}
+			for field, acc := range b.fields {
+				result[field] = acc.result()
+			}
+			results[i] = result
+		}
+		return results
+	}), nil
+}
+
+type sortKey struct {
+	path      string
+	direction int
+}
+
+// compileSort reads the sort keys in the order they are written, which a
+// decoded map would lose.
+func compileSort(raw json.RawMessage) (stage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, errors.New("specification must be an object")
+	}
+
+	var keys []sortKey
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var direction interface{}
+		if err := decoder.Decode(&direction); err != nil {
+			return nil, err
+		}
+		// {"$meta": "textScore"} and {"$meta": "vectorSearchScore"} sort by
+		// relevance, best first.
+		if meta, ok := direction.(map[string]interface{}); ok && len(meta) == 1 {
+			if field, ok := metaFields[meta["$meta"]]; ok {
+				keys = append(keys, sortKey{path: field, direction: -1})
+				continue
+			}
+		}
+		if direction != 1.0 && direction != -1.0 {
+			return nil, fmt.Errorf("direction of %v must be 1, -1 or a $meta score", token)
+		}
+		keys = append(keys, sortKey{path: token.(string), direction: int(direction.(float64))})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("specification must name at least one field")
+	}
+
+	return blockingStage(func(docs []map[string]interface{}, _ environment) []map[string]interface{} {
+		sorted := append([]map[string]interface{}(nil), docs...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			for _, key := range keys {
+				a, _ := lookupField(sorted[i], key.path)
+				b, _ := lookupField(sorted[j], key.path)
+				if c := compareOrder(a, b); c != 0 {
+					return c*key.direction < 0
+				}
+			}
+			return false
+		})
+		return sorted
+	}), nil
+}
+
+// blockingStage collects all of its input before handing it to process.
+func blockingStage(process func(docs []map[string]interface{}, env environment) []map[string]interface{}) stage {
+	return func(input iterator, env environment) iterator {
+		var output []map[string]interface{}
+		done := false
+		return func() (map[string]interface{}, bool) {
+			if !done {
+				var docs []map[string]interface{}
+				for doc, ok := input(); ok; doc, ok = input() {
+					docs = append(docs, doc)
+				}
+				output = process(docs, env)
+				done = true
+			}
+			if len(output) == 0 {
+				return nil, false
+			}
+			doc := output[0]
+			output = output[1:]
+			return doc, true
+		}
+	}
+}
+
+func limitStage(n int) stage {
+	return func(input iterator, _ environment) iterator {
+		returned := 0
+		return func() (map[string]interface{}, bool) {
+			if returned == n {
+				return nil, false
+			}
+			returned++
+			return input()
+		}
+	}
+}
+
+func skipStage(n int) stage {
+	return func(input iterator, _ environment) iterator {
+		skipped := false
+		return func() (map[string]interface{}, bool) {
+			if !skipped {
+				skipped = true
+				for i := 0; i < n; i++ {
+					if _, ok := input(); !ok {
+						return nil, false
+					}
+				}
+			}
+			return input()
+		}
+	}
+}
+
+// compileUnwind emits one document per element of an array field. The
+// argument is either the field path or an object with path,
+// includeArrayIndex and preserveNullAndEmptyArrays.
+func compileUnwind(arg interface{}) (stage, error) {
+	var path, indexField string
+	var preserve bool
+	switch a := arg.(type) {
+	case string:
+		path = a
+	case map[string]interface{}:
+		path, _ = a["path"].(string)
+		if value, exists := a["includeArrayIndex"]; exists {
+			if indexField, _ = value.(string); indexField == "" || strings.HasPrefix(indexField, "$") {
+				return nil, errors.New("includeArrayIndex must be a field name")
+			}
+		}
+		if value, exists := a["preserveNullAndEmptyArrays"]; exists {
+			var ok bool
+			if preserve, ok = value.(bool); !ok {
+				return nil, errors.New("preserveNullAndEmptyArrays must be a boolean")
+			}
+		}
+	}
+	if !strings.HasPrefix(path, "$") || len(path) < 2 {
+		return nil, errors.New("path must be a field path starting with $")
+	}
+	field := strings.Split(path[1:], ".")
+
+	return func(input iterator, _ environment) iterator {
+		var pending []map[string]interface{}
+		return func() (map[string]interface{}, bool) {
+			for len(pending) == 0 {
+				doc, ok := input()
+				if !ok {
+					return nil, false
+				}
+				pending = unwind(doc, field, indexField, preserve)
+			}
+			doc := pending[0]
+			pending = pending[1:]
+			return doc, true
+		}
+	}, nil
+}
+
+func unwind(doc map[string]interface{}, field []string, indexField string, preserve bool) []map[string]interface{} {
+	value, exists := lookupField(doc, strings.Join(field, "."))
+	items, isArray := value.([]interface{})
+	if exists && value != nil && !isArray {
+		// A single value is unwound like a one-element array.
+		items = []interface{}{value}
+	}
+
+	if len(items) == 0 {
+		if !preserve {
+			return nil
+		}
+		if indexField != "" {
+			doc = withField(doc, []string{indexField}, nil)
+		}
+		return []map[string]interface{}{doc}
+	}
+
+	results := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		result := withField(doc, field, item)
+		if indexField != "" {
+			result = withField(result, []string{indexField}, float64(i))
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func compileCount(arg interface{}) (stage, error) {
+	field, ok := arg.(string)
+	if !ok || field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+		return nil, errors.New("argument must be a field name")
+	}
+
+	return blockingStage(func(docs []map[string]interface{}, _ environment) []map[string]interface{} {
+		if len(docs) == 0 {
+			return nil
+		}
+		return []map[string]interface{}{{field: len(docs)}}
+	}), nil
+}
+
+func count(arg interface{}) (int, error) {
+	n, ok := arg.(float64)
+	if !ok || n < 0 || n != float64(int(n)) {
+		return 0, errors.New("argument must be a non-negative integer")
+	}
+	return int(n), nil
+}
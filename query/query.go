@@ -2,8 +2,12 @@ package query
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/schema"
 )
 
 type Query struct {
@@ -21,7 +25,8 @@ func (q *Query) Execute(data interface{}, filter map[string]interface{}) (interf
 		return data, nil
 	}
 
-	if err := q.validateFilter(filter); err != nil {
+	compiled, err := q.Compile(filter)
+	if err != nil {
 		return nil, err
 	}
 
@@ -29,7 +34,7 @@ func (q *Query) Execute(data interface{}, filter map[string]interface{}) (interf
 
 		var results []*models.Document
 		for _, doc := range documents {
-			if q.matcher.Matches(doc.Data, filter) {
+			if compiled.Matches(doc.Data) {
 				results = append(results, doc)
 			}
 		}
@@ -39,30 +44,318 @@ func (q *Query) Execute(data interface{}, filter map[string]interface{}) (interf
 	return nil, errors.New("invalid data type")
 }
 
-func (q *Query) validateFilter(filter map[string]interface{}) error {
+// Filter is a filter validated and compiled once to be matched against many
+// documents.
+type Filter struct {
+	matcher    *Matcher
+	conditions map[string]interface{}
+}
+
+// Compile validates a filter and compiles its schemas, expressions,
+// patterns and geometries. A nil filter compiles to one that matches
+// everything.
+func (q *Query) Compile(filter map[string]interface{}) (*Filter, error) {
+	if filter == nil {
+		return &Filter{matcher: q.matcher}, nil
+	}
+	if err := q.validateFilter(filter); err != nil {
+		return nil, err
+	}
+	conditions, err := compileConditions(filter)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{matcher: q.matcher, conditions: conditions}, nil
+}
+
+// Matches reports whether data satisfies the filter.
+func (f *Filter) Matches(data map[string]interface{}) bool {
+	if f.conditions == nil {
+		return true
+	}
+	return f.matcher.Matches(data, f.conditions)
+}
+
+// Matches reports whether data satisfies the filter. A nil filter matches
+// everything. It compiles the filter on every call; use Compile to match
+// many documents.
+func (q *Query) Matches(data map[string]interface{}, filter map[string]interface{}) (bool, error) {
+	compiled, err := q.Compile(filter)
+	if err != nil {
+		return false, err
+	}
+	return compiled.Matches(data), nil
+}
+
+// Validate checks that every operator used in the filter is supported and
+// that its schemas, expressions, patterns, text search and geometries
+// compile.
+func (q *Query) Validate(filter map[string]interface{}) error {
+	if err := q.validateFilter(filter); err != nil {
+		return err
+	}
+	if _, _, err := SplitText(filter); err != nil {
+		return err
+	}
+	if _, _, err := SplitNear(filter); err != nil {
+		return err
+	}
+	_, err := compileConditions(filter)
+	return err
+}
+
+// And combines filters so a document must satisfy all of them. Nil filters
+// are skipped.
+func And(filters ...map[string]interface{}) map[string]interface{} {
+	var clauses []interface{}
+	for _, filter := range filters {
+		if filter != nil {
+			clauses = append(clauses, filter)
+		}
+	}
+
+	switch len(clauses) {
+	case 0:
+		return nil
+	case 1:
+		return clauses[0].(map[string]interface{})
+	}
+	return map[string]interface{}{string(OpAnd): clauses}
+}
+
+// compileConditions returns a copy of the filter with every $jsonSchema,
+// $expr, $text, $regex and geospatial condition compiled, so a schema,
+// expression, pattern or geometry is parsed once per query rather than once
+// per document. The
+// filter itself is left untouched.
+func compileConditions(filter map[string]interface{}) (map[string]interface{}, error) {
+	compiled := make(map[string]interface{}, len(filter))
 	for key, value := range filter {
-		if key[0] == '$' {
-			if !ValidateOperator(Operator(key)) {
-				return errors.New("invalid operator: " + key)
+		switch Operator(key) {
+		case OpJSONSchema:
+			s, err := schema.Compile(value)
+			if err != nil {
+				return nil, errors.New("invalid $jsonSchema: " + err.Error())
+			}
+			compiled[key] = s
+			continue
+		case OpExpr:
+			expr, err := compileExpression(value)
+			if err != nil {
+				return nil, errors.New("invalid $expr: " + err.Error())
+			}
+			compiled[key] = expr
+			continue
+		case OpText:
+			ts, err := ParseTextSearch(value)
+			if err != nil {
+				return nil, err
+			}
+			compiled[key] = ts
+			continue
+		case OpGeoWithin:
+			region, err := ParseGeoWithin(value)
+			if err != nil {
+				return nil, err
+			}
+			compiled[key] = region
+			continue
+		case OpGeoIntersects:
+			g, err := ParseGeoIntersects(value)
+			if err != nil {
+				return nil, err
+			}
+			compiled[key] = g
+			continue
+		case OpNear:
+			near, err := parseNear("", value)
+			if err != nil {
+				return nil, err
+			}
+			compiled[key] = near
+			continue
+		case OpRegex:
+			if pattern, ok := value.(string); ok {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return nil, errors.New("invalid $regex: " + err.Error())
+				}
+				compiled[key] = re
+				continue
 			}
 		}
 
 		switch v := value.(type) {
 		case map[string]interface{}:
-			if err := q.validateFilter(v); err != nil {
-				return err
+			sub, err := compileConditions(v)
+			if err != nil {
+				return nil, err
 			}
+			compiled[key] = sub
 		case []interface{}:
-			for _, item := range v {
+			items := make([]interface{}, len(v))
+			for i, item := range v {
 				if subFilter, ok := item.(map[string]interface{}); ok {
-					if err := q.validateFilter(subFilter); err != nil {
-						return err
+					sub, err := compileConditions(subFilter)
+					if err != nil {
+						return nil, err
 					}
+					items[i] = sub
+				} else {
+					items[i] = item
 				}
 			}
+			compiled[key] = items
+		default:
+			compiled[key] = value
 		}
+	}
+	return compiled, nil
+}
 
+func (q *Query) validateFilter(filter map[string]interface{}) error {
+	for key, value := range filter {
+		if key == "" {
+			return errors.New("empty field name")
+		}
+		if key[0] != '$' {
+			if operators, ok := value.(map[string]interface{}); ok && hasOperatorKeys(operators) {
+				if err := q.validateConditions(key, operators); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		op := Operator(key)
+		switch {
+		case !ValidateOperator(op):
+			return errors.New("invalid operator: " + key)
+		case op == OpAnd || op == OpOr || op == OpNor:
+			clauses, ok := value.([]interface{})
+			if !ok || len(clauses) == 0 {
+				return errors.New(key + " must be a non-empty array of filters")
+			}
+			for _, clause := range clauses {
+				subFilter, ok := clause.(map[string]interface{})
+				if !ok {
+					return errors.New(key + " must be a non-empty array of filters")
+				}
+				if err := q.validateFilter(subFilter); err != nil {
+					return err
+				}
+			}
+		case op == OpJSONSchema || op == OpExpr || op == OpText:
+			// Neither a schema, an expression nor a text search is a
+			// filter; their keywords are checked when they are compiled.
+		default:
+			return errors.New(key + " must be applied to a field")
+		}
 	}
 
 	return nil
 }
+
+// validateConditions checks the operators of a condition on a field and
+// their arguments.
+func (q *Query) validateConditions(field string, operators map[string]interface{}) error {
+	for key, arg := range operators {
+		op := Operator(key)
+		switch {
+		case !strings.HasPrefix(key, "$"):
+			return errors.New("cannot mix operators and field names in the condition on " + field)
+		case !ValidateOperator(op):
+			return errors.New("invalid operator: " + key)
+		case isTopLevelOperator(op):
+			return errors.New(key + " cannot be applied to a field")
+		}
+
+		switch op {
+		case OpIn, OpNotIn:
+			if _, ok := arg.([]interface{}); !ok {
+				return errors.New(key + " requires an array")
+			}
+		case OpExists:
+			if _, ok := arg.(bool); !ok {
+				return errors.New("$exists requires a boolean")
+			}
+		case OpSize:
+			if _, err := count(arg); err != nil {
+				return errors.New("$size " + err.Error())
+			}
+		case OpMod:
+			args, ok := arg.([]interface{})
+			if !ok || len(args) != 2 {
+				return errors.New("$mod requires an array of a divisor and a remainder")
+			}
+			divisor, isNumber := numeric(args[0])
+			if _, ok := numeric(args[1]); !isNumber || !ok {
+				return errors.New("$mod requires an array of a divisor and a remainder")
+			}
+			if int64(divisor) == 0 {
+				return errors.New("$mod divisor must not be zero")
+			}
+		case OpType:
+			names, isList := arg.([]interface{})
+			if !isList {
+				names = []interface{}{arg}
+			}
+			if len(names) == 0 {
+				return errors.New("$type requires a type")
+			}
+			for _, name := range names {
+				if _, ok := typeAlias(name); !ok {
+					return fmt.Errorf("unknown $type: %v", name)
+				}
+			}
+		case OpRegex:
+			if _, ok := arg.(string); !ok {
+				return errors.New("$regex requires a string")
+			}
+		case OpNot:
+			sub, ok := arg.(map[string]interface{})
+			if !ok || len(sub) == 0 || !hasOperatorKeys(sub) {
+				return errors.New("$not requires an object of operators")
+			}
+			if err := q.validateConditions(field, sub); err != nil {
+				return err
+			}
+		case OpElemMatch:
+			if err := q.validateElemMatch(field, arg); err != nil {
+				return err
+			}
+		case OpAll:
+			items, ok := arg.([]interface{})
+			if !ok {
+				return errors.New("$all requires an array")
+			}
+			for _, item := range items {
+				sub, ok := item.(map[string]interface{})
+				if !ok || !hasOperatorKeys(sub) {
+					continue
+				}
+				spec, isElemMatch := sub[string(OpElemMatch)]
+				if !isElemMatch || len(sub) != 1 {
+					return errors.New("$all items may only use $elemMatch")
+				}
+				if err := q.validateElemMatch(field, spec); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateElemMatch checks an $elemMatch argument, either operators on the
+// elements themselves or a filter on elements that are documents.
+func (q *Query) validateElemMatch(field string, arg interface{}) error {
+	spec, ok := arg.(map[string]interface{})
+	if !ok {
+		return errors.New("$elemMatch requires an object")
+	}
+	if isFieldCondition(spec) {
+		return q.validateConditions(field, spec)
+	}
+	return q.validateFilter(spec)
+}
@@ -0,0 +1,203 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// textScoreField carries the text search score of a pipeline document
+// from the first $match to {"$meta": "textScore"}. It is removed from the
+// results.
+const textScoreField = "$textScore"
+
+// TextSearch is a parsed $text condition. It is answered by a collection's
+// text index rather than by the Matcher.
+type TextSearch struct {
+	// Terms are the words to look for; a document matches if it holds any
+	// of them.
+	Terms []string
+	// Phrases must all appear in a matching document.
+	Phrases []string
+	// Negated words and phrases must not appear in a matching document.
+	Negated []string
+	// Language overrides the language of the index when set.
+	Language string
+}
+
+// ParseTextSearch parses {"$search": "...", "$language": "..."}. In the
+// search string, "quoted text" is a phrase and a leading "-" negates a word
+// or phrase.
+func ParseTextSearch(condition interface{}) (*TextSearch, error) {
+	spec, ok := condition.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("$text takes an object with $search")
+	}
+	search, ok := spec["$search"].(string)
+	if !ok {
+		return nil, errors.New("$text requires a $search string")
+	}
+
+	ts := &TextSearch{}
+	for key, value := range spec {
+		switch key {
+		case "$search":
+		case "$language":
+			if ts.Language, ok = value.(string); !ok {
+				return nil, errors.New("$language must be a string")
+			}
+		default:
+			return nil, errors.New("unsupported $text option: " + key)
+		}
+	}
+
+	for rest := strings.TrimSpace(search); rest != ""; rest = strings.TrimSpace(rest) {
+		negated := strings.HasPrefix(rest, "-")
+		if negated {
+			rest = rest[1:]
+		}
+
+		var part string
+		phrase := strings.HasPrefix(rest, `"`)
+		if phrase {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, errors.New("$search has an unterminated phrase")
+			}
+			part, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t\n\"")
+			if end < 0 {
+				end = len(rest)
+			}
+			part, rest = rest[:end], rest[end:]
+		}
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		switch {
+		case negated:
+			ts.Negated = append(ts.Negated, part)
+		case phrase:
+			ts.Phrases = append(ts.Phrases, part)
+		default:
+			ts.Terms = append(ts.Terms, part)
+		}
+	}
+	if len(ts.Terms) == 0 && len(ts.Phrases) == 0 {
+		return nil, errors.New("$search must contain a word or phrase that is not negated")
+	}
+	return ts, nil
+}
+
+// SplitText separates the $text condition of a filter from the rest of it.
+// $text may appear once, at the top level or inside $and, since the
+// documents it matches come from an index rather than from the Matcher. The
+// search is nil when the filter has no $text condition.
+func SplitText(filter map[string]interface{}) (*TextSearch, map[string]interface{}, error) {
+	var found *TextSearch
+	rest, err := extract(filter, OpText, func(key string, value interface{}) (interface{}, bool, error) {
+		if Operator(key) != OpText {
+			return value, false, nil
+		}
+		if found != nil {
+			return nil, true, errors.New("$text may appear only once")
+		}
+		ts, err := ParseTextSearch(value)
+		if err != nil {
+			return nil, true, err
+		}
+		found = ts
+		return nil, true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return found, rest, nil
+}
+
+// extract takes the conditions that must be answered by an index out of a
+// filter. take is called for every condition at the top level and inside
+// $and, where each document has to satisfy it, and returns what is left of
+// the condition, nil if nothing is, and whether it claimed part of it. op
+// anywhere else in the filter is an error.
+func extract(filter map[string]interface{}, op Operator, take func(key string, value interface{}) (interface{}, bool, error)) (map[string]interface{}, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	rest := make(map[string]interface{}, len(filter))
+	for key, value := range filter {
+		if Operator(key) == OpAnd {
+			if clauses, ok := value.([]interface{}); ok {
+				restClauses := make([]interface{}, len(clauses))
+				for i, clause := range clauses {
+					sub, ok := clause.(map[string]interface{})
+					if !ok {
+						restClauses[i] = clause
+						continue
+					}
+					subRest, err := extract(sub, op, take)
+					if err != nil {
+						return nil, err
+					}
+					restClauses[i] = subRest
+				}
+				rest[key] = restClauses
+				continue
+			}
+		}
+
+		remaining, claimed, err := take(key, value)
+		if err != nil {
+			return nil, err
+		}
+		if claimed && remaining == nil {
+			continue
+		}
+		if containsOperator(remaining, op) {
+			return nil, fmt.Errorf("%s must be at the top level of a filter or inside $and", op)
+		}
+		rest[key] = remaining
+	}
+	return rest, nil
+}
+
+func containsOperator(value interface{}, op Operator) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if Operator(key) == op || containsOperator(item, op) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if containsOperator(item, op) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// metaFields maps the scores $meta can read to the hidden fields carrying
+// them.
+var metaFields = map[interface{}]string{
+	"textScore":         textScoreField,
+	"vectorSearchScore": vectorScoreField,
+}
+
+// compileMeta compiles {"$meta": "textScore"}, the relevance score of the
+// document in a pipeline that starts with a $text search, and {"$meta":
+// "vectorSearchScore"}, its similarity in one that starts with
+// $vectorSearch.
+func compileMeta(arg interface{}) (expression, error) {
+	field, ok := metaFields[arg]
+	if !ok {
+		return nil, errors.New(`supported values are "textScore" and "vectorSearchScore"`)
+	}
+	return func(doc, _ map[string]interface{}) interface{} {
+		return fieldValue(doc, field)
+	}, nil
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTextSearch(t *testing.T) {
+	tests := []struct {
+		name   string
+		search string
+		want   TextSearch
+	}{
+		{"terms", `{"$search": "red  apple"}`, TextSearch{Terms: []string{"red", "apple"}}},
+		{"phrase", `{"$search": "\"green tea\" cake"}`, TextSearch{Terms: []string{"cake"}, Phrases: []string{"green tea"}}},
+		{"phrase touching a term", `{"$search": "cake\"green tea\""}`, TextSearch{Terms: []string{"cake"}, Phrases: []string{"green tea"}}},
+		{"negated term and phrase", `{"$search": "tea -milk -\"iced tea\""}`, TextSearch{Terms: []string{"tea"}, Negated: []string{"milk", "iced tea"}}},
+		{"empty phrase", `{"$search": "\"\" tea"}`, TextSearch{Terms: []string{"tea"}}},
+		{"hyphen inside a word", `{"$search": "e-mail"}`, TextSearch{Terms: []string{"e-mail"}}},
+		{"language", `{"$search": "tea", "$language": "none"}`, TextSearch{Terms: []string{"tea"}, Language: "none"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTextSearch(decode(t, tt.search))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+
+	for _, raw := range []string{
+		`{}`,
+		`{"$search": 1}`,
+		`{"$search": "tea", "$language": 1}`,
+		`{"$search": "tea", "$caseSensitive": true}`,
+		`{"$search": "\"green tea"}`,
+		`{"$search": "-milk"}`,
+		`{"$search": "   "}`,
+	} {
+		if _, err := ParseTextSearch(decode(t, raw)); err == nil {
+			t.Errorf("ParseTextSearch(%s) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	search, rest, err := SplitText(decode(t, `{"$text": {"$search": "tea"}, "price": {"$lt": 5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if search == nil || !reflect.DeepEqual(search.Terms, []string{"tea"}) {
+		t.Errorf("search = %+v, want the terms [tea]", search)
+	}
+	if !reflect.DeepEqual(rest, decode(t, `{"price": {"$lt": 5}}`)) {
+		t.Errorf("rest = %v, want the price condition", rest)
+	}
+
+	search, rest, err = SplitText(decode(t, `{"$and": [{"$text": {"$search": "tea"}}, {"price": 1}]}`))
+	if err != nil || search == nil {
+		t.Fatalf("$text inside $and: %+v, %v", search, err)
+	}
+	if !reflect.DeepEqual(rest, decode(t, `{"$and": [{}, {"price": 1}]}`)) {
+		t.Errorf("rest = %v", rest)
+	}
+
+	search, _, err = SplitText(decode(t, `{"price": 1}`))
+	if err != nil || search != nil {
+		t.Errorf("filter without $text: %+v, %v", search, err)
+	}
+
+	for _, raw := range []string{
+		`{"$or": [{"$text": {"$search": "tea"}}, {"price": 1}]}`,
+		`{"$nor": [{"$text": {"$search": "tea"}}]}`,
+		`{"$and": [{"$text": {"$search": "tea"}}, {"$text": {"$search": "cake"}}]}`,
+		`{"$text": {"$search": "-tea"}}`,
+	} {
+		if _, _, err := SplitText(decode(t, raw)); err == nil {
+			t.Errorf("SplitText(%s) succeeded, want an error", raw)
+		}
+	}
+}
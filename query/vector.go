@@ -0,0 +1,133 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+)
+
+// vectorScoreField carries the similarity of a pipeline document from a
+// $vectorSearch stage to {"$meta": "vectorSearchScore"}. Like the text
+// score, it is removed from the results.
+const vectorScoreField = "$vectorSearchScore"
+
+// maxNumCandidates bounds how many candidates a vector search may explore.
+const maxNumCandidates = 10000
+
+// VectorSearch is a parsed $vectorSearch stage. It is answered by a vector
+// index on Path.
+type VectorSearch struct {
+	Path string
+	// Index names the vector index to use; it may be left empty when Path
+	// has a single one.
+	Index       string
+	QueryVector []float64
+	// Limit is the number of documents returned and NumCandidates how many
+	// the approximate search explores to find them.
+	Limit         int
+	NumCandidates int
+	// Filter restricts the documents that can be returned. It is applied
+	// while searching, so Limit documents are returned whenever enough of
+	// them match.
+	Filter map[string]interface{}
+	// Exact compares the query with every vector instead of searching the
+	// graph.
+	Exact bool
+}
+
+// compileVectorSearch compiles a $vectorSearch stage. Like $geoNear it has
+// to be the first stage: the caller runs the search and passes the
+// documents it found, best match first, to Run.
+func (p *Pipeline) compileVectorSearch(arg interface{}) (stage, error) {
+	if len(p.stages) > 0 {
+		return nil, errors.New("$vectorSearch is only allowed as the first stage")
+	}
+	spec, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("specification must be an object")
+	}
+
+	vs := &VectorSearch{}
+	for key, value := range spec {
+		var err error
+		switch key {
+		case "path":
+			if vs.Path, ok = value.(string); !ok || vs.Path == "" {
+				err = errors.New("must be a field name")
+			}
+		case "index":
+			if vs.Index, ok = value.(string); !ok {
+				err = errors.New("must be a string")
+			}
+		case "queryVector":
+			vs.QueryVector, err = queryVector(value)
+		case "limit":
+			if vs.Limit, err = count(value); err == nil && vs.Limit == 0 {
+				err = errors.New("must be positive")
+			}
+		case "numCandidates":
+			if vs.NumCandidates, err = count(value); err == nil && (vs.NumCandidates == 0 || vs.NumCandidates > maxNumCandidates) {
+				err = fmt.Errorf("must be between 1 and %d", maxNumCandidates)
+			}
+		case "filter":
+			vs.Filter, err = vectorFilter(value)
+		case "exact":
+			if vs.Exact, ok = value.(bool); !ok {
+				err = errors.New("must be a boolean")
+			}
+		default:
+			err = errors.New("unsupported option")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+
+	switch {
+	case vs.Path == "":
+		return nil, errors.New("path is required")
+	case vs.QueryVector == nil:
+		return nil, errors.New("queryVector is required")
+	case vs.Limit == 0:
+		return nil, errors.New("limit is required")
+	case vs.Exact && vs.NumCandidates != 0:
+		return nil, errors.New("numCandidates does not apply to an exact search")
+	case vs.NumCandidates == 0:
+		vs.NumCandidates = min(vs.Limit*10, maxNumCandidates)
+	}
+	if vs.NumCandidates < vs.Limit {
+		return nil, errors.New("numCandidates must be at least limit")
+	}
+	p.vector = vs
+
+	return func(input iterator, _ environment) iterator {
+		return input
+	}, nil
+}
+
+func queryVector(value interface{}) ([]float64, error) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, errors.New("must be a non-empty array of numbers")
+	}
+	v := make([]float64, len(items))
+	for i, item := range items {
+		if v[i], ok = item.(float64); !ok {
+			return nil, errors.New("must be a non-empty array of numbers")
+		}
+	}
+	return v, nil
+}
+
+func vectorFilter(value interface{}) (map[string]interface{}, error) {
+	filter, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("must be an object")
+	}
+	if err := NewQuery().Validate(filter); err != nil {
+		return nil, err
+	}
+	if containsOperator(filter, OpText) || containsOperator(filter, OpNear) {
+		return nil, errors.New("cannot use $text or $near")
+	}
+	return filter, nil
+}
@@ -0,0 +1,137 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/store"
+)
+
+var errResyncRequired = errors.New("peer no longer has the requested changes")
+
+type snapshotHeader struct {
+	Seq uint64 `json:"seq"`
+}
+
+type changesResponse struct {
+	Changes []store.Change `json:"changes"`
+	Seq     uint64         `json:"seq"`
+}
+
+// Bootstrap loads a full snapshot of a peer into the store and returns the
+// position in the peer's change log the snapshot was taken at.
+func (r *Replicator) Bootstrap(ds *store.DocumentStore, peer string) (uint64, error) {
+	req, err := r.newRequest(http.MethodGet, peer, "/replication/snapshot", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := r.streamClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to request snapshot: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return 0, fmt.Errorf("received non-OK status: %d", resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return 0, fmt.Errorf("failed to read snapshot header: %v", err)
+	}
+
+	chunks, documents := 0, 0
+	for {
+		var chunk store.SnapshotChunk
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, fmt.Errorf("failed to read snapshot chunk: %v", err)
+		}
+
+		if err := ds.ApplySnapshotChunk(chunk); err != nil {
+			return 0, fmt.Errorf("failed to apply snapshot chunk: %v", err)
+		}
+		chunks++
+		documents += len(chunk.Documents)
+	}
+
+	log.Printf("Bootstrapped from %s: %d document(s) in %d chunk(s) at seq %d", peer, documents, chunks, header.Seq)
+	return header.Seq, nil
+}
+
+// Tail follows a peer's change log from seq and applies every change to the
+// store. If the peer has already discarded the changes the node bootstraps
+// again.
+func (r *Replicator) Tail(ds *store.DocumentStore, peer string, seq uint64) {
+	go func() {
+		backoff := time.Second
+
+		for {
+			next, err := r.pollChanges(ds, peer, seq)
+			if err == errResyncRequired {
+				log.Printf("Fell behind %s, bootstrapping again", peer)
+				next, err = r.Bootstrap(ds, peer)
+			}
+			if err != nil {
+				log.Printf("Failed to tail %s: %v", peer, err)
+				time.Sleep(backoff)
+				if backoff < 30*time.Second {
+					backoff *= 2
+				}
+				continue
+			}
+
+			backoff = time.Second
+			seq = next
+		}
+	}()
+}
+
+func (r *Replicator) pollChanges(ds *store.DocumentStore, peer string, seq uint64) (uint64, error) {
+	path := "/replication/changes?since=" + strconv.FormatUint(seq, 10) + "&wait=5s"
+	req, err := r.newRequest(http.MethodGet, peer, path, nil)
+	if err != nil {
+		return seq, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return seq, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		io.Copy(io.Discard, resp.Body)
+		return seq, errResyncRequired
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return seq, fmt.Errorf("received non-OK status: %d", resp.StatusCode)
+	}
+
+	var changes changesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return seq, fmt.Errorf("failed to decode changes: %v", err)
+	}
+
+	for _, change := range changes.Changes {
+		if err := ds.ApplyChange(change); err != nil {
+			return seq, fmt.Errorf("failed to apply change %d: %v", change.Seq, err)
+		}
+		seq = change.Seq
+	}
+
+	return seq, nil
+}
@@ -0,0 +1,272 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hint is a mutation that could not be delivered to a peer and is kept
+// until the peer is reachable again.
+type Hint struct {
+	Peer      string                 `json:"peer"`
+	Payload   map[string]interface{} `json:"payload"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type HintMetrics struct {
+	Pending int `json:"pending"`
+	Dropped int `json:"dropped"`
+	// DeadLettered counts mutations the peer could not apply and that are
+	// not sent again.
+	DeadLettered int       `json:"dead_lettered"`
+	Oldest       time.Time `json:"oldest,omitempty"`
+}
+
+// HintStore keeps hints per peer in insertion order. When dir is set every
+// peer's hints are mirrored to a JSON Lines file so they survive restarts.
+type HintStore struct {
+	mu       sync.Mutex
+	dir      string
+	maxHints int
+	hints    map[string][]Hint
+	dropped  map[string]int
+	rejected map[string]int
+}
+
+func NewHintStore(dir string, maxHints int) (*HintStore, error) {
+	hs := &HintStore{
+		dir:      dir,
+		maxHints: maxHints,
+		hints:    make(map[string][]Hint),
+		dropped:  make(map[string]int),
+		rejected: make(map[string]int),
+	}
+
+	if dir == "" {
+		return hs, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create hint directory: %v", err)
+	}
+
+	if err := hs.load(); err != nil {
+		return nil, err
+	}
+
+	return hs, nil
+}
+
+func (hs *HintStore) Add(peer string, payload map[string]interface{}) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hint := Hint{
+		Peer:      peer,
+		Payload:   payload,
+		CreatedAt: time.Now().UTC(),
+	}
+	hs.hints[peer] = append(hs.hints[peer], hint)
+
+	if hs.maxHints > 0 && len(hs.hints[peer]) > hs.maxHints {
+		overflow := len(hs.hints[peer]) - hs.maxHints
+		hs.hints[peer] = hs.hints[peer][overflow:]
+		hs.dropped[peer] += overflow
+		log.Printf("Hint storage for %s is full, dropped %d oldest hint(s)", peer, overflow)
+		hs.persist(peer)
+		return
+	}
+
+	hs.appendToFile(peer, hint)
+}
+
+func (hs *HintStore) Pending(peer string) int {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	return len(hs.hints[peer])
+}
+
+// Peek returns up to n of the oldest hints for a peer without removing them.
+func (hs *HintStore) Peek(peer string, n int) []Hint {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hints := hs.hints[peer]
+	if n > len(hints) {
+		n = len(hints)
+	}
+	return append([]Hint(nil), hints[:n]...)
+}
+
+// Pop removes the n oldest hints for a peer once they have been delivered.
+// The hint file is only rewritten on Sync so a replay does not rewrite it
+// for every delivered batch.
+func (hs *HintStore) Pop(peer string, n int) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if n > len(hs.hints[peer]) {
+		n = len(hs.hints[peer])
+	}
+
+	hs.hints[peer] = hs.hints[peer][n:]
+	if len(hs.hints[peer]) == 0 {
+		delete(hs.hints, peer)
+	}
+}
+
+// DeadLetter counts n mutations a peer received but could not apply, which
+// are not sent again.
+func (hs *HintStore) DeadLetter(peer string, n int) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.rejected[peer] += n
+}
+
+func (hs *HintStore) Sync(peer string) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.persist(peer)
+}
+
+func (hs *HintStore) Metrics() map[string]HintMetrics {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	metrics := make(map[string]HintMetrics)
+	for peer, hints := range hs.hints {
+		m := metrics[peer]
+		m.Pending = len(hints)
+		if len(hints) > 0 {
+			m.Oldest = hints[0].CreatedAt
+		}
+		metrics[peer] = m
+	}
+	for peer, dropped := range hs.dropped {
+		m := metrics[peer]
+		m.Dropped = dropped
+		metrics[peer] = m
+	}
+	for peer, rejected := range hs.rejected {
+		m := metrics[peer]
+		m.DeadLettered = rejected
+		metrics[peer] = m
+	}
+
+	return metrics
+}
+
+// persistence
+
+func (hs *HintStore) fileFor(peer string) string {
+	name := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(peer)
+	return filepath.Join(hs.dir, name+".jsonl")
+}
+
+func (hs *HintStore) appendToFile(peer string, hint Hint) {
+	if hs.dir == "" {
+		return
+	}
+
+	f, err := os.OpenFile(hs.fileFor(peer), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("Failed to open hint file for %s: %v", peer, err)
+		return
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(hint); err != nil {
+		log.Printf("Failed to write hint for %s: %v", peer, err)
+	}
+}
+
+// persist rewrites the hint file of a peer with its current hints.
+func (hs *HintStore) persist(peer string) {
+	if hs.dir == "" {
+		return
+	}
+
+	path := hs.fileFor(peer)
+	if len(hs.hints[peer]) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove hint file for %s: %v", peer, err)
+		}
+		return
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Printf("Failed to create hint file for %s: %v", peer, err)
+		return
+	}
+
+	encoder := json.NewEncoder(f)
+	for _, hint := range hs.hints[peer] {
+		if err := encoder.Encode(hint); err != nil {
+			f.Close()
+			log.Printf("Failed to write hint for %s: %v", peer, err)
+			return
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		log.Printf("Failed to write hint file for %s: %v", peer, err)
+		return
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("Failed to replace hint file for %s: %v", peer, err)
+	}
+}
+
+func (hs *HintStore) load() error {
+	files, err := filepath.Glob(filepath.Join(hs.dir, "*.jsonl"))
+	if err != nil {
+		return fmt.Errorf("failed to list hint files: %v", err)
+	}
+
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open hint file: %v", err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var hint Hint
+			if err := json.Unmarshal(scanner.Bytes(), &hint); err != nil {
+				log.Printf("Skipping corrupt hint in %s: %v", path, err)
+				continue
+			}
+			hs.hints[hint.Peer] = append(hs.hints[hint.Peer], hint)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read hint file: %v", err)
+		}
+	}
+
+	for peer := range hs.hints {
+		if hs.maxHints > 0 && len(hs.hints[peer]) > hs.maxHints {
+			overflow := len(hs.hints[peer]) - hs.maxHints
+			hs.hints[peer] = hs.hints[peer][overflow:]
+			hs.dropped[peer] += overflow
+			hs.persist(peer)
+		}
+	}
+
+	return nil
+}
@@ -1,18 +1,68 @@
 package replication
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
-func Replicate(peers []string, projectID string, collection string, id string, doc map[string]interface{}) {
-	println("I was called")
+type Replicator struct {
+	peers  []string
+	hints  *HintStore
+	opts   Options
+	queues map[string]*peerQueue
+	client *http.Client
+	// streamClient has no overall timeout so large snapshots can finish.
+	streamClient *http.Client
 
+	mu        sync.Mutex
+	replaying map[string]bool
+}
+
+func NewReplicator(peers []string, hints *HintStore, opts Options) *Replicator {
+	defaults := DefaultOptions()
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaults.BatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaults.FlushInterval
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaults.QueueSize
+	}
+	if opts.EnqueueTimeout <= 0 {
+		opts.EnqueueTimeout = defaults.EnqueueTimeout
+	}
+
+	// Keep-alive connections are reused across batches, one pool per peer.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+	transport.TLSClientConfig = opts.TLS
+
+	r := &Replicator{
+		peers:        peers,
+		hints:        hints,
+		opts:         opts,
+		queues:       make(map[string]*peerQueue),
+		client:       &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		streamClient: &http.Client{Transport: transport},
+		replaying:    make(map[string]bool),
+	}
+
+	for _, peer := range peers {
+		q := newPeerQueue(peer, opts.QueueSize)
+		r.queues[peer] = q
+		go r.runQueue(q)
+	}
+
+	return r
+}
+
+func (r *Replicator) Replicate(projectID string, collection string, id string, doc map[string]interface{}) {
 	replicationData := map[string]interface{}{
 		"project":    projectID,
 		"collection": collection,
@@ -21,45 +71,173 @@ func Replicate(peers []string, projectID string, collection string, id string, d
 		"created_at": doc["created_at"],
 		"updated_at": doc["updated_at"],
 	}
+	if operation, ok := doc["operation"]; ok {
+		replicationData["operation"] = operation
+	}
+	if deletedAt, ok := doc["deleted_at"]; ok {
+		replicationData["deleted_at"] = deletedAt
+	}
 
-	for _, peer := range peers {
-		go func(url string) {
-			maxRetries := 3
+	r.broadcast(replicationData, projectID+"/"+collection+"/"+id)
+}
+
+// ReplicateLifecycle sends a project or collection lifecycle operation such
+// as create_collection or drop_project to every peer.
+func (r *Replicator) ReplicateLifecycle(operation, projectID, collectionID string, extra map[string]interface{}) {
+	replicationData := map[string]interface{}{
+		"operation":  operation,
+		"project":    projectID,
+		"collection": collectionID,
+	}
+	for key, value := range extra {
+		replicationData[key] = value
+	}
+
+	r.broadcast(replicationData, operation+" "+projectID+"/"+collectionID)
+}
+
+// broadcast queues a mutation for every peer. Every mutation goes through
+// the peer's queue, whose sender alone decides between sending and storing
+// hints, so hints never overtake mutations queued before them.
+func (r *Replicator) broadcast(replicationData map[string]interface{}, description string) {
+	for _, peer := range r.peers {
+		if !r.queues[peer].enqueue(replicationData, r.opts.EnqueueTimeout) {
+			log.Printf("Replication queue for %s was full, %s waited longer than %s", peer, description, r.opts.EnqueueTimeout)
+		}
+	}
+}
+
+// StartHealthChecks periodically probes every peer and replays its hints
+// as soon as it answers again.
+func (r *Replicator) StartHealthChecks(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 
-			for i := 0; i < maxRetries; i++ {
-				if err := replicateToPeer(url, replicationData); err != nil {
-					log.Printf("Failed to replicate to %s (attempt %d/%d): %v", url, i+1, maxRetries, err)
-					time.Sleep(time.Second * time.Duration(i+1))
+		for range ticker.C {
+			for _, peer := range r.peers {
+				if r.hints.Pending(peer) == 0 {
+					continue
+				}
+				if err := r.checkPeer(peer); err != nil {
 					continue
 				}
-				log.Printf("Successfully replicated to %s", url)
-				return
+				go r.replayHints(peer)
 			}
+		}
+	}()
+}
+
+func (r *Replicator) HintMetrics() map[string]HintMetrics {
+	return r.hints.Metrics()
+}
 
-		}(peer)
+func (r *Replicator) Metrics() map[string]PeerMetrics {
+	metrics := make(map[string]PeerMetrics, len(r.queues))
+	for peer, q := range r.queues {
+		m := q.metrics()
+		m.PendingHints = r.hints.Pending(peer)
+		metrics[peer] = m
 	}
+	return metrics
 }
 
-func replicateToPeer(peer string, data map[string]interface{}) error {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal data: %v", err)
+// replayHints delivers the hints of a peer oldest first in batches and stops
+// at the first failure so the remaining hints keep their order. Hints are
+// only removed once the peer has accepted them. Those the peer reports it
+// could not apply are dead-lettered.
+func (r *Replicator) replayHints(peer string) {
+	r.mu.Lock()
+	if r.replaying[peer] {
+		r.mu.Unlock()
+		return
+	}
+	r.replaying[peer] = true
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.replaying, peer)
+		r.mu.Unlock()
+	}()
+	defer r.hints.Sync(peer)
+
+	q := r.queues[peer]
+	if q == nil {
+		return
+	}
+
+	delivered := 0
+	for {
+		hints := r.hints.Peek(peer, r.opts.BatchSize)
+		if len(hints) == 0 {
+			break
+		}
+
+		batch := make([]map[string]interface{}, len(hints))
+		for i, hint := range hints {
+			batch[i] = hint.Payload
+		}
+
+		failures, err := r.sendBatch(q, batch)
+		if err != nil {
+			log.Printf("Failed to replay hints to %s: %v", peer, err)
+			r.alertRefused(q, err)
+			break
+		}
+		r.hints.Pop(peer, len(hints))
+		r.deadLetter(peer, batch, failures)
+		delivered += len(hints) - len(failures)
+	}
+
+	if delivered > 0 {
+		log.Printf("Replayed %d hint(s) to %s", delivered, peer)
+	}
+}
+
+// deadLetter logs and counts the mutations of a delivered batch that the
+// peer reported it could not apply. Resending them would fail the same way.
+func (r *Replicator) deadLetter(peer string, batch []map[string]interface{}, failures []BatchFailure) {
+	dead := 0
+	for _, failure := range failures {
+		if failure.Index < 0 || failure.Index >= len(batch) {
+			continue
+		}
+		log.Printf("Peer %s could not apply %s, dead-lettering it: %s", peer, describeMutation(batch[failure.Index]), failure.Error)
+		dead++
+	}
+	if dead > 0 {
+		r.hints.DeadLetter(peer, dead)
 	}
+}
+
+// describeMutation names what a replicated mutation changes for the log.
+func describeMutation(payload map[string]interface{}) string {
+	operation, _ := payload["operation"].(string)
+	if operation == "" {
+		operation = "upsert"
+	}
+	project, _ := payload["project"].(string)
+	collection, _ := payload["collection"].(string)
+	id, _ := payload["id"].(string)
+	return strings.TrimRight(operation+" "+project+"/"+collection+"/"+id, "/")
+}
 
-	resp, err := http.Post("http://"+peer+"/replicate", "application/json", bytes.NewBuffer(jsonData))
+func (r *Replicator) checkPeer(peer string) error {
+	req, err := r.newRequest(http.MethodGet, peer, "/health", nil)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	_, err = io.Copy(io.Discard, resp.Body)
+	resp, err := r.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %v", err)
+		return err
 	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("received non-OK status: %d", resp.StatusCode)
 	}
-
 	return nil
 }
@@ -0,0 +1,340 @@
+package replication
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/security"
+)
+
+// Options configures how mutations are batched and sent to peers.
+type Options struct {
+	// BatchSize is the maximum number of mutations sent in one request.
+	BatchSize int
+	// FlushInterval is how long a partial batch waits for more mutations.
+	FlushInterval time.Duration
+	// QueueSize bounds the mutations waiting per peer.
+	QueueSize int
+	// EnqueueTimeout is how long a write blocks on a full queue before the
+	// wait is logged as stalled. The write keeps waiting, so the peer still
+	// receives every mutation in order.
+	EnqueueTimeout time.Duration
+	// TLS, when set, is used to call peers over HTTPS with a client
+	// certificate.
+	TLS *tls.Config
+	// Secret, when set, signs every request sent to a peer.
+	Secret []byte
+}
+
+func DefaultOptions() Options {
+	return Options{
+		BatchSize:      500,
+		FlushInterval:  50 * time.Millisecond,
+		QueueSize:      10000,
+		EnqueueTimeout: time.Second,
+	}
+}
+
+type PeerMetrics struct {
+	Queued             int     `json:"queued"`
+	PendingHints       int     `json:"pending_hints"`
+	Sent               uint64  `json:"sent"`
+	Batches            uint64  `json:"batches"`
+	FailedBatches      uint64  `json:"failed_batches"`
+	RefusedBatches     uint64  `json:"refused_batches"`
+	Stalled            uint64  `json:"stalled"`
+	BytesRaw           uint64  `json:"bytes_raw"`
+	BytesCompressed    uint64  `json:"bytes_compressed"`
+	MutationsPerSecond float64 `json:"mutations_per_second"`
+}
+
+type batchRequest struct {
+	Mutations []map[string]interface{} `json:"mutations"`
+}
+
+// BatchResponse is the answer of a peer to a batch. Errors lists the
+// mutations the peer could not apply.
+type BatchResponse struct {
+	Applied int            `json:"applied"`
+	Errors  []BatchFailure `json:"errors"`
+}
+
+// BatchFailure is a mutation of a batch that a peer could not apply, by its
+// position in the batch.
+type BatchFailure struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// peerQueue feeds one peer from a bounded queue. A single sender goroutine
+// per peer keeps mutations in order.
+type peerQueue struct {
+	peer  string
+	queue chan map[string]interface{}
+
+	sent            uint64
+	batches         uint64
+	failedBatches   uint64
+	refused         uint64
+	stalled         uint64
+	bytesRaw        uint64
+	bytesCompressed uint64
+
+	rateMu sync.Mutex
+	rate   float64
+}
+
+func newPeerQueue(peer string, size int) *peerQueue {
+	return &peerQueue{
+		peer:  peer,
+		queue: make(chan map[string]interface{}, size),
+	}
+}
+
+// enqueue blocks while the peer's queue is full, which slows writers down
+// when a peer falls behind. It reports false if the wait outlasted the
+// timeout. The queue always drains: a batch that cannot be delivered is
+// stored as hints, after which the rest of the queue is too.
+func (q *peerQueue) enqueue(mutation map[string]interface{}, timeout time.Duration) bool {
+	select {
+	case q.queue <- mutation:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case q.queue <- mutation:
+		return true
+	case <-timer.C:
+		atomic.AddUint64(&q.stalled, 1)
+	}
+	q.queue <- mutation
+	return false
+}
+
+func (r *Replicator) runQueue(q *peerQueue) {
+	flush := time.NewTicker(r.opts.FlushInterval)
+	defer flush.Stop()
+	rate := time.NewTicker(time.Second)
+	defer rate.Stop()
+
+	batch := make([]map[string]interface{}, 0, r.opts.BatchSize)
+	lastSent := uint64(0)
+
+	for {
+		select {
+		case mutation := <-q.queue:
+			batch = append(batch, mutation)
+			if len(batch) < r.opts.BatchSize {
+				continue
+			}
+		case <-flush.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-rate.C:
+			sent := atomic.LoadUint64(&q.sent)
+			q.rateMu.Lock()
+			q.rate = float64(sent - lastSent)
+			q.rateMu.Unlock()
+			lastSent = sent
+			continue
+		}
+
+		r.deliver(q, batch)
+		batch = make([]map[string]interface{}, 0, r.opts.BatchSize)
+	}
+}
+
+// deliver sends a batch with retries. Batches that cannot be delivered, and
+// batches for a peer that still has hints pending, are stored as hints.
+func (r *Replicator) deliver(q *peerQueue, batch []map[string]interface{}) {
+	if r.hints.Pending(q.peer) == 0 {
+		maxRetries := 3
+
+		for i := 0; i < maxRetries; i++ {
+			failures, err := r.sendBatch(q, batch)
+			if err != nil {
+				atomic.AddUint64(&q.failedBatches, 1)
+				log.Printf("Failed to replicate %d mutation(s) to %s (attempt %d/%d): %v", len(batch), q.peer, i+1, maxRetries, err)
+				r.alertRefused(q, err)
+				time.Sleep(time.Second * time.Duration(i+1))
+				continue
+			}
+			r.deadLetter(q.peer, batch, failures)
+			return
+		}
+
+		log.Printf("Peer %s unreachable, storing %d hint(s)", q.peer, len(batch))
+	}
+
+	for _, mutation := range batch {
+		r.hints.Add(q.peer, mutation)
+	}
+	go r.replayHints(q.peer)
+}
+
+// sendBatch posts a batch to the peer and returns the mutations the peer
+// accepted the batch with but could not apply.
+func (r *Replicator) sendBatch(q *peerQueue, batch []map[string]interface{}) ([]BatchFailure, error) {
+	raw, err := json.Marshal(batchRequest{Mutations: batch})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal batch: %v", err)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(raw); err != nil {
+		return nil, fmt.Errorf("failed to compress batch: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress batch: %v", err)
+	}
+	size := compressed.Len()
+
+	req, err := r.newRequest(http.MethodPost, q.peer, "/replicate/batch", compressed.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &statusError{status: resp.StatusCode}
+	}
+
+	var result BatchResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		log.Printf("Could not read the batch response of %s, assuming every mutation was applied: %v", q.peer, err)
+	}
+
+	atomic.AddUint64(&q.sent, uint64(len(batch)))
+	atomic.AddUint64(&q.batches, 1)
+	atomic.AddUint64(&q.bytesRaw, uint64(len(raw)))
+	atomic.AddUint64(&q.bytesCompressed, uint64(size))
+	return result.Errors, nil
+}
+
+// statusError is a response from a peer outside the 2xx range.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("received non-OK status: %d", e.status)
+}
+
+// refused reports whether a peer turned a request down because of how the
+// cluster is set up rather than what was sent: a signature it cannot verify
+// after a secret rotation or with skewed clocks, a certificate it does not
+// accept, or an endpoint it does not serve yet. Such requests go through
+// once the setup is fixed, so they are retried and kept as hints like any
+// other failure.
+func refused(err error) bool {
+	var status *statusError
+	if !errors.As(err, &status) {
+		return false
+	}
+	switch status.status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed:
+		return true
+	default:
+		return false
+	}
+}
+
+// alertRefused counts and logs a request the peer refused so the
+// misconfiguration is noticed while its mutations wait as hints.
+func (r *Replicator) alertRefused(q *peerQueue, err error) {
+	if !refused(err) {
+		return
+	}
+	atomic.AddUint64(&q.refused, 1)
+	log.Printf("ALERT: peer %s refused replication (%v); check CLUSTER_SECRET, clock skew, peer certificates and that the peer serves /replicate/batch. Its mutations are kept as hints.", q.peer, err)
+}
+
+func (q *peerQueue) metrics() PeerMetrics {
+	q.rateMu.Lock()
+	rate := q.rate
+	q.rateMu.Unlock()
+
+	return PeerMetrics{
+		Queued:             len(q.queue),
+		Sent:               atomic.LoadUint64(&q.sent),
+		Batches:            atomic.LoadUint64(&q.batches),
+		FailedBatches:      atomic.LoadUint64(&q.failedBatches),
+		RefusedBatches:     atomic.LoadUint64(&q.refused),
+		Stalled:            atomic.LoadUint64(&q.stalled),
+		BytesRaw:           atomic.LoadUint64(&q.bytesRaw),
+		BytesCompressed:    atomic.LoadUint64(&q.bytesCompressed),
+		MutationsPerSecond: rate,
+	}
+}
+
+// newRequest builds a request to a peer, using HTTPS when TLS is configured
+// and signing it when a cluster secret is set.
+func (r *Replicator) newRequest(method, peer, path string, body []byte) (*http.Request, error) {
+	scheme := "http"
+	if r.opts.TLS != nil {
+		scheme = "https"
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, scheme+"://"+peer+path, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.opts.Secret) > 0 {
+		security.SignRequest(req, r.opts.Secret, body)
+	}
+	return req, nil
+}
+
+// DecodeBatch reads a batch request body, decompressing it when the sender
+// used gzip.
+func DecodeBatch(r *http.Request) ([]map[string]interface{}, error) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress batch: %v", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var batch batchRequest
+	if err := json.NewDecoder(body).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("failed to decode batch: %v", err)
+	}
+	return batch.Mutations, nil
+}
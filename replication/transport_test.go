@@ -0,0 +1,114 @@
+package replication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestPeer answers batches with handle and returns the peer's address.
+func newTestPeer(t *testing.T, handle func(w http.ResponseWriter, mutations []map[string]interface{})) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutations, err := DecodeBatch(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handle(w, mutations)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func newTestReplicator(t *testing.T, peer string) *Replicator {
+	t.Helper()
+	hints, err := NewHintStore("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewReplicator([]string{peer}, hints, Options{FlushInterval: time.Hour})
+}
+
+func mutations(ids ...string) []map[string]interface{} {
+	batch := make([]map[string]interface{}, len(ids))
+	for i, id := range ids {
+		batch[i] = map[string]interface{}{"project": "p", "collection": "c", "id": id}
+	}
+	return batch
+}
+
+func TestDeliverDeadLettersReportedMutations(t *testing.T) {
+	peer := newTestPeer(t, func(w http.ResponseWriter, mutations []map[string]interface{}) {
+		json.NewEncoder(w).Encode(BatchResponse{
+			Applied: len(mutations) - 1,
+			Errors:  []BatchFailure{{Index: 1, Error: "Missing document data"}},
+		})
+	})
+	r := newTestReplicator(t, peer)
+
+	r.deliver(r.queues[peer], mutations("a", "b", "c"))
+
+	m := r.HintMetrics()[peer]
+	if m.Pending != 0 || m.DeadLettered != 1 {
+		t.Errorf("hint metrics = %+v, want no hints and one dead letter", m)
+	}
+	if sent := r.Metrics()[peer].Sent; sent != 3 {
+		t.Errorf("sent = %d, want 3", sent)
+	}
+}
+
+func TestReplayKeepsHintsThePeerRefuses(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusUnauthorized
+	var received []string
+	peer := newTestPeer(t, func(w http.ResponseWriter, mutations []map[string]interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if status != http.StatusOK {
+			http.Error(w, "invalid signature", status)
+			return
+		}
+		for _, mutation := range mutations {
+			received = append(received, mutation["id"].(string))
+		}
+		json.NewEncoder(w).Encode(BatchResponse{
+			Applied: len(mutations) - 1,
+			Errors:  []BatchFailure{{Index: 0, Error: "Missing document data"}},
+		})
+	})
+	r := newTestReplicator(t, peer)
+	for _, mutation := range mutations("a", "b", "c") {
+		r.hints.Add(peer, mutation)
+	}
+
+	for _, refusal := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusBadRequest, http.StatusInternalServerError} {
+		mu.Lock()
+		status = refusal
+		mu.Unlock()
+		r.replayHints(peer)
+		if m := r.HintMetrics()[peer]; m.Pending != 3 || m.DeadLettered != 0 {
+			t.Fatalf("after a %d, hint metrics = %+v, want every hint kept", refusal, m)
+		}
+	}
+	if refused := r.Metrics()[peer].RefusedBatches; refused != 3 {
+		t.Errorf("refused batches = %d, want 3", refused)
+	}
+
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	r.replayHints(peer)
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(received, ",") != "a,b,c" {
+		t.Errorf("peer received %v, want a, b and c in order", received)
+	}
+	if m := r.HintMetrics()[peer]; m.Pending != 0 || m.DeadLettered != 1 {
+		t.Errorf("hint metrics = %+v, want no hints and one dead letter", m)
+	}
+}
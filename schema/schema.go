@@ -0,0 +1,292 @@
+// Package schema validates documents against a subset of JSON Schema draft
+// 2020-12: type, enum, const, required, properties, patternProperties,
+// additionalProperties, items, prefixItems, the length, size and range
+// keywords, pattern, multipleOf and the allOf, anyOf, oneOf and not
+// combinators. Annotations such as title or format are accepted and
+// ignored; keywords that would change validation but are not supported,
+// such as $ref, are rejected when the schema is compiled.
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+var validTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+var unsupportedKeywords = []string{
+	"$ref", "$dynamicRef", "if", "then", "else", "dependentSchemas",
+	"dependentRequired", "propertyNames", "contains", "unevaluatedItems",
+	"unevaluatedProperties",
+}
+
+// Schema is a compiled schema. The zero value accepts every document.
+type Schema struct {
+	alwaysFalse bool
+
+	types    []string
+	enum     []interface{}
+	constant *interface{}
+
+	required             []string
+	properties           map[string]*Schema
+	patternProperties    []patternSchema
+	additionalProperties *Schema
+	minProperties        *int
+	maxProperties        *int
+
+	items       *Schema
+	prefixItems []*Schema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+}
+
+type patternSchema struct {
+	pattern *regexp.Regexp
+	schema  *Schema
+}
+
+// Compile parses a schema from its decoded JSON form.
+func Compile(definition interface{}) (*Schema, error) {
+	return compile(definition, "")
+}
+
+func compile(definition interface{}, path string) (*Schema, error) {
+	switch def := definition.(type) {
+	case bool:
+		return &Schema{alwaysFalse: !def}, nil
+	case map[string]interface{}:
+		return compileObject(def, path)
+	}
+	return nil, fmt.Errorf("schema at %q must be an object or a boolean", pointer(path))
+}
+
+func compileObject(def map[string]interface{}, path string) (*Schema, error) {
+	for _, keyword := range unsupportedKeywords {
+		if _, exists := def[keyword]; exists {
+			return nil, fmt.Errorf("unsupported keyword %q at %q", keyword, pointer(path))
+		}
+	}
+
+	s := &Schema{}
+	var err error
+
+	if value, exists := def["type"]; exists {
+		if s.types, err = stringList(value); err != nil {
+			return nil, keywordError(path, "type", err)
+		}
+		for _, t := range s.types {
+			if !validTypes[t] {
+				return nil, keywordError(path, "type", fmt.Errorf("unknown type %q", t))
+			}
+		}
+	}
+
+	if value, exists := def["enum"]; exists {
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, keywordError(path, "enum", fmt.Errorf("must be an array"))
+		}
+		s.enum = list
+	}
+	if value, exists := def["const"]; exists {
+		s.constant = &value
+	}
+
+	if value, exists := def["required"]; exists {
+		if s.required, err = stringList(value); err != nil {
+			return nil, keywordError(path, "required", err)
+		}
+	}
+
+	if value, exists := def["properties"]; exists {
+		properties, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, keywordError(path, "properties", fmt.Errorf("must be an object"))
+		}
+		s.properties = make(map[string]*Schema, len(properties))
+		for name, sub := range properties {
+			if s.properties[name], err = compile(sub, path+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if value, exists := def["patternProperties"]; exists {
+		patterns, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, keywordError(path, "patternProperties", fmt.Errorf("must be an object"))
+		}
+		keys := make([]string, 0, len(patterns))
+		for key := range patterns {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			re, err := regexp.Compile(key)
+			if err != nil {
+				return nil, keywordError(path, "patternProperties", err)
+			}
+			sub, err := compile(patterns[key], path+"/patternProperties/"+key)
+			if err != nil {
+				return nil, err
+			}
+			s.patternProperties = append(s.patternProperties, patternSchema{pattern: re, schema: sub})
+		}
+	}
+
+	if value, exists := def["additionalProperties"]; exists {
+		if s.additionalProperties, err = compile(value, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+
+	if value, exists := def["items"]; exists {
+		if s.items, err = compile(value, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	if value, exists := def["prefixItems"]; exists {
+		if s.prefixItems, err = compileList(value, path, "prefixItems"); err != nil {
+			return nil, err
+		}
+	}
+	if value, exists := def["uniqueItems"]; exists {
+		unique, ok := value.(bool)
+		if !ok {
+			return nil, keywordError(path, "uniqueItems", fmt.Errorf("must be a boolean"))
+		}
+		s.uniqueItems = unique
+	}
+
+	counts := map[string]**int{
+		"minProperties": &s.minProperties,
+		"maxProperties": &s.maxProperties,
+		"minItems":      &s.minItems,
+		"maxItems":      &s.maxItems,
+		"minLength":     &s.minLength,
+		"maxLength":     &s.maxLength,
+	}
+	for keyword, target := range counts {
+		if value, exists := def[keyword]; exists {
+			n, ok := value.(float64)
+			if !ok || n < 0 || n != float64(int(n)) {
+				return nil, keywordError(path, keyword, fmt.Errorf("must be a non-negative integer"))
+			}
+			count := int(n)
+			*target = &count
+		}
+	}
+
+	numbers := map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf":       &s.multipleOf,
+	}
+	for keyword, target := range numbers {
+		if value, exists := def[keyword]; exists {
+			n, ok := value.(float64)
+			if !ok {
+				return nil, keywordError(path, keyword, fmt.Errorf("must be a number"))
+			}
+			*target = &n
+		}
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return nil, keywordError(path, "multipleOf", fmt.Errorf("must be greater than 0"))
+	}
+
+	if value, exists := def["pattern"]; exists {
+		pattern, ok := value.(string)
+		if !ok {
+			return nil, keywordError(path, "pattern", fmt.Errorf("must be a string"))
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, keywordError(path, "pattern", err)
+		}
+	}
+
+	combinators := map[string]*[]*Schema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf}
+	for keyword, target := range combinators {
+		if value, exists := def[keyword]; exists {
+			if *target, err = compileList(value, path, keyword); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if value, exists := def["not"]; exists {
+		if s.not, err = compile(value, path+"/not"); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func compileList(value interface{}, path, keyword string) ([]*Schema, error) {
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, keywordError(path, keyword, fmt.Errorf("must be a non-empty array"))
+	}
+
+	schemas := make([]*Schema, len(list))
+	for i, item := range list {
+		sub, err := compile(item, fmt.Sprintf("%s/%s/%d", path, keyword, i))
+		if err != nil {
+			return nil, err
+		}
+		schemas[i] = sub
+	}
+	return schemas, nil
+}
+
+func stringList(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("must contain only strings")
+			}
+			list[i] = s
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("must be a string or an array of strings")
+}
+
+func keywordError(path, keyword string, err error) error {
+	return fmt.Errorf("invalid %q at %q: %v", keyword, pointer(path), err)
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,299 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is one violation. Path is a JSON Pointer to the offending value.
+type Error struct {
+	Path    string `json:"path"`
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a document does not conform.
+type ValidationError struct {
+	Errors []Error `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("document does not match the collection schema: %s: %s", pointer(e.Errors[0].Path), e.Errors[0].Message)
+	}
+	return fmt.Sprintf("document does not match the collection schema: %d violations", len(e.Errors))
+}
+
+// Validate returns every violation of the schema by value, or nil if it
+// conforms.
+func (s *Schema) Validate(value interface{}) []Error {
+	var errs []Error
+	s.validate(value, "", &errs)
+	return errs
+}
+
+// Valid reports whether value conforms without collecting the violations.
+func (s *Schema) Valid(value interface{}) bool {
+	return len(s.Validate(value)) == 0
+}
+
+func (s *Schema) validate(value interface{}, path string, errs *[]Error) {
+	if s == nil {
+		return
+	}
+	if s.alwaysFalse {
+		addError(errs, path, "false", "no value is allowed here")
+		return
+	}
+
+	if len(s.types) > 0 && !s.matchesType(value) {
+		addError(errs, path, "type", fmt.Sprintf("expected %s, got %s", strings.Join(s.types, " or "), typeOf(value)))
+		return
+	}
+
+	if s.enum != nil && !containsEqual(s.enum, value) {
+		addError(errs, path, "enum", "value is not one of the allowed values")
+	}
+	if s.constant != nil && !equal(*s.constant, value) {
+		addError(errs, path, "const", "value does not equal the constant")
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(v, path, errs)
+	case []interface{}:
+		s.validateArray(v, path, errs)
+	case string:
+		s.validateString(v, path, errs)
+	default:
+		if n, ok := toFloat(value); ok {
+			s.validateNumber(n, path, errs)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(value, path, errs)
+	}
+	if s.anyOf != nil {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.Valid(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			addError(errs, path, "anyOf", "value matches none of the schemas")
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.Valid(value) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			addError(errs, path, "oneOf", fmt.Sprintf("value matches %d of the schemas instead of exactly one", matched))
+		}
+	}
+	if s.not != nil && s.not.Valid(value) {
+		addError(errs, path, "not", "value matches a schema it must not match")
+	}
+}
+
+func (s *Schema) validateObject(object map[string]interface{}, path string, errs *[]Error) {
+	for _, name := range s.required {
+		if _, exists := object[name]; !exists {
+			addError(errs, path+"/"+escape(name), "required", "missing required property")
+		}
+	}
+
+	if s.minProperties != nil && len(object) < *s.minProperties {
+		addError(errs, path, "minProperties", fmt.Sprintf("expected at least %d properties", *s.minProperties))
+	}
+	if s.maxProperties != nil && len(object) > *s.maxProperties {
+		addError(errs, path, "maxProperties", fmt.Sprintf("expected at most %d properties", *s.maxProperties))
+	}
+
+	// Walk the properties in a fixed order so errors are reported the
+	// same way every time.
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := object[name]
+		childPath := path + "/" + escape(name)
+		evaluated := false
+
+		if sub, exists := s.properties[name]; exists {
+			sub.validate(value, childPath, errs)
+			evaluated = true
+		}
+		for _, ps := range s.patternProperties {
+			if ps.pattern.MatchString(name) {
+				ps.schema.validate(value, childPath, errs)
+				evaluated = true
+			}
+		}
+		if !evaluated && s.additionalProperties != nil {
+			if s.additionalProperties.alwaysFalse {
+				addError(errs, childPath, "additionalProperties", "property is not allowed")
+			} else {
+				s.additionalProperties.validate(value, childPath, errs)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateArray(array []interface{}, path string, errs *[]Error) {
+	if s.minItems != nil && len(array) < *s.minItems {
+		addError(errs, path, "minItems", fmt.Sprintf("expected at least %d items", *s.minItems))
+	}
+	if s.maxItems != nil && len(array) > *s.maxItems {
+		addError(errs, path, "maxItems", fmt.Sprintf("expected at most %d items", *s.maxItems))
+	}
+
+	for i, item := range array {
+		itemPath := path + "/" + strconv.Itoa(i)
+		if i < len(s.prefixItems) {
+			s.prefixItems[i].validate(item, itemPath, errs)
+		} else if s.items != nil {
+			s.items.validate(item, itemPath, errs)
+		}
+	}
+
+	if s.uniqueItems {
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if equal(array[i], array[j]) {
+					addError(errs, path, "uniqueItems", fmt.Sprintf("items %d and %d are equal", i, j))
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Schema) validateString(value, path string, errs *[]Error) {
+	length := utf8.RuneCountInString(value)
+	if s.minLength != nil && length < *s.minLength {
+		addError(errs, path, "minLength", fmt.Sprintf("expected at least %d characters", *s.minLength))
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		addError(errs, path, "maxLength", fmt.Sprintf("expected at most %d characters", *s.maxLength))
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		addError(errs, path, "pattern", fmt.Sprintf("does not match pattern %q", s.pattern.String()))
+	}
+}
+
+func (s *Schema) validateNumber(value float64, path string, errs *[]Error) {
+	if s.minimum != nil && value < *s.minimum {
+		addError(errs, path, "minimum", fmt.Sprintf("must be at least %v", *s.minimum))
+	}
+	if s.maximum != nil && value > *s.maximum {
+		addError(errs, path, "maximum", fmt.Sprintf("must be at most %v", *s.maximum))
+	}
+	if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+		addError(errs, path, "exclusiveMinimum", fmt.Sprintf("must be greater than %v", *s.exclusiveMinimum))
+	}
+	if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+		addError(errs, path, "exclusiveMaximum", fmt.Sprintf("must be less than %v", *s.exclusiveMaximum))
+	}
+	if s.multipleOf != nil {
+		quotient := value / *s.multipleOf
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			addError(errs, path, "multipleOf", fmt.Sprintf("must be a multiple of %v", *s.multipleOf))
+		}
+	}
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	actual := typeOf(value)
+	for _, t := range s.types {
+		if t == actual {
+			return true
+		}
+		if t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value. Numbers with
+// no fractional part are integers.
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if n, ok := toFloat(value); ok {
+		if n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// equal compares JSON values, treating numbers of different Go types as
+// equal when their values are.
+func equal(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func containsEqual(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if equal(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func addError(errs *[]Error, path, keyword, message string) {
+	*errs = append(*errs, Error{Path: pointer(path), Keyword: keyword, Message: message})
+}
+
+// escape encodes a property name as a JSON Pointer reference token.
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,192 @@
+package security
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var secret = []byte("cluster secret")
+
+// writeNode issues a certificate for 127.0.0.1 and writes it, its key and
+// the CA certificate to files the TLS configs are loaded from.
+func writeNode(t *testing.T, ca *CA, name string) (certFile, keyFile, caFile string) {
+	t.Helper()
+	certPEM, keyPEM, err := ca.Issue(name, []string{"localhost", "127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	caFile = filepath.Join(dir, "ca.pem")
+	for path, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM, caFile: ca.CertPEM()} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile, caFile
+}
+
+func newCA(t *testing.T, name string) *CA {
+	t.Helper()
+	ca, err := NewLocalCA(name, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// newPeerServer starts an HTTPS server that requires a client certificate
+// from ca and a signature with the cluster secret, and echoes the body.
+func newPeerServer(t *testing.T, ca *CA) *httptest.Server {
+	t.Helper()
+	serverTLS, err := ServerTLSConfig(writeNode(t, ca, "server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverTLS.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("ClientAuth = %v, want RequireAndVerifyClientCert", serverTLS.ClientAuth)
+	}
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	server := httptest.NewUnstartedServer(RequireSignature(secret, time.Minute)(echo))
+	server.TLS = serverTLS
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newPeerClient(t *testing.T, ca *CA, name string) *http.Client {
+	t.Helper()
+	clientTLS, err := ClientTLSConfig(writeNode(t, ca, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+}
+
+func TestRequireSignature(t *testing.T) {
+	ca := newCA(t, "cluster CA")
+	server := newPeerServer(t, ca)
+	client := newPeerClient(t, ca, "client")
+
+	body := []byte(`{"mutations": []}`)
+	tests := []struct {
+		name    string
+		prepare func(req *http.Request)
+		send    []byte
+		want    int
+	}{
+		{"signed", func(req *http.Request) { SignRequest(req, secret, body) }, body, http.StatusOK},
+		{"unsigned", func(req *http.Request) {}, body, http.StatusUnauthorized},
+		{"wrong secret", func(req *http.Request) { SignRequest(req, []byte("other secret"), body) }, body, http.StatusUnauthorized},
+		{"body tampered", func(req *http.Request) { SignRequest(req, secret, body) }, []byte(`{"mutations": [{}]}`), http.StatusUnauthorized},
+		{"clock skewed into the past", func(req *http.Request) { signAt(req, body, time.Now().Add(-2*time.Minute)) }, body, http.StatusUnauthorized},
+		{"clock skewed into the future", func(req *http.Request) { signAt(req, body, time.Now().Add(2*time.Minute)) }, body, http.StatusUnauthorized},
+		{"skew within the window", func(req *http.Request) { signAt(req, body, time.Now().Add(-30*time.Second)) }, body, http.StatusOK},
+		{"invalid timestamp", func(req *http.Request) {
+			SignRequest(req, secret, body)
+			req.Header.Set(TimestampHeader, "yesterday")
+		}, body, http.StatusUnauthorized},
+		{"path changed", func(req *http.Request) {
+			SignRequest(req, secret, body)
+			req.URL.Path = "/replicate/other"
+		}, body, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/replicate/batch", bytes.NewReader(tt.send))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.prepare(req)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.want, got)
+			}
+			if tt.want == http.StatusOK && !bytes.Equal(got, tt.send) {
+				t.Errorf("handler read %q, want the verified body %q", got, tt.send)
+			}
+		})
+	}
+}
+
+// signAt signs a request as if the sender's clock read at.
+func signAt(req *http.Request, body []byte, at time.Time) {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(secret, req.Method, req.URL.RequestURI(), timestamp, body))
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCA(t, "cluster CA")
+	server := newPeerServer(t, ca)
+
+	send := func(client *http.Client) error {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/health", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SignRequest(req, secret, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		return nil
+	}
+
+	if err := send(newPeerClient(t, ca, "peer")); err != nil {
+		t.Errorf("peer with a certificate from the cluster CA was refused: %v", err)
+	}
+
+	// A certificate from another CA is refused by the server, and the
+	// client does not trust the server either.
+	if err := send(newPeerClient(t, newCA(t, "other CA"), "intruder")); err == nil {
+		t.Error("peer with a certificate from another CA was accepted")
+	}
+
+	// A client that trusts the cluster CA but presents a certificate from
+	// another CA is refused in the handshake.
+	trusting, err := ClientTLSConfig(writeNode(t, ca, "peer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreignCert, foreignKey, _ := writeNode(t, newCA(t, "other CA"), "intruder")
+	cert, err := tls.LoadX509KeyPair(foreignCert, foreignKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusting.Certificates = []tls.Certificate{cert}
+	if err := send(&http.Client{Transport: &http.Transport{TLSClientConfig: trusting}}); err == nil {
+		t.Error("client certificate signed by another CA was accepted")
+	}
+
+	// A client without a certificate is refused.
+	anonymous := trusting.Clone()
+	anonymous.Certificates = nil
+	if err := send(&http.Client{Transport: &http.Transport{TLSClientConfig: anonymous}}); err == nil {
+		t.Error("client without a certificate was accepted")
+	}
+}
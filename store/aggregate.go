@@ -0,0 +1,179 @@
+package store
+
+import (
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// ScopeFunc returns the caller's scope on a collection of the project an
+// operation runs in. A nil ScopeFunc is unrestricted.
+type ScopeFunc func(collectionID string) *Scope
+
+// AggregateAs runs a pipeline over the documents of a collection. Each
+// document enters the pipeline as its data with the document ID under _id,
+// unless the data has its own _id. Collections joined by $lookup are read
+// from the same project under the same lock, so the pipeline sees one
+// consistent state of the store, and each of them is filtered by the
+// caller's scope on it. A $text search in the first $match stage is answered
+// by the collection's text index, a $geoNear stage by a 2dsphere index and
+// a $vectorSearch stage by a vector index.
+func (ds *DocumentStore) AggregateAs(scopes ScopeFunc, projectID, collectionID string, pipeline *query.Pipeline) ([]map[string]interface{}, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bind every security filter up front so a caller missing an attribute
+	// gets an error rather than an empty join.
+	filters := make(map[string]map[string]interface{})
+	for _, id := range append([]string{collectionID}, pipeline.Collections()...) {
+		if _, done := filters[id]; done {
+			continue
+		}
+		joined, err := ds.collection(projectID, id)
+		if err == ErrCollectionNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filters[id], err = joined.securityFilter(scopes.scope(id)); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC()
+	cache := make(map[string][]map[string]interface{})
+	var documents []map[string]interface{}
+	var scores []float64
+	if search := pipeline.TextSearch(); search != nil {
+		documents, scores, err = ds.searchInput(collection, filters[collectionID], search, now)
+	} else if near := pipeline.GeoNear(); near != nil {
+		documents, err = ds.geoNearInput(collection, filters[collectionID], near, now)
+	} else if search := pipeline.VectorSearch(); search != nil {
+		documents, scores, err = ds.vectorSearchInput(collection, filters[collectionID], search, now)
+	} else {
+		documents, err = ds.pipelineInput(collection, filters[collectionID], now)
+		cache[collectionID] = documents
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceErr error
+	source := func(id string) []map[string]interface{} {
+		if docs, cached := cache[id]; cached {
+			return docs
+		}
+		joined, err := ds.collection(projectID, id)
+		if err != nil {
+			return nil
+		}
+		docs, err := ds.pipelineInput(joined, filters[id], now)
+		if err != nil && sourceErr == nil {
+			sourceErr = err
+		}
+		cache[id] = docs
+		return docs
+	}
+
+	results := pipeline.Run(documents, scores, source)
+	if sourceErr != nil {
+		return nil, sourceErr
+	}
+	return results, nil
+}
+
+func (scopes ScopeFunc) scope(collectionID string) *Scope {
+	if scopes == nil {
+		return nil
+	}
+	return scopes(collectionID)
+}
+
+// pipelineInput returns the documents of a collection that are live and
+// match the filter, in insertion order, or the samples of a time-series
+// collection series by series. The caller must hold the lock.
+func (ds *DocumentStore) pipelineInput(collection *Collection, filter map[string]interface{}, now time.Time) ([]map[string]interface{}, error) {
+	if collection.Settings.TimeSeries != nil {
+		return ds.samples(collection, SampleRange{}, filter, now)
+	}
+	compiled, err := ds.compile(filter)
+	if err != nil {
+		return nil, err
+	}
+	documents := make([]map[string]interface{}, 0, len(collection.Documents))
+	for _, doc := range collection.ordered() {
+		if collection.expired(doc, now) || !compiled.Matches(doc.Data) {
+			continue
+		}
+		documents = append(documents, pipelineDocument(doc))
+	}
+	return documents, nil
+}
+
+// searchInput returns the documents a text search finds among those that
+// are live and match the filter, best match first, with their scores. The
+// caller must hold the lock.
+func (ds *DocumentStore) searchInput(collection *Collection, filter map[string]interface{}, search *query.TextSearch, now time.Time) ([]map[string]interface{}, []float64, error) {
+	found, err := collection.textSearch(search)
+	if err != nil {
+		return nil, nil, err
+	}
+	compiled, err := ds.compile(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	candidates := collection.byScore(found)
+	documents := make([]map[string]interface{}, 0, len(candidates))
+	scores := make([]float64, 0, len(candidates))
+	for _, doc := range candidates {
+		if collection.expired(doc, now) || !compiled.Matches(doc.Data) {
+			continue
+		}
+		documents = append(documents, pipelineDocument(doc))
+		scores = append(scores, found[doc.ID])
+	}
+	return documents, scores, nil
+}
+
+// geoNearInput returns the documents a $geoNear stage finds among those
+// that are live and match the filter, nearest first, with their distances
+// added. The caller must hold the lock.
+func (ds *DocumentStore) geoNearInput(collection *Collection, filter map[string]interface{}, near *query.GeoNear, now time.Time) ([]map[string]interface{}, error) {
+	distances, index, err := collection.nearSearch(&near.Near)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := ds.compile(query.And(near.Query, filter))
+	if err != nil {
+		return nil, err
+	}
+	candidates := collection.byDistance(distances)
+	documents := make([]map[string]interface{}, 0, len(candidates))
+	for _, doc := range candidates {
+		if collection.expired(doc, now) || !compiled.Matches(doc.Data) {
+			continue
+		}
+		location := getField(doc.Data, index.spec.Fields[0])
+		documents = append(documents, near.Annotate(pipelineDocument(doc), distances[doc.ID], location))
+	}
+	return documents, nil
+}
+
+func pipelineDocument(doc *models.Document) map[string]interface{} {
+	if _, exists := doc.Data["_id"]; exists {
+		return doc.Data
+	}
+	document := make(map[string]interface{}, len(doc.Data)+1)
+	for key, value := range doc.Data {
+		document[key] = value
+	}
+	document["_id"] = doc.ID
+	return document
+}
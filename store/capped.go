@@ -0,0 +1,280 @@
+package store
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+)
+
+var ErrDocumentTooLarge = errors.New("document is larger than the capped collection allows")
+
+// CappedSettings bound a collection by document count, by the total size
+// of its documents' data in bytes, or both. Writing beyond a bound evicts
+// the documents created first.
+type CappedSettings struct {
+	MaxDocuments int `json:"max_documents,omitempty"`
+	MaxBytes     int `json:"max_bytes,omitempty"`
+}
+
+// TailEntry is a document together with its position in insertion order.
+type TailEntry struct {
+	Seq      uint64           `json:"seq"`
+	Document *models.Document `json:"document"`
+}
+
+// orderEntry tracks a document in the insertion order of its collection.
+// Sequence numbers are local to the node. In a capped collection the entry
+// is also in the eviction queue at index, and size holds the size of its
+// data when max_bytes is set.
+type orderEntry struct {
+	id        string
+	seq       uint64
+	createdAt time.Time
+	size      int
+	index     int
+}
+
+// evictionQueue orders the documents of a capped collection by creation
+// time, then ID, which every node agrees on whatever order the documents
+// arrived in.
+type evictionQueue []*orderEntry
+
+func (q evictionQueue) Len() int { return len(q) }
+func (q evictionQueue) Less(i, j int) bool {
+	if !q[i].createdAt.Equal(q[j].createdAt) {
+		return q[i].createdAt.Before(q[j].createdAt)
+	}
+	return q[i].id < q[j].id
+}
+func (q evictionQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *evictionQueue) Push(v interface{}) {
+	entry := v.(*orderEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+func (q *evictionQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	entry.index = -1
+	*q = old[:len(old)-1]
+	return entry
+}
+
+func (c *CappedSettings) normalize() error {
+	if c.MaxDocuments < 0 || c.MaxBytes < 0 {
+		return errors.New("capped limits must not be negative")
+	}
+	if c.MaxDocuments == 0 && c.MaxBytes == 0 {
+		return errors.New("capped needs max_documents or max_bytes")
+	}
+	return nil
+}
+
+func dataSize(data map[string]interface{}) int {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
+
+// admit rejects data that could never be stored in the collection: data
+// larger than a capped collection, anything but geometry in a field under
+// a 2dsphere index and anything but a vector of the right length in a
+// field under a vector index.
+func (c *Collection) admit(data map[string]interface{}) error {
+	capped := c.Settings.Capped
+	if capped != nil && capped.MaxBytes > 0 && dataSize(data) > capped.MaxBytes {
+		return ErrDocumentTooLarge
+	}
+	if err := c.checkGeometry(data); err != nil {
+		return err
+	}
+	return c.checkVectors(data)
+}
+
+// tracksBytes reports whether the collection needs the size of every
+// document, which is only the case when it is capped by max_bytes.
+func (c *Collection) tracksBytes() bool {
+	return c.Settings.Capped != nil && c.Settings.Capped.MaxBytes > 0
+}
+
+// applyCapped rebuilds the eviction queue and the document sizes after the
+// settings changed. Collections that are not capped keep neither.
+func (c *Collection) applyCapped() {
+	c.evictions = nil
+	c.bytes = 0
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*orderEntry)
+		entry.size, entry.index = 0, -1
+		if c.Settings.Capped == nil {
+			continue
+		}
+		if c.tracksBytes() {
+			entry.size = dataSize(c.Documents[entry.id].Data)
+			c.bytes += entry.size
+		}
+		c.evictions = append(c.evictions, entry)
+		entry.index = len(c.evictions) - 1
+	}
+	heap.Init(&c.evictions)
+}
+
+// put stores a document. New documents go to the end of the insertion
+// order; replaced ones keep their position.
+func (c *Collection) put(doc *models.Document) {
+	size := 0
+	if c.tracksBytes() {
+		size = dataSize(doc.Data)
+	}
+	if element, exists := c.positions[doc.ID]; exists {
+		entry := element.Value.(*orderEntry)
+		c.bytes += size - entry.size
+		entry.size = size
+	} else {
+		c.nextSeq++
+		entry := &orderEntry{id: doc.ID, seq: c.nextSeq, createdAt: doc.CreatedAt, size: size, index: -1}
+		c.positions[doc.ID] = c.order.PushBack(entry)
+		c.bytes += size
+		if c.Settings.Capped != nil {
+			heap.Push(&c.evictions, entry)
+		}
+	}
+	c.Documents[doc.ID] = doc
+	c.indexDocument(doc)
+	c.recordRevision(doc.ID, Revision{Data: doc.Data, CreatedAt: doc.CreatedAt, UpdatedAt: doc.UpdatedAt})
+}
+
+// remove deletes a document that went away at the given time.
+func (c *Collection) remove(documentID string, removedAt time.Time) {
+	if doc, exists := c.Documents[documentID]; exists {
+		c.recordRevision(documentID, Revision{CreatedAt: doc.CreatedAt, UpdatedAt: removedAt, Deleted: true})
+	}
+	if element, exists := c.positions[documentID]; exists {
+		entry := element.Value.(*orderEntry)
+		c.bytes -= entry.size
+		if entry.index >= 0 {
+			heap.Remove(&c.evictions, entry.index)
+		}
+		c.order.Remove(element)
+		delete(c.positions, documentID)
+	}
+	c.unindexDocument(documentID)
+	delete(c.Documents, documentID)
+}
+
+// enforceCap evicts the documents created first, by creation time and
+// then ID, until the collection is within its bounds. Every node caps its
+// own copy as documents arrive rather than pushing evictions to peers, so
+// the order and the eviction time depend only on the documents: once the
+// same writes have arrived, every node holds the same documents and the
+// same tombstones, whatever order they came in. A write can evict the
+// document it wrote when that document is the oldest. The eviction is
+// stamped just after the document's last write, which keeps that write in
+// its history, lets a replayed copy of it be discarded and lets a newer
+// update bring the document back. Evictions leave tombstones and are
+// recorded in the change log. The caller must hold the write lock.
+func (ds *DocumentStore) enforceCap(projectID, collectionID string, c *Collection) {
+	capped := c.Settings.Capped
+	if capped == nil {
+		return
+	}
+
+	for c.evictions.Len() > 0 {
+		over := (capped.MaxDocuments > 0 && len(c.Documents) > capped.MaxDocuments) ||
+			(capped.MaxBytes > 0 && c.bytes > capped.MaxBytes)
+		if !over {
+			return
+		}
+
+		id := c.evictions[0].id
+		evictedAt := c.Documents[id].UpdatedAt.Add(time.Nanosecond)
+		c.remove(id, evictedAt)
+		if current, exists := c.Tombstones[id]; !exists || evictedAt.After(current) {
+			c.Tombstones[id] = evictedAt
+		}
+		ds.recordDelete(projectID, collectionID, id, evictedAt)
+	}
+}
+
+// TailAs returns up to limit documents inserted after the given sequence
+// number, in insertion order. When there are none and wait is positive it
+// blocks until new documents arrive or wait expires. Updates do not move a
+// document, so only inserts are seen by a tailing reader.
+func (ds *DocumentStore) TailAs(scope *Scope, projectID, collectionID string, after uint64, limit int, wait time.Duration) ([]TailEntry, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		entries, changeSeq, err := ds.tail(scope, projectID, collectionID, after, limit)
+		if err != nil || len(entries) > 0 {
+			return entries, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return entries, nil
+		}
+		ds.WaitForChanges(changeSeq, remaining)
+	}
+}
+
+func (ds *DocumentStore) tail(scope *Scope, projectID, collectionID string, after uint64, limit int) ([]TailEntry, uint64, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, ds.changes.seq, err
+	}
+
+	filter, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, ds.changes.seq, err
+	}
+	allowed, err := ds.compile(filter)
+	if err != nil {
+		return nil, ds.changes.seq, err
+	}
+
+	// Sequence numbers grow along the list, so walk back to the first
+	// entry after the cursor.
+	start := collection.order.Back()
+	for start != nil && start.Value.(*orderEntry).seq > after {
+		if prev := start.Prev(); prev != nil && prev.Value.(*orderEntry).seq > after {
+			start = prev
+			continue
+		}
+		break
+	}
+	if start != nil && start.Value.(*orderEntry).seq <= after {
+		start = nil
+	}
+
+	now := time.Now().UTC()
+	entries := []TailEntry{}
+	for element := start; element != nil && (limit <= 0 || len(entries) < limit); element = element.Next() {
+		entry := element.Value.(*orderEntry)
+		doc := collection.Documents[entry.id]
+		if collection.expired(doc, now) || !allowed.Matches(doc.Data) {
+			continue
+		}
+		entries = append(entries, TailEntry{Seq: entry.seq, Document: doc})
+	}
+
+	return entries, ds.changes.seq, nil
+}
+
+// ordered returns the documents of a collection in insertion order. The
+// caller must hold the lock.
+func (c *Collection) ordered() []*models.Document {
+	docs := make([]*models.Document, 0, len(c.Documents))
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		docs = append(docs, c.Documents[element.Value.(*orderEntry).id])
+	}
+	return docs
+}
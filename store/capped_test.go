@@ -0,0 +1,146 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+)
+
+func newCappedStore(t *testing.T, capped *CappedSettings) *DocumentStore {
+	t.Helper()
+	ds := NewStore()
+	if _, err := ds.CreateProject("p"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CreateCollection("p", "c", CollectionSettings{Capped: capped}); err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+// remaining returns the IDs of the documents in c and of its tombstones.
+func remaining(ds *DocumentStore) ([]string, map[string]time.Time) {
+	c := ds.Projects["p"].Collections["c"]
+	var ids []string
+	for id := range c.Documents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	tombstones := make(map[string]time.Time, len(c.Tombstones))
+	for id, at := range c.Tombstones {
+		tombstones[id] = at
+	}
+	return ids, tombstones
+}
+
+func TestCappedTracksSizesOnlyWhenCapped(t *testing.T) {
+	ds := newCappedStore(t, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := ds.Create("p", "c", map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := ds.Projects["p"].Collections["c"]
+	if c.bytes != 0 || c.evictions != nil {
+		t.Fatalf("uncapped collection tracks bytes %d and %d evictions", c.bytes, c.evictions.Len())
+	}
+
+	settings := CollectionSettings{Capped: &CappedSettings{MaxBytes: 1 << 20}}
+	if _, err := ds.UpdateSettings("p", "c", settings); err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for _, doc := range c.Documents {
+		want += dataSize(doc.Data)
+	}
+	if c.bytes != want || c.evictions.Len() != 3 {
+		t.Fatalf("capped collection tracks bytes %d and %d evictions, want %d and 3", c.bytes, c.evictions.Len(), want)
+	}
+
+	if _, err := ds.UpdateSettings("p", "c", CollectionSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	if c.bytes != 0 || c.evictions != nil {
+		t.Fatalf("uncapped collection tracks bytes %d and %d evictions", c.bytes, c.evictions.Len())
+	}
+}
+
+func TestCappedEvictionIsDeterministic(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var docs []*models.Document
+	for i := 0; i < 6; i++ {
+		// d0 and d1 share a creation time, so the ID breaks the tie.
+		created := base.Add(time.Duration(i/2*2) * time.Second)
+		docs = append(docs, &models.Document{
+			ID:        fmt.Sprintf("d%d", i),
+			Data:      map[string]interface{}{"n": i},
+			CreatedAt: created,
+			UpdatedAt: created.Add(time.Minute),
+		})
+	}
+	orders := [][]int{{0, 1, 2, 3, 4, 5}, {5, 4, 3, 2, 1, 0}, {3, 0, 5, 1, 4, 2}}
+
+	var wantIDs []string
+	var wantTombstones map[string]time.Time
+	for n, order := range orders {
+		ds := newCappedStore(t, &CappedSettings{MaxDocuments: 3})
+		for _, i := range order {
+			doc := *docs[i]
+			if err := ds.InsertWithID("p", "c", &doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids, tombstones := remaining(ds)
+		if n == 0 {
+			wantIDs, wantTombstones = ids, tombstones
+			if !equalNames(ids, []string{"d3", "d4", "d5"}) {
+				t.Fatalf("kept %v, want [d3 d4 d5]", ids)
+			}
+			for _, doc := range docs[:3] {
+				if at := tombstones[doc.ID]; !at.Equal(doc.UpdatedAt.Add(time.Nanosecond)) {
+					t.Fatalf("tombstone of %s at %v, want just after %v", doc.ID, at, doc.UpdatedAt)
+				}
+			}
+			continue
+		}
+		if !equalNames(ids, wantIDs) {
+			t.Fatalf("order %v kept %v, want %v", order, ids, wantIDs)
+		}
+		if len(tombstones) != len(wantTombstones) {
+			t.Fatalf("order %v left %d tombstones, want %d", order, len(tombstones), len(wantTombstones))
+		}
+		for id, at := range wantTombstones {
+			if !tombstones[id].Equal(at) {
+				t.Fatalf("order %v left tombstone of %s at %v, want %v", order, id, tombstones[id], at)
+			}
+		}
+	}
+}
+
+func TestCappedEvictionKeepsLastWriteInHistory(t *testing.T) {
+	ds := newVersionedStore(t, 0)
+	settings := CollectionSettings{Capped: &CappedSettings{MaxDocuments: 1}, Versioning: &VersioningSettings{}}
+	if _, err := ds.UpdateSettings("p", "c", settings); err != nil {
+		t.Fatal(err)
+	}
+	c := ds.Projects["p"].Collections["c"]
+	writeAt(t, ds, 1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	at := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	newer := &models.Document{ID: "e", Data: map[string]interface{}{"n": 2.0}, CreatedAt: at, UpdatedAt: at}
+	if err := ds.InsertWithID("p", "c", newer); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := c.Documents["d"]; exists {
+		t.Fatal("d was not evicted")
+	}
+	revisions, err := ds.History(nil, "p", "c", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Deleted || !revisions[1].Deleted {
+		t.Fatalf("history of d is %+v, want its write and then the eviction", revisions)
+	}
+}
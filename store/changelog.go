@@ -0,0 +1,131 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+)
+
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete"
+)
+
+var ErrChangesTruncated = errors.New("requested changes are no longer in the change log")
+
+// Change is one entry of the store's change log. Sequence numbers are
+// assigned under the store lock so they line up with snapshots.
+type Change struct {
+	Seq        uint64           `json:"seq"`
+	Operation  string           `json:"operation"`
+	Project    string           `json:"project"`
+	Collection string           `json:"collection"`
+	ID         string           `json:"id,omitempty"`
+	Document   *models.Document `json:"document,omitempty"`
+	DeletedAt  time.Time        `json:"deleted_at,omitempty"`
+	// Name is the new project or collection ID of a rename.
+	Name      string              `json:"name,omitempty"`
+	Settings  *CollectionSettings `json:"settings,omitempty"`
+	Timestamp time.Time           `json:"timestamp,omitempty"`
+}
+
+type changeLog struct {
+	seq     uint64
+	max     int
+	changes []Change
+	notify  chan struct{}
+}
+
+func newChangeLog(max int) *changeLog {
+	return &changeLog{
+		max:    max,
+		notify: make(chan struct{}),
+	}
+}
+
+// append must be called with the store lock held.
+func (cl *changeLog) append(change Change) {
+	cl.seq++
+	change.Seq = cl.seq
+	cl.changes = append(cl.changes, change)
+
+	if cl.max > 0 && len(cl.changes) >= 2*cl.max {
+		cl.changes = append([]Change(nil), cl.changes[len(cl.changes)-cl.max:]...)
+	}
+
+	close(cl.notify)
+	cl.notify = make(chan struct{})
+}
+
+func (ds *DocumentStore) recordUpsert(projectID, collectionID string, doc *models.Document) {
+	snapshot := *doc
+	ds.changes.append(Change{
+		Operation:  ChangeUpsert,
+		Project:    projectID,
+		Collection: collectionID,
+		ID:         doc.ID,
+		Document:   &snapshot,
+	})
+}
+
+func (ds *DocumentStore) recordDelete(projectID, collectionID, documentID string, deletedAt time.Time) {
+	ds.changes.append(Change{
+		Operation:  ChangeDelete,
+		Project:    projectID,
+		Collection: collectionID,
+		ID:         documentID,
+		DeletedAt:  deletedAt,
+	})
+}
+
+// ChangesSince returns up to limit changes with a sequence number greater
+// than seq, along with the latest sequence number of the store. A position
+// ahead of the log was handed out before the node restarted with an empty
+// log, so it is reported as truncated for the caller to bootstrap again.
+func (ds *DocumentStore) ChangesSince(seq uint64, limit int) ([]Change, uint64, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	cl := ds.changes
+	if seq > cl.seq {
+		return nil, cl.seq, ErrChangesTruncated
+	}
+	if seq == cl.seq {
+		return []Change{}, cl.seq, nil
+	}
+
+	if len(cl.changes) == 0 || seq+1 < cl.changes[0].Seq {
+		return nil, cl.seq, ErrChangesTruncated
+	}
+
+	start := int(seq + 1 - cl.changes[0].Seq)
+	end := len(cl.changes)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	changes := make([]Change, end-start)
+	copy(changes, cl.changes[start:end])
+	return changes, cl.seq, nil
+}
+
+// WaitForChanges blocks until a change after seq exists or the timeout
+// expires. It returns at once for a position ahead of the log.
+func (ds *DocumentStore) WaitForChanges(seq uint64, timeout time.Duration) {
+	ds.mu.RLock()
+	if ds.changes.seq != seq {
+		ds.mu.RUnlock()
+		return
+	}
+	notify := ds.changes.notify
+	ds.mu.RUnlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-notify:
+	case <-timer.C:
+	}
+}
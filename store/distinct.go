@@ -0,0 +1,121 @@
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// CountAs counts the documents matching the filter ANDed with the scope's
+// security filter.
+func (ds *DocumentStore) CountAs(scope *Scope, projectID, collectionID string, filter map[string]interface{}) (int, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return 0, err
+	}
+
+	security, err := collection.securityFilter(scope)
+	if err != nil {
+		return 0, err
+	}
+
+	documents, _, err := ds.find(collection, query.And(filter, security))
+	if err != nil {
+		return 0, err
+	}
+	return len(documents), nil
+}
+
+// DistinctAs returns the distinct values of a dotted path among the
+// documents matching the filter and the scope's security filter, in the
+// order $sort uses. Arrays contribute each of their elements; null and
+// missing values are left out. Without a filter, a single field index on
+// the path answers from its keys instead of reading every document.
+func (ds *DocumentStore) DistinctAs(scope *Scope, projectID, collectionID, field string, filter map[string]interface{}) ([]interface{}, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	security, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	visible, err := ds.compile(security)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	if filter == nil {
+		now := time.Now().UTC()
+		var indexed bool
+		values, indexed = collection.distinctFromIndex(field, func(doc *models.Document, value interface{}) bool {
+			if collection.expired(doc, now) || !containsDistinct(distinctValues(doc.Data, field), value) {
+				return false
+			}
+			return visible.Matches(doc.Data)
+		})
+		if indexed {
+			sortValues(values)
+			return values, nil
+		}
+	}
+
+	documents, _, err := ds.find(collection, query.And(filter, security))
+	if err != nil {
+		return nil, err
+	}
+
+	values = []interface{}{}
+	for _, doc := range documents {
+		for _, value := range distinctValues(doc.Data, field) {
+			if !containsDistinct(values, value) {
+				values = append(values, value)
+			}
+		}
+	}
+	sortValues(values)
+	return values, nil
+}
+
+// distinctValues returns the values a document contributes to a distinct
+// list.
+func distinctValues(data map[string]interface{}, field string) []interface{} {
+	var values []interface{}
+	for _, value := range query.Lookup(data, field) {
+		items, isArray := value.([]interface{})
+		if !isArray {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			if item != nil {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+func containsDistinct(values []interface{}, value interface{}) bool {
+	for _, existing := range values {
+		if query.Compare(existing, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func sortValues(values []interface{}) {
+	sort.SliceStable(values, func(i, j int) bool {
+		return query.Compare(values[i], values[j]) < 0
+	})
+}
@@ -0,0 +1,294 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/itsyaboikris/go_document_store/geo"
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+var (
+	ErrGeoIndexRequired = errors.New("$near and $geoNear require a 2dsphere index on the field")
+	ErrInvalidGeometry  = errors.New("invalid geometry")
+	ErrTextWithNear     = errors.New("$text and $near cannot be combined")
+	ErrGeoNearKey       = errors.New("$geoNear requires a key when the collection has more than one 2dsphere index")
+)
+
+const (
+	// geoCellDegrees is the size of the latitude and longitude grid a
+	// 2dsphere index files shapes under.
+	geoCellDegrees = 1.0
+	// geoMaxCells bounds the cells a shape is filed under. Larger shapes
+	// are kept apart and are candidates for every lookup.
+	geoMaxCells = 256
+)
+
+type geoCell struct {
+	lat, lng int
+}
+
+// geoIndex files the shape held by a field of each document under the grid
+// cells its bounding rectangle touches. A lookup reads the cells a region
+// touches, so it only narrows the candidates; the filter is still applied
+// to each of them. Documents without the field are not indexed.
+type geoIndex struct {
+	spec   IndexSpec
+	cells  map[geoCell]map[string]bool
+	wide   map[string]bool
+	shapes map[string]*geo.Geometry
+	keys   map[string][]geoCell
+}
+
+func newGeoIndex(spec IndexSpec) *geoIndex {
+	return &geoIndex{
+		spec:   spec,
+		cells:  make(map[geoCell]map[string]bool),
+		wide:   make(map[string]bool),
+		shapes: make(map[string]*geo.Geometry),
+		keys:   make(map[string][]geoCell),
+	}
+}
+
+func (x *geoIndex) add(doc *models.Document) {
+	x.delete(doc.ID)
+
+	value := getField(doc.Data, x.spec.Fields[0])
+	if value == nil {
+		return
+	}
+	shape, err := geo.Parse(value)
+	if err != nil {
+		// admit keeps invalid geometry out, except from documents stored
+		// before the index was created.
+		return
+	}
+	x.shapes[doc.ID] = shape
+
+	cells, ok := geoCells(shape.Bound())
+	if !ok {
+		x.wide[doc.ID] = true
+		return
+	}
+	for _, cell := range cells {
+		if x.cells[cell] == nil {
+			x.cells[cell] = make(map[string]bool)
+		}
+		x.cells[cell][doc.ID] = true
+	}
+	x.keys[doc.ID] = cells
+}
+
+func (x *geoIndex) delete(documentID string) {
+	for _, cell := range x.keys[documentID] {
+		delete(x.cells[cell], documentID)
+		if len(x.cells[cell]) == 0 {
+			delete(x.cells, cell)
+		}
+	}
+	delete(x.keys, documentID)
+	delete(x.wide, documentID)
+	delete(x.shapes, documentID)
+}
+
+// lookup returns the documents whose shapes may reach into the rectangle.
+func (x *geoIndex) lookup(r geo.Rect) map[string]bool {
+	found := make(map[string]bool)
+	cells, ok := geoCells(r)
+	if !ok {
+		for id := range x.shapes {
+			found[id] = true
+		}
+		return found
+	}
+	for _, cell := range cells {
+		for id := range x.cells[cell] {
+			found[id] = true
+		}
+	}
+	for id := range x.wide {
+		found[id] = true
+	}
+	return found
+}
+
+// geoCells returns the grid cells a rectangle touches, or false if there
+// are more than geoMaxCells of them.
+func geoCells(r geo.Rect) ([]geoCell, bool) {
+	minLat, maxLat := cellOf(r.MinLat+90, 180), cellOf(r.MaxLat+90, 180)
+	lngRanges := [][2]int{{cellOf(r.MinLng+180, 360), cellOf(r.MaxLng+180, 360)}}
+	if r.MinLng > r.MaxLng {
+		// The rectangle crosses the antimeridian.
+		lngRanges = [][2]int{{cellOf(r.MinLng+180, 360), cellOf(360, 360)}, {0, cellOf(r.MaxLng+180, 360)}}
+	}
+
+	count := 0
+	for _, lng := range lngRanges {
+		count += (maxLat - minLat + 1) * (lng[1] - lng[0] + 1)
+	}
+	if count > geoMaxCells {
+		return nil, false
+	}
+
+	cells := make([]geoCell, 0, count)
+	for lat := minLat; lat <= maxLat; lat++ {
+		for _, lng := range lngRanges {
+			for l := lng[0]; l <= lng[1]; l++ {
+				cells = append(cells, geoCell{lat: lat, lng: l})
+			}
+		}
+	}
+	return cells, true
+}
+
+// cellOf returns the cell holding an offset of degrees from the start of a
+// range of the given span.
+func cellOf(degrees, span float64) int {
+	cell := int(math.Floor(degrees / geoCellDegrees))
+	last := int(span/geoCellDegrees) - 1
+	if cell < 0 {
+		return 0
+	}
+	if cell > last {
+		return last
+	}
+	return cell
+}
+
+// checkGeometry rejects data whose field under a 2dsphere index holds
+// something other than geometry.
+func (c *Collection) checkGeometry(data map[string]interface{}) error {
+	for _, index := range c.geoIndexes() {
+		field := index.spec.Fields[0]
+		value := getField(data, field)
+		if value == nil {
+			continue
+		}
+		if _, err := geo.Parse(value); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidGeometry, field, err)
+		}
+	}
+	return nil
+}
+
+// geoIndexes returns the 2dsphere indexes ordered by name.
+func (c *Collection) geoIndexes() []*geoIndex {
+	indexes := make([]*geoIndex, 0, len(c.geo))
+	for _, index := range c.geo {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].spec.Name < indexes[j].spec.Name
+	})
+	return indexes
+}
+
+// geoIndexOn returns the 2dsphere index on a field. An empty field names
+// the collection's only 2dsphere index.
+func (c *Collection) geoIndexOn(field string) (*geoIndex, error) {
+	indexes := c.geoIndexes()
+	if field == "" {
+		if len(indexes) > 1 {
+			return nil, ErrGeoNearKey
+		}
+		if len(indexes) == 1 {
+			return indexes[0], nil
+		}
+		return nil, ErrGeoIndexRequired
+	}
+	for _, index := range indexes {
+		if index.spec.Fields[0] == field {
+			return index, nil
+		}
+	}
+	return nil, ErrGeoIndexRequired
+}
+
+// nearSearch returns the distance in meters to every document whose indexed
+// shape is within the bounds of the search. The caller must hold the lock.
+func (c *Collection) nearSearch(near *query.Near) (map[string]float64, *geoIndex, error) {
+	index, err := c.geoIndexOn(near.Field)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ids map[string]bool
+	if math.IsInf(near.MaxDistance, 1) {
+		ids = make(map[string]bool, len(index.shapes))
+		for id := range index.shapes {
+			ids[id] = true
+		}
+	} else {
+		reach := geo.Cap{Center: near.Point, Radius: math.Min(near.MaxDistance/geo.EarthRadius, math.Pi)}
+		ids = index.lookup(reach.Bound())
+	}
+
+	distances := make(map[string]float64, len(ids))
+	for id := range ids {
+		d := geo.DistanceTo(near.Point, index.shapes[id])
+		if d >= near.MinDistance && d <= near.MaxDistance {
+			distances[id] = d
+		}
+	}
+	return distances, index, nil
+}
+
+// geoCandidates returns the documents a 2dsphere index says may satisfy a
+// $geoWithin or $geoIntersects condition of the filter, in insertion order.
+// It returns false when no such condition is on an indexed field. The
+// caller must hold the lock.
+func (c *Collection) geoCandidates(filter map[string]interface{}) ([]*models.Document, bool) {
+	if len(c.geo) == 0 || filter == nil {
+		return nil, false
+	}
+
+	bounds := make(map[string]geo.Rect)
+	geoConditions(filter, bounds)
+	for _, index := range c.geoIndexes() {
+		if bound, exists := bounds[index.spec.Fields[0]]; exists {
+			return c.inOrder(index.lookup(bound)), true
+		}
+	}
+	return nil, false
+}
+
+// geoConditions collects the bounds of the $geoWithin and $geoIntersects
+// conditions at the top level of a filter and inside $and.
+func geoConditions(filter map[string]interface{}, found map[string]geo.Rect) {
+	for key, condition := range filter {
+		if query.Operator(key) == query.OpAnd {
+			clauses, _ := condition.([]interface{})
+			for _, clause := range clauses {
+				if sub, ok := clause.(map[string]interface{}); ok {
+					geoConditions(sub, found)
+				}
+			}
+			continue
+		}
+		operators, ok := condition.(map[string]interface{})
+		if key == "" || key[0] == '$' || !ok {
+			continue
+		}
+		if _, exists := found[key]; exists {
+			continue
+		}
+		if arg, exists := operators[string(query.OpGeoWithin)]; exists {
+			if region, err := query.ParseGeoWithin(arg); err == nil {
+				found[key] = region.Bound()
+			}
+		} else if arg, exists := operators[string(query.OpGeoIntersects)]; exists {
+			if shape, err := query.ParseGeoIntersects(arg); err == nil {
+				found[key] = shape.Bound()
+			}
+		}
+	}
+}
+
+// byDistance returns the documents nearest first, keeping insertion order
+// between equal distances.
+func (c *Collection) byDistance(distances map[string]float64) []*models.Document {
+	return c.ranked(distances, func(a, b float64) bool { return a < b })
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/itsyaboikris/go_document_store/geo"
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// cities are stored out of distance order from London.
+var cities = []struct {
+	name string
+	at   geo.Point
+}{
+	{"rome", geo.Point{Lng: 12.4964, Lat: 41.9028}},
+	{"paris", geo.Point{Lng: 2.3522, Lat: 48.8566}},
+	{"madrid", geo.Point{Lng: -3.7038, Lat: 40.4168}},
+	{"brussels", geo.Point{Lng: 4.3517, Lat: 50.8503}},
+	{"london", geo.Point{Lng: -0.1278, Lat: 51.5074}},
+}
+
+var london = cities[4].at
+
+func newGeoStore(t *testing.T) *DocumentStore {
+	t.Helper()
+	ds := NewStore()
+	if _, err := ds.CreateProject("p"); err != nil {
+		t.Fatal(err)
+	}
+	settings := CollectionSettings{Indexes: []IndexSpec{{Fields: []string{"location"}, Type: "2dsphere"}}}
+	if _, err := ds.CreateCollection("p", "cities", settings); err != nil {
+		t.Fatal(err)
+	}
+	for _, city := range cities {
+		_, err := ds.Create("p", "cities", map[string]interface{}{
+			"name": city.name,
+			"location": map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []interface{}{city.at.Lng, city.at.Lat},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ds
+}
+
+func decodeFilter(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var filter map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &filter); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	return filter
+}
+
+func TestNearOrdersByDistance(t *testing.T) {
+	ds := newGeoStore(t)
+
+	tests := []struct {
+		name   string
+		filter string
+		want   []string
+	}{
+		{"all", `{"location": {"$near": {"$geometry": {"type": "Point", "coordinates": [-0.1278, 51.5074]}}}}`,
+			[]string{"london", "brussels", "paris", "madrid", "rome"}},
+		{"max distance", `{"location": {"$near": {"$geometry": {"type": "Point", "coordinates": [-0.1278, 51.5074]}, "$maxDistance": 400000}}}`,
+			[]string{"london", "brussels", "paris"}},
+		{"min distance", `{"location": {"$near": {"$geometry": {"type": "Point", "coordinates": [-0.1278, 51.5074]}, "$minDistance": 1000, "$maxDistance": 1300000}}}`,
+			[]string{"brussels", "paris", "madrid"}},
+		{"with another condition", `{"location": {"$near": {"$geometry": {"type": "Point", "coordinates": [-0.1278, 51.5074]}}}, "name": {"$ne": "paris"}}`,
+			[]string{"london", "brussels", "madrid", "rome"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := ds.Query("p", "cities", decodeFilter(t, tt.filter))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, doc := range docs {
+				got = append(got, doc.Data["name"].(string))
+			}
+			if !equalNames(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeoNearDistanceField(t *testing.T) {
+	ds := newGeoStore(t)
+
+	pipeline, err := query.ParsePipeline([]byte(`[
+		{"$geoNear": {"near": [-0.1278, 51.5074], "distanceField": "dist.meters", "includeLocs": "loc", "maxDistance": 1300000}},
+		{"$project": {"_id": 0, "name": 1, "dist": 1, "loc": 1}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ds.AggregateAs(nil, "p", "cities", pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"london", "brussels", "paris", "madrid"}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	previous := -1.0
+	for i, result := range results {
+		name := result["name"].(string)
+		if name != want[i] {
+			t.Errorf("result %d = %s, want %s", i, name, want[i])
+		}
+		dist, _ := result["dist"].(map[string]interface{})
+		meters, ok := dist["meters"].(float64)
+		if !ok {
+			t.Fatalf("result %d has no dist.meters: %v", i, result)
+		}
+		if expected := geo.Distance(london, cityAt(name)); math.Abs(meters-expected) > 1 {
+			t.Errorf("%s dist.meters = %.1f, want %.1f", name, meters, expected)
+		}
+		if meters < previous {
+			t.Errorf("%s is nearer than the result before it", name)
+		}
+		previous = meters
+		if result["loc"] == nil {
+			t.Errorf("%s lacks includeLocs", name)
+		}
+	}
+	if paris := results[2]["dist"].(map[string]interface{})["meters"].(float64); math.Abs(paris-343939) > 100 {
+		t.Errorf("paris dist.meters = %.1f, want about 343.9 km", paris)
+	}
+
+	pipeline, err = query.ParsePipeline([]byte(`[
+		{"$geoNear": {"near": {"type": "Point", "coordinates": [-0.1278, 51.5074]}, "distanceField": "km", "distanceMultiplier": 0.001, "query": {"name": "rome"}}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = ds.AggregateAs(nil, "p", "cities", pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0]["name"] != "rome" {
+		t.Fatalf("query results = %v, want rome alone", results)
+	}
+	if km := results[0]["km"].(float64); math.Abs(km-geo.Distance(london, cityAt("rome"))/1000) > 0.001 {
+		t.Errorf("km = %v, want the distance in kilometers", km)
+	}
+}
+
+func cityAt(name string) geo.Point {
+	for _, city := range cities {
+		if city.name == name {
+			return city.at
+		}
+	}
+	return geo.Point{}
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
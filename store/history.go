@@ -0,0 +1,293 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+var (
+	ErrVersioningDisabled = errors.New("versioning is not enabled for this collection")
+	ErrRevisionNotFound   = errors.New("revision not found")
+	ErrTextSearchAsOf     = errors.New("$text cannot be combined with asOf")
+	ErrNearAsOf           = errors.New("$near cannot be combined with asOf")
+)
+
+// VersioningSettings keep the prior revisions of every document. History
+// starts when versioning is enabled and is kept by each node from the
+// writes it applies. MaxRevisions bounds the revisions kept per document;
+// zero keeps them all.
+type VersioningSettings struct {
+	MaxRevisions int `json:"max_revisions,omitempty"`
+}
+
+// Revision is the state of a document from UpdatedAt until the next
+// revision. A deleted revision marks when the document went away.
+type Revision struct {
+	Version   int                    `json:"version"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	Deleted   bool                   `json:"deleted,omitempty"`
+}
+
+func (v *VersioningSettings) normalize() error {
+	if v.MaxRevisions < 0 {
+		return errors.New("max_revisions must not be negative")
+	}
+	return nil
+}
+
+// applyVersioning starts or stops keeping history after the settings
+// changed. Existing documents are recorded as their first revision.
+func (c *Collection) applyVersioning() {
+	if c.Settings.Versioning == nil {
+		c.history = nil
+		c.versions = nil
+		return
+	}
+	if c.history != nil {
+		c.prune()
+		return
+	}
+
+	c.history = make(map[string][]Revision, len(c.Documents))
+	c.versions = make(map[string]int, len(c.Documents))
+	for _, doc := range c.Documents {
+		c.recordRevision(doc.ID, Revision{Data: doc.Data, CreatedAt: doc.CreatedAt, UpdatedAt: doc.UpdatedAt})
+	}
+}
+
+// recordRevision adds a revision of documentID, keeping the history sorted
+// by time. A revision at the same time as an existing one replaces it and
+// keeps its version, so a replayed replicated write is not recorded twice.
+// Every other revision gets the next version when it is recorded, and
+// versions are never changed afterwards: one that arrives out of order is
+// placed by time but numbered by arrival.
+func (c *Collection) recordRevision(documentID string, revision Revision) {
+	if c.history == nil {
+		return
+	}
+
+	revisions := c.history[documentID]
+	i := sort.Search(len(revisions), func(i int) bool {
+		return !revisions[i].UpdatedAt.Before(revision.UpdatedAt)
+	})
+	if i < len(revisions) && revisions[i].UpdatedAt.Equal(revision.UpdatedAt) {
+		revision.Version = revisions[i].Version
+		revisions[i] = revision
+	} else {
+		c.versions[documentID]++
+		revision.Version = c.versions[documentID]
+		revisions = append(revisions, Revision{})
+		copy(revisions[i+1:], revisions[i:])
+		revisions[i] = revision
+	}
+	c.history[documentID] = revisions
+	c.pruneDocument(documentID)
+}
+
+func (c *Collection) prune() {
+	for documentID := range c.history {
+		c.pruneDocument(documentID)
+	}
+}
+
+// pruneDocument drops the oldest revisions beyond MaxRevisions. The
+// remaining revisions keep their versions.
+func (c *Collection) pruneDocument(documentID string) {
+	limit := c.Settings.Versioning.MaxRevisions
+	revisions := c.history[documentID]
+	if limit > 0 && len(revisions) > limit {
+		c.history[documentID] = append([]Revision(nil), revisions[len(revisions)-limit:]...)
+	}
+}
+
+// revisionAt returns the revision of documentID in effect at the given
+// time.
+func (c *Collection) revisionAt(documentID string, at time.Time) (Revision, bool) {
+	revisions := c.history[documentID]
+	i := sort.Search(len(revisions), func(i int) bool {
+		return revisions[i].UpdatedAt.After(at)
+	})
+	if i == 0 || revisions[i-1].Deleted {
+		return Revision{}, false
+	}
+	return revisions[i-1], true
+}
+
+// versionedAt returns the document as it was at the given time, or nil if
+// it did not exist or had expired then. The caller must hold the lock.
+func (c *Collection) versionedAt(documentID string, at time.Time) *models.Document {
+	revision, ok := c.revisionAt(documentID, at)
+	if !ok {
+		return nil
+	}
+	doc := &models.Document{
+		ID:        documentID,
+		Data:      revision.Data,
+		CreatedAt: revision.CreatedAt,
+		UpdatedAt: revision.UpdatedAt,
+	}
+	if c.expired(doc, at) {
+		return nil
+	}
+	return doc
+}
+
+// History returns the revisions of a document that the scope is allowed to
+// see, oldest first. Deletion markers are included once any revision of
+// the document is visible.
+func (ds *DocumentStore) History(scope *Scope, projectID, collectionID, documentID string) ([]Revision, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	if collection.history == nil {
+		return nil, ErrVersioningDisabled
+	}
+
+	filter, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := ds.compile(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []Revision{}
+	visible := false
+	for _, revision := range collection.history[documentID] {
+		if !revision.Deleted {
+			if !allowed.Matches(revision.Data) {
+				continue
+			}
+			visible = true
+		}
+		revisions = append(revisions, revision)
+	}
+	if !visible {
+		return nil, ErrDocumentNotFound
+	}
+	return revisions, nil
+}
+
+// Revision returns one revision of a document by version.
+func (ds *DocumentStore) Revision(scope *Scope, projectID, collectionID, documentID string, version int) (Revision, error) {
+	return ds.findRevision(scope, projectID, collectionID, documentID, func(revision Revision) bool {
+		return revision.Version == version
+	})
+}
+
+// RevisionWrittenAt returns the revision of a document written at the given
+// time. Unlike versions, which each node numbers in the order it recorded
+// the revisions, write times are the same on every replica.
+func (ds *DocumentStore) RevisionWrittenAt(scope *Scope, projectID, collectionID, documentID string, updatedAt time.Time) (Revision, error) {
+	return ds.findRevision(scope, projectID, collectionID, documentID, func(revision Revision) bool {
+		return revision.UpdatedAt.Equal(updatedAt)
+	})
+}
+
+func (ds *DocumentStore) findRevision(scope *Scope, projectID, collectionID, documentID string, match func(Revision) bool) (Revision, error) {
+	revisions, err := ds.History(scope, projectID, collectionID, documentID)
+	if err != nil {
+		return Revision{}, err
+	}
+	for _, revision := range revisions {
+		if match(revision) {
+			return revision, nil
+		}
+	}
+	return Revision{}, ErrRevisionNotFound
+}
+
+// GetAt returns a document as it was at the given time.
+func (ds *DocumentStore) GetAt(scope *Scope, projectID, collectionID, documentID string, at time.Time) (*models.Document, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	if collection.history == nil {
+		return nil, ErrVersioningDisabled
+	}
+
+	doc := collection.versionedAt(documentID, at)
+	if doc == nil {
+		return nil, ErrDocumentNotFound
+	}
+
+	filter, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := ds.matches(doc.Data, filter); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrDocumentNotFound
+	}
+	return doc, nil
+}
+
+// QueryAt runs a query against the collection as it was at the given time.
+func (ds *DocumentStore) QueryAt(scope *Scope, projectID, collectionID string, filter map[string]interface{}, at time.Time) ([]*models.Document, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	if collection.history == nil {
+		return nil, ErrVersioningDisabled
+	}
+	// The text and 2dsphere indexes only know the current documents.
+	if search, _, err := query.SplitText(filter); err != nil {
+		return nil, err
+	} else if search != nil {
+		return nil, ErrTextSearchAsOf
+	}
+	if near, _, err := query.SplitNear(filter); err != nil {
+		return nil, err
+	} else if near != nil {
+		return nil, ErrNearAsOf
+	}
+
+	security, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]*models.Document, 0, len(collection.history))
+	for id := range collection.history {
+		if doc := collection.versionedAt(id, at); doc != nil {
+			documents = append(documents, doc)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		if !documents[i].CreatedAt.Equal(documents[j].CreatedAt) {
+			return documents[i].CreatedAt.Before(documents[j].CreatedAt)
+		}
+		return documents[i].ID < documents[j].ID
+	})
+
+	results, err := ds.querier.Execute(documents, query.And(filter, security))
+	if err != nil {
+		return nil, err
+	}
+
+	if docs, ok := results.([]*models.Document); ok {
+		return docs, nil
+	}
+
+	return nil, errors.New("invalid query result type")
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+)
+
+func newVersionedStore(t *testing.T, maxRevisions int) *DocumentStore {
+	t.Helper()
+	ds := NewStore()
+	if _, err := ds.CreateProject("p"); err != nil {
+		t.Fatal(err)
+	}
+	settings := CollectionSettings{Versioning: &VersioningSettings{MaxRevisions: maxRevisions}}
+	if _, err := ds.CreateCollection("p", "c", settings); err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func writeAt(t *testing.T, ds *DocumentStore, n float64, at time.Time) {
+	t.Helper()
+	doc := &models.Document{ID: "d", Data: map[string]interface{}{"n": n}, CreatedAt: at, UpdatedAt: at}
+	if err := ds.InsertWithID("p", "c", doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// versions returns the versions of the history of d in time order and the
+// value of n each one holds.
+func versions(t *testing.T, ds *DocumentStore) ([]int, map[int]float64) {
+	t.Helper()
+	revisions, err := ds.History(nil, "p", "c", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []int
+	values := make(map[int]float64)
+	for _, revision := range revisions {
+		order = append(order, revision.Version)
+		values[revision.Version], _ = revision.Data["n"].(float64)
+	}
+	return order, values
+}
+
+func TestVersionsAreStable(t *testing.T) {
+	ds := newVersionedStore(t, 0)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeAt(t, ds, 0, base)
+	writeAt(t, ds, 2, base.Add(2*time.Hour))
+	writeAt(t, ds, 3, base.Add(3*time.Hour))
+
+	before, err := ds.Revision(nil, "p", "c", "d", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A revision from between the first two arrives last, as a replicated
+	// write can.
+	ds.mu.Lock()
+	ds.Projects["p"].Collections["c"].recordRevision("d", Revision{
+		Data:      map[string]interface{}{"n": 1.0},
+		CreatedAt: base,
+		UpdatedAt: base.Add(time.Hour),
+	})
+	ds.mu.Unlock()
+
+	order, values := versions(t, ds)
+	if want := []int{1, 4, 2, 3}; !equalInts(order, want) {
+		t.Fatalf("versions in time order = %v, want %v", order, want)
+	}
+	if values[4] != 1 {
+		t.Errorf("version 4 holds n = %v, want the late revision", values[4])
+	}
+
+	after, err := ds.Revision(nil, "p", "c", "d", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.UpdatedAt.Equal(before.UpdatedAt) || after.Data["n"] != before.Data["n"] {
+		t.Errorf("version 2 changed from %+v to %+v", before, after)
+	}
+
+	late, err := ds.RevisionWrittenAt(nil, "p", "c", "d", base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if late.Version != 4 {
+		t.Errorf("revision written at 01:00 has version %d, want 4", late.Version)
+	}
+	if _, err := ds.RevisionWrittenAt(nil, "p", "c", "d", base.Add(time.Minute)); err != ErrRevisionNotFound {
+		t.Errorf("revision at a time nothing was written: err = %v, want ErrRevisionNotFound", err)
+	}
+
+	// Replaying a write keeps its version.
+	writeAt(t, ds, 3, base.Add(3*time.Hour))
+	if order, _ := versions(t, ds); !equalInts(order, []int{1, 4, 2, 3}) {
+		t.Errorf("versions after a replayed write = %v, want them unchanged", order)
+	}
+
+	// A revision as of any time returns the data written by then.
+	for hour, want := range []float64{0, 1, 2, 3} {
+		doc, err := ds.GetAt(nil, "p", "c", "d", base.Add(time.Duration(hour)*time.Hour+time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Data["n"] != want {
+			t.Errorf("n as of %02d:01 = %v, want %v", hour, doc.Data["n"], want)
+		}
+	}
+}
+
+func TestPrunedVersionsAreNotReused(t *testing.T) {
+	ds := newVersionedStore(t, 2)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		writeAt(t, ds, float64(i), base.Add(time.Duration(i)*time.Hour))
+	}
+	if order, values := versions(t, ds); !equalInts(order, []int{3, 4}) || values[4] != 3 {
+		t.Errorf("versions = %v (%v), want 3 and 4 holding n = 2 and 3", order, values)
+	}
+	if _, err := ds.Revision(nil, "p", "c", "d", 1); err != ErrRevisionNotFound {
+		t.Errorf("pruned version 1: err = %v, want ErrRevisionNotFound", err)
+	}
+}
+
+func TestHistoryOutlivesTombstones(t *testing.T) {
+	ds := newVersionedStore(t, 0)
+	created := time.Now().UTC().Add(-time.Hour)
+	writeAt(t, ds, 1, created)
+	deletedAt := time.Now().UTC().Add(-time.Minute)
+	if err := ds.ApplyDelete("p", "c", "d", deletedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed := ds.CollectTombstones(0); removed != 1 {
+		t.Fatalf("collected %d tombstone(s), want 1", removed)
+	}
+
+	doc, err := ds.GetAt(nil, "p", "c", "d", created.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc == nil || doc.Data["n"] != 1.0 {
+		t.Errorf("document as of before its deletion = %v, want n = 1", doc)
+	}
+	if order, _ := versions(t, ds); !equalInts(order, []int{1, 2}) {
+		t.Errorf("versions = %v, want the write and the deletion", order)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,325 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// hashIndex maps the values of its fields to the documents holding them.
+// A field is indexed under every value its path reaches through arrays of
+// documents, a value that is an array also under each element, and a
+// missing field under null, so an index lookup returns every document an
+// equality filter could match. Lookups only narrow the
+// candidates; the filter is still applied to each of them.
+type hashIndex struct {
+	spec    IndexSpec
+	entries map[string]map[string]bool
+	// keys remembers the keys of every document so an update can unindex
+	// the old data after the document was changed in place.
+	keys map[string][]string
+}
+
+func newHashIndex(spec IndexSpec) *hashIndex {
+	return &hashIndex{
+		spec:    spec,
+		entries: make(map[string]map[string]bool),
+		keys:    make(map[string][]string),
+	}
+}
+
+func (x *hashIndex) add(doc *models.Document) {
+	x.delete(doc.ID)
+
+	values := make([][]interface{}, len(x.spec.Fields))
+	for i, field := range x.spec.Fields {
+		values[i] = query.PathValues(doc.Data, field)
+	}
+
+	keys := combineKeys(values)
+	for _, key := range keys {
+		if x.entries[key] == nil {
+			x.entries[key] = make(map[string]bool)
+		}
+		x.entries[key][doc.ID] = true
+	}
+	x.keys[doc.ID] = keys
+}
+
+func (x *hashIndex) delete(documentID string) {
+	for _, key := range x.keys[documentID] {
+		delete(x.entries[key], documentID)
+		if len(x.entries[key]) == 0 {
+			delete(x.entries, key)
+		}
+	}
+	delete(x.keys, documentID)
+}
+
+// indexValues returns the values a field is indexed under.
+func indexValues(value interface{}) []interface{} {
+	values := []interface{}{value}
+	if items, ok := value.([]interface{}); ok {
+		values = append(values, items...)
+	}
+	return values
+}
+
+// combineKeys returns the key of every combination of one value per field.
+func combineKeys(values [][]interface{}) []string {
+	combinations := [][]interface{}{{}}
+	for _, options := range values {
+		var next [][]interface{}
+		for _, combination := range combinations {
+			for _, option := range options {
+				next = append(next, append(append([]interface{}{}, combination...), option))
+			}
+		}
+		combinations = next
+	}
+
+	seen := make(map[string]bool, len(combinations))
+	keys := make([]string, 0, len(combinations))
+	for _, combination := range combinations {
+		key := indexKey(combination)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// indexKey encodes one value per field, so that values equal in the
+// comparison order share a key.
+func indexKey(values []interface{}) string {
+	encoded, _ := json.Marshal(query.Canonical(values))
+	return string(encoded)
+}
+
+// applyIndexes rebuilds the indexes after the settings changed.
+func (c *Collection) applyIndexes() {
+	c.indexes = make(map[string]*hashIndex, len(c.Settings.Indexes))
+	c.geo = make(map[string]*geoIndex)
+	c.vectors = make(map[string]*vectorIndex)
+	c.text = nil
+	c.series = nil
+	if c.Settings.TimeSeries != nil {
+		c.series = newSeriesIndex(c.Settings.TimeSeries)
+	}
+	for _, spec := range c.Settings.Indexes {
+		switch spec.Type {
+		case IndexTypeText:
+			c.text = newTextIndex(spec)
+		case IndexType2dsphere:
+			c.geo[spec.Name] = newGeoIndex(spec)
+		case IndexTypeVector:
+			c.vectors[spec.Name] = newVectorIndex(spec)
+		default:
+			c.indexes[spec.Name] = newHashIndex(spec)
+		}
+	}
+	for _, doc := range c.Documents {
+		c.indexDocument(doc)
+	}
+}
+
+func (c *Collection) indexDocument(doc *models.Document) {
+	for _, index := range c.indexes {
+		index.add(doc)
+	}
+	for _, index := range c.geo {
+		index.add(doc)
+	}
+	for _, index := range c.vectors {
+		index.add(doc)
+	}
+	if c.text != nil {
+		c.text.add(doc)
+	}
+	if c.series != nil {
+		c.series.add(doc)
+	}
+}
+
+func (c *Collection) unindexDocument(documentID string) {
+	for _, index := range c.indexes {
+		index.delete(documentID)
+	}
+	for _, index := range c.geo {
+		index.delete(documentID)
+	}
+	for _, index := range c.vectors {
+		index.delete(documentID)
+	}
+	if c.text != nil {
+		c.text.delete(documentID)
+	}
+	if c.series != nil {
+		c.series.delete(documentID)
+	}
+}
+
+// equalities collects the fields a filter requires to equal one of a set
+// of values, from plain equality and $in conditions at the top level and
+// inside $and. Other conditions are left to the matcher.
+func equalities(filter map[string]interface{}, found map[string][]interface{}) {
+	for key, condition := range filter {
+		if query.Operator(key) == query.OpAnd {
+			clauses, _ := condition.([]interface{})
+			for _, clause := range clauses {
+				if sub, ok := clause.(map[string]interface{}); ok {
+					equalities(sub, found)
+				}
+			}
+			continue
+		}
+		if key == "" || key[0] == '$' {
+			continue
+		}
+
+		operators, isObject := condition.(map[string]interface{})
+		if !isObject || !hasOperators(operators) {
+			if _, exists := found[key]; !exists {
+				found[key] = []interface{}{condition}
+			}
+			continue
+		}
+		if values, ok := operators[string(query.OpIn)].([]interface{}); ok {
+			if _, exists := found[key]; !exists {
+				found[key] = values
+			}
+		}
+	}
+}
+
+func hasOperators(object map[string]interface{}) bool {
+	for key := range object {
+		if key != "" && key[0] == '$' {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the documents an index says may match the filter, in
+// insertion order, and the name of the index used. It returns false when
+// no index covers the filter and every document has to be scanned. The
+// caller must hold the lock.
+func (c *Collection) candidates(filter map[string]interface{}) ([]*models.Document, string, bool) {
+	if len(c.indexes) == 0 || filter == nil {
+		return nil, "", false
+	}
+
+	found := make(map[string][]interface{})
+	equalities(filter, found)
+
+	// Prefer the index covering the most fields, then the fewest lookups.
+	var best *hashIndex
+	bestLookups := 0
+	for _, index := range c.indexes {
+		lookups := 1
+		covered := true
+		for _, field := range index.spec.Fields {
+			values, exists := found[field]
+			if !exists {
+				covered = false
+				break
+			}
+			lookups *= len(values)
+		}
+		if !covered {
+			continue
+		}
+		if best == nil || len(index.spec.Fields) > len(best.spec.Fields) ||
+			(len(index.spec.Fields) == len(best.spec.Fields) && lookups < bestLookups) ||
+			(len(index.spec.Fields) == len(best.spec.Fields) && lookups == bestLookups && index.spec.Name < best.spec.Name) {
+			best, bestLookups = index, lookups
+		}
+	}
+	if best == nil {
+		return nil, "", false
+	}
+
+	values := make([][]interface{}, len(best.spec.Fields))
+	for i, field := range best.spec.Fields {
+		values[i] = found[field]
+	}
+	matched := make(map[string]bool)
+	for _, key := range combineKeys(values) {
+		for id := range best.entries[key] {
+			matched[id] = true
+		}
+	}
+	return c.inOrder(matched), best.spec.Name, true
+}
+
+// inOrder returns the documents with the given IDs in insertion order.
+func (c *Collection) inOrder(ids map[string]bool) []*models.Document {
+	documents := make([]*models.Document, 0, len(ids))
+	for id := range ids {
+		if doc, exists := c.Documents[id]; exists {
+			documents = append(documents, doc)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return c.sequence(documents[i].ID) < c.sequence(documents[j].ID)
+	})
+	return documents
+}
+
+// ranked returns the documents with the given values ordered by them,
+// keeping insertion order between equal values.
+func (c *Collection) ranked(values map[string]float64, before func(a, b float64) bool) []*models.Document {
+	ids := make(map[string]bool, len(values))
+	for id := range values {
+		ids[id] = true
+	}
+	documents := c.inOrder(ids)
+	sort.SliceStable(documents, func(i, j int) bool {
+		return before(values[documents[i].ID], values[documents[j].ID])
+	})
+	return documents
+}
+
+// sequence returns the position of a document in insertion order.
+func (c *Collection) sequence(documentID string) uint64 {
+	if element, exists := c.positions[documentID]; exists {
+		return element.Value.(*orderEntry).seq
+	}
+	return 0
+}
+
+// distinctFromIndex returns the distinct values of a field from a single
+// field index on it, calling visible to check that some document holding
+// a value may be returned. It returns false when there is no such index.
+func (c *Collection) distinctFromIndex(field string, visible func(doc *models.Document, value interface{}) bool) ([]interface{}, bool) {
+	var index *hashIndex
+	for _, candidate := range c.indexes {
+		if len(candidate.spec.Fields) == 1 && candidate.spec.Fields[0] == field {
+			if index == nil || candidate.spec.Name < index.spec.Name {
+				index = candidate
+			}
+		}
+	}
+	if index == nil {
+		return nil, false
+	}
+
+	var values []interface{}
+	for key, ids := range index.entries {
+		var decoded []interface{}
+		if err := json.Unmarshal([]byte(key), &decoded); err != nil || len(decoded) != 1 {
+			continue
+		}
+		for id := range ids {
+			if doc, exists := c.Documents[id]; exists && visible(doc, decoded[0]) {
+				values = append(values, decoded[0])
+				break
+			}
+		}
+	}
+	return values, true
+}
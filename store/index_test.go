@@ -0,0 +1,102 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// mixedValues hold every type, with dates and numbers written in more than
+// one form.
+var mixedValues = []string{
+	`null`, `1`, `1.0`, `-0`, `0`, `"2"`, `"10"`, `"2024-05-01"`,
+	`"2024-05-01T12:00:00+02:00"`, `"2024-05-01T10:00:00Z"`, `"2024-05-01T10:00:00"`,
+	`{"a": 1}`, `[1, 2]`, `true`, `false`,
+}
+
+// newMixedStore writes mixedValues to the field "v" of one collection with a
+// hash index on it and one without.
+func newMixedStore(t *testing.T) *DocumentStore {
+	t.Helper()
+	ds := NewStore()
+	if _, err := ds.CreateProject("p"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CreateCollection("p", "indexed", CollectionSettings{Indexes: []IndexSpec{{Fields: []string{"v"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CreateCollection("p", "scanned", CollectionSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range mixedValues {
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			t.Fatal(err)
+		}
+		for _, collection := range []string{"indexed", "scanned"} {
+			if _, err := ds.Create("p", collection, map[string]interface{}{"v": value, "raw": raw}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return ds
+}
+
+func matchedValues(t *testing.T, ds *DocumentStore, collection string, filter map[string]interface{}) []string {
+	t.Helper()
+	docs, err := ds.Query("p", collection, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raws := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		raws = append(raws, doc.Data["raw"].(string))
+	}
+	sort.Strings(raws)
+	return raws
+}
+
+func TestIndexAgreesWithComparisonOrder(t *testing.T) {
+	ds := newMixedStore(t)
+
+	for _, raw := range mixedValues {
+		filter := decodeFilter(t, `{"v": `+raw+`}`)
+		indexed := matchedValues(t, ds, "indexed", filter)
+		scanned := matchedValues(t, ds, "scanned", filter)
+		if !equalNames(indexed, scanned) {
+			t.Errorf("%s: index found %v, scan found %v", raw, indexed, scanned)
+		}
+	}
+
+	// One instant written in two zones shares an index key.
+	got := matchedValues(t, ds, "indexed", decodeFilter(t, `{"v": "2024-05-01T11:00:00+01:00"}`))
+	want := []string{`"2024-05-01T10:00:00Z"`, `"2024-05-01T12:00:00+02:00"`}
+	if !equalNames(got, want) {
+		t.Errorf("date lookup found %v, want %v", got, want)
+	}
+}
+
+func TestDistinctAgreesWithSort(t *testing.T) {
+	ds := newMixedStore(t)
+
+	for _, collection := range []string{"indexed", "scanned"} {
+		values, err := ds.DistinctAs(nil, "p", collection, "v", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(values); i++ {
+			if query.Compare(values[i-1], values[i]) >= 0 {
+				t.Errorf("%s: %v is listed before %v", collection, values[i-1], values[i])
+			}
+		}
+
+		// 1 and 1.0, -0 and 0, and the two forms of one instant collapse;
+		// null is left out and the array contributes its elements, of which
+		// 1 is already present.
+		if len(values) != 11 {
+			t.Errorf("%s: got %d distinct values %v, want 11", collection, len(values), values)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	ChangeCreateProject    = "create_project"
+	ChangeDropProject      = "drop_project"
+	ChangeRenameProject    = "rename_project"
+	ChangeCreateCollection = "create_collection"
+	ChangeDropCollection   = "drop_collection"
+	ChangeRenameCollection = "rename_collection"
+	ChangeUpdateSettings   = "update_settings"
+)
+
+// dropKey identifies a dropped project (empty collection) or collection.
+type dropKey struct {
+	project    string
+	collection string
+}
+
+type CollectionInfo struct {
+	ID        string `json:"_id"`
+	Documents int    `json:"documents"`
+	// Samples counts the samples of a time-series collection, whose
+	// documents are its buckets.
+	Samples  int                `json:"samples,omitempty"`
+	Settings CollectionSettings `json:"settings"`
+}
+
+// droppedAfter reports whether the project or collection was dropped at or
+// after t. The caller must hold the lock.
+func (ds *DocumentStore) droppedAfter(projectID, collectionID string, t time.Time) bool {
+	if droppedAt, ok := ds.drops[dropKey{project: projectID}]; ok && !t.After(droppedAt) {
+		return true
+	}
+	if droppedAt, ok := ds.drops[dropKey{project: projectID, collection: collectionID}]; ok && !t.After(droppedAt) {
+		return true
+	}
+	return false
+}
+
+func (ds *DocumentStore) markDropped(key dropKey, droppedAt time.Time) {
+	if current, ok := ds.drops[key]; !ok || droppedAt.After(current) {
+		ds.drops[key] = droppedAt
+	}
+}
+
+func (ds *DocumentStore) ListProjects() []string {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	projects := make([]string, 0, len(ds.Projects))
+	for id := range ds.Projects {
+		projects = append(projects, id)
+	}
+	sort.Strings(projects)
+	return projects
+}
+
+func (ds *DocumentStore) ListCollections(projectID string) ([]CollectionInfo, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	project, exists := ds.Projects[projectID]
+	if !exists {
+		return nil, ErrProjectNotFound
+	}
+
+	collections := make([]CollectionInfo, 0, len(project.Collections))
+	for _, collection := range project.Collections {
+		info := CollectionInfo{
+			ID:        collection.ID,
+			Documents: len(collection.Documents),
+			Settings:  collection.Settings,
+		}
+		if collection.series != nil {
+			info.Samples = collection.series.samples()
+		}
+		collections = append(collections, info)
+	}
+	sort.Slice(collections, func(i, j int) bool { return collections[i].ID < collections[j].ID })
+	return collections, nil
+}
+
+func (ds *DocumentStore) GetSettings(projectID, collectionID string) (CollectionSettings, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return CollectionSettings{}, err
+	}
+	return collection.Settings, nil
+}
+
+func (ds *DocumentStore) CreateProject(projectID string) (*Project, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, exists := ds.Projects[projectID]; exists {
+		return nil, ErrProjectExists
+	}
+
+	project := &Project{
+		ID:          projectID,
+		Collections: make(map[string]*Collection),
+	}
+
+	ds.Projects[projectID] = project
+	ds.changes.append(Change{Operation: ChangeCreateProject, Project: projectID, Timestamp: time.Now().UTC()})
+	return project, nil
+}
+
+// DropProject removes a project with all of its collections and returns the
+// drop time used to discard late replicated writes.
+func (ds *DocumentStore) DropProject(projectID string) (time.Time, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, exists := ds.Projects[projectID]; !exists {
+		return time.Time{}, ErrProjectNotFound
+	}
+
+	droppedAt := time.Now().UTC()
+	ds.dropProject(projectID, droppedAt)
+	return droppedAt, nil
+}
+
+func (ds *DocumentStore) RenameProject(projectID, newID string) (time.Time, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, exists := ds.Projects[projectID]; !exists {
+		return time.Time{}, ErrProjectNotFound
+	}
+	if _, exists := ds.Projects[newID]; exists {
+		return time.Time{}, ErrProjectExists
+	}
+
+	renamedAt := time.Now().UTC()
+	ds.renameProject(projectID, newID, renamedAt)
+	return renamedAt, nil
+}
+
+func (ds *DocumentStore) CreateCollection(projectID, collectionID string, settings CollectionSettings) (*Collection, error) {
+	if err := settings.Normalize(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	project, exists := ds.Projects[projectID]
+	if !exists {
+		return nil, ErrProjectNotFound
+	}
+
+	if _, exists := project.Collections[collectionID]; exists {
+		return nil, ErrCollectionExists
+	}
+
+	return ds.createCollection(projectID, collectionID, settings, ChangeCreateCollection, time.Now().UTC()), nil
+}
+
+func (ds *DocumentStore) DropCollection(projectID, collectionID string) (time.Time, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, err := ds.collection(projectID, collectionID); err != nil {
+		return time.Time{}, err
+	}
+
+	droppedAt := time.Now().UTC()
+	ds.dropCollection(projectID, collectionID, droppedAt)
+	return droppedAt, nil
+}
+
+func (ds *DocumentStore) RenameCollection(projectID, collectionID, newID string) (time.Time, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, err := ds.collection(projectID, collectionID); err != nil {
+		return time.Time{}, err
+	}
+	if _, exists := ds.Projects[projectID].Collections[newID]; exists {
+		return time.Time{}, ErrCollectionExists
+	}
+
+	renamedAt := time.Now().UTC()
+	ds.renameCollection(projectID, collectionID, newID, renamedAt)
+	return renamedAt, nil
+}
+
+func (ds *DocumentStore) UpdateSettings(projectID, collectionID string, settings CollectionSettings) (*Collection, error) {
+	if err := settings.Normalize(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	// The buckets of a time-series collection are laid out by its settings.
+	if !sameSeries(collection.Settings.TimeSeries, settings.TimeSeries) {
+		return nil, ErrTimeSeriesSettings
+	}
+
+	return ds.createCollection(projectID, collectionID, settings, ChangeUpdateSettings, time.Now().UTC()), nil
+}
+
+// applyLifecycle applies a replicated lifecycle change. Replicated changes
+// are idempotent: creating something that exists updates its settings and
+// dropping or renaming something that is already gone is a no-op. Creates
+// and settings updates older than a drop of the same name or than the
+// collection's current settings are discarded, like document writes.
+func (ds *DocumentStore) applyLifecycle(change Change) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	timestamp := change.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now().UTC()
+	}
+
+	switch change.Operation {
+	case ChangeCreateProject:
+		if ds.droppedAfter(change.Project, "", timestamp) {
+			return nil
+		}
+		if _, exists := ds.Projects[change.Project]; !exists {
+			ds.Projects[change.Project] = &Project{
+				ID:          change.Project,
+				Collections: make(map[string]*Collection),
+			}
+			ds.changes.append(Change{Operation: ChangeCreateProject, Project: change.Project, Timestamp: timestamp})
+		}
+	case ChangeDropProject:
+		ds.dropProject(change.Project, timestamp)
+	case ChangeRenameProject:
+		if _, exists := ds.Projects[change.Project]; !exists {
+			return nil
+		}
+		if _, exists := ds.Projects[change.Name]; exists {
+			return ErrProjectExists
+		}
+		ds.renameProject(change.Project, change.Name, timestamp)
+	case ChangeCreateCollection, ChangeUpdateSettings:
+		// A snapshot of a collection whose settings were never written
+		// carries no time, and must not replace settings that were.
+		timestamp = change.Timestamp
+		if ds.droppedAfter(change.Project, change.Collection, timestamp) {
+			return nil
+		}
+		if collection, err := ds.collection(change.Project, change.Collection); err == nil && timestamp.Before(collection.settingsAt) {
+			return nil
+		}
+		var settings CollectionSettings
+		if change.Settings != nil {
+			settings = *change.Settings
+		}
+		if err := settings.Normalize(); err != nil {
+			return err
+		}
+		ds.createCollection(change.Project, change.Collection, settings, change.Operation, timestamp)
+	case ChangeDropCollection:
+		ds.dropCollection(change.Project, change.Collection, timestamp)
+	case ChangeRenameCollection:
+		project, exists := ds.Projects[change.Project]
+		if !exists {
+			return nil
+		}
+		if _, exists := project.Collections[change.Collection]; !exists {
+			return nil
+		}
+		if _, exists := project.Collections[change.Name]; exists {
+			return ErrCollectionExists
+		}
+		ds.renameCollection(change.Project, change.Collection, change.Name, timestamp)
+	}
+
+	return nil
+}
+
+// The helpers below mutate the store and record the change. They must be
+// called with the write lock held.
+
+func (ds *DocumentStore) createCollection(projectID, collectionID string, settings CollectionSettings, operation string, createdAt time.Time) *Collection {
+	collection := ds.ensureCollection(projectID, collectionID)
+	collection.Settings = settings
+	collection.settingsAt = createdAt
+
+	ds.changes.append(Change{
+		Operation:  operation,
+		Project:    projectID,
+		Collection: collectionID,
+		Settings:   &settings,
+		Timestamp:  createdAt,
+	})
+	collection.applyIndexes()
+	collection.applyVersioning()
+	collection.applyCapped()
+	// Capping an existing collection trims it right away.
+	ds.enforceCap(projectID, collectionID, collection)
+	return collection
+}
+
+func (ds *DocumentStore) dropProject(projectID string, droppedAt time.Time) {
+	delete(ds.Projects, projectID)
+	ds.markDropped(dropKey{project: projectID}, droppedAt)
+	ds.changes.append(Change{Operation: ChangeDropProject, Project: projectID, Timestamp: droppedAt})
+}
+
+func (ds *DocumentStore) renameProject(projectID, newID string, renamedAt time.Time) {
+	project := ds.Projects[projectID]
+	delete(ds.Projects, projectID)
+	project.ID = newID
+	ds.Projects[newID] = project
+
+	ds.markDropped(dropKey{project: projectID}, renamedAt)
+	for key := range ds.drops {
+		if key.project == newID {
+			delete(ds.drops, key)
+		}
+	}
+	ds.changes.append(Change{Operation: ChangeRenameProject, Project: projectID, Name: newID, Timestamp: renamedAt})
+}
+
+func (ds *DocumentStore) dropCollection(projectID, collectionID string, droppedAt time.Time) {
+	if project, exists := ds.Projects[projectID]; exists {
+		delete(project.Collections, collectionID)
+	}
+	ds.markDropped(dropKey{project: projectID, collection: collectionID}, droppedAt)
+	ds.changes.append(Change{Operation: ChangeDropCollection, Project: projectID, Collection: collectionID, Timestamp: droppedAt})
+}
+
+func (ds *DocumentStore) renameCollection(projectID, collectionID, newID string, renamedAt time.Time) {
+	project := ds.Projects[projectID]
+	collection := project.Collections[collectionID]
+	delete(project.Collections, collectionID)
+	collection.ID = newID
+	project.Collections[newID] = collection
+
+	ds.markDropped(dropKey{project: projectID, collection: collectionID}, renamedAt)
+	delete(ds.drops, dropKey{project: projectID, collection: newID})
+	ds.changes.append(Change{Operation: ChangeRenameCollection, Project: projectID, Collection: collectionID, Name: newID, Timestamp: renamedAt})
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func settingsChange(maxRevisions int, at time.Time) Change {
+	return Change{
+		Operation:  ChangeUpdateSettings,
+		Project:    "p",
+		Collection: "c",
+		Settings:   &CollectionSettings{Versioning: &VersioningSettings{MaxRevisions: maxRevisions}},
+		Timestamp:  at,
+	}
+}
+
+func maxRevisions(t *testing.T, ds *DocumentStore) int {
+	t.Helper()
+	settings, err := ds.GetSettings("p", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Versioning == nil {
+		return -1
+	}
+	return settings.Versioning.MaxRevisions
+}
+
+func TestSettingsLastWriteWins(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	older, newer := settingsChange(5, base), settingsChange(10, base.Add(time.Second))
+
+	for _, order := range [][]Change{{older, newer}, {newer, older}} {
+		ds := NewStore()
+		for _, change := range order {
+			if err := ds.ApplyChange(change); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := maxRevisions(t, ds); got != 10 {
+			t.Fatalf("max_revisions is %d after %v then %v, want 10", got, order[0].Timestamp, order[1].Timestamp)
+		}
+	}
+}
+
+func TestSnapshotSettingsLastWriteWins(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ds := NewStore()
+	if err := ds.ApplyChange(settingsChange(10, base.Add(time.Second))); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := CollectionSettings{Versioning: &VersioningSettings{MaxRevisions: 5}}
+	chunks := []SnapshotChunk{
+		{Project: "p", Collection: "c", Settings: &stale, SettingsAt: &base},
+		// A collection created by a document write has never had settings.
+		{Project: "p", Collection: "c", Settings: &CollectionSettings{}, SettingsAt: &time.Time{}},
+	}
+	for _, chunk := range chunks {
+		if err := ds.ApplySnapshotChunk(chunk); err != nil {
+			t.Fatal(err)
+		}
+		if got := maxRevisions(t, ds); got != 10 {
+			t.Fatalf("max_revisions is %d after a snapshot from %v, want 10", got, chunk.SettingsAt)
+		}
+	}
+
+	later := base.Add(time.Minute)
+	fresh := CollectionSettings{Versioning: &VersioningSettings{MaxRevisions: 20}}
+	if err := ds.ApplySnapshotChunk(SnapshotChunk{Project: "p", Collection: "c", Settings: &fresh, SettingsAt: &later}); err != nil {
+		t.Fatal(err)
+	}
+	if got := maxRevisions(t, ds); got != 20 {
+		t.Fatalf("max_revisions is %d after a newer snapshot, want 20", got)
+	}
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// userVariable prefixes the placeholders a security filter may use, such as
+// "$$user.id", to refer to attributes of the caller.
+const userVariable = "$$user."
+
+// securityRoles are the roles a security filter can be attached to. They
+// mirror the roles of the auth package.
+var securityRoles = map[string]bool{"read": true, "write": true, "admin": true}
+
+var ErrScopeViolation = errors.New("document is outside the caller's security filter")
+
+// Scope describes the caller of a scoped operation: the role it holds on
+// the collection and the attributes "$$user." placeholders resolve to. A
+// nil scope is unrestricted and is used for internal and replicated writes.
+type Scope struct {
+	Role string
+	User map[string]interface{}
+}
+
+// securityFilter returns the collection's filter for the scope's role with
+// its placeholders bound, or nil when the role is not restricted.
+func (c *Collection) securityFilter(scope *Scope) (map[string]interface{}, error) {
+	if scope == nil {
+		return nil, nil
+	}
+
+	filter, exists := c.Settings.SecurityFilters[scope.Role]
+	if !exists {
+		return nil, nil
+	}
+
+	bound, err := bindUser(filter, scope.User)
+	if err != nil {
+		return nil, err
+	}
+	return bound.(map[string]interface{}), nil
+}
+
+// matches reports whether one document's data satisfies a bound security
+// filter. Callers that check many documents compile the filter once.
+func (ds *DocumentStore) matches(data map[string]interface{}, filter map[string]interface{}) (bool, error) {
+	return ds.querier.Matches(data, filter)
+}
+
+// compile compiles a bound security filter, or a query filter, once for
+// all the documents an operation checks against it.
+func (ds *DocumentStore) compile(filter map[string]interface{}) (*query.Filter, error) {
+	return ds.querier.Compile(filter)
+}
+
+// bindUser replaces "$$user." placeholders with the caller's attributes. A
+// placeholder for a missing attribute is an error rather than nil, which
+// would match every document lacking the field.
+func bindUser(value interface{}, user map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.HasPrefix(v, userVariable) {
+			return v, nil
+		}
+		resolved, ok := lookupPath(user, strings.TrimPrefix(v, userVariable))
+		if !ok {
+			return nil, fmt.Errorf("%w: caller has no attribute %q", ErrScopeViolation, strings.TrimPrefix(v, userVariable))
+		}
+		return resolved, nil
+
+	case map[string]interface{}:
+		bound := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved, err := bindUser(item, user)
+			if err != nil {
+				return nil, err
+			}
+			bound[key] = resolved
+		}
+		return bound, nil
+
+	case []interface{}:
+		bound := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := bindUser(item, user)
+			if err != nil {
+				return nil, err
+			}
+			bound[i] = resolved
+		}
+		return bound, nil
+	}
+	return value, nil
+}
+
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, current != nil
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/itsyaboikris/go_document_store/query"
+)
+
+// ErrInvalidSettings wraps the reason collection settings were rejected.
+var ErrInvalidSettings = errors.New("invalid collection settings")
+
+const (
+	IndexTypeHash     = "hash"
+	IndexTypeText     = "text"
+	IndexType2dsphere = "2dsphere"
+	IndexTypeVector   = "vector"
+)
+
+type IndexSpec struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+	Type   string   `json:"type,omitempty"`
+	// Weights scales the score of matches in each field of a text index.
+	// Fields without a weight count once.
+	Weights map[string]float64 `json:"weights,omitempty"`
+	// Language selects the analysis of a text index: "english", the
+	// default, or "none".
+	Language string `json:"language,omitempty"`
+	// Dimensions is the length of the vectors in a vector index, and Metric
+	// how they are compared: "cosine", the default, "dot" or "l2".
+	Dimensions int    `json:"dimensions,omitempty"`
+	Metric     string `json:"metric,omitempty"`
+}
+
+// CollectionSettings holds the per-collection configuration that is
+// replicated together with create and settings changes.
+type CollectionSettings struct {
+	Indexes []IndexSpec `json:"indexes,omitempty"`
+	// SecurityFilters maps a role to a filter that is ANDed onto every read
+	// and write by callers holding that role on the collection. Roles
+	// without a filter are unrestricted.
+	SecurityFilters map[string]map[string]interface{} `json:"security_filters,omitempty"`
+	// Validation attaches a JSON Schema that documents must match.
+	Validation *ValidationSettings `json:"validation,omitempty"`
+	// TTL expires documents after a fixed time or at a time they carry.
+	TTL *TTLSettings `json:"ttl,omitempty"`
+	// Capped bounds the collection and evicts the oldest documents.
+	Capped *CappedSettings `json:"capped,omitempty"`
+	// Versioning keeps prior revisions for history and point-in-time reads.
+	Versioning *VersioningSettings `json:"versioning,omitempty"`
+	// TimeSeries stores samples in compressed buckets instead of documents.
+	TimeSeries *TimeSeriesSettings `json:"time_series,omitempty"`
+}
+
+// Normalize fills in defaults and rejects settings that cannot be applied
+// with an error wrapping ErrInvalidSettings.
+func (s *CollectionSettings) Normalize() error {
+	if err := s.normalize(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	return nil
+}
+
+func (s *CollectionSettings) normalize() error {
+	names := make(map[string]bool)
+	textIndexes := 0
+	for i := range s.Indexes {
+		index := &s.Indexes[i]
+		if len(index.Fields) == 0 {
+			return errors.New("index must have at least one field")
+		}
+		if index.Type == "" {
+			index.Type = IndexTypeHash
+		}
+		if index.Type != IndexTypeText && (index.Weights != nil || index.Language != "") {
+			return errors.New("weights and language only apply to text indexes")
+		}
+		if index.Type != IndexTypeVector && (index.Dimensions != 0 || index.Metric != "") {
+			return errors.New("dimensions and metric only apply to vector indexes")
+		}
+		switch index.Type {
+		case IndexTypeHash:
+		case IndexType2dsphere:
+			if len(index.Fields) != 1 {
+				return errors.New("a 2dsphere index must have exactly one field")
+			}
+		case IndexTypeVector:
+			if err := index.normalizeVector(); err != nil {
+				return err
+			}
+		case IndexTypeText:
+			if textIndexes++; textIndexes > 1 {
+				return errors.New("a collection can have only one text index")
+			}
+			if err := index.normalizeText(); err != nil {
+				return err
+			}
+		default:
+			return errors.New("unsupported index type: " + index.Type)
+		}
+		if index.Name == "" {
+			index.Name = strings.Join(index.Fields, "_") + "_" + index.Type
+		}
+		if names[index.Name] {
+			return errors.New("duplicate index name: " + index.Name)
+		}
+		names[index.Name] = true
+	}
+
+	querier := query.NewQuery()
+	for role, filter := range s.SecurityFilters {
+		if !securityRoles[role] {
+			return errors.New("security filter for unknown role: " + role)
+		}
+		if err := querier.Validate(filter); err != nil {
+			return fmt.Errorf("invalid security filter for %s: %v", role, err)
+		}
+	}
+
+	if s.Validation != nil {
+		if err := s.Validation.normalize(); err != nil {
+			return fmt.Errorf("invalid validation settings: %v", err)
+		}
+	}
+
+	if s.TTL != nil {
+		if err := s.TTL.normalize(); err != nil {
+			return fmt.Errorf("invalid ttl settings: %v", err)
+		}
+	}
+
+	if s.Capped != nil {
+		if err := s.Capped.normalize(); err != nil {
+			return fmt.Errorf("invalid capped settings: %v", err)
+		}
+	}
+
+	if s.Versioning != nil {
+		if err := s.Versioning.normalize(); err != nil {
+			return fmt.Errorf("invalid versioning settings: %v", err)
+		}
+	}
+
+	if s.TimeSeries != nil {
+		if err := s.TimeSeries.normalize(); err != nil {
+			return fmt.Errorf("invalid time_series settings: %v", err)
+		}
+		if len(s.Indexes) > 0 || s.TTL != nil || s.Capped != nil || s.Versioning != nil {
+			return errors.New("time-series collections cannot have indexes, ttl, capped or versioning settings")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+)
+
+// SnapshotChunk holds part of one collection. The first chunk of every
+// collection carries its settings and when they were written, and empty
+// collections produce a single chunk without documents so they are
+// recreated on the receiver.
+type SnapshotChunk struct {
+	Project    string               `json:"project"`
+	Collection string               `json:"collection"`
+	Settings   *CollectionSettings  `json:"settings,omitempty"`
+	SettingsAt *time.Time           `json:"settings_at,omitempty"`
+	Documents  []*models.Document   `json:"documents"`
+	Tombstones map[string]time.Time `json:"tombstones,omitempty"`
+}
+
+// Snapshot is a consistent copy of the whole store taken at a change log
+// position. Taking it only copies the document headers under the lock; the
+// chunks are built afterwards, one at a time, as they are sent.
+type Snapshot struct {
+	Seq         uint64
+	collections []snapshotCollection
+}
+
+type snapshotCollection struct {
+	project    string
+	collection string
+	settings   CollectionSettings
+	settingsAt time.Time
+	documents  []models.Document
+	tombstones map[string]time.Time
+}
+
+// Snapshot copies the store. Document data is shared rather than copied,
+// which is safe because writes replace a document's data instead of
+// changing it.
+func (ds *DocumentStore) Snapshot() *Snapshot {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	snapshot := &Snapshot{Seq: ds.changes.seq}
+	for projectID, project := range ds.Projects {
+		for collectionID, collection := range project.Collections {
+			copied := snapshotCollection{
+				project:    projectID,
+				collection: collectionID,
+				settings:   collection.Settings,
+				settingsAt: collection.settingsAt,
+			}
+			ordered := collection.ordered()
+			copied.documents = make([]models.Document, len(ordered))
+			for i, doc := range ordered {
+				copied.documents[i] = *doc
+			}
+			if len(collection.Tombstones) > 0 {
+				copied.tombstones = make(map[string]time.Time, len(collection.Tombstones))
+				for id, deletedAt := range collection.Tombstones {
+					copied.tombstones[id] = deletedAt
+				}
+			}
+			snapshot.collections = append(snapshot.collections, copied)
+		}
+	}
+	return snapshot
+}
+
+// Chunks passes the snapshot to emit in chunks of at most chunkSize
+// documents, stopping at the first error emit returns.
+func (s *Snapshot) Chunks(chunkSize int, emit func(SnapshotChunk) error) error {
+	if chunkSize <= 0 {
+		chunkSize = 500
+	}
+
+	for i := range s.collections {
+		c := &s.collections[i]
+		chunk := SnapshotChunk{
+			Project:    c.project,
+			Collection: c.collection,
+			Settings:   &c.settings,
+			SettingsAt: &c.settingsAt,
+			Tombstones: c.tombstones,
+		}
+		for start := 0; ; start += chunkSize {
+			end := min(start+chunkSize, len(c.documents))
+			chunk.Documents = make([]*models.Document, 0, end-start)
+			for j := start; j < end; j++ {
+				chunk.Documents = append(chunk.Documents, &c.documents[j])
+			}
+			if err := emit(chunk); err != nil {
+				return err
+			}
+			if end == len(c.documents) {
+				break
+			}
+			chunk = SnapshotChunk{Project: c.project, Collection: c.collection}
+		}
+	}
+	return nil
+}
+
+// ApplySnapshotChunk loads a chunk received from a peer. Documents and
+// tombstones go through the same last-write-wins rules as replicated writes.
+func (ds *DocumentStore) ApplySnapshotChunk(chunk SnapshotChunk) error {
+	for id, deletedAt := range chunk.Tombstones {
+		if err := ds.ApplyDelete(chunk.Project, chunk.Collection, id, deletedAt); err != nil {
+			return err
+		}
+	}
+
+	if chunk.Settings != nil || len(chunk.Documents) == 0 {
+		var settingsAt time.Time
+		if chunk.SettingsAt != nil {
+			settingsAt = *chunk.SettingsAt
+		}
+		err := ds.applyLifecycle(Change{
+			Operation:  ChangeCreateCollection,
+			Project:    chunk.Project,
+			Collection: chunk.Collection,
+			Settings:   chunk.Settings,
+			Timestamp:  settingsAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, doc := range chunk.Documents {
+		if err := ds.InsertWithID(chunk.Project, chunk.Collection, doc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyChange applies a change read from a peer's change log.
+func (ds *DocumentStore) ApplyChange(change Change) error {
+	switch change.Operation {
+	case ChangeDelete:
+		return ds.ApplyDelete(change.Project, change.Collection, change.ID, change.DeletedAt)
+	case ChangeUpsert:
+		if change.Document == nil {
+			return nil
+		}
+		doc := *change.Document
+		return ds.InsertWithID(change.Project, change.Collection, &doc)
+	default:
+		return ds.applyLifecycle(change)
+	}
+}
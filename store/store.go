@@ -1,7 +1,9 @@
 package store
 
 import (
+	"container/list"
 	"errors"
+	"log"
 	"sync"
 	"time"
 
@@ -10,9 +12,54 @@ import (
 	"github.com/itsyaboikris/go_document_store/query"
 )
 
+var (
+	ErrProjectNotFound    = errors.New("project not found")
+	ErrProjectExists      = errors.New("project already exists")
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrCollectionExists   = errors.New("collection already exists")
+	ErrDocumentNotFound   = errors.New("document not found")
+)
+
 type Collection struct {
 	ID        string                      `json:"_id"`
 	Documents map[string]*models.Document `json:"documents"`
+	Settings  CollectionSettings          `json:"settings"`
+	// Tombstones records when each deleted document was removed so a late
+	// replicated create or update cannot bring it back.
+	Tombstones map[string]time.Time `json:"tombstones"`
+	// settingsAt is when the settings were last written, so an older
+	// replicated settings change cannot replace newer ones. It is zero for
+	// collections created by a document write.
+	settingsAt time.Time
+
+	// order keeps the documents in insertion order for capped collections
+	// and tailing readers; positions indexes it by document ID.
+	order     *list.List
+	positions map[string]*list.Element
+	nextSeq   uint64
+	bytes     int
+	// evictions holds the documents of a capped collection in the order
+	// they are evicted; it is nil otherwise.
+	evictions evictionQueue
+
+	// history holds the revisions of every document while versioning is
+	// enabled and is nil otherwise. versions holds the last version number
+	// given to a revision of each document, so numbers are never reused.
+	history  map[string][]Revision
+	versions map[string]int
+
+	// indexes holds the hash index built for each IndexSpec, by name, geo
+	// the 2dsphere indexes, vectors the vector indexes, and text the text
+	// index if there is one.
+	indexes map[string]*hashIndex
+	geo     map[string]*geoIndex
+	vectors map[string]*vectorIndex
+	text    *textIndex
+
+	// series indexes the buckets of a time-series collection and open
+	// holds the bucket this node appends to for each series.
+	series *seriesIndex
+	open   map[string]string
 }
 
 type Project struct {
@@ -24,21 +71,42 @@ type DocumentStore struct {
 	Projects map[string]*Project `json:"projects"`
 	mu       sync.RWMutex
 	querier  *query.Query
+	changes  *changeLog
+	// drops records when projects and collections were dropped or renamed
+	// away so late replicated writes for them are discarded.
+	drops map[dropKey]time.Time
+}
+
+func newCollection(collectionID string) *Collection {
+	return &Collection{
+		ID:         collectionID,
+		Documents:  make(map[string]*models.Document),
+		Tombstones: make(map[string]time.Time),
+		order:      list.New(),
+		positions:  make(map[string]*list.Element),
+	}
 }
 
 func NewStore() *DocumentStore {
+	return NewStoreWithChangeLog(100000)
+}
+
+// NewStoreWithChangeLog creates a store that keeps at least the given
+// number of changes for peers tailing it.
+func NewStoreWithChangeLog(size int) *DocumentStore {
 	return &DocumentStore{
 		Projects: make(map[string]*Project),
 		querier:  query.NewQuery(),
+		changes:  newChangeLog(size),
+		drops:    make(map[dropKey]time.Time),
 	}
 }
 
-func (ds *DocumentStore) Create(projectID, collectionID string, document map[string]interface{}) (*models.Document, error) {
-	ds.mu.Lock()
-	defer ds.mu.Unlock()
-
-	project, exist := ds.Projects[projectID]
-	if !exist {
+// ensureCollection returns the collection, creating it and its project if
+// needed. The caller must hold the write lock.
+func (ds *DocumentStore) ensureCollection(projectID, collectionID string) *Collection {
+	project, exists := ds.Projects[projectID]
+	if !exists {
 		project = &Project{
 			ID:          projectID,
 			Collections: make(map[string]*Collection),
@@ -48,115 +116,202 @@ func (ds *DocumentStore) Create(projectID, collectionID string, document map[str
 
 	collection, exists := project.Collections[collectionID]
 	if !exists {
-		collection = &Collection{
-			ID:        collectionID,
-			Documents: make(map[string]*models.Document),
-		}
+		collection = newCollection(collectionID)
 		project.Collections[collectionID] = collection
 	}
 
-	now := time.Now().UTC()
-	doc := &models.Document{
-		ID:        uuid.New().String(),
-		Data:      document,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-
-	collection.Documents[doc.ID] = doc
-
-	return doc, nil
+	return collection
 }
 
-func (ds *DocumentStore) Get(projectID, collectionID, documentID string) (*models.Document, error) {
-	ds.mu.RLock()
-	defer ds.mu.RUnlock()
-
+// collection looks up an existing collection. The caller must hold the lock.
+func (ds *DocumentStore) collection(projectID, collectionID string) (*Collection, error) {
 	project, exists := ds.Projects[projectID]
 	if !exists {
-		return nil, errors.New("project not found")
+		return nil, ErrProjectNotFound
 	}
 
 	collection, exists := project.Collections[collectionID]
 	if !exists {
-		return nil, errors.New("collection not found")
+		return nil, ErrCollectionNotFound
 	}
 
-	doc, exists := collection.Documents[documentID]
-	if !exists {
-		return nil, errors.New("document not found")
+	return collection, nil
+}
+
+func (ds *DocumentStore) Create(projectID, collectionID string, document map[string]interface{}) (*models.Document, error) {
+	return ds.CreateAs(nil, projectID, collectionID, document)
+}
+
+// CreateAs creates a document on behalf of scope. The document must satisfy
+// the scope's security filter.
+func (ds *DocumentStore) CreateAs(scope *Scope, projectID, collectionID string, document map[string]interface{}) (*models.Document, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	collection := ds.ensureCollection(projectID, collectionID)
+	if collection.Settings.TimeSeries != nil {
+		return nil, ErrTimeSeriesWrite
 	}
 
-	return doc, nil
+	filter, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := ds.matches(document, filter); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrScopeViolation
+	}
+	if err := collection.checkSchema(document, false); err != nil {
+		return nil, err
+	}
+	if err := collection.admit(document); err != nil {
+		return nil, err
+	}
+
+	return ds.insertNew(projectID, collectionID, collection, document), nil
 }
 
-func (ds *DocumentStore) GetAll(projectID, collectionID string) ([]*models.Document, error) {
+// insertNew stores data as a new document with a generated ID. The caller
+// must hold the write lock.
+func (ds *DocumentStore) insertNew(projectID, collectionID string, collection *Collection, data map[string]interface{}) *models.Document {
+	now := time.Now().UTC()
+	doc := &models.Document{
+		ID:        uuid.New().String(),
+		Data:      data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	collection.put(doc)
+	ds.recordUpsert(projectID, collectionID, doc)
+	ds.enforceCap(projectID, collectionID, collection)
+	return doc
+}
+
+func (ds *DocumentStore) Get(projectID, collectionID, documentID string) (*models.Document, error) {
+	return ds.GetAs(nil, projectID, collectionID, documentID)
+}
+
+// GetAs returns a document only if it satisfies the scope's security filter.
+// Documents outside the filter are reported as not found.
+func (ds *DocumentStore) GetAs(scope *Scope, projectID, collectionID, documentID string) (*models.Document, error) {
 	ds.mu.RLock()
 	defer ds.mu.RUnlock()
 
-	project, exists := ds.Projects[projectID]
-	if !exists {
-		return nil, errors.New("project not found")
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
 	}
 
-	collection, exists := project.Collections[collectionID]
-	if !exists {
-		return nil, errors.New("collection not found")
-	}
+	return ds.visibleDocument(collection, scope, documentID)
+}
 
-	docs := make([]*models.Document, 0, len(collection.Documents))
-	for _, doc := range collection.Documents {
-		docs = append(docs, doc)
-	}
+func (ds *DocumentStore) GetAll(projectID, collectionID string) ([]*models.Document, error) {
+	return ds.GetAllAs(nil, projectID, collectionID)
+}
 
-	return docs, nil
+func (ds *DocumentStore) GetAllAs(scope *Scope, projectID, collectionID string) ([]*models.Document, error) {
+	return ds.QueryAs(scope, projectID, collectionID, nil)
 }
 
 func (ds *DocumentStore) Update(projectID, collectionID, documentID string, data map[string]interface{}) (*models.Document, error) {
+	return ds.UpdateAs(nil, projectID, collectionID, documentID, data)
+}
+
+// UpdateAs replaces a document visible to scope. The new data must still
+// satisfy the security filter, so a caller cannot hand a document over to
+// someone else.
+func (ds *DocumentStore) UpdateAs(scope *Scope, projectID, collectionID, documentID string, data map[string]interface{}) (*models.Document, error) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 
-	project, exists := ds.Projects[projectID]
-	if !exists {
-		return nil, errors.New("project not found")
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	if collection.Settings.TimeSeries != nil {
+		return nil, ErrTimeSeriesWrite
 	}
 
-	collection, exists := project.Collections[collectionID]
-	if !exists {
-		return nil, errors.New("collection not found")
+	doc, err := ds.visibleDocument(collection, scope, documentID)
+	if err != nil {
+		return nil, err
 	}
 
-	doc, exists := collection.Documents[documentID]
-	if !exists {
-		return nil, errors.New("document not found")
+	filter, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := ds.matches(data, filter); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrScopeViolation
+	}
+	if err := collection.checkSchema(data, false); err != nil {
+		return nil, err
+	}
+	if err := collection.admit(data); err != nil {
+		return nil, err
 	}
 
 	doc.Data = data
 	doc.UpdatedAt = time.Now().UTC()
+	collection.put(doc)
+	ds.recordUpsert(projectID, collectionID, doc)
+	ds.enforceCap(projectID, collectionID, collection)
 
 	return doc, nil
 }
 
-func (ds *DocumentStore) Delete(projectID, collectionID, documentID string) error {
+// Delete removes a document and leaves a tombstone behind. It returns the
+// deletion time recorded in the tombstone.
+func (ds *DocumentStore) Delete(projectID, collectionID, documentID string) (time.Time, error) {
+	return ds.DeleteAs(nil, projectID, collectionID, documentID)
+}
+
+func (ds *DocumentStore) DeleteAs(scope *Scope, projectID, collectionID, documentID string) (time.Time, error) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 
-	project, exists := ds.Projects[projectID]
-	if !exists {
-		return errors.New("project not found")
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if collection.Settings.TimeSeries != nil {
+		return time.Time{}, ErrTimeSeriesWrite
 	}
 
-	collection, exists := project.Collections[collectionID]
-	if !exists {
-		return errors.New("collection not found")
+	if _, err := ds.visibleDocument(collection, scope, documentID); err != nil {
+		return time.Time{}, err
 	}
 
-	if _, exists := collection.Documents[documentID]; !exists {
-		return errors.New("document not found")
+	deletedAt := time.Now().UTC()
+	collection.remove(documentID, deletedAt)
+	collection.Tombstones[documentID] = deletedAt
+	ds.recordDelete(projectID, collectionID, documentID, deletedAt)
+	return deletedAt, nil
+}
+
+// visibleDocument looks up a document the scope is allowed to see. The
+// caller must hold the lock.
+func (ds *DocumentStore) visibleDocument(collection *Collection, scope *Scope, documentID string) (*models.Document, error) {
+	doc, exists := collection.Documents[documentID]
+	if !exists || collection.expired(doc, time.Now().UTC()) {
+		return nil, ErrDocumentNotFound
 	}
 
-	delete(collection.Documents, documentID)
-	return nil
+	filter, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := ds.matches(doc.Data, filter); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrDocumentNotFound
+	}
+
+	return doc, nil
 }
 
 // replication
@@ -164,112 +319,206 @@ func (ds *DocumentStore) InsertWithID(projectID, collectionID string, doc *model
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 
-	// Ensure project exists
-	project, exists := ds.Projects[projectID]
-	if !exists {
-		project = &Project{
-			ID:          projectID,
-			Collections: make(map[string]*Collection),
+	if doc.CreatedAt.IsZero() {
+		doc.CreatedAt = time.Now().UTC()
+	}
+	if doc.UpdatedAt.IsZero() {
+		doc.UpdatedAt = doc.CreatedAt
+	}
+
+	if ds.droppedAfter(projectID, collectionID, doc.UpdatedAt) {
+		return nil
+	}
+
+	collection := ds.ensureCollection(projectID, collectionID)
+	// The schema of a time-series collection applies to its samples, which
+	// were checked when they were inserted, rather than to its buckets.
+	if collection.Settings.TimeSeries == nil {
+		if err := collection.checkSchema(doc.Data, true); err != nil {
+			return err
 		}
-		ds.Projects[projectID] = project
+	}
+	if err := collection.admit(doc.Data); err != nil {
+		return err
 	}
 
-	// Ensure collection exists
-	collection, exists := project.Collections[collectionID]
-	if !exists {
-		collection = &Collection{
-			ID:        collectionID,
-			Documents: make(map[string]*models.Document),
+	// A write that is not newer than the deletion arrived late and must not
+	// resurrect the document.
+	if deletedAt, deleted := collection.Tombstones[doc.ID]; deleted {
+		if !doc.UpdatedAt.After(deletedAt) {
+			return nil
 		}
-		project.Collections[collectionID] = collection
+		delete(collection.Tombstones, doc.ID)
 	}
 
 	existingDoc, exists := collection.Documents[doc.ID]
 	if exists {
+		if doc.UpdatedAt.Before(existingDoc.UpdatedAt) {
+			return nil
+		}
 		existingDoc.Data = doc.Data
-		existingDoc.UpdatedAt = time.Now().UTC()
+		existingDoc.UpdatedAt = doc.UpdatedAt
+		collection.put(existingDoc)
+		ds.recordUpsert(projectID, collectionID, existingDoc)
 	} else {
-		if doc.CreatedAt.IsZero() {
-			doc.CreatedAt = time.Now().UTC()
-		}
-		if doc.UpdatedAt.IsZero() {
-			doc.UpdatedAt = doc.CreatedAt
-		}
-		collection.Documents[doc.ID] = doc
+		collection.put(doc)
+		ds.recordUpsert(projectID, collectionID, doc)
 	}
+	ds.enforceCap(projectID, collectionID, collection)
 
 	return nil
 }
 
-// helpers
-
-// Helper functions for managing projects and collections
-func (ds *DocumentStore) CreateProject(projectID string) (*Project, error) {
+// ApplyDelete applies a replicated delete. The tombstone is recorded even
+// when the document has not arrived yet so its create is discarded later.
+func (ds *DocumentStore) ApplyDelete(projectID, collectionID, documentID string, deletedAt time.Time) error {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 
-	if _, exists := ds.Projects[projectID]; exists {
-		return nil, errors.New("project already exists")
+	if ds.droppedAfter(projectID, collectionID, deletedAt) {
+		return nil
 	}
 
-	project := &Project{
-		ID:          projectID,
-		Collections: make(map[string]*Collection),
+	collection := ds.ensureCollection(projectID, collectionID)
+
+	if doc, exists := collection.Documents[documentID]; exists {
+		if doc.UpdatedAt.After(deletedAt) {
+			return nil
+		}
+		collection.remove(documentID, deletedAt)
 	}
 
-	ds.Projects[projectID] = project
-	return project, nil
+	if current, exists := collection.Tombstones[documentID]; !exists || deletedAt.After(current) {
+		collection.Tombstones[documentID] = deletedAt
+		ds.recordDelete(projectID, collectionID, documentID, deletedAt)
+	}
+
+	return nil
 }
 
-func (ds *DocumentStore) CreateCollection(projectID, collectionID string) (*Collection, error) {
+// CollectTombstones drops tombstones and drop markers older than the grace
+// period and returns how many were removed. The history of a deleted
+// document is kept, bounded by max_revisions like any other.
+func (ds *DocumentStore) CollectTombstones(gracePeriod time.Duration) int {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 
-	project, exists := ds.Projects[projectID]
-	if !exists {
-		return nil, errors.New("project not found")
+	cutoff := time.Now().UTC().Add(-gracePeriod)
+	removed := 0
+	for _, project := range ds.Projects {
+		for _, collection := range project.Collections {
+			for id, deletedAt := range collection.Tombstones {
+				if deletedAt.Before(cutoff) {
+					delete(collection.Tombstones, id)
+					removed++
+				}
+			}
+		}
 	}
 
-	if _, exists := project.Collections[collectionID]; exists {
-		return nil, errors.New("collection already exists")
+	for key, droppedAt := range ds.drops {
+		if droppedAt.Before(cutoff) {
+			delete(ds.drops, key)
+			removed++
+		}
 	}
 
-	collection := &Collection{
-		ID:        collectionID,
-		Documents: make(map[string]*models.Document),
-	}
+	return removed
+}
 
-	project.Collections[collectionID] = collection
-	return collection, nil
+func (ds *DocumentStore) StartTombstoneGC(gracePeriod, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if removed := ds.CollectTombstones(gracePeriod); removed > 0 {
+				log.Printf("Garbage collected %d tombstone(s)", removed)
+			}
+		}
+	}()
 }
 
 func (ds *DocumentStore) Query(projectID, collectionID string, filter map[string]interface{}) ([]*models.Document, error) {
+	return ds.QueryAs(nil, projectID, collectionID, filter)
+}
+
+// QueryAs runs the filter ANDed with the scope's security filter.
+func (ds *DocumentStore) QueryAs(scope *Scope, projectID, collectionID string, filter map[string]interface{}) ([]*models.Document, error) {
 	ds.mu.RLock()
 	defer ds.mu.RUnlock()
 
-	project, exists := ds.Projects[projectID]
-	if !exists {
-		return nil, errors.New("project not found")
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
 	}
 
-	collection, exists := project.Collections[collectionID]
-	if !exists {
-		return nil, errors.New("collection not found")
+	security, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
 	}
 
-	documents := make([]*models.Document, 0, len(collection.Documents))
-	for _, doc := range collection.Documents {
-		documents = append(documents, doc)
+	documents, _, err := ds.find(collection, query.And(filter, security))
+	return documents, err
+}
+
+// find returns the live documents matching the filter, reading only the
+// candidates of an index when one covers the filter. With a $text condition
+// the candidates come from the text index and are returned best match
+// first together with their scores, and with a $near condition they come
+// from a 2dsphere index and are returned nearest first. Otherwise they are
+// in insertion order and the scores are nil. The caller must hold the lock.
+func (ds *DocumentStore) find(collection *Collection, filter map[string]interface{}) ([]*models.Document, map[string]float64, error) {
+	search, filter, err := query.SplitText(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	near, filter, err := query.SplitNear(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var candidates []*models.Document
+	var scores map[string]float64
+	switch {
+	case search != nil && near != nil:
+		return nil, nil, ErrTextWithNear
+	case search != nil:
+		if scores, err = collection.textSearch(search); err != nil {
+			return nil, nil, err
+		}
+		candidates = collection.byScore(scores)
+	case near != nil:
+		distances, _, err := collection.nearSearch(near)
+		if err != nil {
+			return nil, nil, err
+		}
+		candidates = collection.byDistance(distances)
+	default:
+		var indexed bool
+		if candidates, _, indexed = collection.candidates(filter); !indexed {
+			if candidates, indexed = collection.geoCandidates(filter); !indexed {
+				candidates = collection.ordered()
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	documents := make([]*models.Document, 0, len(candidates))
+	for _, doc := range candidates {
+		if !collection.expired(doc, now) {
+			documents = append(documents, doc)
+		}
 	}
 
 	results, err := ds.querier.Execute(documents, filter)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if docs, ok := results.([]*models.Document); ok {
-		return docs, nil
+		return docs, scores, nil
 	}
 
-	return nil, errors.New("invalid query result type")
+	return nil, nil, errors.New("invalid query result type")
 }
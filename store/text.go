@@ -0,0 +1,267 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+	"github.com/itsyaboikris/go_document_store/text"
+)
+
+var ErrTextIndexRequired = errors.New("$text requires a text index on the collection")
+
+// BM25 parameters: k1 limits how much repeating a term raises the score and
+// b how much long documents are penalised.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+func (index *IndexSpec) normalizeText() error {
+	if index.Language == "" {
+		index.Language = text.English
+	}
+	if !text.ValidLanguage(index.Language) {
+		return errors.New("unsupported text index language: " + index.Language)
+	}
+	for field, weight := range index.Weights {
+		if weight <= 0 {
+			return fmt.Errorf("weight of %s must be positive", field)
+		}
+		indexed := false
+		for _, f := range index.Fields {
+			indexed = indexed || f == field
+		}
+		if !indexed {
+			return fmt.Errorf("weight for %s, which is not indexed", field)
+		}
+	}
+	return nil
+}
+
+// textIndex is an inverted index from the terms of the indexed string
+// fields to the documents holding them, with the weighted term frequencies
+// and document lengths BM25 needs.
+type textIndex struct {
+	spec     IndexSpec
+	postings map[string]map[string]float64
+	terms    map[string]map[string]float64
+	lengths  map[string]float64
+	total    float64
+}
+
+func newTextIndex(spec IndexSpec) *textIndex {
+	return &textIndex{
+		spec:     spec,
+		postings: make(map[string]map[string]float64),
+		terms:    make(map[string]map[string]float64),
+		lengths:  make(map[string]float64),
+	}
+}
+
+func (x *textIndex) weight(field string) float64 {
+	if weight, ok := x.spec.Weights[field]; ok {
+		return weight
+	}
+	return 1
+}
+
+func (x *textIndex) add(doc *models.Document) {
+	x.delete(doc.ID)
+
+	frequencies := make(map[string]float64)
+	length := 0.0
+	for _, field := range x.spec.Fields {
+		weight := x.weight(field)
+		for _, s := range fieldStrings(doc.Data, field) {
+			for _, term := range text.Analyze(s, x.spec.Language) {
+				frequencies[term] += weight
+				length += weight
+			}
+		}
+	}
+	if len(frequencies) == 0 {
+		return
+	}
+
+	for term, frequency := range frequencies {
+		if x.postings[term] == nil {
+			x.postings[term] = make(map[string]float64)
+		}
+		x.postings[term][doc.ID] = frequency
+	}
+	x.terms[doc.ID] = frequencies
+	x.lengths[doc.ID] = length
+	x.total += length
+}
+
+func (x *textIndex) delete(documentID string) {
+	for term := range x.terms[documentID] {
+		delete(x.postings[term], documentID)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+		}
+	}
+	x.total -= x.lengths[documentID]
+	delete(x.terms, documentID)
+	delete(x.lengths, documentID)
+}
+
+// fieldStrings returns the strings a field holds, directly or as elements
+// of an array.
+func fieldStrings(data map[string]interface{}, field string) []string {
+	switch value := getField(data, field).(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var strs []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+// score is the BM25 relevance of a document to the query terms.
+func (x *textIndex) score(documentID string, terms []string) float64 {
+	n := float64(len(x.lengths))
+	average := x.total / n
+	length := x.lengths[documentID]
+
+	score := 0.0
+	for _, term := range terms {
+		frequency := x.postings[term][documentID]
+		if frequency == 0 {
+			continue
+		}
+		holders := float64(len(x.postings[term]))
+		idf := math.Log(1 + (n-holders+0.5)/(holders+0.5))
+		score += idf * frequency * (bm25K1 + 1) / (frequency + bm25K1*(1-bm25B+bm25B*length/average))
+	}
+	return score
+}
+
+// contains reports whether a document holds the phrase in one of the
+// indexed fields, ignoring case.
+func (x *textIndex) contains(data map[string]interface{}, phrase string) bool {
+	phrase = strings.Join(text.Tokenize(phrase), " ")
+	for _, field := range x.spec.Fields {
+		for _, s := range fieldStrings(data, field) {
+			if strings.Contains(" "+strings.Join(text.Tokenize(s), " ")+" ", " "+phrase+" ") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// textSearch returns the BM25 score of every document matching the search.
+// A document matches when it holds any of the terms, or every phrase if
+// there are phrases, and none of the negated words or phrases. The caller
+// must hold the lock.
+func (c *Collection) textSearch(ts *query.TextSearch) (map[string]float64, error) {
+	x := c.text
+	if x == nil {
+		return nil, ErrTextIndexRequired
+	}
+	language := x.spec.Language
+	if ts.Language != "" {
+		if !text.ValidLanguage(ts.Language) {
+			return nil, errors.New("unsupported $language: " + ts.Language)
+		}
+		language = ts.Language
+	}
+
+	var scoring []string
+	seen := make(map[string]bool)
+	addTerms := func(terms []string) {
+		for _, term := range terms {
+			if !seen[term] {
+				seen[term] = true
+				scoring = append(scoring, term)
+			}
+		}
+	}
+	addTerms(text.Analyze(strings.Join(ts.Terms, " "), language))
+
+	matched := make(map[string]bool)
+	if len(ts.Phrases) > 0 {
+		// Narrow to the documents holding every term of every phrase, then
+		// check the phrases themselves.
+		var required []string
+		for _, phrase := range ts.Phrases {
+			terms := text.Analyze(phrase, language)
+			required = append(required, terms...)
+			addTerms(terms)
+		}
+		candidates := make(map[string]float64, len(x.lengths))
+		for id, length := range x.lengths {
+			candidates[id] = length
+		}
+		if len(required) > 0 {
+			candidates = x.postings[required[0]]
+		}
+		for id := range candidates {
+			if hasAllTerms(x, id, required) && c.containsAll(x, id, ts.Phrases) {
+				matched[id] = true
+			}
+		}
+	} else {
+		for _, term := range scoring {
+			for id := range x.postings[term] {
+				matched[id] = true
+			}
+		}
+	}
+
+	for _, negated := range ts.Negated {
+		terms := text.Analyze(negated, language)
+		for id := range matched {
+			if len(terms) == 1 && x.postings[terms[0]][id] > 0 {
+				delete(matched, id)
+			} else if len(terms) > 1 && c.containsAll(x, id, []string{negated}) {
+				delete(matched, id)
+			}
+		}
+	}
+
+	scores := make(map[string]float64, len(matched))
+	for id := range matched {
+		scores[id] = x.score(id, scoring)
+	}
+	return scores, nil
+}
+
+func hasAllTerms(x *textIndex, documentID string, terms []string) bool {
+	for _, term := range terms {
+		if x.postings[term][documentID] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Collection) containsAll(x *textIndex, documentID string, phrases []string) bool {
+	doc, exists := c.Documents[documentID]
+	if !exists {
+		return false
+	}
+	for _, phrase := range phrases {
+		if !x.contains(doc.Data, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// byScore returns the scored documents by descending score, keeping
+// insertion order between equal scores.
+func (c *Collection) byScore(scores map[string]float64) []*models.Document {
+	return c.ranked(scores, func(a, b float64) bool { return a > b })
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func newTextStore(t *testing.T, weights map[string]float64, docs map[string]string) *DocumentStore {
+	t.Helper()
+	ds := NewStore()
+	if _, err := ds.CreateProject("p"); err != nil {
+		t.Fatal(err)
+	}
+	settings := CollectionSettings{Indexes: []IndexSpec{{Fields: []string{"title", "body"}, Type: IndexTypeText, Weights: weights}}}
+	if _, err := ds.CreateCollection("p", "posts", settings); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range docs {
+		if _, err := ds.Create("p", "posts", map[string]interface{}{"name": name, "body": body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ds
+}
+
+func searchNames(t *testing.T, ds *DocumentStore, filter string) []string {
+	t.Helper()
+	docs, err := ds.Query("p", "posts", decodeFilter(t, filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(docs))
+	for i, doc := range docs {
+		names[i] = doc.Data["name"].(string)
+	}
+	return names
+}
+
+func TestTextSearchMatches(t *testing.T) {
+	ds := newTextStore(t, nil, map[string]string{
+		"green":  "Green tea, served hot",
+		"swap":   "The tea is green",
+		"cup":    "A cup of tea",
+		"and":    "cup and tea",
+		"iced":   "Iced tea with milk",
+		"runner": "She runs every morning",
+		"coffee": "Black coffee",
+	})
+
+	tests := []struct {
+		name   string
+		search string
+		want   []string
+	}{
+		{"phrase in order", `"green tea"`, []string{"green"}},
+		{"phrase across punctuation", `"tea served"`, []string{"green"}},
+		{"phrase with a stop word", `"cup of tea"`, []string{"cup"}},
+		{"phrase ignores case", `"ICED TEA"`, []string{"iced"}},
+		{"two phrases", `"green tea" "served hot"`, []string{"green"}},
+		{"phrase and a term", `"cup of tea" coffee`, []string{"cup"}},
+		{"phrase nobody holds", `"tea green"`, nil},
+		{"negated term", `tea -milk -green`, []string{"and", "cup"}},
+		{"negated phrase", `tea -"iced tea" -"cup of tea"`, []string{"and", "green", "swap"}},
+		{"stemmed term", `running`, []string{"runner"}},
+		{"stop words only match nothing", `the of`, nil},
+		{"any term", `coffee morning`, []string{"coffee", "runner"}},
+	}
+	for _, tt := range tests {
+		got := searchNames(t, ds, `{"$text": {"$search": `+strconv.Quote(tt.search)+`}}`)
+		if !equalNames(sorted(got), tt.want) {
+			t.Errorf("%s: found %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTextSearchRanksByBM25(t *testing.T) {
+	tests := []struct {
+		name string
+		docs map[string]string
+		// search finds every document but "none", best first. Documents
+		// with equal scores keep insertion order, which a map does not fix,
+		// so top only lists the ones whose rank is certain.
+		search string
+		top    []string
+	}{
+		{
+			"more occurrences rank higher",
+			map[string]string{"once": "tea and cake", "twice": "tea with tea", "none": "coffee"},
+			"tea",
+			[]string{"twice", "once"},
+		},
+		{
+			"shorter documents rank higher",
+			map[string]string{"short": "tea time", "long": "tea in a large pot brewed slowly all afternoon", "none": "coffee"},
+			"tea",
+			[]string{"short", "long"},
+		},
+		{
+			"rarer terms weigh more",
+			map[string]string{"rare": "matcha", "common": "cake", "a": "cake", "b": "cake", "c": "cake", "none": "coffee"},
+			"cake matcha",
+			[]string{"rare"},
+		},
+		{
+			"more distinct terms rank higher",
+			map[string]string{"repeated": "green tea tea", "both": "green tea", "green": "green leaf", "none": "coffee"},
+			"green tea",
+			[]string{"repeated", "both", "green"},
+		},
+	}
+	for _, tt := range tests {
+		ds := newTextStore(t, nil, tt.docs)
+		got := searchNames(t, ds, `{"$text": {"$search": "`+tt.search+`"}}`)
+		if len(got) != len(tt.docs)-1 || !equalNames(got[:len(tt.top)], tt.top) {
+			t.Errorf("%s: ranked %v, want %v first and every document but none", tt.name, got, tt.top)
+		}
+	}
+}
+
+func TestTextSearchFieldWeights(t *testing.T) {
+	ds := NewStore()
+	if _, err := ds.CreateProject("p"); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"title", "body"} {
+		settings := CollectionSettings{Indexes: []IndexSpec{{
+			Fields:  []string{"title", "body"},
+			Type:    IndexTypeText,
+			Weights: map[string]float64{field: 10},
+		}}}
+		if _, err := ds.CreateCollection("p", field, settings); err != nil {
+			t.Fatal(err)
+		}
+		for _, data := range []map[string]interface{}{
+			{"name": "title", "title": "tea", "body": "brewing notes"},
+			{"name": "body", "title": "notes", "body": "tea brewing"},
+		} {
+			if _, err := ds.Create("p", field, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		docs, err := ds.Query("p", field, decodeFilter(t, `{"$text": {"$search": "tea"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 2 || docs[0].Data["name"] != field {
+			t.Errorf("with %s weighted: ranked %v, want the match in %s first", field, docs, field)
+		}
+	}
+}
+
+func sorted(names []string) []string {
+	if names == nil {
+		return nil
+	}
+	names = append([]string{}, names...)
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,771 @@
+package store
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+	"github.com/itsyaboikris/go_document_store/schema"
+)
+
+var (
+	ErrNotTimeSeries      = errors.New("collection is not a time-series collection")
+	ErrTimeSeriesWrite    = errors.New("time-series collections take samples through the samples endpoint")
+	ErrTimeSeriesSettings = errors.New("only expire_after of the time_series settings can be changed")
+	ErrInvalidSample      = errors.New("invalid sample")
+	ErrInvalidDownsample  = errors.New("invalid downsample")
+)
+
+const (
+	GranularitySeconds = "seconds"
+	GranularityMinutes = "minutes"
+	GranularityHours   = "hours"
+)
+
+// maxBucketSamples bounds the samples of one bucket. Samples beyond it go
+// to a new bucket for the same window.
+const maxBucketSamples = 1000
+
+// bucketSpans is how much time the buckets of each granularity cover.
+var bucketSpans = map[string]time.Duration{
+	GranularitySeconds: time.Hour,
+	GranularityMinutes: 24 * time.Hour,
+	GranularityHours:   30 * 24 * time.Hour,
+}
+
+// TimeSeriesSettings make a collection store samples, each taken at the
+// time in TimeField and belonging to the series named by MetaField. The
+// samples of a series are packed into compressed buckets, one per window of
+// the granularity's span, which are the collection's documents. With
+// ExpireAfter a bucket is removed once its newest sample is older than
+// that, and older samples are hidden from reads right away.
+type TimeSeriesSettings struct {
+	TimeField   string `json:"time_field"`
+	MetaField   string `json:"meta_field,omitempty"`
+	Granularity string `json:"granularity,omitempty"`
+	ExpireAfter string `json:"expire_after,omitempty"`
+
+	span        time.Duration
+	expireAfter time.Duration
+}
+
+func (t *TimeSeriesSettings) normalize() error {
+	if t.TimeField == "" {
+		return errors.New("time_field is required")
+	}
+	for _, field := range []string{t.TimeField, t.MetaField} {
+		if strings.Contains(field, ".") || field == "_id" {
+			return errors.New("time_field and meta_field must be top-level fields other than _id")
+		}
+	}
+	if t.MetaField == t.TimeField {
+		return errors.New("meta_field must differ from time_field")
+	}
+
+	if t.Granularity == "" {
+		t.Granularity = GranularitySeconds
+	}
+	span, exists := bucketSpans[t.Granularity]
+	if !exists {
+		return errors.New("granularity must be seconds, minutes or hours")
+	}
+	t.span = span
+
+	if t.ExpireAfter != "" {
+		after, err := time.ParseDuration(t.ExpireAfter)
+		if err != nil {
+			return err
+		}
+		if after <= 0 {
+			return errors.New("expire_after must be positive")
+		}
+		t.expireAfter = after
+	}
+	return nil
+}
+
+// sameSeries reports whether two settings store samples the same way, so
+// a collection can move from one to the other.
+func sameSeries(a, b *TimeSeriesSettings) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.TimeField == b.TimeField && a.MetaField == b.MetaField && a.Granularity == b.Granularity
+}
+
+// sample is one measurement: its time in unix nanoseconds and its fields
+// other than the time and meta fields.
+type sample struct {
+	at     int64
+	fields map[string]interface{}
+}
+
+// split takes a sample apart into its series, its time and its other
+// fields.
+func (t *TimeSeriesSettings) split(data map[string]interface{}) (interface{}, sample, error) {
+	value, exists := data[t.TimeField]
+	if !exists {
+		return nil, sample{}, errors.New("missing " + t.TimeField)
+	}
+	at, ok := parseTime(value)
+	if !ok {
+		return nil, sample{}, errors.New(t.TimeField + " must be an RFC 3339 string or unix seconds")
+	}
+
+	var meta interface{}
+	fields := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		switch key {
+		case t.TimeField:
+		case t.MetaField:
+			meta = value
+		default:
+			fields[key] = value
+		}
+	}
+	return meta, sample{at: at.UnixNano(), fields: fields}, nil
+}
+
+// document puts a sample back together as it was inserted, with its time
+// as an RFC 3339 string in UTC.
+func (t *TimeSeriesSettings) document(meta interface{}, s sample) map[string]interface{} {
+	doc := make(map[string]interface{}, len(s.fields)+2)
+	for key, value := range s.fields {
+		doc[key] = value
+	}
+	doc[t.TimeField] = formatNanos(s.at)
+	if t.MetaField != "" && meta != nil {
+		doc[t.MetaField] = meta
+	}
+	return doc
+}
+
+// window returns the start of the bucket window holding a time.
+func (t *TimeSeriesSettings) window(at int64) int64 {
+	span := int64(t.span)
+	return at - ((at%span)+span)%span
+}
+
+func formatNanos(at int64) string {
+	return time.Unix(0, at).UTC().Format(time.RFC3339Nano)
+}
+
+func seriesKey(meta interface{}) string {
+	encoded, _ := json.Marshal(query.Canonical(meta))
+	return string(encoded)
+}
+
+// bucketColumns holds the fields of a bucket's samples column by column.
+// A sample lacking a field has null in its column and is listed in
+// Missing, which tells it apart from an explicit null.
+type bucketColumns struct {
+	Fields  map[string][]interface{} `json:"fields"`
+	Missing map[string][]int         `json:"missing,omitempty"`
+}
+
+// encodeSamples packs samples sorted by time into a bucket's "samples"
+// field: the times as varints of the change between consecutive deltas,
+// which is zero for evenly spaced samples, followed by the other fields in
+// columns, all deflated and base64 encoded.
+func encodeSamples(samples []sample) (string, error) {
+	header := binary.AppendUvarint(nil, uint64(len(samples)))
+	var previous, delta int64
+	for i, s := range samples {
+		if i == 0 {
+			header = binary.AppendVarint(header, s.at)
+		} else {
+			header = binary.AppendVarint(header, s.at-previous-delta)
+			delta = s.at - previous
+		}
+		previous = s.at
+	}
+
+	columns := bucketColumns{Fields: make(map[string][]interface{})}
+	for i, s := range samples {
+		for field, value := range s.fields {
+			if columns.Fields[field] == nil {
+				columns.Fields[field] = make([]interface{}, len(samples))
+			}
+			columns.Fields[field][i] = value
+		}
+	}
+	for field := range columns.Fields {
+		for i, s := range samples {
+			if _, exists := s.fields[field]; !exists {
+				if columns.Missing == nil {
+					columns.Missing = make(map[string][]int)
+				}
+				columns.Missing[field] = append(columns.Missing[field], i)
+			}
+		}
+	}
+	encoded, err := json.Marshal(columns)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(header)
+	w.Write(encoded)
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeSamples(encoded string) ([]sample, error) {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(raw)
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(len(raw)) {
+		return nil, errors.New("corrupt bucket")
+	}
+	samples := make([]sample, n)
+	var previous, delta int64
+	for i := range samples {
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, errors.New("corrupt bucket")
+		}
+		if i == 0 {
+			samples[i].at = v
+		} else {
+			delta += v
+			samples[i].at = previous + delta
+		}
+		previous = samples[i].at
+		samples[i].fields = make(map[string]interface{})
+	}
+
+	var columns bucketColumns
+	if err := json.NewDecoder(r).Decode(&columns); err != nil {
+		return nil, err
+	}
+	for field, values := range columns.Fields {
+		if len(values) != len(samples) {
+			return nil, errors.New("corrupt bucket")
+		}
+		for i, value := range values {
+			samples[i].fields[field] = value
+		}
+	}
+	for field, missing := range columns.Missing {
+		for _, i := range missing {
+			if i >= 0 && i < len(samples) {
+				delete(samples[i].fields, field)
+			}
+		}
+	}
+	return samples, nil
+}
+
+// bucketData returns the data of a bucket holding samples sorted by time.
+// The series and the time bounds are kept outside the compressed samples
+// so the bucket can be indexed without decoding them.
+func bucketData(meta interface{}, samples []sample) (map[string]interface{}, error) {
+	encoded, err := encodeSamples(samples)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"meta": meta,
+		"control": map[string]interface{}{
+			"min":   formatNanos(samples[0].at),
+			"max":   formatNanos(samples[len(samples)-1].at),
+			"count": len(samples),
+		},
+		"samples": encoded,
+	}, nil
+}
+
+// bucketRef describes a bucket to the series index.
+type bucketRef struct {
+	id       string
+	key      string
+	meta     interface{}
+	min, max int64
+	count    int
+}
+
+// bucketInfo reads the series and bounds of a bucket. It returns false for
+// data that is not a bucket.
+func bucketInfo(doc *models.Document) (*bucketRef, bool) {
+	control, ok := doc.Data["control"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	min, minOK := parseTime(control["min"])
+	max, maxOK := parseTime(control["max"])
+	var count int
+	switch n := control["count"].(type) {
+	case int:
+		count = n
+	case float64:
+		count = int(n)
+	}
+	if !minOK || !maxOK || count <= 0 {
+		return nil, false
+	}
+	meta := doc.Data["meta"]
+	return &bucketRef{id: doc.ID, key: seriesKey(meta), meta: meta, min: min.UnixNano(), max: max.UnixNano(), count: count}, true
+}
+
+// seriesIndex orders the buckets of each series by their earliest sample,
+// so a range query only decodes the buckets that overlap it. Since every
+// bucket holds a single window, a bucket starting more than a window
+// before the range cannot reach into it.
+type seriesIndex struct {
+	span    int64
+	series  map[string][]*bucketRef
+	buckets map[string]*bucketRef
+	// values maps each value the meta field of a series can equal, which
+	// includes the elements of an array, to the keys of those series.
+	values map[string]map[string]bool
+}
+
+func newSeriesIndex(settings *TimeSeriesSettings) *seriesIndex {
+	return &seriesIndex{
+		span:    int64(settings.span),
+		series:  make(map[string][]*bucketRef),
+		buckets: make(map[string]*bucketRef),
+		values:  make(map[string]map[string]bool),
+	}
+}
+
+func (x *seriesIndex) add(doc *models.Document) {
+	x.delete(doc.ID)
+
+	ref, ok := bucketInfo(doc)
+	if !ok {
+		return
+	}
+	x.buckets[ref.id] = ref
+
+	refs := x.series[ref.key]
+	if refs == nil {
+		for _, value := range indexValues(ref.meta) {
+			key := seriesKey(value)
+			if x.values[key] == nil {
+				x.values[key] = make(map[string]bool)
+			}
+			x.values[key][ref.key] = true
+		}
+	}
+	i := sort.Search(len(refs), func(i int) bool {
+		return refs[i].min > ref.min || (refs[i].min == ref.min && refs[i].id > ref.id)
+	})
+	refs = append(refs, nil)
+	copy(refs[i+1:], refs[i:])
+	refs[i] = ref
+	x.series[ref.key] = refs
+}
+
+func (x *seriesIndex) delete(documentID string) {
+	ref, exists := x.buckets[documentID]
+	if !exists {
+		return
+	}
+	delete(x.buckets, documentID)
+
+	refs := x.series[ref.key]
+	for i, candidate := range refs {
+		if candidate == ref {
+			refs = append(refs[:i], refs[i+1:]...)
+			break
+		}
+	}
+	if len(refs) > 0 {
+		x.series[ref.key] = refs
+		return
+	}
+	delete(x.series, ref.key)
+	for _, value := range indexValues(ref.meta) {
+		key := seriesKey(value)
+		delete(x.values[key], ref.key)
+		if len(x.values[key]) == 0 {
+			delete(x.values, key)
+		}
+	}
+}
+
+// lookup returns the buckets of the series with the given keys, or of
+// every series for nil keys, that hold samples in [from, to), series by
+// series.
+func (x *seriesIndex) lookup(keys []string, from, to int64) [][]*bucketRef {
+	if keys == nil {
+		for key := range x.series {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := int64(math.MinInt64)
+	if from > start+x.span {
+		start = from - x.span
+	}
+	var found [][]*bucketRef
+	for _, key := range keys {
+		refs := x.series[key]
+		var overlapping []*bucketRef
+		for i := sort.Search(len(refs), func(i int) bool { return refs[i].min >= start }); i < len(refs) && refs[i].min < to; i++ {
+			if refs[i].max >= from {
+				overlapping = append(overlapping, refs[i])
+			}
+		}
+		if len(overlapping) > 0 {
+			found = append(found, overlapping)
+		}
+	}
+	return found
+}
+
+// seriesFor returns the keys of the series whose meta field may equal one
+// of the values.
+func (x *seriesIndex) seriesFor(values []interface{}) []string {
+	keys := []string{}
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for key := range x.values[seriesKey(value)] {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func (x *seriesIndex) samples() int {
+	total := 0
+	for _, ref := range x.buckets {
+		total += ref.count
+	}
+	return total
+}
+
+// InsertSamples adds samples to a time-series collection on behalf of
+// scope and returns the buckets it wrote. Samples join the bucket this
+// node has open for their series and window while it has room; buckets
+// received from peers are never appended to, so every bucket has a single
+// writer and replicas cannot lose samples to last-write-wins. Either every
+// sample is stored or, if any of them is invalid or violates the schema or
+// the security filter, none is.
+func (ds *DocumentStore) InsertSamples(scope *Scope, projectID, collectionID string, samples []map[string]interface{}) ([]*models.Document, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	settings := collection.Settings.TimeSeries
+	if settings == nil {
+		return nil, ErrNotTimeSeries
+	}
+
+	filter, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := ds.compile(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	type series struct {
+		meta    interface{}
+		samples []sample
+	}
+	var order []string
+	bySeries := make(map[string]*series)
+	var violations []schema.Error
+	for i, data := range samples {
+		if !allowed.Matches(data) {
+			return nil, ErrScopeViolation
+		}
+
+		if err := collection.checkSchema(data, false); err != nil {
+			for _, violation := range err.(*schema.ValidationError).Errors {
+				violation.Path = "/" + strconv.Itoa(i) + strings.TrimSuffix(violation.Path, "/")
+				violations = append(violations, violation)
+			}
+		}
+
+		meta, s, err := settings.split(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %d: %v", ErrInvalidSample, i, err)
+		}
+		key := seriesKey(meta)
+		if bySeries[key] == nil {
+			bySeries[key] = &series{meta: meta}
+			order = append(order, key)
+		}
+		bySeries[key].samples = append(bySeries[key].samples, s)
+	}
+	if len(violations) > 0 {
+		return nil, &schema.ValidationError{Errors: violations}
+	}
+
+	if collection.open == nil {
+		collection.open = make(map[string]string)
+	}
+	var written []*models.Document
+	for _, key := range order {
+		s := bySeries[key]
+		sort.SliceStable(s.samples, func(i, j int) bool { return s.samples[i].at < s.samples[j].at })
+		for start := 0; start < len(s.samples); {
+			end := start
+			window := settings.window(s.samples[start].at)
+			for end < len(s.samples) && settings.window(s.samples[end].at) == window {
+				end++
+			}
+			docs, err := ds.appendSamples(projectID, collectionID, collection, key, s.meta, window, s.samples[start:end])
+			if err != nil {
+				return nil, err
+			}
+			written = append(written, docs...)
+			start = end
+		}
+	}
+	return written, nil
+}
+
+// appendSamples stores samples of one series and window, filling the open
+// bucket of the series first if it covers the window. The caller must hold
+// the write lock.
+func (ds *DocumentStore) appendSamples(projectID, collectionID string, collection *Collection, key string, meta interface{}, window int64, samples []sample) ([]*models.Document, error) {
+	settings := collection.Settings.TimeSeries
+
+	var open *models.Document
+	openWindow := int64(math.MinInt64)
+	if doc, exists := collection.Documents[collection.open[key]]; exists {
+		if ref, ok := bucketInfo(doc); ok {
+			openWindow = settings.window(ref.min)
+			if openWindow == window && ref.count < maxBucketSamples {
+				existing, err := decodeSamples(doc.Data["samples"].(string))
+				if err != nil {
+					return nil, err
+				}
+				samples = append(existing, samples...)
+				sort.SliceStable(samples, func(i, j int) bool { return samples[i].at < samples[j].at })
+				open = doc
+			}
+		}
+	}
+
+	var written []*models.Document
+	for start := 0; start < len(samples); start += maxBucketSamples {
+		data, err := bucketData(meta, samples[start:min(start+maxBucketSamples, len(samples))])
+		if err != nil {
+			return nil, err
+		}
+		if open != nil {
+			open.Data = data
+			open.UpdatedAt = time.Now().UTC()
+			collection.put(open)
+			ds.recordUpsert(projectID, collectionID, open)
+			written = append(written, open)
+			open = nil
+			continue
+		}
+		written = append(written, ds.insertNew(projectID, collectionID, collection, data))
+	}
+
+	// Samples arriving late for an earlier window get buckets of their own
+	// and leave the open bucket as it is.
+	if window >= openWindow {
+		collection.open[key] = written[len(written)-1].ID
+	}
+	return written, nil
+}
+
+// SampleRange selects the samples taken in [From, To). A zero bound leaves
+// that side open.
+type SampleRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r SampleRange) bounds() (int64, int64) {
+	from, to := int64(math.MinInt64), int64(math.MaxInt64)
+	if !r.From.IsZero() {
+		from = r.From.UnixNano()
+	}
+	if !r.To.IsZero() {
+		to = r.To.UnixNano()
+	}
+	return from, to
+}
+
+// QuerySamplesAs returns the samples in the range that match the filter
+// ANDed with the scope's security filter, series by series and oldest
+// first within each series.
+func (ds *DocumentStore) QuerySamplesAs(scope *Scope, projectID, collectionID string, rng SampleRange, filter map[string]interface{}) ([]map[string]interface{}, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	if collection.Settings.TimeSeries == nil {
+		return nil, ErrNotTimeSeries
+	}
+
+	security, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	return ds.samples(collection, rng, query.And(filter, security), time.Now().UTC())
+}
+
+// DownsampleAs splits the samples QuerySamplesAs would return into windows
+// of the given length, counted from the unix epoch, and folds the samples
+// of each series and window into one result with the $group accumulators
+// in fields, such as {"cpu": {"$avg": "$cpu"}}. Each result carries the
+// start of its window in the time field and its series in the meta field.
+func (ds *DocumentStore) DownsampleAs(scope *Scope, projectID, collectionID string, rng SampleRange, filter map[string]interface{}, every time.Duration, fields map[string]interface{}) ([]map[string]interface{}, error) {
+	if every < time.Millisecond || every%time.Millisecond != 0 {
+		return nil, fmt.Errorf("%w: every must be a whole number of milliseconds", ErrInvalidDownsample)
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	settings := collection.Settings.TimeSeries
+	if settings == nil {
+		return nil, ErrNotTimeSeries
+	}
+
+	id := map[string]interface{}{
+		"window": map[string]interface{}{
+			"$dateTrunc": map[string]interface{}{
+				"date":    "$" + settings.TimeField,
+				"unit":    "millisecond",
+				"binSize": every.Milliseconds(),
+			},
+		},
+	}
+	if settings.MetaField != "" {
+		id["series"] = "$" + settings.MetaField
+	}
+	group := map[string]interface{}{"_id": id}
+	for field, accumulator := range fields {
+		if field == "_id" || field == settings.TimeField || field == settings.MetaField {
+			return nil, fmt.Errorf("%w: %s is reserved", ErrInvalidDownsample, field)
+		}
+		group[field] = accumulator
+	}
+	raw, err := json.Marshal([]interface{}{map[string]interface{}{"$group": group}})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDownsample, err)
+	}
+	pipeline, err := query.ParsePipeline(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDownsample, err)
+	}
+
+	security, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := ds.samples(collection, rng, query.And(filter, security), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	// The samples arrive series by series and oldest first, and $group
+	// keeps the order in which its groups first appear.
+	results := pipeline.Run(samples, nil, nil)
+	for _, result := range results {
+		key := result["_id"].(map[string]interface{})
+		delete(result, "_id")
+		result[settings.TimeField] = key["window"]
+		if series, exists := key["series"]; exists && series != nil {
+			result[settings.MetaField] = series
+		}
+	}
+	return results, nil
+}
+
+// samples returns the live samples in the range that match the filter,
+// series by series and oldest first within each series. Only the buckets
+// overlapping the range are decoded, and when the filter requires the meta
+// field to equal some values, only those of the matching series. The caller
+// must hold the lock.
+func (ds *DocumentStore) samples(collection *Collection, rng SampleRange, filter map[string]interface{}, now time.Time) ([]map[string]interface{}, error) {
+	settings := collection.Settings.TimeSeries
+	from, to := rng.bounds()
+	if settings.expireAfter > 0 {
+		from = max(from, now.Add(-settings.expireAfter).UnixNano())
+	}
+
+	var keys []string
+	if settings.MetaField != "" && filter != nil {
+		found := make(map[string][]interface{})
+		equalities(filter, found)
+		if values, exists := found[settings.MetaField]; exists {
+			keys = collection.series.seriesFor(values)
+		}
+	}
+
+	compiled, err := ds.compile(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []map[string]interface{}
+	for _, refs := range collection.series.lookup(keys, from, to) {
+		var found []sample
+		for _, ref := range refs {
+			doc := collection.Documents[ref.id]
+			encoded, _ := doc.Data["samples"].(string)
+			decoded, err := decodeSamples(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("bucket %s: %v", ref.id, err)
+			}
+			for _, s := range decoded {
+				if s.at >= from && s.at < to {
+					found = append(found, s)
+				}
+			}
+		}
+		sort.SliceStable(found, func(i, j int) bool { return found[i].at < found[j].at })
+
+		meta := refs[0].meta
+		for _, s := range found {
+			if document := settings.document(meta, s); compiled.Matches(document) {
+				documents = append(documents, document)
+			}
+		}
+	}
+	if documents == nil {
+		documents = []map[string]interface{}{}
+	}
+	return documents, nil
+}
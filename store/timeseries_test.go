@@ -0,0 +1,47 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuerySamplesFilter(t *testing.T) {
+	ds := NewStore()
+	if _, err := ds.CreateProject("p"); err != nil {
+		t.Fatal(err)
+	}
+	settings := CollectionSettings{TimeSeries: &TimeSeriesSettings{TimeField: "at", MetaField: "sensor"}}
+	if _, err := ds.CreateCollection("p", "metrics", settings); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var samples []map[string]interface{}
+	for i := 0; i < 120; i++ {
+		samples = append(samples, map[string]interface{}{
+			"at":     base.Add(time.Duration(i) * time.Minute).Format(time.RFC3339),
+			"sensor": []string{"a", "b"}[i%2],
+			"temp":   float64(i),
+		})
+	}
+	if _, err := ds.InsertSamples(nil, "p", "metrics", samples); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := SampleRange{From: base, To: base.Add(time.Hour)}
+	found, err := ds.QuerySamplesAs(nil, "p", "metrics", rng, decodeFilter(t, `{"sensor": "a", "temp": {"$gte": 50}, "$expr": {"$lt": ["$temp", 58]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var temps []float64
+	for _, sample := range found {
+		temps = append(temps, sample["temp"].(float64))
+	}
+	if want := []float64{50, 52, 54, 56}; len(temps) != len(want) || temps[0] != 50 || temps[3] != 56 {
+		t.Errorf("temps = %v, want %v", temps, want)
+	}
+
+	if _, err := ds.QuerySamplesAs(nil, "p", "metrics", rng, decodeFilter(t, `{"temp": {"$regex": "("}}`)); err == nil {
+		t.Error("an invalid filter was accepted")
+	}
+}
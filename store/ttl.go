@@ -0,0 +1,182 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+)
+
+const (
+	TTLFromCreated = "created_at"
+	TTLFromUpdated = "updated_at"
+)
+
+// TTLSettings expire documents either a fixed duration after they were
+// created or last updated, or at the time stored in one of their fields.
+// The field may hold an RFC 3339 string or unix seconds; documents without
+// it never expire.
+type TTLSettings struct {
+	After string `json:"after,omitempty"`
+	From  string `json:"from,omitempty"`
+	Field string `json:"field,omitempty"`
+
+	after time.Duration
+}
+
+// ExpiredDocument describes a document removed by the TTL reaper.
+type ExpiredDocument struct {
+	Project    string
+	Collection string
+	ID         string
+	ExpiredAt  time.Time
+}
+
+func (t *TTLSettings) normalize() error {
+	if (t.After == "") == (t.Field == "") {
+		return errors.New("ttl needs exactly one of after or field")
+	}
+
+	if t.Field != "" {
+		if t.From != "" {
+			return errors.New("ttl from only applies with after")
+		}
+		return nil
+	}
+
+	after, err := time.ParseDuration(t.After)
+	if err != nil {
+		return err
+	}
+	if after <= 0 {
+		return errors.New("ttl after must be positive")
+	}
+	t.after = after
+
+	if t.From == "" {
+		t.From = TTLFromCreated
+	}
+	if t.From != TTLFromCreated && t.From != TTLFromUpdated {
+		return errors.New("ttl from must be created_at or updated_at")
+	}
+	return nil
+}
+
+// expiresAt returns when the document expires under the settings.
+func (t *TTLSettings) expiresAt(doc *models.Document) (time.Time, bool) {
+	if t.Field == "" {
+		if t.From == TTLFromUpdated {
+			return doc.UpdatedAt.Add(t.after), true
+		}
+		return doc.CreatedAt.Add(t.after), true
+	}
+
+	return parseTime(getField(doc.Data, t.Field))
+}
+
+// parseTime reads a time given as an RFC 3339 string or unix seconds.
+func parseTime(value interface{}) (time.Time, bool) {
+	switch value := value.(type) {
+	case string:
+		at, err := time.Parse(time.RFC3339Nano, value)
+		return at, err == nil
+	case float64:
+		return time.Unix(0, int64(value*float64(time.Second))).UTC(), true
+	case int64:
+		return time.Unix(value, 0).UTC(), true
+	case int:
+		return time.Unix(int64(value), 0).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// expiresAt returns when a document of the collection expires: under its
+// TTL or, for a bucket of a time-series collection with expire_after, that
+// long after its newest sample.
+func (c *Collection) expiresAt(doc *models.Document) (time.Time, bool) {
+	if ttl := c.Settings.TTL; ttl != nil {
+		return ttl.expiresAt(doc)
+	}
+	if settings := c.Settings.TimeSeries; settings != nil && settings.expireAfter > 0 {
+		if ref, ok := bucketInfo(doc); ok {
+			return time.Unix(0, ref.max).UTC().Add(settings.expireAfter), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// expired reports whether the document has outlived the collection's TTL or
+// retention. Expired documents are hidden from reads until the reaper
+// removes them.
+func (c *Collection) expired(doc *models.Document, now time.Time) bool {
+	at, ok := c.expiresAt(doc)
+	return ok && !now.Before(at)
+}
+
+// ReapExpired deletes every expired document and leaves a tombstone dated
+// at its expiry time, so a replicated write from before the expiry cannot
+// bring it back.
+func (ds *DocumentStore) ReapExpired(now time.Time) []ExpiredDocument {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	var expired []ExpiredDocument
+	for projectID, project := range ds.Projects {
+		for collectionID, collection := range project.Collections {
+			if collection.Settings.TTL == nil && collection.Settings.TimeSeries == nil {
+				continue
+			}
+
+			for id, doc := range collection.Documents {
+				at, ok := collection.expiresAt(doc)
+				if !ok || now.Before(at) {
+					continue
+				}
+
+				// A document written after its expiry time is tombstoned at
+				// its write time so the tombstone still covers it.
+				if doc.UpdatedAt.After(at) {
+					at = doc.UpdatedAt
+				}
+
+				collection.remove(id, at)
+				collection.Tombstones[id] = at
+				ds.recordDelete(projectID, collectionID, id, at)
+				expired = append(expired, ExpiredDocument{
+					Project:    projectID,
+					Collection: collectionID,
+					ID:         id,
+					ExpiredAt:  at,
+				})
+			}
+		}
+	}
+	return expired
+}
+
+// StartTTLReaper removes expired documents every interval and passes each
+// of them to onExpire, which is expected to replicate the delete.
+func (ds *DocumentStore) StartTTLReaper(interval time.Duration, onExpire func(ExpiredDocument)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			expired := ds.ReapExpired(time.Now().UTC())
+			for _, doc := range expired {
+				if onExpire != nil {
+					onExpire(doc)
+				}
+			}
+			if len(expired) > 0 {
+				log.Printf("Expired %d document(s)", len(expired))
+			}
+		}
+	}()
+}
+
+func getField(data map[string]interface{}, path string) interface{} {
+	value, _ := lookupPath(data, path)
+	return value
+}
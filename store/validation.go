@@ -0,0 +1,132 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/schema"
+)
+
+const (
+	// ValidationStrict rejects writes that do not match the schema.
+	ValidationStrict = "strict"
+	// ValidationWarn accepts them and reports the violations as warnings.
+	ValidationWarn = "warn"
+)
+
+// ValidationSettings attach a JSON Schema to a collection. Replicated
+// writes are only checked when Replicated is set, so a node with a newer
+// schema does not reject data its peers already accepted.
+type ValidationSettings struct {
+	Schema     map[string]interface{} `json:"schema"`
+	Mode       string                 `json:"mode,omitempty"`
+	Replicated bool                   `json:"replicated,omitempty"`
+
+	compiled *schema.Schema
+}
+
+func (v *ValidationSettings) normalize() error {
+	if v.Mode == "" {
+		v.Mode = ValidationStrict
+	}
+	if v.Mode != ValidationStrict && v.Mode != ValidationWarn {
+		return errors.New("unsupported validation mode: " + v.Mode)
+	}
+	if v.Schema == nil {
+		return errors.New("validation requires a schema")
+	}
+
+	compiled, err := schema.Compile(v.Schema)
+	if err != nil {
+		return err
+	}
+	v.compiled = compiled
+	return nil
+}
+
+// checkSchema rejects data that violates a strict schema. Warn-only schemas
+// never reject; see SchemaWarnings.
+func (c *Collection) checkSchema(data map[string]interface{}, replicated bool) error {
+	validation := c.Settings.Validation
+	if validation == nil || validation.Mode != ValidationStrict {
+		return nil
+	}
+	if replicated && !validation.Replicated {
+		return nil
+	}
+
+	if errs := validation.compiled.Validate(data); len(errs) > 0 {
+		return &schema.ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// SchemaWarnings returns the violations of data against the collection's
+// schema when it is in warn mode. It returns nil for strict schemas since
+// those reject violating writes instead.
+func (ds *DocumentStore) SchemaWarnings(projectID, collectionID string, data map[string]interface{}) []schema.Error {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	collection, err := ds.collection(projectID, collectionID)
+	if err != nil {
+		return nil
+	}
+
+	validation := collection.Settings.Validation
+	if validation == nil || validation.Mode != ValidationWarn {
+		return nil
+	}
+	return validation.compiled.Validate(data)
+}
+
+// CreateMany creates documents on behalf of scope. Either every document is
+// created or, if any of them violates the schema or the security filter or
+// does not fit a capped collection, none is. Schema violations are reported
+// with the document's index as the first segment of their path.
+func (ds *DocumentStore) CreateMany(scope *Scope, projectID, collectionID string, documents []map[string]interface{}) ([]*models.Document, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	collection := ds.ensureCollection(projectID, collectionID)
+	if collection.Settings.TimeSeries != nil {
+		return nil, ErrTimeSeriesWrite
+	}
+
+	filter, err := collection.securityFilter(scope)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := ds.compile(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []schema.Error
+	for i, document := range documents {
+		if !allowed.Matches(document) {
+			return nil, ErrScopeViolation
+		}
+
+		if err := collection.checkSchema(document, false); err != nil {
+			for _, violation := range err.(*schema.ValidationError).Errors {
+				violation.Path = "/" + strconv.Itoa(i) + strings.TrimSuffix(violation.Path, "/")
+				violations = append(violations, violation)
+			}
+		}
+		if err := collection.admit(document); err != nil {
+			return nil, err
+		}
+	}
+	if len(violations) > 0 {
+		return nil, &schema.ValidationError{Errors: violations}
+	}
+
+	docs := make([]*models.Document, len(documents))
+	for i, document := range documents {
+		docs[i] = ds.insertNew(projectID, collectionID, collection, document)
+	}
+	return docs, nil
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/itsyaboikris/go_document_store/models"
+	"github.com/itsyaboikris/go_document_store/query"
+	"github.com/itsyaboikris/go_document_store/vector"
+)
+
+var (
+	ErrVectorIndexRequired = errors.New("$vectorSearch requires a vector index on the path")
+	ErrInvalidVector       = errors.New("invalid vector")
+	ErrVectorIndexName     = errors.New("$vectorSearch requires an index name when the path has more than one vector index")
+)
+
+// maxVectorDimensions bounds the length of indexed vectors.
+const maxVectorDimensions = 4096
+
+func (index *IndexSpec) normalizeVector() error {
+	if len(index.Fields) != 1 {
+		return errors.New("a vector index must have exactly one field")
+	}
+	if index.Dimensions <= 0 || index.Dimensions > maxVectorDimensions {
+		return fmt.Errorf("dimensions must be between 1 and %d", maxVectorDimensions)
+	}
+	if index.Metric == "" {
+		index.Metric = string(vector.Cosine)
+	}
+	if !vector.ValidMetric(vector.Metric(index.Metric)) {
+		return errors.New("unsupported vector metric: " + index.Metric)
+	}
+	return nil
+}
+
+// vectorIndex keeps the numeric array held by a field of each document in
+// an HNSW graph. Documents without the field are not indexed.
+type vectorIndex struct {
+	spec  IndexSpec
+	graph *vector.Index
+}
+
+func newVectorIndex(spec IndexSpec) *vectorIndex {
+	return &vectorIndex{spec: spec, graph: vector.New(vector.Metric(spec.Metric), spec.Dimensions)}
+}
+
+func (x *vectorIndex) add(doc *models.Document) {
+	v, err := vectorValue(getField(doc.Data, x.spec.Fields[0]))
+	if v == nil || err != nil || x.graph.Add(doc.ID, v) != nil {
+		// admit keeps invalid vectors out, except from documents stored
+		// before the index was created.
+		x.graph.Remove(doc.ID)
+	}
+}
+
+func (x *vectorIndex) delete(documentID string) {
+	x.graph.Remove(documentID)
+}
+
+// vectorValue reads a numeric array. It returns nil for a missing field.
+func vectorValue(value interface{}) ([]float64, error) {
+	if value == nil {
+		return nil, nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("expected an array of numbers")
+	}
+	v := make([]float64, len(items))
+	for i, item := range items {
+		if v[i], ok = item.(float64); !ok {
+			return nil, errors.New("expected an array of numbers")
+		}
+	}
+	return v, nil
+}
+
+// checkVectors rejects data whose field under a vector index holds
+// something other than a vector the index accepts.
+func (c *Collection) checkVectors(data map[string]interface{}) error {
+	for _, index := range c.vectorIndexes() {
+		field := index.spec.Fields[0]
+		v, err := vectorValue(getField(data, field))
+		if err == nil && v != nil {
+			err = index.graph.Check(v)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidVector, field, err)
+		}
+	}
+	return nil
+}
+
+// vectorIndexes returns the vector indexes ordered by name.
+func (c *Collection) vectorIndexes() []*vectorIndex {
+	indexes := make([]*vectorIndex, 0, len(c.vectors))
+	for _, index := range c.vectors {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].spec.Name < indexes[j].spec.Name
+	})
+	return indexes
+}
+
+// vectorIndexOn returns the vector index on a path, the one with the given
+// name if there is more than one.
+func (c *Collection) vectorIndexOn(path, name string) (*vectorIndex, error) {
+	var found *vectorIndex
+	for _, index := range c.vectorIndexes() {
+		if index.spec.Fields[0] != path || (name != "" && index.spec.Name != name) {
+			continue
+		}
+		if found != nil {
+			return nil, ErrVectorIndexName
+		}
+		found = index
+	}
+	if found == nil {
+		return nil, ErrVectorIndexRequired
+	}
+	return found, nil
+}
+
+// vectorSearchInput returns the documents a $vectorSearch stage finds among
+// those that are live and match both the filter and the stage's own
+// filter, best match first, with their scores. The filters are applied
+// while the graph is searched rather than to its results, so the stage
+// returns its limit whenever enough documents match. The caller must hold
+// the lock.
+func (ds *DocumentStore) vectorSearchInput(collection *Collection, filter map[string]interface{}, search *query.VectorSearch, now time.Time) ([]map[string]interface{}, []float64, error) {
+	index, err := collection.vectorIndexOn(search.Path, search.Index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := index.graph.Check(search.QueryVector); err != nil {
+		return nil, nil, fmt.Errorf("%w: queryVector: %v", ErrInvalidVector, err)
+	}
+
+	compiled, err := ds.compile(query.And(search.Filter, filter))
+	if err != nil {
+		return nil, nil, err
+	}
+	accept := func(id string) bool {
+		doc, exists := collection.Documents[id]
+		if !exists || collection.expired(doc, now) {
+			return false
+		}
+		return compiled.Matches(doc.Data)
+	}
+
+	var results []vector.Result
+	if search.Exact {
+		results, err = index.graph.Exact(search.QueryVector, search.Limit, accept)
+	} else {
+		results, err = index.graph.Search(search.QueryVector, search.Limit, search.NumCandidates, accept)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	documents := make([]map[string]interface{}, len(results))
+	scores := make([]float64, len(results))
+	for i, result := range results {
+		documents[i] = pipelineDocument(collection.Documents[result.ID])
+		scores[i] = result.Score
+	}
+	return documents, scores, nil
+}
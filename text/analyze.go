@@ -0,0 +1,64 @@
+// Package text turns strings into the terms stored in full-text indexes:
+// it splits on anything that is not a letter or digit, lowercases, drops
+// stop words and, for English, reduces words to their Porter stem so that
+// "running" and "runs" both find "run".
+package text
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	English = "english"
+	// None only tokenizes and lowercases, keeping stop words and
+	// inflections.
+	None = "none"
+)
+
+// ValidLanguage reports whether language is supported.
+func ValidLanguage(language string) bool {
+	return language == English || language == None
+}
+
+// Tokenize splits s into lowercase words.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Analyze returns the index terms of s in order, leaving out stop words.
+func Analyze(s, language string) []string {
+	words := Tokenize(s)
+	if language == None {
+		return words
+	}
+
+	terms := words[:0]
+	for _, word := range words {
+		if stopWords[word] {
+			continue
+		}
+		terms = append(terms, Stem(word))
+	}
+	return terms
+}
+
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		a about above after again against all am an and any are as at be
+		because been before being below between both but by can could did do
+		does doing down during each few for from further had has have having
+		he her here hers herself him himself his how i if in into is it its
+		itself just me more most my myself no nor not now of off on once only
+		or other our ours ourselves out over own same she should so some such
+		than that the their theirs them themselves then there these they this
+		those through to too under until up very was we were what when where
+		which while who whom why will with would you your yours yourself
+		yourselves`) {
+		stopWords[word] = true
+	}
+}
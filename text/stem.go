@@ -0,0 +1,220 @@
+package text
+
+import "strings"
+
+// Stem reduces an English word to its stem with the Porter algorithm
+// (M. F. Porter, "An algorithm for suffix stripping", 1980). Words that
+// are not plain lowercase ASCII, or are two letters or shorter, are
+// returned unchanged.
+func Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	w := step1a(word)
+	w = step1b(w)
+	w = step1c(w)
+	w = step2(w)
+	w = step3(w)
+	w = step4(w)
+	w = step5(w)
+	return w
+}
+
+// consonant reports whether w[i] is a consonant. A y is a consonant at the
+// start of a word or after a vowel.
+func consonant(w string, i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !consonant(w, i-1)
+	}
+	return true
+}
+
+// measure counts the vowel-consonant sequences of a stem, the m of
+// [C](VC)^m[V].
+func measure(stem string) int {
+	m := 0
+	i := 0
+	for i < len(stem) && consonant(stem, i) {
+		i++
+	}
+	for i < len(stem) {
+		for i < len(stem) && !consonant(stem, i) {
+			i++
+		}
+		if i == len(stem) {
+			break
+		}
+		for i < len(stem) && consonant(stem, i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+func hasVowel(stem string) bool {
+	for i := range stem {
+		if !consonant(stem, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleConsonant reports whether the stem ends in two equal consonants.
+func doubleConsonant(stem string) bool {
+	n := len(stem)
+	return n >= 2 && stem[n-1] == stem[n-2] && consonant(stem, n-1)
+}
+
+// cvc reports whether the stem ends consonant-vowel-consonant where the
+// last consonant is not w, x or y, as in "hop" but not "snow".
+func cvc(stem string) bool {
+	n := len(stem)
+	if n < 3 || !consonant(stem, n-1) || consonant(stem, n-2) || !consonant(stem, n-3) {
+		return false
+	}
+	last := stem[n-1]
+	return last != 'w' && last != 'x' && last != 'y'
+}
+
+// replace swaps the first matching suffix for its replacement when the
+// remaining stem has a measure above min. It reports whether a suffix
+// matched, even if the condition then failed.
+func replace(w string, min int, rules [][2]string) (string, bool) {
+	for _, rule := range rules {
+		if strings.HasSuffix(w, rule[0]) {
+			stem := w[:len(w)-len(rule[0])]
+			if measure(stem) > min {
+				return stem + rule[1], true
+			}
+			return w, true
+		}
+	}
+	return w, false
+}
+
+func step1a(w string) string {
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "ies"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"):
+		return w
+	case strings.HasSuffix(w, "s"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func step1b(w string) string {
+	if strings.HasSuffix(w, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			return w[:len(w)-1]
+		}
+		return w
+	}
+
+	var stem string
+	switch {
+	case strings.HasSuffix(w, "ed") && hasVowel(w[:len(w)-2]):
+		stem = w[:len(w)-2]
+	case strings.HasSuffix(w, "ing") && hasVowel(w[:len(w)-3]):
+		stem = w[:len(w)-3]
+	default:
+		return w
+	}
+
+	switch {
+	case strings.HasSuffix(stem, "at"), strings.HasSuffix(stem, "bl"), strings.HasSuffix(stem, "iz"):
+		return stem + "e"
+	case doubleConsonant(stem):
+		if last := stem[len(stem)-1]; last != 'l' && last != 's' && last != 'z' {
+			return stem[:len(stem)-1]
+		}
+	case measure(stem) == 1 && cvc(stem):
+		return stem + "e"
+	}
+	return stem
+}
+
+func step1c(w string) string {
+	if strings.HasSuffix(w, "y") && hasVowel(w[:len(w)-1]) {
+		return w[:len(w)-1] + "i"
+	}
+	return w
+}
+
+var step2Rules = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+	{"izer", "ize"}, {"bli", "ble"}, {"alli", "al"}, {"entli", "ent"},
+	{"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+	{"logi", "log"},
+}
+
+func step2(w string) string {
+	w, _ = replace(w, 0, step2Rules)
+	return w
+}
+
+var step3Rules = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+func step3(w string) string {
+	w, _ = replace(w, 0, step3Rules)
+	return w
+}
+
+var step4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment",
+	"ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+func step4(w string) string {
+	// The longest suffix wins, so "ement" is tried before "ment" and "ent".
+	best := ""
+	for _, suffix := range step4Suffixes {
+		if strings.HasSuffix(w, suffix) && len(suffix) > len(best) {
+			best = suffix
+		}
+	}
+	if best == "" {
+		return w
+	}
+
+	stem := w[:len(w)-len(best)]
+	if best == "ion" && !strings.HasSuffix(stem, "s") && !strings.HasSuffix(stem, "t") {
+		return w
+	}
+	if measure(stem) > 1 {
+		return stem
+	}
+	return w
+}
+
+func step5(w string) string {
+	if strings.HasSuffix(w, "e") {
+		stem := w[:len(w)-1]
+		if m := measure(stem); m > 1 || (m == 1 && !cvc(stem)) {
+			w = stem
+		}
+	}
+	if measure(w) > 1 && doubleConsonant(w) && strings.HasSuffix(w, "l") {
+		w = w[:len(w)-1]
+	}
+	return w
+}
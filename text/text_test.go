@@ -0,0 +1,96 @@
+package text
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	// Examples from Porter's paper and its reference vocabulary.
+	tests := []struct{ word, want string }{
+		// Step 1a
+		{"caresses", "caress"}, {"ponies", "poni"}, {"ties", "ti"},
+		{"caress", "caress"}, {"cats", "cat"},
+		// Step 1b
+		{"feed", "feed"}, {"agreed", "agre"}, {"plastered", "plaster"},
+		{"bled", "bled"}, {"motoring", "motor"}, {"sing", "sing"},
+		{"conflated", "conflat"}, {"troubled", "troubl"}, {"sized", "size"},
+		{"hopping", "hop"}, {"tanned", "tan"}, {"falling", "fall"},
+		{"hissing", "hiss"}, {"fizzed", "fizz"}, {"failing", "fail"},
+		{"filing", "file"},
+		// Step 1c
+		{"happy", "happi"}, {"sky", "sky"},
+		// Step 2
+		{"relational", "relat"}, {"conditional", "condit"}, {"rational", "ration"},
+		{"valenci", "valenc"}, {"hesitanci", "hesit"}, {"digitizer", "digit"},
+		{"conformabli", "conform"}, {"radicalli", "radic"}, {"differentli", "differ"},
+		{"vileli", "vile"}, {"analogousli", "analog"}, {"vietnamization", "vietnam"},
+		{"predication", "predic"}, {"operator", "oper"}, {"feudalism", "feudal"},
+		{"decisiveness", "decis"}, {"hopefulness", "hope"}, {"callousness", "callous"},
+		{"formaliti", "formal"}, {"sensitiviti", "sensit"}, {"sensibiliti", "sensibl"},
+		// Step 3
+		{"triplicate", "triplic"}, {"formative", "form"}, {"formalize", "formal"},
+		{"electriciti", "electr"}, {"electrical", "electr"}, {"hopeful", "hope"},
+		{"goodness", "good"},
+		// Step 4
+		{"revival", "reviv"}, {"allowance", "allow"}, {"inference", "infer"},
+		{"airliner", "airlin"}, {"gyroscopic", "gyroscop"}, {"adjustable", "adjust"},
+		{"defensible", "defens"}, {"irritant", "irrit"}, {"replacement", "replac"},
+		{"adjustment", "adjust"}, {"dependent", "depend"}, {"adoption", "adopt"},
+		{"homologou", "homolog"}, {"communism", "commun"}, {"activate", "activ"},
+		{"angulariti", "angular"}, {"homologous", "homolog"}, {"effective", "effect"},
+		{"bowdlerize", "bowdler"},
+		// Step 5
+		{"probate", "probat"}, {"rate", "rate"}, {"cease", "ceas"},
+		{"controll", "control"}, {"roll", "roll"},
+		// Inflections of one word share a stem.
+		{"running", "run"}, {"runs", "run"}, {"connection", "connect"},
+		{"connected", "connect"}, {"connecting", "connect"}, {"generalizations", "gener"},
+		// Short, non-ASCII and mixed words are left alone.
+		{"is", "is"}, {"a", "a"}, {"", ""}, {"café", "café"}, {"naïve", "naïve"},
+		{"mp3", "mp3"}, {"2024", "2024"},
+	}
+	for _, tt := range tests {
+		if got := Stem(tt.word); got != tt.want {
+			t.Errorf("Stem(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		language string
+		want     []string
+	}{
+		{"stems and drops stop words", "The runners were running to the races", English, []string{"runner", "run", "race"}},
+		{"splits on punctuation", "state-of-the-art, e-mail & co.", English, []string{"state", "art", "e", "mail", "co"}},
+		{"lowercases before the stop list", "THE Quick AND the Dead", English, []string{"quick", "dead"}},
+		{"keeps digits", "Top 10 songs of 2024", English, []string{"top", "10", "song", "2024"}},
+		{"keeps unicode letters", "Crème brûlée recipes", English, []string{"crème", "brûlée", "recip"}},
+		{"only stop words", "to be or not to be", English, []string{}},
+		{"empty", "", English, []string{}},
+		{"none keeps stop words and inflections", "The runners were running", None, []string{"the", "runners", "were", "running"}},
+	}
+	for _, tt := range tests {
+		got := Analyze(tt.s, tt.language)
+		if got == nil {
+			got = []string{}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Analyze(%q) = %q, want %q", tt.name, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("  Hello,\tWORLD!  it's 4 o'clock\n")
+	want := []string{"hello", "world", "it", "s", "4", "o", "clock"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize = %q, want %q", got, want)
+	}
+	if !ValidLanguage(English) || !ValidLanguage(None) || ValidLanguage("french") {
+		t.Error("ValidLanguage does not match the supported languages")
+	}
+}
@@ -0,0 +1,373 @@
+package vector
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// maxNeighbors is how many links a node keeps on each layer above the
+	// bottom one, which keeps twice as many.
+	maxNeighbors = 16
+	// efConstruction is how many candidates an insertion considers when
+	// choosing the neighbors of a new node.
+	efConstruction = 200
+)
+
+// Index is an HNSW graph. Every vector lives on the bottom layer and on a
+// random number of layers above it, each sparser than the one below, so a
+// search descends greedily from the sparse top to the neighborhood of the
+// query before exploring it. It is not safe for concurrent writes.
+type Index struct {
+	metric     Metric
+	dimensions int
+	nodes      map[string]*node
+	entry      *node
+	rng        *rand.Rand
+}
+
+type node struct {
+	id     string
+	vector []float64
+	// neighbors holds the outgoing links on each layer of the node and
+	// incoming the nodes linking to it, so a removal can unlink it.
+	neighbors [][]*node
+	incoming  []map[*node]bool
+}
+
+// Result is a vector found by a search, with its similarity to the query.
+type Result struct {
+	ID    string
+	Score float64
+}
+
+// New returns an empty index of vectors with the given number of
+// dimensions.
+func New(metric Metric, dimensions int) *Index {
+	return &Index{
+		metric:     metric,
+		dimensions: dimensions,
+		nodes:      make(map[string]*node),
+		rng:        rand.New(rand.NewSource(1)),
+	}
+}
+
+// Len returns the number of vectors in the index.
+func (x *Index) Len() int {
+	return len(x.nodes)
+}
+
+// Check reports whether v can be added to or searched in the index.
+func (x *Index) Check(v []float64) error {
+	if len(v) != x.dimensions {
+		return fmt.Errorf("expected %d dimensions, got %d", x.dimensions, len(v))
+	}
+	for _, f := range v {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return errors.New("vector components must be finite")
+		}
+	}
+	_, err := x.metric.prepare(v)
+	return err
+}
+
+// Add inserts the vector under id, replacing any vector already there.
+func (x *Index) Add(id string, v []float64) error {
+	if err := x.Check(v); err != nil {
+		return err
+	}
+	x.Remove(id)
+	prepared, _ := x.metric.prepare(v)
+
+	level := int(-math.Log(1-x.rng.Float64()) / math.Log(maxNeighbors))
+	n := &node{id: id, vector: prepared, neighbors: make([][]*node, level+1), incoming: make([]map[*node]bool, level+1)}
+	for l := range n.incoming {
+		n.incoming[l] = make(map[*node]bool)
+	}
+	x.nodes[id] = n
+	if x.entry == nil {
+		x.entry = n
+		return nil
+	}
+
+	top := x.entry.level()
+	current := x.entry
+	for l := top; l > level; l-- {
+		current = x.greedy(prepared, current, l)
+	}
+	for l := min(level, top); l >= 0; l-- {
+		candidates := x.searchLayer(prepared, current, efConstruction, l)
+		x.link(n, l, x.selectNeighbors(candidates, limitFor(l)))
+		for _, neighbor := range n.neighbors[l] {
+			x.link(neighbor, l, x.closest(neighbor.vector, append(neighbor.neighbors[l], n), limitFor(l)))
+		}
+		current = candidates[0].node
+	}
+	if level > top {
+		x.entry = n
+	}
+	return nil
+}
+
+// Remove deletes the vector under id. The nodes that linked to it are
+// linked to its neighbors instead, so the graph stays navigable.
+func (x *Index) Remove(id string) {
+	n, exists := x.nodes[id]
+	if !exists {
+		return
+	}
+	delete(x.nodes, id)
+
+	for l := range n.neighbors {
+		for _, neighbor := range n.neighbors[l] {
+			delete(neighbor.incoming[l], n)
+		}
+		var linking []*node
+		for from := range n.incoming[l] {
+			linking = append(linking, from)
+		}
+		sort.Slice(linking, func(i, j int) bool { return linking[i].id < linking[j].id })
+		for _, from := range linking {
+			candidates := make([]*node, 0, len(from.neighbors[l])+len(n.neighbors[l]))
+			for _, c := range append(from.neighbors[l], n.neighbors[l]...) {
+				if c != n && c != from {
+					candidates = append(candidates, c)
+				}
+			}
+			x.link(from, l, x.closest(from.vector, candidates, limitFor(l)))
+		}
+	}
+
+	if x.entry == n {
+		x.entry = nil
+		for _, candidate := range x.nodes {
+			if x.entry == nil || candidate.level() > x.entry.level() ||
+				(candidate.level() == x.entry.level() && candidate.id < x.entry.id) {
+				x.entry = candidate
+			}
+		}
+	}
+}
+
+// Search returns up to k vectors most similar to q among those accept
+// allows, best first. It explores ef candidates on the bottom layer; a
+// larger ef finds the true nearest neighbours more often at the cost of
+// speed. When the filter rejects so many candidates that fewer than k
+// remain, Search falls back to comparing q with every vector.
+func (x *Index) Search(q []float64, k, ef int, accept func(id string) bool) ([]Result, error) {
+	if err := x.Check(q); err != nil {
+		return nil, err
+	}
+	if x.entry == nil || k <= 0 {
+		return []Result{}, nil
+	}
+	prepared, _ := x.metric.prepare(q)
+
+	current := x.entry
+	for l := x.entry.level(); l > 0; l-- {
+		current = x.greedy(prepared, current, l)
+	}
+	candidates := x.searchLayer(prepared, current, max(ef, k), 0)
+
+	results := make([]Result, 0, k)
+	for _, c := range candidates {
+		if accept == nil || accept(c.node.id) {
+			results = append(results, Result{ID: c.node.id, Score: x.metric.score(c.distance)})
+			if len(results) == k {
+				return results, nil
+			}
+		}
+	}
+	if len(candidates) == len(x.nodes) {
+		return results, nil
+	}
+	return x.exact(prepared, k, accept), nil
+}
+
+// Exact compares q with every vector accept allows and returns the k most
+// similar, best first.
+func (x *Index) Exact(q []float64, k int, accept func(id string) bool) ([]Result, error) {
+	if err := x.Check(q); err != nil {
+		return nil, err
+	}
+	prepared, _ := x.metric.prepare(q)
+	return x.exact(prepared, k, accept), nil
+}
+
+func (x *Index) exact(q []float64, k int, accept func(id string) bool) []Result {
+	var found []candidate
+	for _, n := range x.nodes {
+		if accept == nil || accept(n.id) {
+			found = append(found, candidate{node: n, distance: x.metric.distance(q, n.vector)})
+		}
+	}
+	sortCandidates(found)
+	if len(found) > k {
+		found = found[:k]
+	}
+	results := make([]Result, len(found))
+	for i, c := range found {
+		results[i] = Result{ID: c.node.id, Score: x.metric.score(c.distance)}
+	}
+	return results
+}
+
+func (n *node) level() int {
+	return len(n.neighbors) - 1
+}
+
+func limitFor(level int) int {
+	if level == 0 {
+		return 2 * maxNeighbors
+	}
+	return maxNeighbors
+}
+
+// link replaces the links of n on a layer.
+func (x *Index) link(n *node, level int, neighbors []*node) {
+	for _, old := range n.neighbors[level] {
+		delete(old.incoming[level], n)
+	}
+	n.neighbors[level] = neighbors
+	for _, neighbor := range neighbors {
+		neighbor.incoming[level][n] = true
+	}
+}
+
+// closest chooses up to limit of the nodes as the neighbors of v.
+func (x *Index) closest(v []float64, nodes []*node, limit int) []*node {
+	candidates := make([]candidate, len(nodes))
+	for i, n := range nodes {
+		candidates[i] = candidate{node: n, distance: x.metric.distance(v, n.vector)}
+	}
+	sortCandidates(candidates)
+	return x.selectNeighbors(candidates, limit)
+}
+
+// selectNeighbors chooses up to limit neighbors among candidates sorted
+// nearest first. A candidate closer to an already chosen neighbor than to
+// the node itself is put off, so the links spread in every direction
+// instead of crowding one cluster, and a far away node is still linked
+// from its nearest neighbors. Candidates put off fill the remaining links.
+func (x *Index) selectNeighbors(candidates []candidate, limit int) []*node {
+	chosen := make([]*node, 0, min(limit, len(candidates)))
+	var deferred []*node
+	for _, c := range candidates {
+		if len(chosen) == limit {
+			break
+		}
+		diverse := true
+		for _, n := range chosen {
+			if x.metric.distance(c.node.vector, n.vector) < c.distance {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			chosen = append(chosen, c.node)
+		} else {
+			deferred = append(deferred, c.node)
+		}
+	}
+	for _, n := range deferred {
+		if len(chosen) == limit {
+			break
+		}
+		chosen = append(chosen, n)
+	}
+	return chosen
+}
+
+// greedy walks a layer towards q for as long as a neighbor is closer.
+func (x *Index) greedy(q []float64, from *node, level int) *node {
+	current, distance := from, x.metric.distance(q, from.vector)
+	for improved := true; improved; {
+		improved = false
+		for _, neighbor := range current.neighbors[level] {
+			if d := x.metric.distance(q, neighbor.vector); d < distance {
+				current, distance, improved = neighbor, d, true
+			}
+		}
+	}
+	return current
+}
+
+// searchLayer returns the ef nodes nearest to q it finds on a layer
+// starting from entry, nearest first.
+func (x *Index) searchLayer(q []float64, entry *node, ef, level int) []candidate {
+	start := candidate{node: entry, distance: x.metric.distance(q, entry.vector)}
+	visited := map[*node]bool{entry: true}
+	frontier := &nearestFirst{start}
+	found := &farthestFirst{start}
+
+	for frontier.Len() > 0 {
+		c := heap.Pop(frontier).(candidate)
+		if found.Len() >= ef && c.distance > (*found)[0].distance {
+			break
+		}
+		for _, neighbor := range c.node.neighbors[level] {
+			if visited[neighbor] {
+				continue
+			}
+			visited[neighbor] = true
+			d := x.metric.distance(q, neighbor.vector)
+			if found.Len() < ef || d < (*found)[0].distance {
+				heap.Push(frontier, candidate{node: neighbor, distance: d})
+				heap.Push(found, candidate{node: neighbor, distance: d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	result := []candidate(*found)
+	sortCandidates(result)
+	return result
+}
+
+type candidate struct {
+	node     *node
+	distance float64
+}
+
+// sortCandidates orders by distance, then by ID so equal distances come
+// out the same way every time.
+func sortCandidates(candidates []candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].node.id < candidates[j].node.id
+	})
+}
+
+type nearestFirst []candidate
+
+func (h nearestFirst) Len() int            { return len(h) }
+func (h nearestFirst) Less(i, j int) bool  { return h[i].distance < h[j].distance }
+func (h nearestFirst) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nearestFirst) Push(v interface{}) { *h = append(*h, v.(candidate)) }
+func (h *nearestFirst) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+type farthestFirst []candidate
+
+func (h farthestFirst) Len() int            { return len(h) }
+func (h farthestFirst) Less(i, j int) bool  { return h[i].distance > h[j].distance }
+func (h farthestFirst) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *farthestFirst) Push(v interface{}) { *h = append(*h, v.(candidate)) }
+func (h *farthestFirst) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
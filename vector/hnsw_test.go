@@ -0,0 +1,233 @@
+package vector
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func ids(results []Result) []string {
+	found := make([]string, len(results))
+	for i, r := range results {
+		found[i] = r.ID
+	}
+	return found
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func randomVector(rng *rand.Rand, dimensions int) []float64 {
+	v := make([]float64, dimensions)
+	for i := range v {
+		v[i] = rng.NormFloat64()
+	}
+	return v
+}
+
+// checkGraph verifies that every link points at a node in the index and
+// that the incoming sets mirror the outgoing links.
+func checkGraph(t *testing.T, x *Index) {
+	t.Helper()
+	if (x.entry == nil) != (len(x.nodes) == 0) {
+		t.Fatalf("entry = %v with %d nodes", x.entry, len(x.nodes))
+	}
+	for _, n := range x.nodes {
+		if x.entry != nil && n.level() > x.entry.level() {
+			t.Errorf("node %s is above the entry point", n.id)
+		}
+		for l, neighbors := range n.neighbors {
+			if len(neighbors) > limitFor(l) {
+				t.Errorf("node %s has %d links on layer %d", n.id, len(neighbors), l)
+			}
+			for _, neighbor := range neighbors {
+				if x.nodes[neighbor.id] != neighbor {
+					t.Errorf("node %s links to removed node %s on layer %d", n.id, neighbor.id, l)
+				}
+				if neighbor == n {
+					t.Errorf("node %s links to itself on layer %d", n.id, l)
+				}
+				if !neighbor.incoming[l][n] {
+					t.Errorf("link %s -> %s on layer %d is not recorded as incoming", n.id, neighbor.id, l)
+				}
+			}
+			for from := range n.incoming[l] {
+				if x.nodes[from.id] != from {
+					t.Errorf("node %s records a link from removed node %s", n.id, from.id)
+				}
+			}
+		}
+	}
+}
+
+func TestAddReplaceRemove(t *testing.T) {
+	x := New(L2, 2)
+	for i := 0; i < 50; i++ {
+		if err := x.Add(fmt.Sprint(i), []float64{float64(i), 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if x.Len() != 50 {
+		t.Fatalf("Len = %d, want 50", x.Len())
+	}
+	checkGraph(t, x)
+
+	// Replacing a vector moves it rather than adding a second one.
+	if err := x.Add("7", []float64{100, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 50 {
+		t.Fatalf("Len after replacing = %d, want 50", x.Len())
+	}
+	results, err := x.Search([]float64{100, 0}, 1, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(results); !equalIDs(got, []string{"7"}) {
+		t.Errorf("search at the new position found %v, want [7]", got)
+	}
+	results, _ = x.Search([]float64{7, 0}, 1, 10, nil)
+	if got := ids(results); equalIDs(got, []string{"7"}) {
+		t.Error("search at the old position still finds the replaced vector")
+	}
+	checkGraph(t, x)
+
+	x.Remove("missing")
+	x.Remove("8")
+	results, _ = x.Search([]float64{8, 0}, 2, 10, nil)
+	for _, r := range results {
+		if r.ID == "8" {
+			t.Error("search found a removed vector")
+		}
+	}
+	checkGraph(t, x)
+
+	// Remove the entry point until the index is empty.
+	for x.Len() > 0 {
+		x.Remove(x.entry.id)
+		checkGraph(t, x)
+	}
+	results, err = x.Search([]float64{1, 0}, 5, 10, nil)
+	if err != nil || len(results) != 0 {
+		t.Errorf("search of an empty index = %v, %v", results, err)
+	}
+	if err := x.Add("new", []float64{1, 1}); err != nil || x.Len() != 1 {
+		t.Errorf("add after emptying the index: %v, Len = %d", err, x.Len())
+	}
+}
+
+// recall is the fraction of the exact top k that Search also returns.
+func recall(t *testing.T, x *Index, queries [][]float64, k, ef int) float64 {
+	t.Helper()
+	found, total := 0, 0
+	for _, q := range queries {
+		approximate, err := x.Search(q, k, ef, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exact, err := x.Exact(q, k, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[string]bool, len(exact))
+		for _, r := range exact {
+			want[r.ID] = true
+		}
+		for i, r := range approximate {
+			if want[r.ID] {
+				found++
+			}
+			if i > 0 && r.Score > approximate[i-1].Score {
+				t.Fatalf("results are not best first: %v", approximate)
+			}
+		}
+		total += len(exact)
+	}
+	return float64(found) / float64(total)
+}
+
+func TestRecallAgainstBruteForce(t *testing.T) {
+	const (
+		dimensions = 16
+		vectors    = 1000
+		k          = 10
+		ef         = 64
+	)
+	rng := rand.New(rand.NewSource(42))
+	data := make([][]float64, vectors)
+	for i := range data {
+		data[i] = randomVector(rng, dimensions)
+	}
+	queries := make([][]float64, 50)
+	for i := range queries {
+		queries[i] = randomVector(rng, dimensions)
+	}
+
+	for _, metric := range []Metric{Cosine, Dot, L2} {
+		t.Run(string(metric), func(t *testing.T) {
+			x := New(metric, dimensions)
+			for i, v := range data {
+				if err := x.Add(fmt.Sprint(i), v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if r := recall(t, x, queries, k, ef); r < 0.95 {
+				t.Errorf("recall = %.3f, want at least 0.95", r)
+			}
+
+			// Deleting half of the vectors keeps the graph navigable.
+			for i := 0; i < vectors; i += 2 {
+				x.Remove(fmt.Sprint(i))
+			}
+			checkGraph(t, x)
+			if r := recall(t, x, queries, k, ef); r < 0.95 {
+				t.Errorf("recall after deleting half = %.3f, want at least 0.95", r)
+			}
+		})
+	}
+}
+
+func TestSearchFilterFallsBackToExact(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	x := New(L2, 8)
+	for i := 0; i < 500; i++ {
+		if err := x.Add(fmt.Sprint(i), randomVector(rng, 8)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only a few vectors pass the filter, so the graph search alone would
+	// find fewer than k of them.
+	accept := func(id string) bool { return len(id) == 1 }
+	q := randomVector(rng, 8)
+	got, err := x.Search(q, 5, 10, accept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := x.Exact(q, 5, accept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalIDs(ids(got), ids(want)) {
+		t.Errorf("filtered search = %v, want %v", ids(got), ids(want))
+	}
+
+	if results, _ := x.Search(q, 5, 10, func(string) bool { return false }); len(results) != 0 {
+		t.Errorf("search with a filter rejecting everything = %v", results)
+	}
+	if results, _ := x.Search(q, 0, 10, nil); len(results) != 0 {
+		t.Errorf("search for k = 0 = %v", results)
+	}
+	if results, _ := x.Search(q, 1000, 10, nil); len(results) != 500 {
+		t.Errorf("search for more than the index holds returned %d results, want 500", len(results))
+	}
+}
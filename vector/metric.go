@@ -0,0 +1,80 @@
+// Package vector implements approximate nearest neighbour search over
+// embeddings with a hierarchical navigable small world (HNSW) graph, kept
+// up to date as vectors are added, replaced and removed.
+package vector
+
+import (
+	"errors"
+	"math"
+)
+
+// Metric is how two vectors are compared.
+type Metric string
+
+const (
+	// Cosine compares directions only; vectors are normalized when added.
+	Cosine Metric = "cosine"
+	// Dot is the inner product, for embeddings trained for it.
+	Dot Metric = "dot"
+	// L2 is the Euclidean distance.
+	L2 Metric = "l2"
+)
+
+// ValidMetric reports whether metric is supported.
+func ValidMetric(metric Metric) bool {
+	return metric == Cosine || metric == Dot || metric == L2
+}
+
+// prepare returns the vector as the index stores it, normalized for Cosine.
+func (m Metric) prepare(v []float64) ([]float64, error) {
+	prepared := make([]float64, len(v))
+	copy(prepared, v)
+	if m != Cosine {
+		return prepared, nil
+	}
+	norm := math.Sqrt(dot(v, v))
+	if norm == 0 {
+		return nil, errors.New("a zero vector has no direction to compare by cosine")
+	}
+	for i := range prepared {
+		prepared[i] /= norm
+	}
+	return prepared, nil
+}
+
+// distance is smaller the more similar two prepared vectors are.
+func (m Metric) distance(a, b []float64) float64 {
+	switch m {
+	case L2:
+		sum := 0.0
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return sum
+	case Dot:
+		return -dot(a, b)
+	}
+	return 1 - dot(a, b)
+}
+
+// score turns a distance into a similarity, larger for closer vectors: the
+// cosine similarity, the dot product, or 1/(1+d) for the Euclidean
+// distance d.
+func (m Metric) score(distance float64) float64 {
+	switch m {
+	case L2:
+		return 1 / (1 + math.Sqrt(distance))
+	case Dot:
+		return -distance
+	}
+	return 1 - distance
+}
+
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,132 @@
+package vector
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMetricScores(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric Metric
+		stored []float64
+		query  []float64
+		want   float64
+	}{
+		{"cosine identical", Cosine, []float64{1, 2}, []float64{1, 2}, 1},
+		{"cosine ignores length", Cosine, []float64{1, 2}, []float64{10, 20}, 1},
+		{"cosine orthogonal", Cosine, []float64{1, 0}, []float64{0, 3}, 0},
+		{"cosine opposite", Cosine, []float64{1, 1}, []float64{-2, -2}, -1},
+		{"cosine at 60 degrees", Cosine, []float64{1, 0}, []float64{0.5, math.Sqrt(3) / 2}, 0.5},
+		{"dot", Dot, []float64{1, 2}, []float64{3, 4}, 11},
+		{"dot keeps length", Dot, []float64{1, 2}, []float64{30, 40}, 110},
+		{"dot of a zero vector", Dot, []float64{0, 0}, []float64{3, 4}, 0},
+		{"l2 identical", L2, []float64{1, 2}, []float64{1, 2}, 1},
+		{"l2", L2, []float64{0, 0}, []float64{3, 4}, 1.0 / 6},
+	}
+	for _, tt := range tests {
+		x := New(tt.metric, len(tt.stored))
+		if err := x.Add("a", tt.stored); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		results, err := x.Exact(tt.query, 1, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(results) != 1 || math.Abs(results[0].Score-tt.want) > 1e-9 {
+			t.Errorf("%s: results = %v, want score %v", tt.name, results, tt.want)
+		}
+	}
+}
+
+func TestMetricRanking(t *testing.T) {
+	// The long vector is farthest by L2 but best by dot product, and
+	// cosine only sees direction.
+	stored := map[string][]float64{
+		"near": {1, 0.1},
+		"long": {10, 5},
+		"side": {0, 1},
+	}
+	tests := []struct {
+		metric Metric
+		want   []string
+	}{
+		{Cosine, []string{"near", "long", "side"}},
+		{Dot, []string{"long", "near", "side"}},
+		{L2, []string{"near", "side", "long"}},
+	}
+	for _, tt := range tests {
+		x := New(tt.metric, 2)
+		for id, v := range stored {
+			if err := x.Add(id, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		results, err := x.Search([]float64{1, 0}, 3, 10, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(results); !equalIDs(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.metric, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRejectsInvalidVectors(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric Metric
+		v      []float64
+		ok     bool
+	}{
+		{"cosine zero vector", Cosine, []float64{0, 0, 0}, false},
+		{"dot zero vector", Dot, []float64{0, 0, 0}, true},
+		{"l2 zero vector", L2, []float64{0, 0, 0}, true},
+		{"too few dimensions", L2, []float64{1, 2}, false},
+		{"too many dimensions", L2, []float64{1, 2, 3, 4}, false},
+		{"no dimensions", Dot, nil, false},
+		{"NaN", L2, []float64{1, math.NaN(), 3}, false},
+		{"infinity", Dot, []float64{1, math.Inf(-1), 3}, false},
+	}
+	for _, tt := range tests {
+		x := New(tt.metric, 3)
+		if err := x.Check(tt.v); (err == nil) != tt.ok {
+			t.Errorf("%s: Check = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+		if err := x.Add("a", tt.v); (err == nil) != tt.ok {
+			t.Errorf("%s: Add = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+		if !tt.ok && x.Len() != 0 {
+			t.Errorf("%s: rejected vector was added", tt.name)
+		}
+		if _, err := x.Search(tt.v, 1, 10, nil); (err == nil) != tt.ok {
+			t.Errorf("%s: Search = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+		if _, err := x.Exact(tt.v, 1, nil); (err == nil) != tt.ok {
+			t.Errorf("%s: Exact = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+
+	if !ValidMetric(Cosine) || !ValidMetric(Dot) || !ValidMetric(L2) || ValidMetric("hamming") {
+		t.Error("ValidMetric does not match the supported metrics")
+	}
+}
+
+func TestAddCopiesTheVector(t *testing.T) {
+	for _, metric := range []Metric{Cosine, Dot, L2} {
+		x := New(metric, 2)
+		v := []float64{1, 0}
+		if err := x.Add("a", v); err != nil {
+			t.Fatal(err)
+		}
+		v[0], v[1] = 0, 1
+		results, err := x.Exact([]float64{1, 0}, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every metric scores the stored [1, 0] against itself as 1.
+		if math.Abs(results[0].Score-1) > 1e-9 {
+			t.Errorf("%s: the index follows changes to the caller's vector: %v", metric, results)
+		}
+	}
+}